@@ -0,0 +1,125 @@
+package hoard
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"sync/atomic"
+)
+
+// tinyLFU is the admission filter of one shard, see WithAdmissionFilter. A
+// doorkeeper bloom filter absorbs the first access to a key and a count-min
+// sketch of 4 bit counters counts the following ones. After sampleSize
+// accesses every counter is halved and the doorkeeper cleared, so the
+// estimates follow recent popularity. All methods are safe for concurrent
+// use; the counters are updated with atomics so lookups holding only a read
+// lock can record accesses.
+type tinyLFU struct {
+	seed       maphash.Seed
+	mask       uint64
+	rows       [sketchDepth][]atomic.Uint32
+	door       []atomic.Uint64
+	additions  atomic.Int64
+	sampleSize int64
+	resetting  atomic.Bool
+}
+
+const (
+	sketchDepth    = 4
+	maxSketchCount = 15
+)
+
+// newTinyLFU sizes the filter for a shard of capacity entries.
+func newTinyLFU(capacity int, seed maphash.Seed) *tinyLFU {
+	width := uint64(1) << bits.Len(uint(max(capacity, 16)-1))
+	t := &tinyLFU{
+		seed:       seed,
+		mask:       width - 1,
+		door:       make([]atomic.Uint64, width/16), // 4 bits per counter
+		sampleSize: int64(10 * width),
+	}
+	for i := range t.rows {
+		t.rows[i] = make([]atomic.Uint32, width)
+	}
+	return t
+}
+
+// index returns the counter slot of key in row i, using double hashing over
+// the two halves of h.
+func (t *tinyLFU) index(h uint64, i int) uint64 {
+	lo, hi := h, (h>>32)|1
+	return (lo + uint64(i)*hi) & t.mask
+}
+
+// record counts an access to key.
+func (t *tinyLFU) record(key string) {
+	h := maphash.String(t.seed, key)
+	if t.admitDoor(h) {
+		for i := range t.rows {
+			c := &t.rows[i][t.index(h, i)]
+			if c.Load() < maxSketchCount {
+				c.Add(1)
+			}
+		}
+	}
+	if t.additions.Add(1) >= t.sampleSize && t.resetting.CompareAndSwap(false, true) {
+		t.reset()
+		t.resetting.Store(false)
+	}
+}
+
+// doorSlot returns the i-th doorkeeper bit of h, from a remix of h so the
+// bits are independent of the sketch slots.
+func (t *tinyLFU) doorSlot(h uint64, i int) (word *atomic.Uint64, bit uint64) {
+	x := (h * 0x9e3779b97f4a7c15) >> (32 * i)
+	slot := x & (uint64(len(t.door))*64 - 1)
+	return &t.door[slot/64], uint64(1) << (slot % 64)
+}
+
+// admitDoor sets the doorkeeper bits of h and reports whether they were all
+// set already, meaning this is not the first recent access.
+func (t *tinyLFU) admitDoor(h uint64) bool {
+	seen := true
+	for i := 0; i < 2; i++ {
+		word, bit := t.doorSlot(h, i)
+		if word.Load()&bit == 0 {
+			seen = false
+			word.Or(bit)
+		}
+	}
+	return seen
+}
+
+// estimate returns the recent access frequency of key.
+func (t *tinyLFU) estimate(key string) uint32 {
+	h := maphash.String(t.seed, key)
+	n := uint32(maxSketchCount)
+	for i := range t.rows {
+		n = min(n, t.rows[i][t.index(h, i)].Load())
+	}
+	for i := 0; i < 2; i++ {
+		if word, bit := t.doorSlot(h, i); word.Load()&bit == 0 {
+			return n
+		}
+	}
+	return n + 1
+}
+
+// reset halves every counter and clears the doorkeeper.
+func (t *tinyLFU) reset() {
+	for i := range t.rows {
+		for j := range t.rows[i] {
+			c := &t.rows[i][j]
+			c.Store(c.Load() / 2)
+		}
+	}
+	for i := range t.door {
+		t.door[i].Store(0)
+	}
+	t.additions.Store(0)
+}
+
+// admit reports whether key, about to replace victim in a full shard, has
+// been accessed more often recently. Ties keep the victim.
+func (t *tinyLFU) admit(key string, victim *CacheItem) bool {
+	return t.estimate(key) > t.estimate(victim.key)
+}
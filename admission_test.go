@@ -0,0 +1,105 @@
+package hoard
+
+import (
+	"hash/maphash"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// hitRatio replays a cache-aside workload: a Zipfian stream of reads, each
+// miss followed by a store, interleaved with keys written once and never
+// read.
+func hitRatio(t *testing.T, opts ...Option) float64 {
+	t.Helper()
+	cache := NewCache(1, 1000, time.Hour, opts...)
+	defer cache.Close()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	zipf := rand.NewZipf(rng, 1.1, 1, 100_000)
+	hits, reads := 0, 0
+	for i := 0; i < 200_000; i++ {
+		key := "key" + strconv.FormatUint(zipf.Uint64(), 10)
+		reads++
+		if _, ok := cache.FetchBytesData(key); ok {
+			hits++
+		} else {
+			cache.StoreBytes(key, []byte("v"), NoExpiration)
+		}
+		cache.StoreBytes("once"+strconv.Itoa(i), []byte("v"), NoExpiration)
+	}
+	return float64(hits) / float64(reads)
+}
+
+// testing that the admission filter beats plain LRU on a skewed trace with a
+// long tail of one-hit wonders
+func TestAdmissionHitRatio(t *testing.T) {
+	lru := hitRatio(t)
+	tiny := hitRatio(t, WithAdmissionFilter())
+	t.Logf("hit ratio: LRU %.3f, TinyLFU %.3f", lru, tiny)
+	if tiny < lru+0.05 {
+		t.Fatalf("Expected the admission filter to raise the hit ratio by 5 points, got %.3f vs %.3f", tiny, lru)
+	}
+}
+
+// testing that rejected stores leave the cache unchanged and are counted
+func TestAdmissionRejects(t *testing.T) {
+	cache := NewCache(1, 2, time.Hour, WithAdmissionFilter())
+	defer cache.Close()
+
+	cache.Store("a", 1, NoExpiration)
+	cache.Store("b", 2, NoExpiration)
+	for i := 0; i < 5; i++ {
+		cache.FetchData("a")
+		cache.FetchData("b")
+	}
+
+	if err := cache.Store("new", 3, NoExpiration); err != nil {
+		t.Fatalf("Expected a rejected store to return nil, got %v", err)
+	}
+	if cache.Exists("new") || !cache.Exists("a") || !cache.Exists("b") {
+		t.Fatal("Expected the cold key to be turned away")
+	}
+	if n := cache.Stats().Rejected; n != 1 {
+		t.Fatalf("Expected 1 rejection, got %d", n)
+	}
+
+	// Once it is asked for more often than the victim, it gets in
+	for i := 0; i < 10; i++ {
+		cache.FetchData("new")
+	}
+	cache.Store("new", 3, NoExpiration)
+	if !cache.Exists("new") {
+		t.Fatal("Expected the now popular key to be admitted")
+	}
+
+	// Overwrites are never filtered
+	cache.Store("new", 4, NoExpiration)
+	if v, _, _ := cache.FetchData("new"); v != int8(4) {
+		t.Fatalf("Expected the overwrite to apply, got %v", v)
+	}
+}
+
+// testing that the sketch ages so old popularity fades
+func TestAdmissionSketchReset(t *testing.T) {
+	sketch := newTinyLFU(16, maphash.MakeSeed())
+	for i := 0; i < 10; i++ {
+		sketch.record("hot")
+	}
+	if n := sketch.estimate("hot"); n != 10 {
+		t.Fatalf("Expected an estimate of 10, got %d", n)
+	}
+	sketch.reset()
+	if n := sketch.estimate("hot"); n != 4 {
+		t.Fatalf("Expected the count to be halved, got %d", n)
+	}
+
+	// Resets happen on their own every sampleSize accesses
+	for i := int64(0); i < sketch.sampleSize; i++ {
+		sketch.record("hot")
+	}
+	if n := sketch.additions.Load(); n != 0 {
+		t.Fatalf("Expected the sample counter to start over, got %d", n)
+	}
+}
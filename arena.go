@@ -0,0 +1,126 @@
+package hoard
+
+import "bytes"
+
+// arenaSlabSize is the size of the slabs values are packed into under
+// WithArenaStorage. Larger values get a slab of their own.
+const arenaSlabSize = 1 << 20
+
+// arena packs the values of a shard into large byte slabs, so the GC sees a
+// few slabs instead of a slice per entry. Values are only ever appended: a
+// replaced or removed value becomes dead space, and compact copies the live
+// ones into fresh slabs once the dead space outweighs them. Slabs are never
+// written to again, so slices of them stay valid and unchanged after the
+// value is freed or compacted away.
+type arena struct {
+	slabs [][]byte
+	size  int64 // bytes handed out or skipped in the slabs
+	dead  int64 // bytes of freed values and skipped slab tails
+}
+
+// put appends val to the arena and returns where it was stored.
+func (a *arena) put(val []byte) (slab, off uint32) {
+	n := len(val)
+	if n == 0 {
+		return 0, 0
+	}
+	last := len(a.slabs) - 1
+	if last < 0 || cap(a.slabs[last])-len(a.slabs[last]) < n {
+		if last >= 0 {
+			// The tail of the full slab is never used
+			tail := int64(cap(a.slabs[last]) - len(a.slabs[last]))
+			a.size += tail
+			a.dead += tail
+		}
+		a.slabs = append(a.slabs, make([]byte, 0, max(arenaSlabSize, n)))
+		last++
+	}
+	s := a.slabs[last]
+	a.slabs[last] = append(s, val...)
+	a.size += int64(n)
+	return uint32(last), uint32(len(s))
+}
+
+// get returns the n bytes stored at off in slab. The slice aliases the slab
+// and is capped so appending to it cannot write into the slab.
+func (a *arena) get(slab, off, n uint32) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	return a.slabs[slab][off : off+n : off+n]
+}
+
+// value returns the bytes of item. Under WithArenaStorage they alias the
+// arena, which is fine for reading them while the shard lock is held or
+// writing them out; callers handing them to users or tiers copy them, see
+// viewOf and ownedValue.
+func (s *CacheShard) value(item *CacheItem) []byte {
+	if s.arena == nil {
+		return item.Value
+	}
+	return s.arena.get(item.arenaSlab, item.arenaOff, item.arenaLen)
+}
+
+// ownedValue returns bytes of item that may be kept past the shard lock:
+// under WithArenaStorage a copy, so they do not pin a whole slab.
+func (s *CacheShard) ownedValue(item *CacheItem) []byte {
+	if s.arena == nil {
+		return item.Value
+	}
+	return bytes.Clone(s.value(item))
+}
+
+// viewOf returns the state of item for use after the shard lock is released,
+// with its value copied out of the arena under WithArenaStorage so values
+// handed to users do not pin whole slabs.
+func (s *CacheShard) viewOf(item *CacheItem) itemView {
+	v := item.view()
+	if s.arena != nil {
+		v.value = s.ownedValue(item)
+	}
+	return v
+}
+
+// sharedView is viewOf without the copy, for callers that copy or decode
+// the value themselves.
+func (s *CacheShard) sharedView(item *CacheItem) itemView {
+	v := item.view()
+	v.value = s.value(item)
+	return v
+}
+
+// putValue stores val as the value of item, which must not hold one. It
+// does not update the byte estimate. The caller must hold s.mu for writing.
+func (s *CacheShard) putValue(item *CacheItem, val []byte) {
+	if s.arena == nil {
+		item.Value = val
+		return
+	}
+	item.arenaSlab, item.arenaOff = s.arena.put(val)
+	item.arenaLen = uint32(len(val))
+}
+
+// freeValue marks the value of item as dead space in the arena. The caller
+// must hold s.mu for writing.
+func (s *CacheShard) freeValue(item *CacheItem) {
+	if s.arena == nil {
+		return
+	}
+	s.arena.dead += int64(item.arenaLen)
+	item.arenaSlab, item.arenaOff, item.arenaLen = 0, 0, 0
+}
+
+// compactArena copies the live values into fresh slabs once dead space
+// takes up more than half the arena and at least a slab. Every entry of
+// the shard must hold a live value. The caller must hold s.mu for writing.
+func (s *CacheShard) compactArena() {
+	a := s.arena
+	if a == nil || a.dead < arenaSlabSize || a.dead*2 < a.size {
+		return
+	}
+	fresh := arena{}
+	for _, item := range s.data {
+		item.arenaSlab, item.arenaOff = fresh.put(a.get(item.arenaSlab, item.arenaOff, item.arenaLen))
+	}
+	*a = fresh
+}
@@ -0,0 +1,203 @@
+package hoard
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// testing that entries stored in arenas behave like any other through the
+// operations that move or rewrite values
+func TestArenaStorage(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute, WithArenaStorage(), WithChecksums())
+	defer cache.Close()
+
+	cache.Store("name", "hoard", NoExpiration)
+	cache.StoreBytes("raw", []byte("raw bytes"), NoExpiration)
+	cache.StoreBytes("empty", nil, NoExpiration)
+	if _, err := cache.Increment("count", 2, NoExpiration); err != nil {
+		t.Fatalf("Increment failed: %v", err)
+	}
+	for _, shard := range cache.shards {
+		for key, item := range shard.data {
+			if item.Value != nil {
+				t.Fatalf("Expected %q to live in the arena, got its own slice", key)
+			}
+		}
+	}
+
+	if v := mustFetch(t, cache, "name"); v != "hoard" {
+		t.Fatalf("Expected hoard, got %v", v)
+	}
+	raw, ok := cache.FetchBytesData("raw")
+	if !ok || string(raw) != "raw bytes" {
+		t.Fatalf("Expected the raw bytes, got %q, %v", raw, ok)
+	}
+	raw[0] = 'X'
+	if again, _ := cache.FetchBytesData("raw"); string(again) != "raw bytes" {
+		t.Fatalf("Expected lookups to hand out copies, got %q", again)
+	}
+	if empty, ok := cache.FetchBytesData("empty"); !ok || len(empty) != 0 {
+		t.Fatalf("Expected the empty value, got %q, %v", empty, ok)
+	}
+	if n, _ := cache.Increment("count", 3, NoExpiration); n != 5 {
+		t.Fatalf("Expected the counter to reach 5, got %d", n)
+	}
+
+	if err := cache.Update("name", "updated", time.Minute); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if ok, err := cache.CompareAndSwap("name", "updated", "swapped", NoExpiration); !ok || err != nil {
+		t.Fatalf("Expected the swap, got %v, %v", ok, err)
+	}
+	// Renaming moves the value into the arena of another shard
+	for i := 0; i < 10; i++ {
+		if err := cache.Rename("name", "name"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		cache.Rename("name"+strconv.Itoa(i), "name")
+	}
+	if err := cache.Copy("raw", "copy", NoExpiration); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if v := mustFetch(t, cache, "name"); v != "swapped" {
+		t.Fatalf("Expected the swapped value after renames, got %v", v)
+	}
+	if v, _ := cache.FetchBytesData("copy"); string(v) != "raw bytes" {
+		t.Fatalf("Expected the copied bytes, got %q", v)
+	}
+
+	clone := cache.Clone()
+	defer clone.Close()
+	if v := mustFetch(t, clone, "name"); v != "swapped" {
+		t.Fatalf("Expected the clone to hold the value, got %v", v)
+	}
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	restored := NewCache(4, 100, time.Minute, WithArenaStorage())
+	defer restored.Close()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if v, _ := restored.FetchBytesData("raw"); string(v) != "raw bytes" {
+		t.Fatalf("Expected the snapshot to hold the bytes, got %q", v)
+	}
+	checkIntegrity(t, cache)
+	checkIntegrity(t, clone)
+}
+
+// testing that rewriting values compacts the arenas instead of growing them
+func TestArenaCompaction(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute, WithArenaStorage())
+	defer cache.Close()
+	shard := cache.shards[0]
+
+	value := func(round, i int) []byte {
+		return []byte(strings.Repeat(strconv.Itoa(round%10), 1000+i))
+	}
+	for round := 0; round < 100; round++ {
+		for i := 0; i < 100; i++ {
+			cache.StoreBytes("key"+strconv.Itoa(i), value(round, i), NoExpiration)
+		}
+	}
+	// 100 rounds wrote 10MB; compaction keeps the arena to a few times the
+	// 100KB that is live
+	if n := len(shard.arena.slabs); n > 3 {
+		t.Fatalf("Expected compaction to bound the arena, got %d slabs", n)
+	}
+	for i := 0; i < 100; i++ {
+		if v, _ := cache.FetchBytesData("key" + strconv.Itoa(i)); !bytes.Equal(v, value(99, i)) {
+			t.Fatalf("Expected key%d to survive compaction, got %d bytes", i, len(v))
+		}
+	}
+	checkIntegrity(t, cache)
+
+	// Emptying the shard leaves only dead space, which goes at the next
+	// compaction
+	cache.CleanupAll()
+	cache.StoreBytes("key", make([]byte, arenaSlabSize), NoExpiration)
+	cache.Delete("key")
+	if shard.arena.size != 0 || len(shard.arena.slabs) != 0 {
+		t.Fatalf("Expected an empty arena, got %d bytes in %d slabs", shard.arena.size, len(shard.arena.slabs))
+	}
+}
+
+// retainingTier keeps the entries put into it as given, checking that none
+// aliases the arena of shard.
+type retainingTier struct {
+	t       *testing.T
+	shard   *CacheShard
+	entries map[string]TierEntry
+}
+
+func (r *retainingTier) Put(key string, entry TierEntry) error {
+	if aliasesArena(r.shard, entry.Value) {
+		r.t.Errorf("Expected the tier to get a copy of %q, got bytes in the arena", key)
+	}
+	r.entries[key] = entry
+	return nil
+}
+
+func (r *retainingTier) Get(key string) (TierEntry, bool, error) {
+	e, ok := r.entries[key]
+	return e, ok, nil
+}
+
+func (r *retainingTier) Delete(key string) error {
+	delete(r.entries, key)
+	return nil
+}
+
+// aliasesArena reports whether b points into a slab of the arena of shard.
+func aliasesArena(shard *CacheShard, b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	p := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	for _, slab := range shard.arena.slabs {
+		start := uintptr(unsafe.Pointer(unsafe.SliceData(slab)))
+		if p >= start && p < start+uintptr(cap(slab)) {
+			return true
+		}
+	}
+	return false
+}
+
+// testing that values leaving the shard, into a tier that keeps them or to
+// subscribers of removals, are copied out of the arena
+func TestArenaValuesEscapeAsCopies(t *testing.T) {
+	tier := &retainingTier{t: t, entries: make(map[string]TierEntry)}
+	cache := NewCache(1, 2, time.Minute, WithArenaStorage(), WithTier(tier))
+	defer cache.Close()
+	tier.shard = cache.shards[0]
+	events, cancel := cache.Events(16, true)
+	defer cancel()
+
+	for i := 0; i < 4; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)), NoExpiration)
+	}
+	if len(tier.entries) != 2 {
+		t.Fatalf("Expected 2 entries spilled into the tier, got %d", len(tier.entries))
+	}
+	if e := tier.entries["key0"]; string(e.Value) != "value0" {
+		t.Fatalf("Expected the tier to hold value0, got %q", e.Value)
+	}
+
+	for evictions := 0; evictions < 2; {
+		ev := nextEvent(t, events)
+		if aliasesArena(tier.shard, ev.Value) {
+			t.Fatalf("Expected the %v event of %q to carry a copy", ev.Reason, ev.Key)
+		}
+		if ev.Reason == OpEvict {
+			if string(ev.Value) != "value"+strconv.Itoa(evictions) {
+				t.Fatalf("Expected the evicted value, got %q", ev.Value)
+			}
+			evictions++
+		}
+	}
+}
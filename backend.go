@@ -0,0 +1,156 @@
+package hoard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Backend is a persistent store the cache writes through to and reads
+// through from, configured with WithBackend. Values are exchanged in their
+// serialized form. Load reports a missing key with an error wrapping
+// ErrKeyNotFound, and may return a ttl as Store accepts it.
+type Backend interface {
+	Load(key string) ([]byte, time.Duration, error)
+	Save(key string, value []byte) error
+	Delete(key string) error
+}
+
+// ContextBackend is a Backend whose loads and saves honor a context. The
+// cache calls LoadCtx instead of Load for misses resolved by FetchCtx and
+// FetchData, the latter with context.Background, and SaveCtx instead of Save
+// for every write, with the context of StoreCtx or context.Background.
+type ContextBackend interface {
+	Backend
+	LoadCtx(ctx context.Context, key string) ([]byte, time.Duration, error)
+	SaveCtx(ctx context.Context, key string, value []byte) error
+}
+
+// readThrough resolves a miss from the backend and caches the result.
+// Concurrent callers for the same key share a single Load.
+func (c *Cache) readThrough(ctx context.Context, key string) (interface{}, bool, error) {
+	res, err, _ := c.loads.do(ctx, key, func() (interface{}, error) {
+		// Another caller may have filled the key since our miss
+		if v, ok := c.fetch(key); ok {
+			return v, nil
+		}
+		val, ttl, err := c.loadBackend(ctx, key)
+		if err != nil {
+			if !errors.Is(err, ErrKeyNotFound) {
+				c.log(slog.LevelWarn, "hoard: backend load failed", slog.String("key", key), slog.Any("err", err))
+			}
+			return nil, err
+		}
+		stored, flags, err := c.pack(val, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		shard := c.getShard(key)
+		exp := c.expiration(ttl)
+		shard.mu.Lock()
+		// A value that does not fit is still returned, just not cached
+		if c.checkValue(key, val) == nil && c.setLocked(shard, key, stored, exp, flags, 1) == nil {
+			c.logWrite(walSet, key, stored, exp, flags)
+		}
+		shard.mu.Unlock()
+		return itemView{value: val, expiration: exp}, nil
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: load %q: %w", ErrBackend, key, err)
+	}
+	value, err := c.decode(res.(itemView))
+	return value, true, err
+}
+
+// loadBackend loads key from the backend, with ctx if it takes one.
+func (c *Cache) loadBackend(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	if b, ok := c.backend.(ContextBackend); ok {
+		return b.LoadCtx(ctx, key)
+	}
+	return c.backend.Load(key)
+}
+
+// saveOp prepares the save of the value v, packed or not, to the backend,
+// for the write to queue with queueIO once it is applied. It returns nil if
+// there is no backend or v is not saved. ctx, if not nil, bounds the wait
+// for the turn of the save and is passed to the backend.
+func (c *Cache) saveOp(ctx context.Context, key string, v itemView) (*ioOp, error) {
+	if c.backend == nil {
+		return nil, nil
+	}
+	val, ok, err := c.backendValue(v)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &ioOp{kind: ioSave, key: key, value: val, ctx: ctx}, nil
+}
+
+// save writes val through to the backend, with ctx if it takes one, or
+// queues it with write-behind.
+func (c *Cache) save(ctx context.Context, key string, val []byte) error {
+	if c.wb != nil {
+		return c.enqueue(ctx, writeOp{key: key, value: val})
+	}
+	var err error
+	if b, ok := c.backend.(ContextBackend); ok {
+		err = b.SaveCtx(ctx, key, val)
+	} else {
+		err = c.backend.Save(key, val)
+	}
+	if err != nil {
+		c.log(slog.LevelWarn, "hoard: backend save failed", slog.String("key", key), slog.Any("err", err))
+		return fmt.Errorf("%w: save %q: %w", ErrBackend, key, err)
+	}
+	return nil
+}
+
+// backendValue returns v in the serialized form the backend exchanges:
+// raw bytes and counters are serialized like any value. It reports false for
+// negative entries, which the backend does not hold.
+func (c *Cache) backendValue(v itemView) ([]byte, bool, error) {
+	v, err := c.unpack(v)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case v.flags&itemNegative != 0:
+		return nil, false, nil
+	case v.flags&itemRaw != 0:
+		val, err := c.serialize(v.value)
+		return val, err == nil, err
+	case v.flags&itemInt != 0:
+		val, err := c.serialize(decodeInt(v.value))
+		return val, err == nil, err
+	}
+	return v.value, true, nil
+}
+
+// deleteThroughLocked queues the delete of key from the backend, if any, to
+// run in turn once shard.mu is released. The caller must hold shard.mu for
+// writing.
+func (c *Cache) deleteThroughLocked(shard *CacheShard, key string) {
+	if c.backend != nil {
+		c.queueIO(shard, &ioOp{kind: ioDelete, key: key})
+	}
+}
+
+// deleteThrough deletes key from the backend or queues the delete with
+// write-behind. Delete cannot return an error, so failures go to the error
+// handler.
+func (c *Cache) deleteThrough(key string) {
+	if c.wb != nil {
+		if err := c.enqueue(context.Background(), writeOp{key: key, delete: true}); err != nil {
+			c.flushError(key, err)
+		}
+		return
+	}
+	if err := c.backend.Delete(key); err != nil {
+		c.reportError(fmt.Errorf("%w: delete %q: %w", ErrBackend, key, err))
+	}
+}
@@ -0,0 +1,546 @@
+package hoard
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memBackend is an in-memory Backend that counts loads and fails every call
+// while err is set. Saves wait for gate when it is set.
+type memBackend struct {
+	mu    sync.Mutex
+	data  map[string][]byte
+	err   error
+	loads atomic.Int32
+	gate  chan struct{}
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{data: make(map[string][]byte)}
+}
+
+func (b *memBackend) Load(key string) ([]byte, time.Duration, error) {
+	b.loads.Add(1)
+	time.Sleep(time.Millisecond) // widen the window for concurrent misses
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return nil, 0, b.err
+	}
+	val, ok := b.data[key]
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	return val, time.Minute, nil
+}
+
+func (b *memBackend) Save(key string, value []byte) error {
+	if b.gate != nil {
+		<-b.gate
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.data[key] = value
+	return nil
+}
+
+func (b *memBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	delete(b.data, key)
+	return nil
+}
+
+func (b *memBackend) fail(err error) {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+}
+
+// testing the miss, load, hit sequence and write-through
+func TestBackendReadThrough(t *testing.T) {
+	backend := newMemBackend()
+	backend.data["aboubakr"], _ = Serialize("kouhadi")
+
+	cache := NewCache(4, 100, time.Minute, WithBackend(backend))
+	defer cache.Close()
+
+	value, ok, err := cache.FetchData("aboubakr")
+	if err != nil || !ok || value != "kouhadi" {
+		t.Fatalf("Expected the backend value, got %v (%v, %v)", value, ok, err)
+	}
+	if !cache.Exists("aboubakr") {
+		t.Fatal("Expected the loaded value to be cached")
+	}
+	cache.FetchData("aboubakr")
+	if n := backend.loads.Load(); n != 1 {
+		t.Fatalf("Expected a hit not to load again, got %d loads", n)
+	}
+
+	// A key the backend does not have is a plain miss
+	if value, ok, err := cache.FetchData("missing"); err != nil || ok || value != nil {
+		t.Fatalf("Expected a miss, got %v (%v, %v)", value, ok, err)
+	}
+
+	// Writes go through
+	cache.Store("key", "value", time.Minute)
+	cache.Update("key", "value2", time.Minute)
+	if data, ok := backend.data["key"]; !ok {
+		t.Fatal("Expected Store to save to the backend")
+	} else if value, _ := Deserialize(data); value != "value2" {
+		t.Fatalf("Expected Update to save to the backend, got %v", value)
+	}
+	cache.Delete("key")
+	if _, ok := backend.data["key"]; ok {
+		t.Fatal("Expected Delete to delete from the backend")
+	}
+}
+
+// testing that concurrent misses share one Load
+func TestBackendCoalescesLoads(t *testing.T) {
+	backend := newMemBackend()
+	backend.data["key"], _ = Serialize("value")
+	cache := NewCache(4, 100, time.Minute, WithBackend(backend))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, ok, err := cache.FetchData("key"); err != nil || !ok || value != "value" {
+				t.Errorf("Expected the backend value, got %v (%v, %v)", value, ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := backend.loads.Load(); n != 1 {
+		t.Fatalf("Expected 1 load, got %d", n)
+	}
+}
+
+// testing that backend failures are reported as ErrBackend and leave no
+// value in the cache that the backend does not have
+func TestBackendFailures(t *testing.T) {
+	backend := newMemBackend()
+	var reported atomic.Value
+	cache := NewCache(4, 100, time.Minute, WithBackend(backend), WithErrorHandler(func(err error) {
+		reported.Store(err)
+	}))
+	defer cache.Close()
+	cache.Store("key", "old", time.Minute)
+
+	errDown := errors.New("backend down")
+	backend.fail(errDown)
+
+	if _, ok, err := cache.FetchData("missing"); !errors.Is(err, ErrBackend) || !errors.Is(err, errDown) || ok {
+		t.Fatalf("Expected a wrapped backend error, got %v (%v)", err, ok)
+	}
+	if err := cache.Store("new", "value", time.Minute); !errors.Is(err, ErrBackend) {
+		t.Fatalf("Expected Store to fail, got %v", err)
+	}
+	if err := cache.Update("key", "new", time.Minute); !errors.Is(err, ErrBackend) {
+		t.Fatalf("Expected Update to fail, got %v", err)
+	}
+	if cache.Exists("new") {
+		t.Fatal("Expected a failed Store not to be cached")
+	}
+	if cache.Exists("key") {
+		t.Fatal("Expected a failed Update to drop the key")
+	}
+
+	cache.Delete("key")
+	if err, _ := reported.Load().(error); !errors.Is(err, ErrBackend) {
+		t.Fatalf("Expected the failed delete to be reported, got %v", err)
+	}
+}
+
+// testing that a slow save holds up neither readers nor writers of other
+// keys in the same shard
+func TestBackendCalledOutsideLock(t *testing.T) {
+	backend := newMemBackend()
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend))
+	defer cache.Close()
+	cache.Store("ready", "value", time.Minute)
+	backend.gate = make(chan struct{})
+
+	done := make(chan error, 2)
+	go func() { done <- cache.Store("slow", "value", time.Minute) }()
+	waitFor(t, func() bool { return cache.Exists("slow") })
+	go func() { done <- cache.Store("other", "value", time.Minute) }()
+	waitFor(t, func() bool { return cache.Exists("other") })
+	if value, ok, err := cache.FetchData("ready"); !ok || err != nil || value != "value" {
+		t.Fatalf("Expected a read during the save, got %v (%v, %v)", value, ok, err)
+	}
+	if n := cache.DeleteByPrefix("ready"); n != 1 {
+		t.Fatalf("Expected a delete during the save, got %d", n)
+	}
+
+	close(backend.gate)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Expected the stores to succeed, got %v", err)
+		}
+	}
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if _, ok := backend.data["ready"]; ok || len(backend.data) != 2 {
+		t.Fatalf("Expected slow and other in the backend, got %d keys", len(backend.data))
+	}
+}
+
+// testing that concurrent writes to a key reach the backend in the order the
+// cache applied them
+func TestBackendKeepsWriteOrder(t *testing.T) {
+	backend := newMemBackend()
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if (w+i)%3 == 0 {
+					cache.Delete("key")
+				} else {
+					cache.Store("key", w*1000+i, time.Minute)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	value, ok, _ := cache.Peek("key")
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	data, saved := backend.data["key"]
+	if ok != saved {
+		t.Fatalf("Expected the cache and the backend to agree, got %v and %v", ok, saved)
+	}
+	if want, _ := Serialize(value); ok && !bytes.Equal(data, want) {
+		t.Fatalf("Expected the backend to hold %v", value)
+	}
+}
+
+// testing that a loader and a backend are mutually exclusive
+func TestBackendWithLoader(t *testing.T) {
+	loader := func(string) (interface{}, time.Duration, error) { return nil, 0, nil }
+	if _, err := NewCacheWithOptions(WithLoader(loader), WithBackend(newMemBackend())); err == nil {
+		t.Fatal("Expected an error for a loader and a backend")
+	}
+}
+
+// testing that write-behind keeps the order of writes to a key and applies
+// pending writes on Close
+func TestWriteBehindOrderAndClose(t *testing.T) {
+	backend := newMemBackend()
+	backend.gate = make(chan struct{})
+	cache := NewCache(4, 1000, time.Minute, WithBackend(backend), WithWriteBehind(1000, 4, BlockWhenFull))
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		cache.Store(key, i, time.Minute)
+		if i%2 == 0 {
+			cache.Delete(key) // must not be overtaken by the save
+		}
+	}
+	if len(backend.data) != 0 {
+		t.Fatal("Expected writes to be queued, not applied")
+	}
+
+	close(backend.gate) // let the saves through
+	cache.Close()
+	if n := len(backend.data); n != 50 {
+		t.Fatalf("Expected 50 keys in the backend after Close, got %d", n)
+	}
+	for i := 1; i < 100; i += 2 {
+		if _, ok := backend.data["key"+strconv.Itoa(i)]; !ok {
+			t.Fatalf("Expected key%d in the backend", i)
+		}
+	}
+}
+
+// testing the policies for a full write-behind queue
+func TestWriteBehindQueueFull(t *testing.T) {
+	// The worker holds one write while the gate is closed, the queue another
+	backend := newMemBackend()
+	backend.gate = make(chan struct{})
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend), WithWriteBehind(1, 1, FailWhenFull))
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+		time.Sleep(time.Millisecond) // let the worker take the first write
+	}
+	if !errors.Is(err, ErrQueueFull) || cache.Exists("key2") {
+		t.Fatalf("Expected the third write to fail with ErrQueueFull, got %v", err)
+	}
+	close(backend.gate)
+	cache.Close()
+
+	backend = newMemBackend()
+	backend.gate = make(chan struct{})
+	var mu sync.Mutex
+	var dropped []string
+	cache = NewCache(1, 100, time.Minute, WithBackend(backend), WithWriteBehind(1, 1, DropOldestWhenFull),
+		WithFlushErrorHandler(func(key string, err error) {
+			mu.Lock()
+			dropped = append(dropped, key)
+			mu.Unlock()
+			if !errors.Is(err, ErrQueueFull) {
+				t.Errorf("Expected ErrQueueFull for %s, got %v", key, err)
+			}
+		}))
+	for i := 0; i < 3; i++ {
+		if err := cache.Store("key"+strconv.Itoa(i), i, time.Minute); err != nil {
+			t.Fatalf("Expected DropOldestWhenFull not to fail, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.gate)
+	cache.Close()
+	if len(dropped) != 1 || dropped[0] != "key1" {
+		t.Fatalf("Expected key1 to be dropped, got %v", dropped)
+	}
+	if _, ok := backend.data["key2"]; !ok || len(backend.data) != 2 {
+		t.Fatalf("Expected key0 and key2 in the backend, got %d keys", len(backend.data))
+	}
+}
+
+// testing that a write waiting for room in a full write-behind queue holds
+// up neither readers nor writers of other keys in the same shard
+func TestWriteBehindFullQueueOutsideLock(t *testing.T) {
+	backend := newMemBackend()
+	backend.gate = make(chan struct{})
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend), WithWriteBehind(1, 1, BlockWhenFull))
+
+	// The worker holds key0 while the gate is closed, the queue key1
+	cache.Store("key0", 0, time.Minute)
+	time.Sleep(time.Millisecond)
+	cache.Store("key1", 1, time.Minute)
+
+	done := make(chan error, 2)
+	for i := 2; i < 4; i++ {
+		go func() { done <- cache.Store("key"+strconv.Itoa(i), i, time.Minute) }()
+		waitFor(t, func() bool { return cache.Exists("key" + strconv.Itoa(i)) })
+	}
+	if value, ok, err := cache.FetchData("key0"); !ok || err != nil || value != int8(0) {
+		t.Fatalf("Expected a read while the queue is full, got %v (%v, %v)", value, ok, err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the writes to wait for room, got %v", err)
+	default:
+	}
+
+	close(backend.gate)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Expected the writes to be queued, got %v", err)
+		}
+	}
+	cache.Close()
+	if n := len(backend.data); n != 4 {
+		t.Fatalf("Expected 4 keys in the backend after Close, got %d", n)
+	}
+}
+
+// testing that Close fails the writes waiting for room in a full queue
+// rather than waiting for them
+func TestWriteBehindCloseWhileFull(t *testing.T) {
+	backend := newMemBackend()
+	backend.gate = make(chan struct{})
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend), WithWriteBehind(1, 1, BlockWhenFull))
+	cache.Store("key0", 0, time.Minute)
+	time.Sleep(time.Millisecond)
+	cache.Store("key1", 1, time.Minute)
+
+	done := make(chan error, 1)
+	go func() { done <- cache.Store("key2", 2, time.Minute) }()
+	waitFor(t, func() bool { return cache.Exists("key2") })
+	closed := make(chan struct{})
+	go func() {
+		cache.Close()
+		close(closed)
+	}()
+	if err := <-done; !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("Expected the waiting write to fail with ErrCacheClosed, got %v", err)
+	}
+	close(backend.gate)
+	<-closed
+	if _, ok := backend.data["key2"]; ok || len(backend.data) != 2 {
+		t.Fatalf("Expected key0 and key1 in the backend, got %d keys", len(backend.data))
+	}
+}
+
+// testing that backend failures during write-behind reach the handler
+func TestWriteBehindFlushError(t *testing.T) {
+	backend := newMemBackend()
+	errDown := errors.New("backend down")
+	backend.fail(errDown)
+	failed := make(chan string, 1)
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend), WithWriteBehind(10, 1, BlockWhenFull),
+		WithFlushErrorHandler(func(key string, err error) {
+			if errors.Is(err, ErrBackend) && errors.Is(err, errDown) {
+				failed <- key
+			}
+		}))
+
+	if err := cache.Store("key", "value", time.Minute); err != nil {
+		t.Fatalf("Expected Store to succeed before the flush, got %v", err)
+	}
+	cache.Close()
+	select {
+	case key := <-failed:
+		if key != "key" {
+			t.Fatalf("Expected the failure of key, got %s", key)
+		}
+	default:
+		t.Fatal("Expected the failed flush to be reported")
+	}
+
+	if _, err := NewCacheWithOptions(WithWriteBehind(10, 1, BlockWhenFull)); err == nil {
+		t.Fatal("Expected an error for write-behind without a backend")
+	}
+}
+
+// recordingBus is an InvalidationBus recording the keys published on it.
+type recordingBus struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (b *recordingBus) Publish(ev InvalidationEvent) error {
+	b.mu.Lock()
+	b.keys = append(b.keys, ev.Key)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *recordingBus) Subscribe(func(InvalidationEvent)) func() { return func() {} }
+
+func (b *recordingBus) published(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Contains(b.keys, key)
+}
+
+// testing that every public write reaches the backend, the invalidation bus
+// and the write log alike
+func TestWritesGoThroughEverySink(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(c *Cache) error
+		saved   []string // keys the backend must hold afterwards
+		deleted []string // keys the backend must not hold afterwards
+	}{
+		{"Store", func(c *Cache) error { return c.Store("k", 1, time.Minute) }, []string{"k"}, nil},
+		{"StoreWithCost", func(c *Cache) error { return c.StoreWithCost("k", 1, time.Minute, 2) }, []string{"k"}, nil},
+		{"StoreWithOptions", func(c *Cache) error {
+			return c.StoreWithOptions("k", 1, ItemOptions{Pinned: true, Tags: []string{"t"}})
+		}, []string{"k"}, nil},
+		{"StoreUntil", func(c *Cache) error { return c.StoreUntil("k", 1, time.Now().Add(time.Hour)) }, []string{"k"}, nil},
+		{"StoreTagged", func(c *Cache) error { return c.StoreTagged("k", 1, time.Minute, "t") }, []string{"k"}, nil},
+		{"StoreBytes", func(c *Cache) error { return c.StoreBytes("k", []byte("raw"), time.Minute) }, []string{"k"}, nil},
+		{"StoreGetEvicted", func(c *Cache) error { _, _, err := c.StoreGetEvicted("k", 1, time.Minute); return err }, []string{"k"}, nil},
+		{"StoreMany", func(c *Cache) error {
+			if errs := c.StoreMany(map[string]interface{}{"k": 1, "k2": 2}, time.Minute); errs != nil {
+				return errors.Join(slices.Collect(maps.Values(errs))...)
+			}
+			return nil
+		}, []string{"k", "k2"}, nil},
+		{"SetIfAbsent", func(c *Cache) error { _, err := c.SetIfAbsent("k", 1, time.Minute); return err }, []string{"k"}, nil},
+		{"GetSet", func(c *Cache) error { _, _, err := c.GetSet("existing", 1, time.Minute); return err }, []string{"existing"}, nil},
+		{"Update", func(c *Cache) error { return c.Update("existing", 1, time.Minute) }, []string{"existing"}, nil},
+		{"UpdateValue", func(c *Cache) error { return c.UpdateValue("existing", 1) }, []string{"existing"}, nil},
+		{"Increment", func(c *Cache) error { _, err := c.Increment("k", 1, time.Minute); return err }, []string{"k"}, nil},
+		{"IncrementExisting", func(c *Cache) error {
+			c.Increment("k", 1, time.Minute)
+			delete(c.backend.(*memBackend).data, "k")
+			_, err := c.Increment("k", 1, time.Minute)
+			return err
+		}, []string{"k"}, nil},
+		{"CompareAndSwap", func(c *Cache) error { _, err := c.CompareAndSwap("existing", "old", "new", time.Minute); return err }, []string{"existing"}, nil},
+		{"Copy", func(c *Cache) error { return c.Copy("existing", "k", time.Minute) }, []string{"k"}, nil},
+		{"Rename", func(c *Cache) error { return c.Rename("existing", "k") }, []string{"k"}, []string{"existing"}},
+		{"Merge", func(c *Cache) error {
+			src := NewCache(1, 10, time.Minute)
+			defer src.Close()
+			src.Store("k", 1, time.Minute)
+			return c.Merge(src, Overwrite)
+		}, []string{"k"}, nil},
+		{"TxnSet", func(c *Cache) error {
+			return c.Txn([]string{"k"}, func(tx *Tx) error { return tx.Set("k", 1, time.Minute) })
+		}, []string{"k"}, nil},
+		{"TxnDelete", func(c *Cache) error {
+			return c.Txn([]string{"existing"}, func(tx *Tx) error { return tx.Delete("existing") })
+		}, nil, []string{"existing"}},
+		{"Delete", func(c *Cache) error { c.Delete("existing"); return nil }, nil, []string{"existing"}},
+		{"DeleteMany", func(c *Cache) error { c.DeleteMany([]string{"existing"}); return nil }, nil, []string{"existing"}},
+		{"Pop", func(c *Cache) error { _, _, err := c.Pop("existing"); return err }, nil, []string{"existing"}},
+		{"DeleteByPrefix", func(c *Cache) error { c.DeleteByPrefix("exist"); return nil }, nil, []string{"existing"}},
+		{"InvalidateTag", func(c *Cache) error { c.InvalidateTag("tag"); return nil }, nil, []string{"existing"}},
+		{"CompareAndDelete", func(c *Cache) error { _, err := c.CompareAndDelete("existing", "old"); return err }, nil, []string{"existing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newMemBackend()
+			bus := &recordingBus{}
+			var log bytes.Buffer
+			cache := NewCache(1, 10, time.Minute,
+				WithBackend(backend),
+				WithInvalidationBus(bus, InvalidateOnStore|InvalidateOnUpdate|InvalidateOnDelete),
+				WithWriteLog(&log),
+			)
+			if err := cache.StoreTagged("existing", "old", time.Minute, "tag"); err != nil {
+				t.Fatal(err)
+			}
+			logged := log.Len()
+			waitFor(t, func() bool { return bus.published("existing") })
+			bus.mu.Lock()
+			bus.keys = nil
+			bus.mu.Unlock()
+
+			if err := tt.op(cache); err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+			cache.Close() // publishes what is queued
+			for _, key := range tt.saved {
+				val, ok := backend.data[key]
+				if !ok {
+					t.Fatalf("Expected %s to save %q to the backend", tt.name, key)
+				}
+				if _, err := cache.deserialize(val); err != nil {
+					t.Fatalf("Expected %q to be saved serialized, got %v", key, err)
+				}
+			}
+			for _, key := range tt.deleted {
+				if _, ok := backend.data[key]; ok {
+					t.Fatalf("Expected %s to delete %q from the backend", tt.name, key)
+				}
+			}
+			for _, key := range slices.Concat(tt.saved, tt.deleted) {
+				if !bus.published(key) {
+					t.Fatalf("Expected %s to publish %q", tt.name, key)
+				}
+			}
+			if log.Len() == logged {
+				t.Fatalf("Expected %s to append to the write log", tt.name)
+			}
+		})
+	}
+}
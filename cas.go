@@ -0,0 +1,99 @@
+package hoard
+
+import (
+	"bytes"
+	"time"
+)
+
+// CompareAndSwap replaces the value of key with new only if the current value
+// serializes to the same bytes as old, refreshing the ttl and the entry's
+// position in the eviction order. It reports false without an error when the
+// key is missing, expired or holds a different value. Entries stored with
+// StoreBytes match an old []byte with the same contents, counters written by
+// Increment match an integer with the same value.
+func (c *Cache) CompareAndSwap(key string, old, new interface{}, ttl time.Duration) (bool, error) {
+	if c.isClosed() {
+		return false, ErrCacheClosed
+	}
+	if err := c.checkKey(key); err != nil {
+		return false, err
+	}
+	shard := c.getShard(key)
+	exp := c.expiration(ttl)
+
+	oldVal, err := c.serialize(old)
+	if err != nil {
+		return false, err
+	}
+	newVal, err := c.serialize(new)
+	if err != nil {
+		return false, err
+	}
+	if err := c.checkValue(key, newVal); err != nil {
+		return false, err
+	}
+	stored, flags, err := c.pack(newVal, 0)
+	if err != nil {
+		return false, err
+	}
+
+	shard.mu.Lock()
+	item, ok := c.matchLocked(shard, key, old, oldVal)
+	if !ok {
+		shard.mu.Unlock()
+		return false, nil
+	}
+	if err := shard.mu.unlockErr(c.replaceLocked(shard, key, item, newVal, stored, flags, exp)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete removes key only if its current value serializes to the
+// same bytes as old, and reports whether it did. It follows the matching
+// rules of CompareAndSwap.
+func (c *Cache) CompareAndDelete(key string, old interface{}) (bool, error) {
+	if c.isClosed() {
+		return false, ErrCacheClosed
+	}
+	shard := c.getShard(key)
+
+	oldVal, err := c.serialize(old)
+	if err != nil {
+		return false, err
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, ok := c.matchLocked(shard, key, old, oldVal); !ok {
+		return false, nil
+	}
+	c.removeLocked(shard, key, c.now())
+	return true, nil
+}
+
+// matchLocked returns the live item stored under key if its value equals old,
+// whose serialized form is oldVal. The caller must hold shard.mu for writing.
+func (c *Cache) matchLocked(shard *CacheShard, key string, old interface{}, oldVal []byte) (*CacheItem, bool) {
+	item, ok := shard.data[key]
+	if !ok || item.expired(c.now()) {
+		return nil, false
+	}
+	v, err := c.unpack(shard.sharedView(item))
+	if err != nil {
+		c.reportError(err)
+		return item, false
+	}
+	switch {
+	case v.flags&itemNegative != 0:
+		return item, false
+	case v.flags&itemRaw != 0:
+		b, isBytes := old.([]byte)
+		return item, isBytes && bytes.Equal(v.value, b)
+	case v.flags&itemInt != 0:
+		n, err := c.intValue(itemView{value: oldVal})
+		return item, err == nil && n == decodeInt(v.value)
+	}
+	return item, bytes.Equal(v.value, oldVal)
+}
@@ -0,0 +1,83 @@
+package hoard
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing CompareAndSwap on matching, mismatching and missing keys
+func TestCompareAndSwap(t *testing.T) {
+	cache := NewCache(2, 10, time.Hour)
+	defer cache.Close()
+
+	cache.Store("version", 1, time.Minute)
+	if ok, err := cache.CompareAndSwap("version", 2, 3, time.Minute); err != nil || ok {
+		t.Fatalf("Expected a mismatch, got %v (%v)", ok, err)
+	}
+	if ok, err := cache.CompareAndSwap("version", 1, 2, time.Hour); err != nil || !ok {
+		t.Fatalf("Expected the swap to succeed, got %v (%v)", ok, err)
+	}
+	if value, _, _ := cache.FetchData("version"); value != int8(2) {
+		t.Fatalf("Expected 2, got %v", value)
+	}
+	if ttl, _ := cache.TTL("version"); ttl <= time.Minute {
+		t.Errorf("Expected the swap to refresh the TTL, got %v", ttl)
+	}
+	if ok, err := cache.CompareAndSwap("missing", 1, 2, time.Minute); err != nil || ok {
+		t.Fatalf("Expected false for a missing key, got %v (%v)", ok, err)
+	}
+
+	// Raw entries and counters
+	cache.StoreBytes("raw", []byte("abc"), time.Minute)
+	if ok, _ := cache.CompareAndSwap("raw", []byte("abc"), "def", time.Minute); !ok {
+		t.Error("Expected raw bytes to match")
+	}
+	cache.Increment("counter", 7, time.Minute)
+	if ok, _ := cache.CompareAndSwap("counter", 7, 8, time.Minute); !ok {
+		t.Error("Expected the counter to match")
+	}
+}
+
+// testing CompareAndDelete for lock markers
+func TestCompareAndDelete(t *testing.T) {
+	cache := NewCache(2, 10, time.Hour)
+	defer cache.Close()
+
+	cache.Store("lock", "owner-1", time.Minute)
+	if ok, err := cache.CompareAndDelete("lock", "owner-2"); err != nil || ok {
+		t.Fatalf("Expected a mismatch, got %v (%v)", ok, err)
+	}
+	if ok, err := cache.CompareAndDelete("lock", "owner-1"); err != nil || !ok {
+		t.Fatalf("Expected the delete to succeed, got %v (%v)", ok, err)
+	}
+	if cache.Exists("lock") {
+		t.Fatal("Expected the lock to be gone")
+	}
+}
+
+// testing that exactly one of two racing CompareAndSwap calls wins
+func TestCompareAndSwapRace(t *testing.T) {
+	cache := NewCache(4, 10, time.Hour)
+	defer cache.Close()
+
+	for round := 0; round < 200; round++ {
+		cache.Store("key", round, time.Minute)
+		var wins atomic.Int32
+		var wg sync.WaitGroup
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				if ok, _ := cache.CompareAndSwap("key", round, -1-g, time.Minute); ok {
+					wins.Add(1)
+				}
+			}(g)
+		}
+		wg.Wait()
+		if n := wins.Load(); n != 1 {
+			t.Fatalf("Round %d: expected exactly one winner, got %d", round, n)
+		}
+	}
+}
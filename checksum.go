@@ -0,0 +1,44 @@
+package hoard
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+// ErrCorrupted is returned when a value no longer matches the checksum taken
+// when it was stored, see WithChecksums. The entry is removed.
+var ErrCorrupted = errors.New("hoard: value corrupted")
+
+// castagnoli is hardware accelerated on amd64 and arm64, so checksumming a
+// value costs a fraction of a lookup.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func checksum(val []byte) uint32 {
+	return crc32.Checksum(val, castagnoli)
+}
+
+// setSum records the checksum of the value of item if the shard keeps them.
+// The caller must hold s.mu for writing.
+func (s *CacheShard) setSum(item *CacheItem) {
+	if s.checksums {
+		item.sum = checksum(s.value(item))
+	}
+}
+
+// verify checks a view returned by a lookup against its checksum. On a
+// mismatch the entry is removed, unless a write replaced it meanwhile, and
+// a view marked itemCorrupted is returned, which unpack turns into
+// ErrCorrupted.
+func (c *Cache) verify(shard *CacheShard, key string, v itemView) itemView {
+	if checksum(v.value) == v.sum {
+		return v
+	}
+	shard.mu.Lock()
+	if item, ok := shard.data[key]; ok && checksum(shard.value(item)) != item.sum {
+		shard.removeItem(key, item)
+		c.notify(OpDelete, key, nil)
+	}
+	shard.mu.Unlock()
+	shard.stats.corrupted.Add(1)
+	return itemView{flags: itemCorrupted}
+}
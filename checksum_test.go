@@ -0,0 +1,89 @@
+package hoard
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// corrupt flips a bit of the value stored under key in place.
+func corrupt(c *Cache, key string) {
+	shard := c.getShard(key)
+	shard.mu.Lock()
+	shard.data[key].Value[0] ^= 1
+	shard.mu.Unlock()
+}
+
+// testing that a corrupted value is detected, removed and counted
+func TestChecksums(t *testing.T) {
+	var reported error
+	cache := NewCache(2, 100, time.Minute, WithChecksums(),
+		WithErrorHandler(func(err error) { reported = err }))
+	defer cache.Close()
+
+	cache.Store("a", "value a", NoExpiration)
+	cache.StoreBytes("b", []byte("value b"), NoExpiration)
+	cache.Store("c", "value c", NoExpiration)
+	cache.Update("c", "updated c", NoExpiration)
+
+	if v, ok, err := cache.FetchData("c"); !ok || err != nil || v != "updated c" {
+		t.Fatalf("Expected an intact value to pass, got %v, %v", v, err)
+	}
+
+	corrupt(cache, "a")
+	if _, _, err := cache.FetchData("a"); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Expected ErrCorrupted, got %v", err)
+	}
+	if cache.Exists("a") {
+		t.Fatal("Expected the corrupted entry to be removed")
+	}
+
+	corrupt(cache, "b")
+	if b, ok := cache.FetchBytes("b"); ok || b != nil {
+		t.Fatalf("Expected FetchBytes to miss, got %q", b)
+	}
+	if !errors.Is(reported, ErrCorrupted) {
+		t.Fatalf("Expected ErrCorrupted to be reported, got %v", reported)
+	}
+
+	if n := cache.Stats().Corrupted; n != 2 {
+		t.Fatalf("Expected 2 corrupted entries, got %d", n)
+	}
+}
+
+// testing that Load skips records failing their checksum with WithChecksums
+// and aborts without it
+func TestChecksumsLoad(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 3; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)), NoExpiration)
+	}
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	snapshot := buf.Bytes()
+	i := bytes.Index(snapshot, []byte("value1"))
+	snapshot[i] ^= 1
+
+	plain := NewCache(1, 100, time.Minute)
+	defer plain.Close()
+	if err := plain.Load(bytes.NewReader(snapshot)); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+
+	checked := NewCache(1, 100, time.Minute, WithChecksums())
+	defer checked.Close()
+	if err := checked.Load(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Expected the bad record to be skipped, got %v", err)
+	}
+	if checked.Len() != 2 || checked.Exists("key1") {
+		t.Fatalf("Expected key0 and key2 only, got %d items", checked.Len())
+	}
+	if n := checked.Stats().Corrupted; n != 1 {
+		t.Fatalf("Expected 1 corrupted record, got %d", n)
+	}
+}
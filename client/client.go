@@ -0,0 +1,317 @@
+// Package client spreads keys over a fleet of hoard nodes served by
+// httpserver. Every key is routed to one node by a consistent-hash ring, so
+// adding or removing a node only moves a fraction of the keys. Reads that
+// fail on a key's node are retried on the next nodes of the ring, and an
+// optional health check takes unreachable nodes out of the ring until they
+// answer again.
+//
+// Nodes hold independent caches: a key written while its node was out of the
+// ring stays on the node that took it, and a read retried on another node
+// usually misses.
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+	"github.com/mrkouhadi/hoard/httpserver"
+)
+
+// ErrNoNodes is returned when the ring has no healthy node to route a key to.
+var ErrNoNodes = errors.New("client: no healthy nodes")
+
+// DefaultTimeout bounds each request unless changed with WithTimeout.
+const DefaultTimeout = time.Second
+
+// Client talks to a fleet of hoard HTTP nodes.
+type Client struct {
+	httpClient   *http.Client
+	timeout      time.Duration
+	readAttempts int
+	replicas     int
+	interval     time.Duration
+
+	mu    sync.RWMutex
+	nodes []string        // every configured node
+	down  map[string]bool // nodes taken out of the ring by the health check
+	ring  *ring
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout bounds every request to a node, including health checks.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithHTTPClient sets the http.Client requests are sent with. The default is
+// http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithReadAttempts sets how many distinct nodes Fetch tries before giving up
+// when requests fail. A miss is an answer and is not retried. The default
+// is 2.
+func WithReadAttempts(n int) Option {
+	return func(c *Client) {
+		c.readAttempts = n
+	}
+}
+
+// WithHealthCheck probes every node each interval and takes the ones that do
+// not answer out of the ring, adding them back once they do.
+func WithHealthCheck(interval time.Duration) Option {
+	return func(c *Client) {
+		c.interval = interval
+	}
+}
+
+// New returns a Client routing keys over nodes, the base URLs of httpserver
+// handlers such as "http://10.0.0.1:8080". replicas is the number of points
+// each node owns on the ring; more points spread keys more evenly, and 100
+// is a reasonable choice.
+func New(nodes []string, replicas int, opts ...Option) *Client {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	c := &Client{
+		httpClient:   http.DefaultClient,
+		timeout:      DefaultTimeout,
+		readAttempts: 2,
+		replicas:     replicas,
+		nodes:        slices.Clone(nodes),
+		down:         make(map[string]bool),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.rebuildLocked()
+	if c.interval > 0 {
+		c.wg.Add(1)
+		go c.healthLoop()
+	}
+	return c
+}
+
+// Close stops the health check. The Client must not be used afterwards.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.wg.Wait()
+	return nil
+}
+
+// AddNode adds node to the ring.
+func (c *Client) AddNode(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.nodes, node) {
+		c.nodes = append(c.nodes, node)
+		c.rebuildLocked()
+	}
+}
+
+// RemoveNode removes node from the ring.
+func (c *Client) RemoveNode(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i := slices.Index(c.nodes, node); i >= 0 {
+		c.nodes = slices.Delete(c.nodes, i, i+1)
+		delete(c.down, node)
+		c.rebuildLocked()
+	}
+}
+
+// Nodes returns the nodes currently in the ring.
+func (c *Client) Nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var live []string
+	for _, node := range c.nodes {
+		if !c.down[node] {
+			live = append(live, node)
+		}
+	}
+	return live
+}
+
+// NodeFor returns the node key is routed to.
+func (c *Client) NodeFor(key string) (string, error) {
+	nodes := c.route(key, 1)
+	if len(nodes) == 0 {
+		return "", ErrNoNodes
+	}
+	return nodes[0], nil
+}
+
+// Store stores value under key on its node. A ttl <= 0 uses the node's
+// default.
+func (c *Client) Store(key string, value []byte, ttl time.Duration) error {
+	node, err := c.NodeFor(key)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if ttl > 0 {
+		header.Set(httpserver.TTLHeader, ttl.String())
+	}
+	status, _, err := c.do(node, http.MethodPut, "/cache/"+url.PathEscape(key), value, header)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		return statusError(node, status)
+	}
+	return nil
+}
+
+// Fetch returns the bytes stored under key, or an error wrapping
+// hoard.ErrKeyNotFound on a miss. If the key's node fails, the next nodes of
+// the ring are tried, up to the attempts set with WithReadAttempts.
+func (c *Client) Fetch(key string) ([]byte, error) {
+	nodes := c.route(key, c.readAttempts)
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+	var errs []error
+	for _, node := range nodes {
+		status, body, err := c.do(node, http.MethodGet, "/cache/"+url.PathEscape(key), nil, nil)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case status == http.StatusOK:
+			return body, nil
+		case status == http.StatusNotFound:
+			return nil, fmt.Errorf("%w: %s", hoard.ErrKeyNotFound, key)
+		default:
+			errs = append(errs, statusError(node, status))
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Delete removes key from its node and reports whether it was there.
+func (c *Client) Delete(key string) (bool, error) {
+	node, err := c.NodeFor(key)
+	if err != nil {
+		return false, err
+	}
+	status, _, err := c.do(node, http.MethodDelete, "/cache/"+url.PathEscape(key), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, statusError(node, status)
+	}
+}
+
+func (c *Client) route(key string, n int) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.lookup(key, n)
+}
+
+func (c *Client) rebuildLocked() {
+	var live []string
+	for _, node := range c.nodes {
+		if !c.down[node] {
+			live = append(live, node)
+		}
+	}
+	c.ring = newRing(c.replicas, live)
+}
+
+// do sends one request to node and returns the status and body.
+func (c *Client) do(node, method, path string, body []byte, header http.Header) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, node+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("client: %s: %w", node, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("client: %s: %w", node, err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+func statusError(node string, status int) error {
+	return fmt.Errorf("client: %s: unexpected status %d", node, status)
+}
+
+func (c *Client) healthLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.CheckHealth()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// CheckHealth probes every node once, taking the ones that do not answer out
+// of the ring and adding back the ones that do. WithHealthCheck calls it
+// periodically.
+func (c *Client) CheckHealth() {
+	c.mu.RLock()
+	nodes := slices.Clone(c.nodes)
+	c.mu.RUnlock()
+
+	down := make(map[string]bool)
+	for _, node := range nodes {
+		status, _, err := c.do(node, http.MethodGet, "/stats", nil, nil)
+		if err != nil || status != http.StatusOK {
+			down[node] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := false
+	for _, node := range c.nodes {
+		if c.down[node] != down[node] {
+			changed = true
+		}
+	}
+	if changed {
+		c.down = down
+		c.rebuildLocked()
+	}
+}
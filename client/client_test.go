@@ -0,0 +1,153 @@
+package client
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+	"github.com/mrkouhadi/hoard/httpserver"
+)
+
+// newFleet starts n fake nodes, each serving its own cache.
+func newFleet(t *testing.T, n int) ([]*httptest.Server, []string) {
+	t.Helper()
+	var servers []*httptest.Server
+	var urls []string
+	for i := 0; i < n; i++ {
+		cache := hoard.NewCache(4, 1000, 0)
+		srv := httptest.NewServer(httpserver.New(cache))
+		t.Cleanup(func() {
+			srv.Close()
+			cache.Close()
+		})
+		servers = append(servers, srv)
+		urls = append(urls, srv.URL)
+	}
+	return servers, urls
+}
+
+// testing store, fetch and delete routed over several nodes
+func TestClient(t *testing.T) {
+	_, urls := newFleet(t, 3)
+	c := New(urls, 50)
+	defer c.Close()
+
+	used := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := c.Store(key, []byte(key), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		node, _ := c.NodeFor(key)
+		used[node] = true
+	}
+	if len(used) != 3 {
+		t.Fatalf("Expected keys on every node, got %d nodes", len(used))
+	}
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if v, err := c.Fetch(key); err != nil || string(v) != key {
+			t.Fatalf("Expected %s, got %q, %v", key, v, err)
+		}
+	}
+
+	if ok, err := c.Delete("key0"); !ok || err != nil {
+		t.Fatalf("Expected key0 to be deleted, got %v, %v", ok, err)
+	}
+	if ok, err := c.Delete("key0"); ok || err != nil {
+		t.Fatalf("Expected key0 to be gone, got %v, %v", ok, err)
+	}
+	if _, err := c.Fetch("key0"); !errors.Is(err, hoard.ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+// testing that reads fail over to the next node and that the health check
+// ejects and restores a dead node
+func TestClientFailover(t *testing.T) {
+	servers, urls := newFleet(t, 3)
+	c := New(urls, 50, WithTimeout(200*time.Millisecond))
+	defer c.Close()
+
+	key := "key"
+	owner, _ := c.NodeFor(key)
+	next := c.route(key, 2)[1]
+	var dead *httptest.Server
+	for _, srv := range servers {
+		if srv.URL == owner {
+			dead = srv
+		}
+	}
+
+	// Plant the key on the node a failed read moves on to
+	c.RemoveNode(owner)
+	if n, _ := c.NodeFor(key); n != next {
+		t.Fatalf("Expected %s to move to %s, got %s", key, next, n)
+	}
+	if err := c.Store(key, []byte("value"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	c.AddNode(owner)
+
+	// Take the owner down without the client noticing
+	dead.Config.SetKeepAlivesEnabled(false)
+	dead.CloseClientConnections()
+	dead.Listener.Close()
+
+	if v, err := c.Fetch(key); err != nil || string(v) != "value" {
+		t.Fatalf("Expected the read to fail over, got %q, %v", v, err)
+	}
+	if err := c.Store(key, []byte("value"), time.Minute); err == nil {
+		t.Fatal("Expected a write to the dead owner to fail")
+	}
+	c2 := New(urls, 50, WithTimeout(200*time.Millisecond), WithReadAttempts(1))
+	defer c2.Close()
+	if _, err := c2.Fetch(key); err == nil || errors.Is(err, hoard.ErrKeyNotFound) {
+		t.Fatalf("Expected a failed read without retries, got %v", err)
+	}
+
+	c.CheckHealth()
+	if nodes := c.Nodes(); len(nodes) != 2 {
+		t.Fatalf("Expected the dead node to be ejected, got %v", nodes)
+	}
+	if n, _ := c.NodeFor(key); n != next {
+		t.Fatalf("Expected %s to be routed to %s, got %s", key, next, n)
+	}
+	if err := c.Store(key, []byte("again"), time.Minute); err != nil {
+		t.Fatalf("Expected writes to go to the remaining nodes, got %v", err)
+	}
+}
+
+// testing that the health check loop runs in the background
+func TestClientHealthLoop(t *testing.T) {
+	servers, urls := newFleet(t, 2)
+	c := New(urls, 50, WithTimeout(100*time.Millisecond), WithHealthCheck(10*time.Millisecond))
+	defer c.Close()
+
+	servers[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.Nodes()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the dead node to be ejected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if c.Nodes()[0] != urls[1] {
+		t.Fatalf("Expected %s to remain, got %v", urls[1], c.Nodes())
+	}
+}
+
+// testing that an empty ring reports ErrNoNodes
+func TestClientNoNodes(t *testing.T) {
+	c := New(nil, 10)
+	defer c.Close()
+	if _, err := c.Fetch("key"); !errors.Is(err, ErrNoNodes) {
+		t.Fatalf("Expected ErrNoNodes, got %v", err)
+	}
+	if err := c.Store("key", nil, 0); !errors.Is(err, ErrNoNodes) {
+		t.Fatalf("Expected ErrNoNodes, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// ring is a consistent-hash ring: every node owns replicas points on a
+// 32-bit circle, and a key belongs to the node owning the first point at or
+// after the key's hash. Adding or removing a node only moves the keys whose
+// first point it gains or loses, about 1/n of them.
+type ring struct {
+	replicas int
+	points   []uint32
+	owners   map[uint32]string
+}
+
+func newRing(replicas int, nodes []string) *ring {
+	r := &ring{replicas: replicas, owners: make(map[uint32]string, replicas*len(nodes))}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			p := hashKey(node + "#" + strconv.Itoa(i))
+			// On a collision the lexically smaller node keeps the point, so
+			// the ring does not depend on the order nodes were added in
+			if owner, ok := r.owners[p]; ok && owner < node {
+				continue
+			}
+			r.owners[p] = node
+		}
+	}
+	r.points = make([]uint32, 0, len(r.owners))
+	for p := range r.owners {
+		r.points = append(r.points, p)
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+// lookup returns up to n distinct nodes for key, its owner first and then
+// the nodes met walking the ring clockwise.
+func (r *ring) lookup(key string, n int) []string {
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+	h := hashKey(key)
+	i, _ := slices.BinarySearch(r.points, h)
+	nodes := make([]string, 0, n)
+	for j := 0; j < len(r.points) && len(nodes) < n; j++ {
+		node := r.owners[r.points[(i+j)%len(r.points)]]
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// hashKey hashes s with FNV-1a followed by the murmur3 finalizer, since
+// FNV-1a alone leaves keys differing in their last bytes close together on
+// the ring.
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
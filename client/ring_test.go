@@ -0,0 +1,55 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+// testing that adding or removing a node only remaps the keys it gains or
+// loses
+func TestRingRemapping(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	before := newRing(100, nodes)
+	after := newRing(100, append(nodes, "e"))
+
+	const keys = 10000
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		from, to := before.lookup(key, 1)[0], after.lookup(key, 1)[0]
+		if from != to {
+			moved++
+			if to != "e" {
+				t.Fatalf("Expected %s to move to the new node, it moved from %s to %s", key, from, to)
+			}
+		}
+	}
+	// The new node should take about a fifth of the keys
+	if moved < keys/10 || moved > keys*3/10 {
+		t.Fatalf("Expected about %d keys to move, %d did", keys/5, moved)
+	}
+
+	// Removing the node again restores the original placement
+	removed := newRing(100, nodes)
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		if before.lookup(key, 1)[0] != removed.lookup(key, 1)[0] {
+			t.Fatalf("Expected %s to return to its node", key)
+		}
+	}
+}
+
+// testing that lookup returns distinct nodes, owner first
+func TestRingLookup(t *testing.T) {
+	r := newRing(10, []string{"a", "b", "c"})
+	nodes := r.lookup("key", 5)
+	if len(nodes) != 3 {
+		t.Fatalf("Expected every node once, got %v", nodes)
+	}
+	if nodes[0] != r.lookup("key", 1)[0] {
+		t.Fatalf("Expected the owner first, got %v", nodes)
+	}
+	if got := newRing(10, nil).lookup("key", 1); got != nil {
+		t.Fatalf("Expected no node on an empty ring, got %v", got)
+	}
+}
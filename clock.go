@@ -0,0 +1,32 @@
+package hoard
+
+import "time"
+
+// Clock tells the cache the current time. Every expiration decision goes
+// through it, so tests can inject a fake clock and advance time instantly
+// instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock, backed by time.Now.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// now returns the cache's current time in unix nanoseconds.
+func (c *Cache) now() int64 {
+	return c.clock.Now().UnixNano()
+}
+
+// RunCleanup removes expired entries from every shard synchronously, as one
+// tick of the background cleanup would. It is useful with a fake Clock or
+// when background cleanup is disabled.
+func (c *Cache) RunCleanup() {
+	start := time.Now()
+	removed := 0
+	for _, shard := range c.shards {
+		removed += c.cleanupShard(shard)
+	}
+	c.logCleanup(removed, start)
+}
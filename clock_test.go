@@ -0,0 +1,83 @@
+package hoard
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// testing that expiration follows the injected clock
+func TestFakeClockExpiration(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("key", "value", time.Minute)
+	if exp, _ := cache.ExpiresAt("key"); !exp.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("Expected the deadline to follow the clock, got %v", exp)
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := cache.FetchBytesData("key"); !ok {
+		t.Fatal("Expected the entry to live until its deadline")
+	}
+	clock.Advance(time.Nanosecond)
+	if _, ok := cache.FetchBytesData("key"); ok {
+		t.Fatal("Expected the entry to expire past its deadline")
+	}
+}
+
+// testing that RunCleanup removes expired entries from every shard
+func TestRunCleanup(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(4, 100, 0, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("short1", 1, time.Second)
+	cache.Store("short2", 2, time.Second)
+	cache.Store("long", 3, time.Hour)
+	cache.Store("forever", 4, NoExpiration)
+
+	cache.RunCleanup()
+	if n := cache.Len(); n != 4 {
+		t.Fatalf("Expected nothing to be removed yet, got %d items", n)
+	}
+
+	clock.Advance(time.Minute)
+	cache.RunCleanup()
+	if n := cache.Len(); n != 2 || !cache.Exists("long") || !cache.Exists("forever") {
+		t.Fatalf("Expected only the short-lived entries to be removed, got %d items", n)
+	}
+	if removed := cache.Stats().CleanupRemovals; removed != 2 {
+		t.Fatalf("Expected 2 cleanup removals, got %d", removed)
+	}
+}
+
+// testing that a nil clock is rejected
+func TestNilClock(t *testing.T) {
+	if _, err := NewCacheWithOptions(WithClock(nil)); err == nil {
+		t.Fatal("Expected an error for a nil clock")
+	}
+}
@@ -0,0 +1,111 @@
+package hoard
+
+import "slices"
+
+// Clone returns an independent copy of the cache holding its live entries,
+// for reading at leisure without holding locks or seeing later writes. The
+// copy has the same settings, including the current size set by Resize, and
+// its own cleanup goroutine; it must be closed like any other cache. It is
+// not attached to the backend, tier, write log, invalidation bus, snapshot
+// schedule or memory watcher of the original, and has no watchers.
+//
+// Each shard is copied under its read lock in turn, so the copy of a shard
+// is consistent but writes made to other shards while Clone runs may or may
+// not be included. Values are copied, and entries keep their expiration,
+// tags, pins and access metadata, and under LRU and FIFO their order.
+func (c *Cache) Clone() *Cache {
+	c.shards[0].mu.RLock()
+	capacity := c.shards[0].capacity
+	c.shards[0].mu.RUnlock()
+
+	clone, err := NewCacheWithOptions(func(n *Cache) { n.copySettings(c, capacity) })
+	if err != nil {
+		// The settings were validated when c was created
+		panic(err)
+	}
+	for i, shard := range c.shards {
+		clone.cloneShard(clone.shards[i], shard)
+	}
+	return clone
+}
+
+// copySettings copies the settings of src that do not tie a cache to outside
+// resources, with capacity entries per shard.
+func (c *Cache) copySettings(src *Cache, capacity int) {
+	c.numShards = src.numShards
+	c.maxItemsPerShard = capacity
+	c.initialCapacity = src.initialCapacity
+	c.maxItems = src.maxItems
+	c.admission = src.admission
+	c.maxKeyLength = src.maxKeyLength
+	c.maxValueSize = src.maxValueSize
+	c.cleanupInterval = src.cleanupInterval
+	c.defaultTTL = src.defaultTTL
+	c.hash = src.hash
+	c.shardFn = src.shardFn
+	c.evictionPolicy = src.evictionPolicy
+	c.policySet = src.policySet
+	c.segmentRatio = src.segmentRatio
+	c.lruSamples = src.lruSamples
+	c.sliding = src.sliding
+	c.slidingItems.Store(src.slidingItems.Load())
+	c.clock = src.clock
+	c.ttlJitter = src.ttlJitter
+	c.cleanupBatchSize = src.cleanupBatchSize
+	c.cleanupTimeSlice = src.cleanupTimeSlice
+	c.iterateWorkers = src.iterateWorkers
+	c.maxBytes = src.maxBytes
+	c.maxCost = src.maxCost
+	if src.hot != nil {
+		c.hot = &hotKeys{n: src.hot.n, window: src.hot.window}
+	}
+	c.accessMeta = src.accessMeta
+	c.latency = src.latency.clone()
+	c.logger = src.logger
+	c.readOptimized = src.readOptimized
+	c.arenaStorage = src.arenaStorage
+	c.loader = src.loader
+	c.refreshAhead = src.refreshAhead
+	c.onError = src.onError
+	c.serializer = src.serializer
+	c.codec = src.codec
+	c.compressMin = src.compressMin
+	c.encryptionKey = src.encryptionKey
+	c.checksums = src.checksums
+}
+
+// cloneShard copies the live entries of src into the empty shard dst of c,
+// oldest first so list based policies rebuild the same order.
+func (c *Cache) cloneShard(dst, src *CacheShard) {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	now := c.now()
+	copyItem := func(item *CacheItem) {
+		if item.expired(now) {
+			return
+		}
+		clone := cacheItemPool.Get().(*CacheItem)
+		clone.Expiration = item.Expiration
+		clone.flags = item.flags
+		clone.sum = item.sum
+		clone.cost = item.cost
+		clone.ttl = item.ttl
+		clone.pinned = item.pinned
+		clone.sliding = item.sliding
+		clone.created = item.created
+		clone.lastUsed.Store(item.lastUsed.Load())
+		clone.accessed.Store(item.accessed.Load())
+		clone.hits.Store(item.hits.Load())
+		dst.linkItem(item.key, clone, slices.Clone(src.value(item)))
+		dst.tag(clone, item.tags)
+	}
+	src.policy.walk(copyItem)
+	for _, item := range src.data {
+		if item.pinned {
+			copyItem(item)
+		}
+	}
+}
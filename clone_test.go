@@ -0,0 +1,75 @@
+package hoard
+
+import (
+	"testing"
+	"time"
+)
+
+// testing that a clone holds the live entries and is independent of the
+// original in both directions
+func TestClone(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(4, 100, time.Minute, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("a", "one", NoExpiration)
+	cache.StoreBytes("raw", []byte("bytes"), NoExpiration)
+	cache.StoreTagged("tagged", 1, time.Minute, "t")
+	cache.StoreWithOptions("pinned", 2, ItemOptions{TTL: NoExpiration, Pinned: true})
+	cache.Store("gone", 3, time.Second)
+	clock.Advance(2 * time.Second)
+
+	clone := cache.Clone()
+	defer clone.Close()
+	if clone.Len() != 4 || clone.Exists("gone") {
+		t.Fatalf("Expected the 4 live entries, got %v", clone.Keys())
+	}
+
+	cache.Store("a", "changed", NoExpiration)
+	cache.Delete("raw")
+	cache.Store("new", 4, NoExpiration)
+	if v, _, _ := clone.FetchData("a"); v != "one" {
+		t.Fatalf("Expected the clone to keep its value, got %v", v)
+	}
+	if b, ok := clone.FetchBytes("raw"); !ok || string(b) != "bytes" || clone.Exists("new") {
+		t.Fatal("Expected writes to the original not to reach the clone")
+	}
+
+	clone.Store("a", "clone", NoExpiration)
+	clone.Store("only", 5, NoExpiration)
+	if v, _, _ := cache.FetchData("a"); v != "changed" || cache.Exists("only") {
+		t.Fatal("Expected writes to the clone not to reach the original")
+	}
+
+	// Expirations, tags and pins carry over
+	if n := clone.InvalidateTag("t"); n != 1 {
+		t.Fatalf("Expected the tag to be copied, got %d", n)
+	}
+	info, _ := clone.Metadata("pinned")
+	if !info.ExpiresAt.IsZero() || !clone.shards[clone.ShardForKey("pinned")].data["pinned"].pinned {
+		t.Fatal("Expected the pin to be copied")
+	}
+	clone.Store("short", 6, time.Second)
+	clock.Advance(2 * time.Second)
+	if clone.Exists("short") {
+		t.Fatal("Expected the clone to use the same clock")
+	}
+}
+
+// testing that the clone keeps the recency order and the current size
+func TestCloneOrder(t *testing.T) {
+	cache := NewCache(1, 10, time.Minute)
+	defer cache.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Store(key, key, NoExpiration)
+	}
+	cache.FetchData("a")
+	cache.Resize(4)
+
+	clone := cache.Clone()
+	defer clone.Close()
+	clone.Store("e", "e", NoExpiration)
+	if clone.Exists("b") || !clone.Exists("a") || clone.Len() != 4 {
+		t.Fatalf("Expected b, the least recently used entry, to be evicted, got %v", clone.Keys())
+	}
+}
@@ -0,0 +1,258 @@
+// Command hoardctl inspects hoard snapshot files and caches served by the
+// httpserver package:
+//
+//	hoardctl keys <snapshot>              lists the keys of a snapshot
+//	hoardctl get [-raw] <snapshot> <key>  prints the value of one key
+//	hoardctl stats <url>                  prints the stats of a served cache
+//	hoardctl delete <url> <key>           removes a key from a served cache
+//	hoardctl flush [-shard N] <url>       empties a served cache, or one shard
+//
+// Snapshots are read with hoard.ReadSnapshot. get decodes values the way a
+// cache with the default serializer would and prints strings and raw bytes
+// as they are and anything else as JSON; -raw prints the stored bytes
+// instead, which is the only way to read values saved compressed or
+// encrypted.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+const usage = `usage:
+	hoardctl keys <snapshot>
+	hoardctl get [-raw] <snapshot> <key>
+	hoardctl stats <url>
+	hoardctl delete <url> <key>
+	hoardctl flush [-shard N] <url>
+`
+
+// errUsage reports bad arguments; run prints the usage and exits with 2.
+var errUsage = errors.New("usage")
+
+// httpClient is used by the remote commands.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command in args and returns the exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "keys":
+		err = keys(args, stdout)
+	case "get":
+		err = get(args, stdout)
+	case "stats":
+		err = stats(args, stdout)
+	case "delete":
+		err = remove(args)
+	case "flush":
+		err = flush(args)
+	default:
+		err = errUsage
+	}
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprint(stderr, usage)
+		return 2
+	case err != nil:
+		fmt.Fprintln(stderr, "hoardctl:", err)
+		return 1
+	}
+	return 0
+}
+
+// newFlagSet returns a flag set reporting errors through run.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// readSnapshot calls fn for every entry of the snapshot file at path.
+func readSnapshot(path string, fn func(e hoard.SnapshotEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return hoard.ReadSnapshot(f, fn)
+}
+
+func keys(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	type entry struct {
+		key     string
+		expires time.Time
+		size    int
+	}
+	var entries []entry
+	err := readSnapshot(args[0], func(e hoard.SnapshotEntry) error {
+		entries = append(entries, entry{e.Key, e.Expiration, len(e.Value)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tEXPIRES\tSIZE")
+	for _, e := range entries {
+		expires := "never"
+		if !e.expires.IsZero() {
+			expires = e.expires.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%q\t%s\t%d\n", e.key, expires, e.size)
+	}
+	return tw.Flush()
+}
+
+func get(args []string, stdout io.Writer) error {
+	fs := newFlagSet("get")
+	raw := fs.Bool("raw", false, "print the stored bytes")
+	if fs.Parse(args) != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+	path, key := fs.Arg(0), fs.Arg(1)
+	var found *hoard.SnapshotEntry
+	err := readSnapshot(path, func(e hoard.SnapshotEntry) error {
+		if e.Key == key {
+			found = &e
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return fmt.Errorf("%q not found in %s", key, path)
+	}
+	if *raw {
+		_, err := stdout.Write(found.Value)
+		return err
+	}
+	v, err := found.Decode()
+	if err != nil {
+		return fmt.Errorf("%q: %w (use -raw for the stored bytes)", key, err)
+	}
+	return printValue(stdout, v)
+}
+
+// printValue writes strings and byte slices as they are and other values as
+// indented JSON, falling back to Go syntax for values JSON cannot hold.
+func printValue(w io.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		_, err := fmt.Fprintln(w, v)
+		return err
+	case []byte:
+		_, err := w.Write(v)
+		return err
+	}
+	text, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		_, err := fmt.Fprintf(w, "%#v\n", v)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", text)
+	return err
+}
+
+func stats(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	body, err := call(http.MethodGet, args[0], "/stats", nil)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(stdout)
+	return err
+}
+
+func remove(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	_, err := call(http.MethodDelete, args[0], "/cache/"+url.PathEscape(args[1]), nil)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("%q not found", args[1])
+	}
+	return err
+}
+
+func flush(args []string) error {
+	fs := newFlagSet("flush")
+	shard := fs.Int("shard", -1, "only empty this shard")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	query := url.Values{}
+	if *shard >= 0 {
+		query.Set("shard", fmt.Sprint(*shard))
+	}
+	_, err := call(http.MethodPost, fs.Arg(0), "/flush", query)
+	return err
+}
+
+// errNotFound is returned by call on a 404 response.
+var errNotFound = errors.New("not found")
+
+// call sends a request to the server at base and returns the body of a
+// successful response.
+func call(method, base, path string, query url.Values) ([]byte, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u = u.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
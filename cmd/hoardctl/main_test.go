@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+	"github.com/mrkouhadi/hoard/httpserver"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// fixedClock is a hoard.Clock stopped at a fixed time, so snapshots and
+// their listings are the same on every run.
+type fixedClock struct{}
+
+func (fixedClock) Now() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+// writeSnapshot saves a cache holding one value of each kind and returns
+// the path of the file.
+func writeSnapshot(t *testing.T) string {
+	t.Helper()
+	cache := hoard.NewCache(1, 100, time.Minute, hoard.WithClock(fixedClock{}))
+	defer cache.Close()
+	cache.Store("name", "hoard", time.Hour)
+	cache.Store("user", map[string]interface{}{"id": 7, "roles": []string{"admin", "dev"}}, hoard.NoExpiration)
+	cache.StoreBytes("raw", []byte("raw bytes\n"), hoard.NoExpiration)
+	cache.Increment("hits", 42, hoard.NoExpiration)
+	cache.Store("key with spaces", 3.5, 24*time.Hour)
+
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	if err := cache.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	return path
+}
+
+// runCmd runs hoardctl with args and returns its exit status and output.
+func runCmd(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// checkGolden compares got with testdata/name.golden, rewriting the file
+// instead with -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("Output differs from %s:\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// testing the snapshot commands against golden files
+func TestSnapshotCommands(t *testing.T) {
+	path := writeSnapshot(t)
+	cases := []struct {
+		golden string
+		args   []string
+	}{
+		{"keys", []string{"keys", path}},
+		{"get_string", []string{"get", path, "name"}},
+		{"get_map", []string{"get", path, "user"}},
+		{"get_raw_entry", []string{"get", path, "raw"}},
+		{"get_counter", []string{"get", path, "hits"}},
+		{"get_float", []string{"get", path, "key with spaces"}},
+		{"get_raw_flag", []string{"get", "-raw", path, "name"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.golden, func(t *testing.T) {
+			code, stdout, stderr := runCmd(tc.args...)
+			if code != 0 {
+				t.Fatalf("Expected success, got %d: %s", code, stderr)
+			}
+			checkGolden(t, tc.golden, stdout)
+		})
+	}
+}
+
+// testing the errors of the snapshot commands
+func TestSnapshotCommandErrors(t *testing.T) {
+	path := writeSnapshot(t)
+	if code, _, stderr := runCmd("get", path, "missing"); code != 1 || !strings.Contains(stderr, `"missing" not found`) {
+		t.Fatalf("Expected a missing key to fail, got %d: %s", code, stderr)
+	}
+	if code, _, _ := runCmd("keys", filepath.Join(t.TempDir(), "none")); code != 1 {
+		t.Fatalf("Expected a missing file to fail, got %d", code)
+	}
+	bad := filepath.Join(t.TempDir(), "bad")
+	os.WriteFile(bad, []byte("not a snapshot"), 0o644)
+	if code, _, stderr := runCmd("keys", bad); code != 1 || !strings.Contains(stderr, "snapshot") {
+		t.Fatalf("Expected a malformed file to fail, got %d: %s", code, stderr)
+	}
+	for _, args := range [][]string{nil, {"keys"}, {"get", path}, {"get", "-bogus", path, "name"}, {"nope"}} {
+		if code, _, stderr := runCmd(args...); code != 2 || !strings.HasPrefix(stderr, "usage:") {
+			t.Fatalf("Expected usage for %q, got %d: %s", args, code, stderr)
+		}
+	}
+
+	// Compressed values are only readable with -raw
+	cache := hoard.NewCache(1, 100, time.Minute, hoard.WithCompression(hoard.NewSnappyCodec(), 0))
+	defer cache.Close()
+	cache.Store("packed", strings.Repeat("a", 100), hoard.NoExpiration)
+	packed := filepath.Join(t.TempDir(), "packed.snap")
+	if err := cache.SaveFile(packed); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if code, _, stderr := runCmd("get", packed, "packed"); code != 1 || !strings.Contains(stderr, "-raw") {
+		t.Fatalf("Expected the compressed value to fail, got %d: %s", code, stderr)
+	}
+	if code, stdout, _ := runCmd("get", "-raw", packed, "packed"); code != 0 || stdout == "" {
+		t.Fatalf("Expected the stored bytes with -raw, got %d", code)
+	}
+}
+
+// testing the remote commands against a served cache
+func TestRemoteCommands(t *testing.T) {
+	cache := hoard.NewCache(4, 100, time.Minute)
+	defer cache.Close()
+	srv := httptest.NewServer(httpserver.New(cache))
+	defer srv.Close()
+
+	cache.StoreBytes("a", []byte("1"), hoard.NoExpiration)
+	cache.StoreBytes("dir/b", []byte("2"), hoard.NoExpiration)
+	cache.StoreBytes("c", []byte("3"), hoard.NoExpiration)
+	cache.FetchBytes("a")
+
+	code, stdout, stderr := runCmd("stats", srv.URL)
+	if code != 0 || !strings.Contains(stdout, `"Hits": 1`) {
+		t.Fatalf("Expected the stats, got %d: %s%s", code, stdout, stderr)
+	}
+
+	if code, _, stderr := runCmd("delete", srv.URL+"/", "dir/b"); code != 0 || cache.Exists("dir/b") {
+		t.Fatalf("Expected the key to be deleted, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := runCmd("delete", srv.URL, "dir/b"); code != 1 || !strings.Contains(stderr, "not found") {
+		t.Fatalf("Expected a missing key to fail, got %d: %s", code, stderr)
+	}
+
+	shard := cache.ShardForKey("a")
+	if code, _, stderr := runCmd("flush", "-shard", "9", srv.URL); code != 1 || !strings.Contains(stderr, "400") {
+		t.Fatalf("Expected an invalid shard to fail, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := runCmd("flush", "-shard", strconv.Itoa(shard), srv.URL); code != 0 || cache.Exists("a") {
+		t.Fatalf("Expected the shard to be flushed, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := runCmd("flush", srv.URL); code != 0 || cache.Len() != 0 {
+		t.Fatalf("Expected the cache to be flushed, got %d: %s", code, stderr)
+	}
+
+	if code, _, _ := runCmd("stats", "http://127.0.0.1:1"); code != 1 {
+		t.Fatalf("Expected an unreachable server to fail, got %d", code)
+	}
+}
@@ -0,0 +1,118 @@
+package hoard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Codec compresses values stored by a cache configured with WithCompression.
+// Implementations must be safe for concurrent use. Decompress must accept any
+// output of Compress, including output written by another process using the
+// same codec, since compressed entries persist through snapshots and the
+// write-ahead log.
+type Codec interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// NewSnappyCodec returns a Codec using the snappy block format. It is fast
+// and compresses moderately, which suits most cached payloads.
+func NewSnappyCodec() Codec {
+	return snappyCodec{}
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// NewGzipCodec returns a Codec writing gzip streams at level, one of the
+// compress/gzip levels. Invalid levels fall back to gzip.DefaultCompression.
+// It compresses better than snappy at a much higher CPU cost.
+func NewGzipCodec(level int) Codec {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	codec := &gzipCodec{}
+	codec.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}
+	return codec
+}
+
+// gzipCodec pools its writers, which are expensive to allocate.
+type gzipCodec struct {
+	writers sync.Pool
+}
+
+func (g *gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := g.writers.Get().(*gzip.Writer)
+	w.Reset(&buf)
+	_, err := w.Write(src)
+	if err == nil {
+		err = w.Close()
+	}
+	g.writers.Put(w)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *gzipCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compress returns val compressed, with itemCompressed added to flags, when
+// compression is configured, val is larger than the threshold and
+// compressing actually shrinks it. Otherwise val and flags are returned as
+// they are. Compression failures are reported and the value is stored as is.
+func (c *Cache) compress(val []byte, flags byte) ([]byte, byte) {
+	if c.codec == nil || len(val) <= c.compressMin {
+		return val, flags
+	}
+	packed, err := c.codec.Compress(val)
+	if err != nil {
+		c.reportError(fmt.Errorf("hoard: compressing value: %w", err))
+		return val, flags
+	}
+	if len(packed) >= len(val) {
+		return val, flags
+	}
+	return packed, flags | itemCompressed
+}
+
+// decompress returns v with its value decompressed if it is stored
+// compressed. Errors wrap ErrSerialization.
+func (c *Cache) decompress(v itemView) (itemView, error) {
+	if v.flags&itemCompressed == 0 {
+		return v, nil
+	}
+	if c.codec == nil {
+		return itemView{}, fmt.Errorf("%w: compressed entry but no codec configured", ErrSerialization)
+	}
+	val, err := c.codec.Decompress(v.value)
+	if err != nil {
+		return itemView{}, fmt.Errorf("%w: decompressing value: %w", ErrSerialization, err)
+	}
+	v.value = val
+	v.flags &^= itemCompressed
+	return v, nil
+}
@@ -0,0 +1,134 @@
+package hoard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// compressed reports whether key is stored compressed.
+func compressed(c *Cache, key string) bool {
+	shard := c.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	item, ok := shard.data[key]
+	return ok && item.flags&itemCompressed != 0
+}
+
+// testing that both codecs round-trip compressible and incompressible values
+func TestCompressionRoundTrip(t *testing.T) {
+	text := strings.Repeat(`{"name":"hoard","tags":["cache","go"]},`, 1000)
+	noise := make([]byte, 16<<10)
+	rand.Read(noise)
+
+	for name, codec := range map[string]Codec{
+		"snappy": NewSnappyCodec(),
+		"gzip":   NewGzipCodec(gzip.BestSpeed),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cache := NewCache(4, 100, time.Minute, WithCompression(codec, 1024))
+			defer cache.Close()
+
+			cache.Store("text", text, NoExpiration)
+			cache.StoreBytes("noise", noise, NoExpiration)
+			if !compressed(cache, "text") {
+				t.Fatal("Expected the compressible value to be compressed")
+			}
+			if compressed(cache, "noise") {
+				t.Fatal("Expected the incompressible value to be stored as is")
+			}
+			if n := cache.Stats().Bytes; n > int64(len(text)/4+len(noise)+1024) {
+				t.Fatalf("Expected the byte count to reflect compression, got %d", n)
+			}
+
+			if v, ok, err := cache.FetchData("text"); !ok || err != nil || v != text {
+				t.Fatal("Expected the compressed value back")
+			}
+			if b, ok := cache.FetchBytesData("noise"); !ok || !bytes.Equal(b, noise) {
+				t.Fatal("Expected the incompressible value back")
+			}
+
+			raw := []byte(text)
+			cache.StoreBytes("raw", raw, NoExpiration)
+			if b, ok := cache.FetchBytes("raw"); !ok || !bytes.Equal(b, raw) {
+				t.Fatal("Expected compressed raw bytes back")
+			}
+			var dest string
+			if ok, err := cache.FetchInto("text", &dest); !ok || err != nil || dest != text {
+				t.Fatalf("Expected FetchInto to decompress, got %v", err)
+			}
+
+			seen := 0
+			cache.Iterate(func(key string, b []byte) {
+				if key == "raw" && bytes.Equal(b, raw) {
+					seen++
+				}
+			})
+			if seen != 1 {
+				t.Fatal("Expected Iterate to see the decompressed bytes")
+			}
+		})
+	}
+}
+
+// testing that values at or below the threshold are never compressed
+func TestCompressionThreshold(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute, WithCompression(NewSnappyCodec(), 1024))
+	defer cache.Close()
+
+	small := bytes.Repeat([]byte("a"), 1024)
+	large := bytes.Repeat([]byte("a"), 1025)
+	cache.StoreBytes("small", small, NoExpiration)
+	cache.StoreBytes("large", large, NoExpiration)
+	if compressed(cache, "small") {
+		t.Fatal("Expected a value of exactly minSize bytes to be stored as is")
+	}
+	if !compressed(cache, "large") {
+		t.Fatal("Expected a value above minSize to be compressed")
+	}
+
+	// An update past the threshold compresses, a small one reverts
+	cache.Update("small", string(large), NoExpiration)
+	if !compressed(cache, "small") {
+		t.Fatal("Expected the update to be compressed")
+	}
+	cache.Update("small", "tiny", NoExpiration)
+	if compressed(cache, "small") {
+		t.Fatal("Expected the small update to be stored as is")
+	}
+	if v, _, _ := cache.FetchData("small"); v != "tiny" {
+		t.Fatalf("Expected tiny, got %v", v)
+	}
+
+	if _, err := NewCacheWithOptions(WithCompression(NewSnappyCodec(), -1)); err == nil {
+		t.Fatal("Expected an error for a negative threshold")
+	}
+}
+
+// testing that compressed entries survive a snapshot and work with CAS
+func TestCompressionSnapshotAndCAS(t *testing.T) {
+	value := strings.Repeat("compressible ", 200)
+	cache := NewCache(2, 100, time.Minute, WithCompression(NewSnappyCodec(), 64))
+	defer cache.Close()
+	cache.Store("key", value, NoExpiration)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	restored := NewCache(2, 100, time.Minute, WithCompression(NewSnappyCodec(), 64))
+	defer restored.Close()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if v, ok, err := restored.FetchData("key"); !ok || err != nil || v != value {
+		t.Fatal("Expected the compressed entry to survive a snapshot")
+	}
+
+	if swapped, err := cache.CompareAndSwap("key", value, "new", NoExpiration); err != nil || !swapped {
+		t.Fatalf("Expected CAS to match the compressed value, got %v", err)
+	}
+}
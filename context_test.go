@@ -0,0 +1,211 @@
+package hoard
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing that canceling mid-scan stops the callback and returns the
+// context's error
+func TestIterateCtxCancelMidway(t *testing.T) {
+	cache := NewCache(8, 1000, time.Minute, WithIterateWorkers(1))
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, NoExpiration)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls, late atomic.Int32
+	var canceled atomic.Bool
+	err := cache.IterateCtx(ctx, func(string, []byte) error {
+		if canceled.Load() {
+			late.Add(1)
+		}
+		if calls.Add(1) == 10 {
+			cancel()
+			canceled.Store(true)
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if n := late.Load(); n != 0 {
+		t.Fatalf("Expected no calls after the cancellation, got %d", n)
+	}
+	if n := calls.Load(); n != 10 {
+		t.Fatalf("Expected the scan to stop after 10 entries, got %d", n)
+	}
+
+	// With several workers, calls already running may finish but the scan
+	// still stops early
+	cache = NewCache(8, 1000, time.Minute, WithIterateWorkers(8))
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, NoExpiration)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls.Store(0)
+	err = cache.IterateCtx(ctx, func(string, []byte) error {
+		calls.Add(1)
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || calls.Load() >= 1000 {
+		t.Fatalf("Expected the deadline to stop the scan, got %v after %d calls", err, calls.Load())
+	}
+}
+
+// testing that StoreCtx and FetchCtx honor a done context
+func TestStoreFetchCtx(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute)
+	defer cache.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := cache.StoreCtx(ctx, "key", "value", NoExpiration); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if cache.Exists("key") {
+		t.Fatal("Expected nothing to be stored")
+	}
+	if err := cache.StoreCtx(context.Background(), "key", "value", NoExpiration); err != nil {
+		t.Fatalf("StoreCtx failed: %v", err)
+	}
+	if _, ok, err := cache.FetchCtx(ctx, "key"); ok || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v, %v", ok, err)
+	}
+	if v, ok, err := cache.FetchCtx(context.Background(), "key"); !ok || err != nil || v != "value" {
+		t.Fatalf("Expected the value, got %v, %v, %v", v, ok, err)
+	}
+	if _, _, err := cache.GetOrStoreCtx(ctx, "other", NoExpiration, func(context.Context) (interface{}, error) {
+		t.Fatal("Expected the loader not to run")
+		return nil, nil
+	}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// ctxKey keys the value the tests pass through contexts.
+type ctxKey struct{}
+
+// testing that loaders receive the caller's context and that waiters stop
+// waiting when their own context is done
+func TestLoaderCtx(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	cache := NewCache(4, 100, time.Minute, WithLoaderCtx(func(ctx context.Context, key string) (interface{}, time.Duration, error) {
+		if key == "slow" {
+			close(started)
+			<-release
+		}
+		return ctx.Value(ctxKey{}), NoExpiration, nil
+	}))
+	defer cache.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "traced")
+	if v, ok, err := cache.FetchCtx(ctx, "key"); !ok || err != nil || v != "traced" {
+		t.Fatalf("Expected the loader to see the context, got %v, %v, %v", v, ok, err)
+	}
+
+	leader := make(chan error)
+	go func() {
+		_, _, err := cache.FetchCtx(context.Background(), "slow")
+		leader <- err
+	}()
+	<-started
+	waitCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := cache.FetchCtx(waitCtx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the waiter to give up, got %v", err)
+	}
+	close(release)
+	if err := <-leader; err != nil {
+		t.Fatalf("Expected the load to finish, got %v", err)
+	}
+
+	v, loaded, err := cache.GetOrStoreCtx(ctx, "stored", NoExpiration, func(ctx context.Context) (interface{}, error) {
+		return ctx.Value(ctxKey{}), nil
+	})
+	if !loaded || err != nil || v != "traced" {
+		t.Fatalf("Expected GetOrStoreCtx to pass the context, got %v, %v, %v", v, loaded, err)
+	}
+}
+
+// ctxBackend is a memBackend that records the context of its loads.
+type ctxBackend struct {
+	*memBackend
+	seen atomic.Value
+}
+
+func (b *ctxBackend) LoadCtx(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	b.seen.Store(ctx.Value(ctxKey{}))
+	return b.Load(key)
+}
+
+func (b *ctxBackend) SaveCtx(ctx context.Context, key string, value []byte) error {
+	b.seen.Store(ctx.Value(ctxKey{}))
+	return b.Save(key, value)
+}
+
+// testing that read-through uses LoadCtx when the backend has it
+func TestContextBackend(t *testing.T) {
+	backend := &ctxBackend{memBackend: newMemBackend()}
+	backend.data["key"], _ = msgpackSerializer{}.Marshal("value")
+	cache := NewCache(4, 100, time.Minute, WithBackend(backend))
+	defer cache.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "traced")
+	if v, ok, err := cache.FetchCtx(ctx, "key"); !ok || err != nil || v != "value" {
+		t.Fatalf("Expected the backend to resolve the miss, got %v, %v, %v", v, ok, err)
+	}
+	if backend.seen.Load() != "traced" {
+		t.Fatalf("Expected LoadCtx to get the context, got %v", backend.seen.Load())
+	}
+}
+
+// testing that StoreCtx passes its context to SaveCtx
+func TestStoreCtxSaveCtx(t *testing.T) {
+	backend := &ctxBackend{memBackend: newMemBackend()}
+	cache := NewCache(4, 100, time.Minute, WithBackend(backend))
+	defer cache.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "traced")
+	if err := cache.StoreCtx(ctx, "key", "value", time.Minute); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	if backend.seen.Load() != "traced" {
+		t.Fatalf("Expected SaveCtx to get the context, got %v", backend.seen.Load())
+	}
+}
+
+// testing that the context of StoreCtx bounds the wait for room in a full
+// write-behind queue, and that the key is dropped when it runs out
+func TestStoreCtxFullQueue(t *testing.T) {
+	backend := newMemBackend()
+	backend.gate = make(chan struct{})
+	cache := NewCache(1, 100, time.Minute, WithBackend(backend), WithWriteBehind(1, 1, BlockWhenFull))
+	cache.Store("key0", 0, time.Minute)
+	time.Sleep(time.Millisecond)
+	cache.Store("key1", 1, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cache.StoreCtx(ctx, "key2", 2, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the wait to end with the context, got %v", err)
+	}
+	if cache.Exists("key2") {
+		t.Fatal("Expected the unsaved key to be dropped")
+	}
+	close(backend.gate)
+	cache.Close()
+	if _, ok := backend.data["key2"]; ok {
+		t.Fatal("Expected key2 not to reach the backend")
+	}
+}
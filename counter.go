@@ -0,0 +1,128 @@
+package hoard
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotInteger is returned by Increment and Decrement when the existing
+// value of a key is not an integer.
+var ErrNotInteger = errors.New("hoard: value is not an integer")
+
+// Increment atomically adds delta to the integer stored under key and returns
+// the new value. A missing or expired key is created with value delta and the
+// given ttl; an existing key keeps its expiration. Integers written by Store
+// are accepted, any other value fails with ErrNotInteger and is left as is.
+// Counters are kept as 8 little endian bytes rather than serialized, so
+// FetchData returns them as an int64. The result wraps around on overflow.
+func (c *Cache) Increment(key string, delta int64, ttl time.Duration) (int64, error) {
+	if c.isClosed() {
+		return 0, ErrCacheClosed
+	}
+	if err := c.checkKey(key); err != nil {
+		return 0, err
+	}
+	shard := c.getShard(key)
+
+	shard.mu.Lock()
+	n, err := c.incrementLocked(shard, key, delta, ttl)
+	if err := shard.mu.unlockErr(err); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// incrementLocked implements Increment. The caller must hold shard.mu for
+// writing.
+func (c *Cache) incrementLocked(shard *CacheShard, key string, delta int64, ttl time.Duration) (int64, error) {
+	now := c.now()
+	item, ok := shard.data[key]
+	if ok && item.expired(now) {
+		c.expireLocked(shard, key, item)
+		ok = false
+	}
+	if !ok || item.flags&itemNegative != 0 {
+		val := encodeInt(delta)
+		stored, flags, err := c.pack(val, itemInt)
+		if err != nil {
+			return 0, err
+		}
+		p := pendingStore{val: val, stored: stored, flags: flags, exp: c.expiration(ttl), cost: 1}
+		if err := c.storeLocked(shard, key, p); err != nil {
+			return 0, err
+		}
+		return delta, nil
+	}
+
+	n, err := c.intValue(shard.sharedView(item))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", err, key)
+	}
+	n += delta
+	val := encodeInt(n)
+	stored, flags, err := c.pack(val, itemInt)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.replaceLocked(shard, key, item, val, stored, flags, item.Expiration); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Decrement atomically subtracts delta from the integer stored under key. It
+// follows the rules of Increment.
+func (c *Cache) Decrement(key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.Increment(key, -delta, ttl)
+}
+
+// intValue returns the integer held by a stored entry.
+func (c *Cache) intValue(v itemView) (int64, error) {
+	v, err := c.unpack(v)
+	if err != nil {
+		return 0, err
+	}
+	if v.flags&itemInt != 0 {
+		return decodeInt(v.value), nil
+	}
+	if v.flags&itemRaw != 0 {
+		return 0, ErrNotInteger
+	}
+	val, err := c.deserialize(v.value)
+	if err != nil {
+		return 0, err
+	}
+	switch n := val.(type) {
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case uint8:
+		return int64(n), nil
+	case uint16:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case uint:
+		return int64(n), nil
+	}
+	return 0, ErrNotInteger
+}
+
+func encodeInt(n int64) []byte {
+	return binary.LittleEndian.AppendUint64(make([]byte, 0, 8), uint64(n))
+}
+
+func decodeInt(b []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(b))
+}
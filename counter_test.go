@@ -0,0 +1,86 @@
+package hoard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testing Increment and Decrement on new and existing keys
+func TestIncrement(t *testing.T) {
+	cache := NewCache(2, 10, time.Hour)
+	defer cache.Close()
+
+	if n, err := cache.Increment("hits", 5, time.Minute); err != nil || n != 5 {
+		t.Fatalf("Expected 5, got %d (%v)", n, err)
+	}
+	if n, err := cache.Decrement("hits", 2, time.Minute); err != nil || n != 3 {
+		t.Fatalf("Expected 3, got %d (%v)", n, err)
+	}
+	value, ok, err := cache.FetchData("hits")
+	if err != nil || !ok || value.(int64) != 3 {
+		t.Fatalf("Expected FetchData to return int64 3, got %v %v %v", value, ok, err)
+	}
+	var n int
+	if ok, err := cache.FetchInto("hits", &n); err != nil || !ok || n != 3 {
+		t.Fatalf("Expected FetchInto to decode 3, got %d %v %v", n, ok, err)
+	}
+
+	// Integers written by Store are accepted
+	cache.Store("stored", 40, time.Minute)
+	if n, err := cache.Increment("stored", 2, time.Minute); err != nil || n != 42 {
+		t.Fatalf("Expected 42, got %d (%v)", n, err)
+	}
+
+	// Expired keys start over
+	cache.Increment("expired", 10, -time.Second)
+	if n, err := cache.Increment("expired", 1, time.Minute); err != nil || n != 1 {
+		t.Fatalf("Expected an expired counter to restart at 1, got %d (%v)", n, err)
+	}
+}
+
+// testing that non-integer values are rejected and left untouched
+func TestIncrementNotInteger(t *testing.T) {
+	cache := NewCache(2, 10, time.Hour)
+	defer cache.Close()
+
+	cache.Store("name", "aboubakr", time.Minute)
+	cache.StoreBytes("raw", []byte{1, 2, 3}, time.Minute)
+	for _, key := range []string{"name", "raw"} {
+		if _, err := cache.Increment(key, 1, time.Minute); !errors.Is(err, ErrNotInteger) {
+			t.Errorf("Expected ErrNotInteger for %s, got %v", key, err)
+		}
+	}
+	if value, _, _ := cache.FetchData("name"); value != "aboubakr" {
+		t.Errorf("Expected the value to be left as is, got %v", value)
+	}
+}
+
+// testing 100 goroutines incrementing the same key
+func TestIncrementConcurrent(t *testing.T) {
+	cache := NewCache(4, 10, time.Hour)
+	defer cache.Close()
+
+	const workers, perWorker = 100, 100
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if _, err := cache.Increment("counter", 1, time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _, _ := cache.FetchData("counter")
+	if value.(int64) != workers*perWorker {
+		t.Fatalf("Expected %d, got %v", workers*perWorker, value)
+	}
+	checkIntegrity(t, cache)
+}
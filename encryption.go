@@ -0,0 +1,100 @@
+package hoard
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrDecryption is returned when a value stored by a cache configured with
+// WithEncryption cannot be decrypted, because it was encrypted with another
+// key or has been tampered with. No plaintext is returned with it.
+var ErrDecryption = errors.New("hoard: decryption failed")
+
+// initEncryption builds the AES-GCM cipher for the key set with
+// WithEncryption. validate has already checked the key length.
+func (c *Cache) initEncryption() {
+	if c.encryptionKey == nil {
+		return
+	}
+	block, err := aes.NewCipher(c.encryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		panic(err)
+	}
+}
+
+// encrypt seals val with a fresh random nonce, which is stored in front of
+// the ciphertext, and adds itemEncrypted to flags.
+func (c *Cache) encrypt(val []byte, flags byte) ([]byte, byte, error) {
+	size := c.aead.NonceSize()
+	out := make([]byte, size, size+len(val)+c.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, 0, fmt.Errorf("hoard: generating nonce: %w", err)
+	}
+	return c.aead.Seal(out, out, val, nil), flags | itemEncrypted, nil
+}
+
+// decrypt returns v with its value decrypted if it is stored encrypted.
+// Errors wrap ErrDecryption.
+func (c *Cache) decrypt(v itemView) (itemView, error) {
+	if v.flags&itemEncrypted == 0 {
+		return v, nil
+	}
+	if c.aead == nil {
+		return itemView{}, fmt.Errorf("%w: encrypted entry but no key configured", ErrDecryption)
+	}
+	size := c.aead.NonceSize()
+	if len(v.value) < size {
+		return itemView{}, fmt.Errorf("%w: ciphertext too short", ErrDecryption)
+	}
+	val, err := c.aead.Open(nil, v.value[:size], v.value[size:], nil)
+	if err != nil {
+		return itemView{}, fmt.Errorf("%w: %w", ErrDecryption, err)
+	}
+	v.value = val
+	v.flags &^= itemEncrypted
+	return v, nil
+}
+
+// pack turns a serialized or raw value into the bytes the cache stores,
+// compressing and then encrypting it as configured, and returns the flags
+// describing them.
+func (c *Cache) pack(val []byte, flags byte) ([]byte, byte, error) {
+	val, flags = c.compress(val, flags)
+	if c.aead == nil {
+		return val, flags, nil
+	}
+	return c.encrypt(val, flags)
+}
+
+// unpack reverses pack on a stored entry, failing with ErrCorrupted for a
+// view verify rejected.
+func (c *Cache) unpack(v itemView) (itemView, error) {
+	if v.flags&(itemCompressed|itemEncrypted|itemCorrupted) == 0 {
+		return v, nil
+	}
+	if v.flags&itemCorrupted != 0 {
+		return itemView{}, ErrCorrupted
+	}
+	v, err := c.decrypt(v)
+	if err != nil {
+		return itemView{}, err
+	}
+	return c.decompress(v)
+}
+
+// unpackFound is unpack for lookups that cannot return an error: entries
+// that fail to unpack are reported and treated as missing.
+func (c *Cache) unpackFound(v itemView) (itemView, bool) {
+	v, err := c.unpack(v)
+	if err != nil {
+		c.reportError(err)
+		return itemView{}, false
+	}
+	return v, true
+}
@@ -0,0 +1,146 @@
+package hoard
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{1}, 32)
+	otherKey = bytes.Repeat([]byte{2}, 32)
+)
+
+// storedValue returns the bytes the cache holds for key.
+func storedValue(c *Cache, key string) []byte {
+	shard := c.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.data[key].Value
+}
+
+// testing that values are encrypted in memory and decrypted on the way out
+func TestEncryptionRoundTrip(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute, WithEncryption(testKey))
+	defer cache.Close()
+
+	secret := []byte("token-0123456789")
+	cache.StoreBytes("raw", secret, NoExpiration)
+	cache.Store("value", "ssn 123-45-6789", NoExpiration)
+	if bytes.Contains(storedValue(cache, "raw"), secret) {
+		t.Fatal("Expected the raw value to be encrypted in memory")
+	}
+	if bytes.Contains(storedValue(cache, "value"), []byte("123-45-6789")) {
+		t.Fatal("Expected the serialized value to be encrypted in memory")
+	}
+
+	if b, ok := cache.FetchBytes("raw"); !ok || !bytes.Equal(b, secret) {
+		t.Fatalf("Expected FetchBytes to return the plaintext, got %q", b)
+	}
+	if v, ok, err := cache.FetchData("value"); !ok || err != nil || v != "ssn 123-45-6789" {
+		t.Fatalf("Expected the decrypted value, got %v, %v", v, err)
+	}
+
+	// Equal values get distinct nonces
+	cache.StoreBytes("raw2", secret, NoExpiration)
+	if bytes.Equal(storedValue(cache, "raw"), storedValue(cache, "raw2")) {
+		t.Fatal("Expected a fresh nonce per entry")
+	}
+
+	if n, err := cache.Increment("counter", 5, NoExpiration); err != nil || n != 5 {
+		t.Fatalf("Expected 5, got %d, %v", n, err)
+	}
+	if n, err := cache.Increment("counter", 2, NoExpiration); err != nil || n != 7 {
+		t.Fatalf("Expected 7, got %d, %v", n, err)
+	}
+}
+
+// testing that a snapshot persists ciphertext and reloads with the same key
+// only
+func TestEncryptionSnapshot(t *testing.T) {
+	cache := NewCache(2, 100, time.Minute, WithEncryption(testKey))
+	defer cache.Close()
+	cache.Store("secret", "hunter2", NoExpiration)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+		t.Fatal("Expected the snapshot to hold ciphertext")
+	}
+	snapshot := buf.Bytes()
+
+	same := NewCache(2, 100, time.Minute, WithEncryption(testKey))
+	defer same.Close()
+	if err := same.Load(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if v, ok, err := same.FetchData("secret"); !ok || err != nil || v != "hunter2" {
+		t.Fatalf("Expected the value back with the same key, got %v, %v", v, err)
+	}
+
+	var reported error
+	other := NewCache(2, 100, time.Minute, WithEncryption(otherKey),
+		WithErrorHandler(func(err error) { reported = err }))
+	defer other.Close()
+	if err := other.Load(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, _, err := other.FetchData("secret"); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("Expected ErrDecryption with another key, got %v", err)
+	}
+	if b, ok := other.FetchBytes("secret"); ok || b != nil {
+		t.Fatalf("Expected FetchBytes to miss rather than return garbage, got %q", b)
+	}
+	if !errors.Is(reported, ErrDecryption) {
+		t.Fatalf("Expected the failure to be reported, got %v", reported)
+	}
+}
+
+// testing that altered ciphertext is detected
+func TestEncryptionTampered(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute, WithEncryption(testKey))
+	defer cache.Close()
+	cache.Store("key", "value", NoExpiration)
+
+	stored := storedValue(cache, "key")
+	stored[len(stored)-1] ^= 0xff
+	if _, _, err := cache.FetchData("key"); !errors.Is(err, ErrDecryption) {
+		t.Fatalf("Expected ErrDecryption, got %v", err)
+	}
+}
+
+// testing that encryption composes with compression
+func TestEncryptionWithCompression(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute,
+		WithCompression(NewSnappyCodec(), 64), WithEncryption(testKey))
+	defer cache.Close()
+
+	value := strings.Repeat("compressible ", 500)
+	cache.Store("key", value, NoExpiration)
+	if n := len(storedValue(cache, "key")); n > len(value)/4 {
+		t.Fatalf("Expected the value to be compressed before encryption, got %d bytes", n)
+	}
+	if v, ok, err := cache.FetchData("key"); !ok || err != nil || v != value {
+		t.Fatalf("Expected the value back, got %v", err)
+	}
+}
+
+// testing that keys of invalid length are rejected
+func TestEncryptionKeyLength(t *testing.T) {
+	for _, n := range []int{0, 8, 31} {
+		if _, err := NewCacheWithOptions(WithEncryption(make([]byte, n))); err == nil {
+			t.Fatalf("Expected an error for a %d byte key", n)
+		}
+	}
+	for _, n := range []int{16, 24, 32} {
+		cache, err := NewCacheWithOptions(WithEncryption(make([]byte, n)))
+		if err != nil {
+			t.Fatalf("Expected a %d byte key to be accepted, got %v", n, err)
+		}
+		cache.Close()
+	}
+}
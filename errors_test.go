@@ -0,0 +1,67 @@
+package hoard
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testing that every method reports the matching sentinel through errors.Is
+func TestSentinelErrors(t *testing.T) {
+	cache := NewCache(1, 1, time.Hour)
+
+	unencodable := make(chan int)
+	if err := cache.Store("key", unencodable, time.Minute); !errors.Is(err, ErrSerialization) {
+		t.Errorf("Store: expected ErrSerialization, got %v", err)
+	}
+	if errs := cache.StoreMany(map[string]interface{}{"key": unencodable}, time.Minute); !errors.Is(errs["key"], ErrSerialization) {
+		t.Errorf("StoreMany: expected ErrSerialization, got %v", errs)
+	}
+
+	if err := cache.Update("missing", 1, time.Minute); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Update: expected ErrKeyNotFound, got %v", err)
+	}
+	cache.Store("expired", 1, -time.Second)
+	if err := cache.Update("expired", 1, time.Minute); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Update of an expired key: expected ErrKeyNotFound, got %v", err)
+	}
+	if err := cache.Touch("missing", time.Minute); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Touch: expected ErrKeyNotFound, got %v", err)
+	}
+
+	cache.StoreBytes("corrupt", []byte{0xc1}, time.Minute) // never used by msgpack
+	cache.shards[0].data["corrupt"].flags = 0              // pretend it was serialized
+	if _, _, err := cache.FetchData("corrupt"); !errors.Is(err, ErrSerialization) {
+		t.Errorf("FetchData: expected ErrSerialization, got %v", err)
+	}
+	var n int
+	cache.Store("name", "aboubakr", time.Minute)
+	if _, err := cache.FetchInto("name", &n); !errors.Is(err, ErrSerialization) {
+		t.Errorf("FetchInto: expected ErrSerialization, got %v", err)
+	}
+	if _, _, err := cache.FetchMany([]string{"name"}); err != nil {
+		t.Errorf("FetchMany: expected no error, got %v", err)
+	}
+
+	cache.Pin("name")
+	if err := cache.Store("other", 1, time.Minute); !errors.Is(err, ErrCacheFull) {
+		t.Errorf("Store into a pinned shard: expected ErrCacheFull, got %v", err)
+	}
+
+	cache.Close()
+	if err := cache.Store("key", 1, time.Minute); !errors.Is(err, ErrCacheClosed) {
+		t.Errorf("Store after Close: expected ErrCacheClosed, got %v", err)
+	}
+	if _, _, err := cache.FetchData("key"); !errors.Is(err, ErrCacheClosed) {
+		t.Errorf("FetchData after Close: expected ErrCacheClosed, got %v", err)
+	}
+	if err := cache.Update("key", 1, time.Minute); !errors.Is(err, ErrCacheClosed) {
+		t.Errorf("Update after Close: expected ErrCacheClosed, got %v", err)
+	}
+	if errs := cache.StoreMany(map[string]interface{}{"key": 1}, time.Minute); !errors.Is(errs["key"], ErrCacheClosed) {
+		t.Errorf("StoreMany after Close: expected ErrCacheClosed, got %v", errs)
+	}
+	if _, _, err := cache.FetchMany([]string{"key"}); !errors.Is(err, ErrCacheClosed) {
+		t.Errorf("FetchMany after Close: expected ErrCacheClosed, got %v", err)
+	}
+}
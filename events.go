@@ -0,0 +1,111 @@
+package hoard
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+// Event describes a change to any key, as delivered by Events.
+type Event struct {
+	// Reason is why the entry changed: OpStore, OpUpdate, OpDelete, OpExpire
+	// or OpEvict.
+	Reason ChangeOp
+	Key    string
+	// Time is when the change happened, by the cache's Clock.
+	Time time.Time
+	// Value is a copy of the new value for OpStore and OpUpdate and of the
+	// removed value for OpEvict and OpExpire, for subscriptions asking for
+	// values, and nil otherwise. Values are as stored: raw bytes, encoded
+	// counters or serialized values, see Decode for snapshot entries.
+	Value []byte
+}
+
+// subscriber is a channel returned by Events.
+type subscriber struct {
+	ch     chan Event
+	values bool
+	closed bool // guarded by watchers.mu
+}
+
+// Events returns a channel receiving an event for every change to the
+// cache: stores, updates, deletes, expirations and evictions, including
+// those made by the cleanup goroutine. With values, events carry a copy of
+// the value stored or removed.
+//
+// Events are sent without blocking the cache, from the goroutine making the
+// change. The channel buffers up to buffer events, or 16 if buffer is not
+// positive, and events arriving while it is full are dropped and counted in
+// Stats.DroppedEvents, so a slow consumer sees gaps rather than stalling
+// writes. Consumers wanting to batch should drain the channel promptly and
+// size buffer for their bursts. The events of a key arrive in order.
+//
+// The returned func unsubscribes and closes the channel; Close closes every
+// channel too. Neither leaves anything running.
+func (c *Cache) Events(buffer int, values bool) (<-chan Event, func()) {
+	if buffer <= 0 {
+		buffer = watchBufferSize
+	}
+	s := &subscriber{ch: make(chan Event, buffer), values: values}
+	c.watch.mu.Lock()
+	if c.isClosed() {
+		c.watch.mu.Unlock()
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	c.watch.subs = append(c.watch.subs, s)
+	c.watch.n.Add(1)
+	if values {
+		c.watch.values.Add(1)
+	}
+	c.watch.mu.Unlock()
+
+	return s.ch, func() {
+		c.watch.mu.Lock()
+		defer c.watch.mu.Unlock()
+		for i, other := range c.watch.subs {
+			if other == s {
+				c.watch.subs = append(c.watch.subs[:i], c.watch.subs[i+1:]...)
+				break
+			}
+		}
+		c.watch.unsubscribeLocked(s)
+	}
+}
+
+// unsubscribeLocked closes the channel of s once. The caller must hold mu.
+func (ws *watchers) unsubscribeLocked(s *subscriber) {
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+		ws.n.Add(-1)
+		if s.values {
+			ws.values.Add(-1)
+		}
+	}
+}
+
+// send delivers ev to s, copying val into it if s asked for values.
+func (s *subscriber) send(ev Event, val []byte, dropped *atomic.Uint64) {
+	if s.closed {
+		return
+	}
+	if s.values {
+		ev.Value = bytes.Clone(val)
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		dropped.Add(1)
+	}
+}
+
+// notifyRemoved is notify for an entry removed with the value val stored
+// with flags, which only subscribers of Events asking for values get.
+func (c *Cache) notifyRemoved(op ChangeOp, key string, val []byte, flags byte) {
+	if c.watch.values.Load() == 0 {
+		c.notify(op, key, nil)
+		return
+	}
+	c.notifyValue(op, key, val, flags)
+}
@@ -0,0 +1,157 @@
+package hoard
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event on ch or fails after a second.
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("Expected an event, the channel is closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return Event{}
+}
+
+// testing that subscribers see every kind of change to any key, with copies
+// of the values when they ask for them
+func TestEvents(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 2, 0, WithClock(clock), WithCompression(NewSnappyCodec(), 0))
+	defer cache.Close()
+	withValues, cancel := cache.Events(64, true)
+	defer cancel()
+	bare, cancelBare := cache.Events(64, false)
+	defer cancelBare()
+
+	start := clock.Now()
+	cache.StoreBytes("a", []byte("v1"), time.Second)
+	cache.UpdateValue("a", "v2")
+	cache.StoreBytes("b", []byte("b"), NoExpiration)
+	cache.Delete("b")
+	clock.Advance(2 * time.Second)
+	cache.RunCleanup()
+	cache.StoreBytes("c", []byte("c"), NoExpiration)
+	cache.StoreBytes("d", []byte("d"), NoExpiration)
+	cache.StoreBytes("e", []byte("e"), NoExpiration) // evicts c
+
+	want := []struct {
+		reason ChangeOp
+		key    string
+		value  string
+	}{
+		{OpStore, "a", "v1"},
+		{OpUpdate, "a", "\xa2v2"},
+		{OpStore, "b", "b"},
+		{OpDelete, "b", ""},
+		{OpExpire, "a", "\xa2v2"},
+		{OpStore, "c", "c"},
+		{OpStore, "d", "d"},
+		{OpEvict, "c", "c"},
+		{OpStore, "e", "e"},
+	}
+	for i, w := range want {
+		ev := nextEvent(t, withValues)
+		if ev.Reason != w.reason || ev.Key != w.key || string(ev.Value) != w.value {
+			t.Fatalf("Expected %v of %s with %q, got %v of %s with %q", w.reason, w.key, w.value, ev.Reason, ev.Key, ev.Value)
+		}
+		// The first four changes come before the clock moves
+		if at := start.Add(time.Duration(min(i/4, 1)) * 2 * time.Second); !ev.Time.Equal(at) {
+			t.Fatalf("Expected %v of %s at %v, got %v", w.reason, w.key, at, ev.Time)
+		}
+		if ev = nextEvent(t, bare); ev.Reason != w.reason || ev.Key != w.key || ev.Value != nil {
+			t.Fatalf("Expected %v of %s without a value, got %+v", w.reason, w.key, ev)
+		}
+	}
+
+	// Values are copies
+	cache.StoreBytes("f", []byte("f"), NoExpiration)
+	nextEvent(t, withValues).Value[0] = 'X'
+	if v, _ := cache.FetchBytesData("f"); string(v) != "f" {
+		t.Fatalf("Expected the stored bytes untouched, got %q", v)
+	}
+}
+
+// testing that a slow subscriber loses events without blocking writes or
+// other subscribers, and that the losses are counted
+func TestEventsSlowConsumer(t *testing.T) {
+	cache := NewCache(1, 100, 0)
+	defer cache.Close()
+	slow, cancelSlow := cache.Events(2, false)
+	defer cancelSlow()
+	fast, cancelFast := cache.Events(100, false)
+	defer cancelFast()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			cache.StoreBytes("key"+strconv.Itoa(i), nil, NoExpiration)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected writes not to block on a full subscriber")
+	}
+
+	if n := len(fast); n != 10 {
+		t.Fatalf("Expected the other subscriber to get every event, got %d", n)
+	}
+	if n := cache.Stats().DroppedEvents; n != 8 {
+		t.Fatalf("Expected 8 dropped events, got %d", n)
+	}
+	// The oldest events are kept
+	if ev := nextEvent(t, slow); ev.Key != "key0" {
+		t.Fatalf("Expected the first event, got %+v", ev)
+	}
+	cache.ResetStats()
+	if n := cache.Stats().DroppedEvents; n != 0 {
+		t.Fatalf("Expected ResetStats to clear the count, got %d", n)
+	}
+}
+
+// testing that unsubscribing and Close close the channels and leave nothing
+// running
+func TestEventsTeardown(t *testing.T) {
+	cache := NewCache(1, 100, 0)
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		_, cancel := cache.Events(1, true)
+		cancel()
+	}
+	ch, cancel := cache.Events(1, true)
+	cancel()
+	cancel() // a second call does nothing
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected the channel to be closed")
+	}
+	if n := cache.watch.n.Load(); n != 0 || cache.watch.values.Load() != 0 || len(cache.watch.subs) != 0 {
+		t.Fatalf("Expected no subscribers left, got %d", n)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("Expected no goroutines left behind, got %d more", n-goroutines)
+	}
+	cache.StoreBytes("key", nil, NoExpiration) // nothing to send to
+
+	open, cancelOpen := cache.Events(1, false)
+	cache.Close()
+	if _, ok := <-open; ok {
+		t.Fatal("Expected Close to close the channel")
+	}
+	cancelOpen() // after Close does nothing
+	if ch, _ := cache.Events(1, false); ch == nil {
+		t.Fatal("Expected a channel after Close")
+	} else if _, ok := <-ch; ok {
+		t.Fatal("Expected a closed channel after Close")
+	}
+}
@@ -0,0 +1,45 @@
+package hoard
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// evictedEntry is an entry evicted by a store, see StoreGetEvicted.
+type evictedEntry struct {
+	store string // key being stored, never reported
+	key   string
+	value []byte // as stored, aliasing the shard's memory
+	flags byte
+	ok    bool
+}
+
+// StoreGetEvicted is like Store but also reports the entry the store evicted
+// from the shard of key to make room, if any. A store may evict several
+// entries when the shard also has a byte or cost budget; the first one, the
+// eviction policy's victim, is reported. Entries evicted from other shards
+// to respect WithMaxItems are not reported, and neither is key itself when
+// value alone exceeds a budget and is evicted right away.
+func (c *Cache) StoreGetEvicted(key string, value interface{}, ttl time.Duration) (evictedKey string, evicted bool, err error) {
+	var e evictedEntry
+	err = c.store(context.Background(), key, value, 1, ItemOptions{TTL: ttl}, &e)
+	return e.key, e.ok, err
+}
+
+// StoreGetEvictedValue is like StoreGetEvicted but also returns a copy of
+// the bytes of the evicted entry: raw bytes, an encoded counter or a
+// serialized value, decompressed and decrypted. Copying them costs an
+// allocation StoreGetEvicted avoids. If the evicted bytes cannot be
+// decrypted, the error is returned although value was stored.
+func (c *Cache) StoreGetEvictedValue(key string, value interface{}, ttl time.Duration) (evictedKey string, evictedValue []byte, evicted bool, err error) {
+	var e evictedEntry
+	if err = c.store(context.Background(), key, value, 1, ItemOptions{TTL: ttl}, &e); err != nil || !e.ok {
+		return e.key, nil, e.ok, err
+	}
+	v, err := c.unpack(itemView{value: e.value, flags: e.flags})
+	if err != nil {
+		return e.key, nil, true, err
+	}
+	return e.key, bytes.Clone(v.value), true, nil
+}
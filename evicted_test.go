@@ -0,0 +1,95 @@
+package hoard
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testing that StoreGetEvicted reports the least recently used entry it
+// evicted, like Store does in TestLRUEviction
+func TestStoreGetEvicted(t *testing.T) {
+	cache := NewCache(1, 2, time.Second) // 1 shard, max 2 items per shard
+	defer cache.Close()
+
+	// Store two items, nothing to evict yet
+	for _, key := range []string{"aboubakr", "kouhadi"} {
+		if victim, evicted, err := cache.StoreGetEvicted(key, key, 10*time.Second); err != nil || evicted || victim != "" {
+			t.Fatalf("Expected no eviction, got %q, %v, %v", victim, evicted, err)
+		}
+	}
+
+	// Fetch "aboubakr" to make it recently used
+	cache.FetchBytesData("aboubakr")
+
+	// Store a third item, evicting "kouhadi" as the least recently used
+	victim, evicted, err := cache.StoreGetEvicted("qux", 3.14, 10*time.Second)
+	if err != nil {
+		t.Fatalf("StoreGetEvicted failed: %v", err)
+	}
+	if !evicted || victim != "kouhadi" {
+		t.Fatalf("Expected kouhadi to be evicted, got %q, %v", victim, evicted)
+	}
+	if cache.Exists("kouhadi") || !cache.Exists("aboubakr") || !cache.Exists("qux") {
+		t.Fatal("Expected the reported victim to be the one gone")
+	}
+
+	// Overwriting a key evicts nothing
+	if victim, evicted, err := cache.StoreGetEvicted("qux", 1, 10*time.Second); err != nil || evicted {
+		t.Fatalf("Expected no eviction on overwrite, got %q, %v, %v", victim, evicted, err)
+	}
+	// Plain stores are not affected by an earlier capture
+	cache.Store("other", 1, 10*time.Second)
+	if cache.shards[0].evicted != nil {
+		t.Fatal("Expected the capture to be cleared")
+	}
+}
+
+// testing that a value larger than the byte budget, which evicts itself,
+// is never reported as the evicted entry
+func TestStoreGetEvictedOversized(t *testing.T) {
+	cache := NewCache(1, 10, time.Second, WithMaxBytes(256))
+	defer cache.Close()
+	big := strings.Repeat("x", 1024)
+
+	if victim, evicted, err := cache.StoreGetEvicted("big", big, 10*time.Second); err != nil || evicted {
+		t.Fatalf("Expected no eviction reported, got %q, %v, %v", victim, evicted, err)
+	}
+	if cache.Exists("big") {
+		t.Fatal("Expected the oversized value to be evicted")
+	}
+
+	cache.Store("small", 1, 10*time.Second)
+	victim, evicted, err := cache.StoreGetEvicted("big", big, 10*time.Second)
+	if err != nil {
+		t.Fatalf("StoreGetEvicted failed: %v", err)
+	}
+	if !evicted || victim != "small" {
+		t.Fatalf("Expected small to be reported, got %q, %v", victim, evicted)
+	}
+}
+
+// testing that StoreGetEvictedValue returns a copy of the evicted bytes,
+// decompressed
+func TestStoreGetEvictedValue(t *testing.T) {
+	cache := NewCache(1, 1, time.Second, WithCompression(NewSnappyCodec(), 0))
+	defer cache.Close()
+
+	cache.StoreBytes("first", []byte("first value"), NoExpiration)
+	victim, value, evicted, err := cache.StoreGetEvictedValue("second", "v", NoExpiration)
+	if err != nil || !evicted || victim != "first" || string(value) != "first value" {
+		t.Fatalf("Expected first and its bytes, got %q, %q, %v, %v", victim, value, evicted, err)
+	}
+	value[0] = 'X'
+
+	victim, value, evicted, err = cache.StoreGetEvictedValue("third", "v", NoExpiration)
+	if err != nil || !evicted || victim != "second" {
+		t.Fatalf("Expected second to be evicted, got %q, %v, %v", victim, evicted, err)
+	}
+	if v, err := cache.deserialize(value); err != nil || v != "v" {
+		t.Fatalf("Expected the serialized value, got %v, %v", v, err)
+	}
+	if _, value, evicted, _ := cache.StoreGetEvictedValue("third", "w", NoExpiration); evicted || value != nil {
+		t.Fatalf("Expected no eviction and no bytes, got %q, %v", value, evicted)
+	}
+}
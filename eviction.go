@@ -0,0 +1,389 @@
+package hoard
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+)
+
+// EvictionPolicy selects which entry a shard evicts when it grows past
+// maxItemsPerShard.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry. It is the default.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used entry. Access counts are halved
+	// periodically so keys that stopped being read eventually age out.
+	LFU
+	// FIFO evicts the oldest inserted entry. Reads and overwrites do not
+	// change the order, so lookups only take a shard read lock.
+	FIFO
+	// SLRU is a segmented LRU. New entries land in a probationary segment and
+	// move to a protected segment on their second hit; eviction drains the
+	// probationary segment first, so a scan of one-off keys cannot flush the
+	// working set. See WithSegmentRatio.
+	SLRU
+	// ApproxLRU approximates LRU the way Redis does. A hit only stamps the
+	// entry with the time, so lookups take a shard read lock, and eviction
+	// picks the least recently used of a few randomly sampled entries. See
+	// WithLRUSamples.
+	ApproxLRU
+)
+
+// defaultSegmentRatio is the share of a shard reserved for probationary
+// entries under SLRU.
+const defaultSegmentRatio = 0.2
+
+// defaultLRUSamples is how many entries ApproxLRU compares per eviction.
+const defaultLRUSamples = 5
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "LRU"
+	case LFU:
+		return "LFU"
+	case FIFO:
+		return "FIFO"
+	case SLRU:
+		return "SLRU"
+	case ApproxLRU:
+		return "ApproxLRU"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+// evictionPolicy orders the entries of a single shard. Its methods are called
+// with the shard lock held for writing.
+type evictionPolicy interface {
+	// insert links a new item.
+	insert(item *CacheItem)
+	// access records a hit or an overwrite of item.
+	access(item *CacheItem)
+	// remove unlinks item.
+	remove(item *CacheItem)
+	// victim returns the item to evict next, or nil if the shard is empty.
+	victim() *CacheItem
+	// walk calls fn for every item. List based policies start with the next
+	// victim; the LFU heap is walked in no particular order.
+	walk(fn func(item *CacheItem))
+}
+
+// newPolicy returns an empty policy for one shard of c.
+func (c *Cache) newPolicy() evictionPolicy {
+	switch c.evictionPolicy {
+	case LFU:
+		return newLFUPolicy(c.maxItemsPerShard)
+	case FIFO:
+		return &fifoPolicy{}
+	case SLRU:
+		return &slruPolicy{
+			protectedCap: int(float64(c.maxItemsPerShard) * (1 - c.segmentRatio)),
+		}
+	case ApproxLRU:
+		return &sampledLRUPolicy{samples: c.lruSamples, now: c.now}
+	default:
+		return &lruPolicy{}
+	}
+}
+
+// itemList is a doubly linked list threaded through the prev and next fields
+// of its items, so linking an item allocates nothing. An item is in at most
+// one list at a time. The zero value is an empty list.
+type itemList struct {
+	front, back *CacheItem
+	len         int
+}
+
+func (l *itemList) pushFront(item *CacheItem) {
+	item.prev = nil
+	item.next = l.front
+	if l.front != nil {
+		l.front.prev = item
+	} else {
+		l.back = item
+	}
+	l.front = item
+	l.len++
+}
+
+func (l *itemList) remove(item *CacheItem) {
+	if item.prev != nil {
+		item.prev.next = item.next
+	} else {
+		l.front = item.next
+	}
+	if item.next != nil {
+		item.next.prev = item.prev
+	} else {
+		l.back = item.prev
+	}
+	item.prev, item.next = nil, nil
+	l.len--
+}
+
+func (l *itemList) moveToFront(item *CacheItem) {
+	if l.front == item {
+		return
+	}
+	l.remove(item)
+	l.pushFront(item)
+}
+
+// walk calls fn for every item from back to front. fn must not unlink items.
+func (l *itemList) walk(fn func(item *CacheItem)) {
+	for item := l.back; item != nil; item = item.prev {
+		fn(item)
+	}
+}
+
+// lruPolicy keeps items in a list ordered from most to least recently used.
+type lruPolicy struct {
+	list itemList
+}
+
+func (p *lruPolicy) insert(item *CacheItem) {
+	p.list.pushFront(item)
+}
+
+func (p *lruPolicy) access(item *CacheItem) {
+	p.list.moveToFront(item)
+}
+
+func (p *lruPolicy) remove(item *CacheItem) {
+	p.list.remove(item)
+}
+
+func (p *lruPolicy) victim() *CacheItem {
+	return p.list.back
+}
+
+func (p *lruPolicy) walk(fn func(item *CacheItem)) {
+	p.list.walk(fn)
+}
+
+// fifoPolicy keeps items in insertion order and ignores accesses.
+type fifoPolicy struct {
+	lruPolicy
+}
+
+func (p *fifoPolicy) access(item *CacheItem) {}
+
+// slruPolicy keeps a probationary and a protected LRU list. The protected
+// list holds at most protectedCap items; overflowing items are demoted to
+// the front of the probationary list.
+type slruPolicy struct {
+	probation    itemList
+	protected    itemList
+	protectedCap int
+}
+
+func (p *slruPolicy) insert(item *CacheItem) {
+	item.protected = false
+	p.probation.pushFront(item)
+}
+
+func (p *slruPolicy) access(item *CacheItem) {
+	if item.protected {
+		p.protected.moveToFront(item)
+		return
+	}
+	p.probation.remove(item)
+	item.protected = true
+	p.protected.pushFront(item)
+
+	if p.protected.len > p.protectedCap {
+		p.demote()
+	}
+}
+
+// demote moves the least recently used protected item to the front of the
+// probationary list.
+func (p *slruPolicy) demote() {
+	demoted := p.protected.back
+	p.protected.remove(demoted)
+	demoted.protected = false
+	p.probation.pushFront(demoted)
+}
+
+// resize sets protectedCap, demoting protected items that no longer fit.
+func (p *slruPolicy) resize(protectedCap int) {
+	p.protectedCap = protectedCap
+	for p.protected.len > protectedCap {
+		p.demote()
+	}
+}
+
+func (p *slruPolicy) remove(item *CacheItem) {
+	if item.protected {
+		p.protected.remove(item)
+	} else {
+		p.probation.remove(item)
+	}
+}
+
+func (p *slruPolicy) victim() *CacheItem {
+	if p.probation.back != nil {
+		return p.probation.back
+	}
+	return p.protected.back
+}
+
+func (p *slruPolicy) walk(fn func(item *CacheItem)) {
+	p.probation.walk(fn)
+	p.protected.walk(fn)
+}
+
+// lfuPolicy keeps items in a min-heap ordered by access count, breaking ties
+// by least recent access. Counts are halved every decayEvery accesses.
+type lfuPolicy struct {
+	items      lfuHeap
+	clock      uint64
+	accesses   int
+	decayEvery int
+}
+
+// lfuDecayFactor sets how many accesses, relative to shard capacity, pass
+// between two halvings of the access counts.
+const lfuDecayFactor = 8
+
+func newLFUPolicy(capacity int) *lfuPolicy {
+	return &lfuPolicy{decayEvery: capacity * lfuDecayFactor}
+}
+
+func (p *lfuPolicy) insert(item *CacheItem) {
+	p.clock++
+	item.freq = 1
+	item.lastAccess = p.clock
+	heap.Push(&p.items, item)
+}
+
+func (p *lfuPolicy) access(item *CacheItem) {
+	p.clock++
+	item.freq++
+	item.lastAccess = p.clock
+	heap.Fix(&p.items, item.heapIndex)
+
+	p.accesses++
+	if p.accesses >= p.decayEvery {
+		p.decay()
+	}
+}
+
+// decay halves every access count. Halving keeps the order between counts
+// but can create new ties, so the heap is rebuilt.
+func (p *lfuPolicy) decay() {
+	p.accesses = 0
+	for _, item := range p.items {
+		item.freq /= 2
+	}
+	heap.Init(&p.items)
+}
+
+func (p *lfuPolicy) remove(item *CacheItem) {
+	heap.Remove(&p.items, item.heapIndex)
+}
+
+func (p *lfuPolicy) victim() *CacheItem {
+	if len(p.items) == 0 {
+		return nil
+	}
+	return p.items[0]
+}
+
+func (p *lfuPolicy) walk(fn func(item *CacheItem)) {
+	for _, item := range p.items {
+		fn(item)
+	}
+}
+
+// lfuHeap implements heap.Interface over items ordered by (freq, lastAccess).
+type lfuHeap []*CacheItem
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].lastAccess < h[j].lastAccess
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*CacheItem)
+	item.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// sampledLRUPolicy keeps items in a slice in no particular order. Every item
+// carries the time of its last use in lastUsed, which lookups holding only
+// the read lock update atomically; victim compares a random sample.
+type sampledLRUPolicy struct {
+	items   []*CacheItem
+	samples int
+	now     func() int64
+}
+
+func (p *sampledLRUPolicy) insert(item *CacheItem) {
+	item.lastUsed.Store(p.now())
+	item.heapIndex = len(p.items)
+	p.items = append(p.items, item)
+}
+
+func (p *sampledLRUPolicy) access(item *CacheItem) {
+	item.lastUsed.Store(p.now())
+}
+
+func (p *sampledLRUPolicy) remove(item *CacheItem) {
+	last := len(p.items) - 1
+	moved := p.items[last]
+	p.items[item.heapIndex] = moved
+	moved.heapIndex = item.heapIndex
+	p.items[last] = nil
+	p.items = p.items[:last]
+}
+
+// victim returns the least recently used of samples random items, or of all
+// items when there are no more than that.
+func (p *sampledLRUPolicy) victim() *CacheItem {
+	if len(p.items) == 0 {
+		return nil
+	}
+	var oldest *CacheItem
+	consider := func(item *CacheItem) {
+		if oldest == nil || item.lastUsed.Load() < oldest.lastUsed.Load() {
+			oldest = item
+		}
+	}
+	if len(p.items) <= p.samples {
+		for _, item := range p.items {
+			consider(item)
+		}
+		return oldest
+	}
+	for i := 0; i < p.samples; i++ {
+		consider(p.items[rand.IntN(len(p.items))])
+	}
+	return oldest
+}
+
+func (p *sampledLRUPolicy) walk(fn func(item *CacheItem)) {
+	for _, item := range p.items {
+		fn(item)
+	}
+}
@@ -0,0 +1,465 @@
+package hoard
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testing LFU eviction, mirroring TestLRUEviction
+func TestLFUEviction(t *testing.T) {
+	cache := NewCache(1, 2, time.Second, WithEvictionPolicy(LFU)) // 1 shard, max 2 items per shard
+	defer cache.Close()
+
+	cache.Store("aboubakr", "kouhadi", time.Second*10)
+	cache.Store("kouhadi", 42, time.Second*10)
+
+	// Read "kouhadi" more often than "aboubakr", which is read last
+	for i := 0; i < 3; i++ {
+		cache.FetchBytesData("kouhadi")
+	}
+	cache.FetchBytesData("aboubakr")
+
+	// Store a third item (should evict "aboubakr" as it is the least frequently used)
+	if err := cache.Store("qux", 3.14, time.Second*10); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, exists := cache.FetchBytesData("aboubakr"); exists {
+		t.Fatal("Expected 'aboubakr' to be evicted")
+	}
+	value, exists, err := cache.FetchData("kouhadi")
+	if err != nil || !exists {
+		t.Fatalf("Expected 'kouhadi' to exist, got %v %v", exists, err)
+	}
+	if value.(int8) != 42 {
+		t.Errorf("Expected 42, got %v", value)
+	}
+}
+
+// testing that a scan of cold keys does not flush a hot key under LFU
+func TestLFUKeepsHotKey(t *testing.T) {
+	cache := NewCache(1, 10, time.Second, WithEvictionPolicy(LFU))
+	defer cache.Close()
+
+	cache.Store("hot", "value", time.Minute)
+	for i := 0; i < 5; i++ {
+		cache.FetchBytesData("hot")
+	}
+	for i := 0; i < 100; i++ {
+		cache.Store("cold"+strconv.Itoa(i), i, time.Minute)
+	}
+	if !cache.Exists("hot") {
+		t.Fatal("Expected hot key to survive the scan")
+	}
+	if n := cache.Len(); n != 10 {
+		t.Errorf("Expected 10 items, got %d", n)
+	}
+}
+
+// testing that access counts decay so a stale hot key can be evicted
+func TestLFUDecay(t *testing.T) {
+	cache := NewCache(1, 2, time.Second, WithEvictionPolicy(LFU))
+	defer cache.Close()
+
+	// Counts are halved every 2*lfuDecayFactor accesses for this shard
+	cache.Store("stale", 1, time.Minute)
+	for i := 0; i < 2*lfuDecayFactor-1; i++ {
+		cache.FetchBytesData("stale")
+	}
+	// Without decay "fresh" would stay less used than "stale"
+	cache.Store("fresh", 2, time.Minute)
+	for i := 0; i < lfuDecayFactor+2; i++ {
+		cache.FetchBytesData("fresh")
+	}
+
+	cache.Store("new", 3, time.Minute)
+	if cache.Exists("stale") {
+		t.Fatal("Expected the stale key to have decayed and been evicted")
+	}
+	if !cache.Exists("fresh") || !cache.Exists("new") {
+		t.Fatal("Expected fresh and new keys to remain")
+	}
+}
+
+// testing that LFU bookkeeping survives deletes and overwrites
+func TestLFUDeleteAndOverwrite(t *testing.T) {
+	cache := NewCache(1, 3, time.Second, WithEvictionPolicy(LFU))
+	defer cache.Close()
+
+	for i := 0; i < 3; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	cache.Delete("key1")
+	cache.Store("key0", "overwritten", time.Minute)
+	cache.Store("key3", 3, time.Minute)
+	cache.Store("key4", 4, time.Minute)
+
+	// key0 was accessed by the overwrite, key2 is the least used
+	if cache.Exists("key2") {
+		t.Error("Expected key2 to be evicted")
+	}
+	for _, key := range []string{"key0", "key3", "key4"} {
+		if !cache.Exists(key) {
+			t.Errorf("Expected %s to remain", key)
+		}
+	}
+}
+
+// testing that an unknown eviction policy is rejected
+func TestUnknownEvictionPolicy(t *testing.T) {
+	if _, err := NewCacheWithOptions(WithEvictionPolicy(EvictionPolicy(42))); err == nil {
+		t.Fatal("Expected an error for an unknown eviction policy")
+	}
+}
+
+// testing that FIFO evicts the oldest insert regardless of reads
+func TestFIFOEviction(t *testing.T) {
+	cache := NewCache(1, 2, time.Second, WithEvictionPolicy(FIFO))
+	defer cache.Close()
+
+	cache.Store("first", 1, time.Minute)
+	cache.Store("second", 2, time.Minute)
+
+	// Reads and overwrites do not move "first" back
+	cache.FetchBytesData("first")
+	cache.Store("first", "overwritten", time.Minute)
+
+	cache.Store("third", 3, time.Minute)
+	if cache.Exists("first") {
+		t.Fatal("Expected 'first' to be evicted")
+	}
+	if !cache.Exists("second") || !cache.Exists("third") {
+		t.Fatal("Expected 'second' and 'third' to remain")
+	}
+}
+
+// testing that FIFO reads treat expired entries as misses
+func TestFIFOExpiredFetch(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 2, 0, WithEvictionPolicy(FIFO), WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("key", "value", time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	if _, ok, _ := cache.FetchData("key"); ok {
+		t.Fatal("Expected expired entry to be a miss")
+	}
+	if _, missing := cache.FetchBytesMany([]string{"key"}); len(missing) != 1 {
+		t.Fatal("Expected expired entry to be missing")
+	}
+}
+
+// testing that SLRU keeps a read working set through a scan of cold keys
+func TestSLRUScanResistance(t *testing.T) {
+	const hot = 50
+	cache := NewCache(1, 100, time.Second, WithEvictionPolicy(SLRU), WithSegmentRatio(0.2))
+	defer cache.Close()
+
+	for i := 0; i < hot; i++ {
+		cache.Store("hot"+strconv.Itoa(i), i, time.Minute)
+	}
+	for i := 0; i < hot; i++ {
+		cache.FetchBytesData("hot" + strconv.Itoa(i))
+	}
+	for i := 0; i < 10*hot; i++ {
+		cache.Store("cold"+strconv.Itoa(i), i, time.Minute)
+	}
+
+	for i := 0; i < hot; i++ {
+		if !cache.Exists("hot" + strconv.Itoa(i)) {
+			t.Fatalf("Expected hot%d to survive the scan", i)
+		}
+	}
+	if n := cache.Len(); n != 100 {
+		t.Errorf("Expected 100 items, got %d", n)
+	}
+}
+
+// testing that the protected segment is bounded and demotes its LRU entry
+func TestSLRUProtectedCapacity(t *testing.T) {
+	// 10 items per shard, 5 protected
+	cache := NewCache(1, 10, time.Second, WithEvictionPolicy(SLRU), WithSegmentRatio(0.5))
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	// Promote six keys; key0 is demoted back to probation
+	for i := 0; i < 6; i++ {
+		cache.FetchBytesData("key" + strconv.Itoa(i))
+	}
+	// Probation now holds key0 at its front, so four inserts evict key6..key9
+	// and the next one evicts key0
+	for i := 0; i < 4; i++ {
+		cache.Store("new"+strconv.Itoa(i), i, time.Minute)
+	}
+	if !cache.Exists("key0") {
+		t.Fatal("Expected demoted key0 to still be cached")
+	}
+	cache.Store("new4", 4, time.Minute)
+	if cache.Exists("key0") {
+		t.Fatal("Expected demoted key0 to be evicted")
+	}
+	for i := 1; i < 6; i++ {
+		if !cache.Exists("key" + strconv.Itoa(i)) {
+			t.Errorf("Expected protected key%d to remain", i)
+		}
+	}
+}
+
+// testing that segment ratios outside (0, 1) are rejected
+func TestInvalidSegmentRatio(t *testing.T) {
+	for _, r := range []float64{0, 1, -0.5, 2} {
+		if _, err := NewCacheWithOptions(WithEvictionPolicy(SLRU), WithSegmentRatio(r)); err == nil {
+			t.Errorf("Expected an error for ratio %v", r)
+		}
+	}
+}
+
+// testing that ApproxLRU evicts the least recently read entry when the
+// sample covers the whole shard
+func TestApproxLRUEviction(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 3, 0, WithEvictionPolicy(ApproxLRU), WithLRUSamples(3), WithClock(clock))
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Store(key, key, time.Hour)
+		clock.Advance(time.Millisecond)
+	}
+	cache.FetchBytesData("a") // a is now the most recently used
+	clock.Advance(time.Millisecond)
+	cache.Store("b", "b2", time.Hour) // an overwrite counts as use
+	clock.Advance(time.Millisecond)
+
+	cache.Store("d", "d", time.Hour) // evicts c
+	if cache.Exists("c") || !cache.Exists("a") || !cache.Exists("b") || !cache.Exists("d") {
+		t.Fatal("Expected c to be evicted")
+	}
+	cache.Store("e", "e", time.Hour) // evicts a
+	if cache.Exists("a") || cache.Len() != 3 {
+		t.Fatalf("Expected a to be evicted, got %d items", cache.Len())
+	}
+}
+
+// testing that ApproxLRU keeps frequently read keys over a stream of new
+// ones while sampling
+func TestApproxLRUKeepsHotKeys(t *testing.T) {
+	cache := NewCache(1, 100, 0, WithEvictionPolicy(ApproxLRU), WithLRUSamples(10))
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.StoreBytes("hot"+strconv.Itoa(i%10), nil, time.Hour)
+		cache.StoreBytes("cold"+strconv.Itoa(i), nil, time.Hour)
+	}
+	survivors := 0
+	for i := 0; i < 1000; i++ {
+		for j := 0; j < 10; j++ {
+			cache.FetchBytesData("hot" + strconv.Itoa(j))
+		}
+		cache.StoreBytes("new"+strconv.Itoa(i), nil, time.Hour)
+	}
+	for j := 0; j < 10; j++ {
+		if cache.Exists("hot" + strconv.Itoa(j)) {
+			survivors++
+		}
+	}
+	if survivors < 8 || cache.Len() != 100 {
+		t.Fatalf("Expected the hot keys to survive, %d of 10 did (%d items)", survivors, cache.Len())
+	}
+}
+
+// testing that non-positive sample counts are rejected
+func TestInvalidLRUSamples(t *testing.T) {
+	if _, err := NewCacheWithOptions(WithEvictionPolicy(ApproxLRU), WithLRUSamples(0)); err == nil {
+		t.Error("Expected an error for zero samples")
+	}
+}
+
+// testing that a byte budget holds for values of mixed sizes
+func TestMaxBytes(t *testing.T) {
+	const limit = 4096
+	cache := NewCache(1, 1000, time.Second, WithMaxBytes(limit))
+	defer cache.Close()
+
+	sizes := []int{10, 500, 1500, 3, 2000, 64, 900, 1, 3000, 250}
+	for i := 0; i < 100; i++ {
+		size := sizes[i%len(sizes)]
+		cache.StoreBytes("key"+strconv.Itoa(i), make([]byte, size), time.Minute)
+		if b := cache.Stats().Bytes; b > limit {
+			t.Fatalf("Store %d: tracked bytes %d exceed limit %d", i, b, limit)
+		}
+	}
+
+	// Updates and deletes keep the accounting exact
+	cache.CleanupAll()
+	cache.Store("a", "value", time.Minute)
+	cache.Update("a", make([]byte, 1000), time.Minute)
+	cache.Delete("a")
+	if b := cache.Stats().Bytes; b != 0 {
+		t.Errorf("Expected 0 tracked bytes on an empty cache, got %d", b)
+	}
+}
+
+// testing that one large insert can evict several small entries
+func TestMaxBytesLargeInsert(t *testing.T) {
+	small := itemSize("s0", make([]byte, 100))
+	cache := NewCache(1, 1000, time.Second, WithMaxBytes(10*small))
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.StoreBytes("s"+strconv.Itoa(i), make([]byte, 100), time.Minute)
+	}
+	if n := cache.Len(); n != 10 {
+		t.Fatalf("Expected 10 small entries to fit, got %d", n)
+	}
+
+	// Needs the room of about four small entries
+	cache.StoreBytes("big", make([]byte, int(3*small)), time.Minute)
+	if !cache.Exists("big") {
+		t.Fatal("Expected the large entry to be stored")
+	}
+	if n := cache.Len(); n != 7 {
+		t.Errorf("Expected 4 small entries to be evicted, got %d items left", n)
+	}
+	if ev := cache.Stats().Evictions; ev != 4 {
+		t.Errorf("Expected 4 evictions, got %d", ev)
+	}
+	for i := 0; i < 4; i++ {
+		if cache.Exists("s" + strconv.Itoa(i)) {
+			t.Errorf("Expected least recently used s%d to be evicted", i)
+		}
+	}
+
+	// A value that cannot fit on its own is not kept
+	cache.StoreBytes("huge", make([]byte, int(20*small)), time.Minute)
+	if cache.Exists("huge") {
+		t.Error("Expected an oversized entry not to be kept")
+	}
+}
+
+// testing cost-based eviction and cost accounting
+func TestMaxCostPerShard(t *testing.T) {
+	cache := NewCache(1, 1000, time.Second, WithMaxCostPerShard(10))
+	defer cache.Close()
+
+	for i := 0; i < 5; i++ {
+		cache.Store("cheap"+strconv.Itoa(i), i, time.Minute) // cost 1 each
+	}
+	if err := cache.StoreWithCost("pricey", "value", time.Minute, 5); err != nil {
+		t.Fatal(err)
+	}
+	if c := cache.Stats().Cost; c != 10 {
+		t.Fatalf("Expected total cost 10, got %d", c)
+	}
+
+	// Cost 3 needs three more units, taken from the least recently used
+	cache.StoreWithCost("extra", "value", time.Minute, 3)
+	for i := 0; i < 3; i++ {
+		if cache.Exists("cheap" + strconv.Itoa(i)) {
+			t.Errorf("Expected cheap%d to be evicted", i)
+		}
+	}
+	if !cache.Exists("pricey") || !cache.Exists("extra") {
+		t.Fatal("Expected pricey and extra to remain")
+	}
+
+	// Update keeps the cost, overwrite and delete adjust it
+	cache.Update("pricey", "new value", time.Minute)
+	if c := cache.Stats().Cost; c != 10 {
+		t.Errorf("Expected Update to keep the cost, got %d", c)
+	}
+	cache.StoreWithCost("pricey", "value", time.Minute, 2)
+	cache.Delete("extra")
+	if c := cache.Stats().Cost; c != 4 {
+		t.Errorf("Expected total cost 4, got %d", c)
+	}
+
+	if err := cache.StoreWithCost("bad", 1, time.Minute, -1); err == nil {
+		t.Error("Expected an error for a negative cost")
+	}
+}
+
+// testing that eviction skips pinned entries
+func TestPinSkipsEviction(t *testing.T) {
+	cache := NewCache(1, 3, time.Second)
+	defer cache.Close()
+
+	cache.Store("config", "value", time.Minute)
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+	if err := cache.Pin("config"); err != nil {
+		t.Fatal(err)
+	}
+
+	// "config" is the LRU tail but pinned, so "a" and then "b" go first
+	cache.Store("c", 3, time.Minute)
+	cache.Store("d", 4, time.Minute)
+	if !cache.Exists("config") {
+		t.Fatal("Expected pinned key to survive eviction")
+	}
+	if cache.Exists("a") || cache.Exists("b") {
+		t.Fatal("Expected unpinned keys to be evicted")
+	}
+
+	// Once unpinned it is evictable again, as the most recent entry
+	if err := cache.Unpin("config"); err != nil {
+		t.Fatal(err)
+	}
+	cache.Store("e", 5, time.Minute)
+	cache.Store("f", 6, time.Minute)
+	cache.Store("g", 7, time.Minute)
+	if cache.Exists("config") {
+		t.Fatal("Expected unpinned key to be evicted")
+	}
+
+	if err := cache.Pin("missing"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+// testing that a shard full of pinned entries rejects new keys
+func TestPinAllPinnedFull(t *testing.T) {
+	cache := NewCache(1, 2, time.Second)
+	defer cache.Close()
+
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+	cache.Pin("a")
+	cache.Pin("b")
+
+	if err := cache.Store("c", 3, time.Minute); err != ErrCacheFull {
+		t.Fatalf("Expected ErrCacheFull, got %v", err)
+	}
+	// Overwriting a pinned key still works
+	if err := cache.Store("a", 10, time.Minute); err != nil {
+		t.Fatalf("Expected overwrite of a pinned key to succeed, got %v", err)
+	}
+
+	// Pinned entries can still be deleted, and CleanupAll removes them
+	cache.Delete("b")
+	if err := cache.Store("c", 3, time.Minute); err != nil {
+		t.Fatalf("Expected room after Delete, got %v", err)
+	}
+	cache.CleanupAll()
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected CleanupAll to remove pinned entries, got %d items", n)
+	}
+}
+
+// testing that pinned entries still expire
+func TestPinnedExpires(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 2, 0, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("key", "value", 5*time.Millisecond)
+	cache.Pin("key")
+	clock.Advance(10 * time.Millisecond)
+	if _, ok := cache.FetchBytesData("key"); ok {
+		t.Fatal("Expected pinned entry to expire")
+	}
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected expired pinned entry to be removed, got %d items", n)
+	}
+}
@@ -0,0 +1,160 @@
+package hoard
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+)
+
+// ErrPastExpiration is returned by StoreUntil and UpdateUntil for a deadline
+// that is not after the current time.
+var ErrPastExpiration = errors.New("hoard: expiration is in the past")
+
+// StoreUntil is like Store but expires the entry at the absolute time
+// expiresAt, for values whose validity is known as a point in time such as
+// token expiries. The deadline is stored as given, without the TTL jitter
+// and default ttl that apply to relative lifetimes, so ExpiresAt returns it
+// back and TTL the time left until it. A deadline not after the cache's
+// current time is rejected with ErrPastExpiration and nothing is stored; an
+// existing entry under key is left alone.
+func (c *Cache) StoreUntil(key string, value interface{}, expiresAt time.Time) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if err := c.checkDeadline(expiresAt); err != nil {
+		return err
+	}
+	return c.StoreWithOptions(key, value, ItemOptions{ExpiresAt: expiresAt})
+}
+
+// UpdateUntil is like Update but sets the absolute deadline expiresAt, which
+// is checked like StoreUntil checks it.
+func (c *Cache) UpdateUntil(key string, value interface{}, expiresAt time.Time) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if err := c.checkDeadline(expiresAt); err != nil {
+		return err
+	}
+	return c.update(key, value, expiresAt.UnixNano())
+}
+
+// checkDeadline rejects deadlines that are not after the current time.
+func (c *Cache) checkDeadline(expiresAt time.Time) error {
+	if !expiresAt.After(c.clock.Now()) {
+		return ErrPastExpiration
+	}
+	return nil
+}
+
+// expiryHeap is a min-heap of the items of a shard that have a deadline,
+// ordered by Expiration, so cleanup only looks at entries that are due. An
+// item is in the heap exactly when it is stored in the shard and its
+// Expiration is not zero.
+type expiryHeap []*CacheItem
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].Expiration < h[j].Expiration }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expIndex = i
+	h[j].expIndex = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	item := x.(*CacheItem)
+	item.expIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// setDeadline changes the Expiration of item and moves it in, into or out of
+// the expiry heap. The caller must hold s.mu for writing.
+func (s *CacheShard) setDeadline(item *CacheItem, exp int64) {
+	switch {
+	case item.Expiration == 0 && exp != 0:
+		item.Expiration = exp
+		heap.Push(&s.expiry, item)
+	case item.Expiration != 0 && exp == 0:
+		heap.Remove(&s.expiry, item.expIndex)
+		item.Expiration = 0
+	case item.Expiration != exp:
+		item.Expiration = exp
+		heap.Fix(&s.expiry, item.expIndex)
+	}
+}
+
+// unlinkDeadline removes item from the expiry heap. The caller must hold s.mu
+// for writing.
+func (s *CacheShard) unlinkDeadline(item *CacheItem) {
+	if item.Expiration != 0 {
+		heap.Remove(&s.expiry, item.expIndex)
+	}
+}
+
+// appendDue appends the keys and deadlines of the items expiring in
+// [from, to]. It walks only the part of the heap due by to, since the
+// children of a node never expire before it. The caller must hold s.mu.
+func (s *CacheShard) appendDue(dst []shardDeadline, from, to int64) []shardDeadline {
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(s.expiry) || s.expiry[i].Expiration > to {
+			continue
+		}
+		if item := s.expiry[i]; item.Expiration >= from {
+			dst = append(dst, shardDeadline{item.key, item.Expiration})
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	return dst
+}
+
+type shardDeadline struct {
+	key string
+	exp int64
+}
+
+// ExpiringWithin returns the keys of the live entries that expire within d
+// from now, for jobs that refresh entries before they expire. Entries
+// without expiration are never included. Each shard is read-locked in turn
+// and only its entries due within d are looked at.
+func (c *Cache) ExpiringWithin(d time.Duration) []string {
+	var keys []string
+	c.ExpiringWithinFunc(d, func(key string, _ time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// ExpiringWithinFunc is like ExpiringWithin but calls fn with every key and
+// its deadline, without collecting them, until fn returns false. No lock is
+// held while fn runs.
+func (c *Cache) ExpiringWithinFunc(d time.Duration, fn func(key string, expiresAt time.Time) bool) {
+	if c.isClosed() {
+		return
+	}
+	var buf []shardDeadline
+	for _, shard := range c.shards {
+		now := c.now()
+		shard.mu.RLock()
+		buf = shard.appendDue(buf[:0], now, now+int64(d))
+		shard.mu.RUnlock()
+		for _, e := range buf {
+			if !fn(e.key, time.Unix(0, e.exp)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package hoard
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing that cleanup removes due entries promptly and keeps the rest
+func TestExpiryHeapCleanup(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(2, 1000, 0, WithClock(clock))
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Store("short"+strconv.Itoa(i), i, 10*time.Millisecond)
+		cache.Store("long"+strconv.Itoa(i), i, time.Hour)
+	}
+	cache.Store("forever", 1, NoExpiration)
+
+	clock.Advance(20 * time.Millisecond)
+	cache.RunCleanup()
+	if n := cache.Len(); n != 101 {
+		t.Fatalf("Expected short-lived entries to be cleaned up, %d items left", n)
+	}
+	if keys := cache.KeysWithPrefix("long"); len(keys) != 100 || !cache.Exists("forever") {
+		t.Fatalf("Expected long-lived entries to remain, got %d", len(keys))
+	}
+	assertExpiryHeap(t, cache)
+}
+
+// testing that the heap follows Touch, Update, Delete and eviction
+func TestExpiryHeapTracksChanges(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 3, 0, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("a", 1, 10*time.Millisecond)
+	cache.Store("b", 2, 10*time.Millisecond)
+	cache.Store("c", 3, NoExpiration)
+	cache.Touch("a", time.Hour)            // pushed back
+	cache.Update("b", 2, NoExpiration)     // leaves the heap
+	cache.Update("c", 3, time.Millisecond) // joins the heap
+	assertExpiryHeap(t, cache)
+	if n := len(cache.shards[0].expiry); n != 2 {
+		t.Fatalf("Expected 2 entries with a deadline, got %d", n)
+	}
+
+	clock.Advance(20 * time.Millisecond)
+	cache.RunCleanup()
+	if cache.Exists("c") || !cache.Exists("a") || !cache.Exists("b") {
+		t.Fatal("Expected only c to be cleaned up")
+	}
+
+	cache.Delete("a")
+	cache.Store("d", 4, time.Minute)
+	cache.Store("e", 5, time.Minute)
+	cache.Store("f", 6, time.Minute) // evicts b
+	assertExpiryHeap(t, cache)
+	if n := len(cache.shards[0].expiry); n != 3 {
+		t.Fatalf("Expected 3 entries with a deadline, got %d", n)
+	}
+}
+
+// assertExpiryHeap checks that every shard's heap holds exactly its entries
+// with a deadline, at their recorded positions.
+func assertExpiryHeap(t *testing.T, cache *Cache) {
+	t.Helper()
+	for i, shard := range cache.shards {
+		shard.mu.RLock()
+		want := 0
+		for _, item := range shard.data {
+			if item.Expiration != 0 {
+				want++
+				if shard.expiry[item.expIndex] != item {
+					t.Errorf("Shard %d: item %q is not at its heap index", i, item.key)
+				}
+			}
+		}
+		if len(shard.expiry) != want {
+			t.Errorf("Shard %d: expected %d heap entries, got %d", i, want, len(shard.expiry))
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// testing that batched cleanup removes everything in several lock
+// acquisitions and keeps concurrent Fetch latency bounded
+func TestCleanupBatchLatency(t *testing.T) {
+	const numKeys = 200_000
+	clock := newFakeClock()
+	cache := NewCache(1, numKeys+1, 0, WithCleanupBatchSize(500), WithClock(clock))
+	defer cache.Close()
+	for i := 0; i < numKeys; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Millisecond)
+	}
+	cache.Store("probe", "value", time.Hour)
+	clock.Advance(5 * time.Millisecond)
+
+	// Watchdog measuring how long a Fetch waits for the shard lock
+	stop := make(chan struct{})
+	maxWait := make(chan time.Duration)
+	go func() {
+		var worst time.Duration
+		for {
+			select {
+			case <-stop:
+				maxWait <- worst
+				return
+			default:
+			}
+			start := time.Now()
+			cache.FetchBytesData("probe")
+			if d := time.Since(start); d > worst {
+				worst = d
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	start := time.Now()
+	cache.cleanupShard(cache.shards[0])
+	elapsed := time.Since(start)
+	close(stop)
+	worst := <-maxWait
+
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("Expected only the probe to remain, got %d items", n)
+	}
+	if worst > 20*time.Millisecond {
+		t.Errorf("Expected Fetch to wait at most one batch, waited %v during a %v cleanup", worst, elapsed)
+	}
+}
+
+// testing that a time slice makes progress however small it is
+func TestCleanupTimeSlice(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 1000, 0, WithCleanupTimeSlice(time.Nanosecond), WithClock(clock))
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Millisecond)
+	}
+	clock.Advance(5 * time.Millisecond)
+	cache.cleanupShard(cache.shards[0])
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected every entry to be removed, got %d items", n)
+	}
+}
+
+// testing that ExpiringWithin only returns entries due within the window
+func TestExpiringWithin(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(4, 1000, 0, WithClock(clock))
+	defer cache.Close()
+
+	for i := 0; i < 50; i++ {
+		cache.Store("soon"+strconv.Itoa(i), i, time.Duration(i+1)*time.Second)
+		cache.Store("later"+strconv.Itoa(i), i, time.Hour+time.Duration(i)*time.Second)
+	}
+	cache.Store("forever", 1, NoExpiration)
+	cache.Store("gone", 1, time.Millisecond)
+	clock.Advance(time.Second)
+
+	keys := cache.ExpiringWithin(time.Minute)
+	slices.Sort(keys)
+	want := cache.KeysWithPrefix("soon")
+	slices.Sort(want)
+	if !slices.Equal(keys, want) {
+		t.Fatalf("Expected only the imminent keys, got %v", keys)
+	}
+	// soon0 expires right now and is still live
+	if keys := cache.ExpiringWithin(10 * time.Second); len(keys) != 11 {
+		t.Fatalf("Expected the 11 keys due within 10s, got %v", keys)
+	}
+	if keys := cache.ExpiringWithin(2 * time.Hour); len(keys) != 100 || slices.Contains(keys, "forever") {
+		t.Fatalf("Expected every expiring key but not forever, got %d keys", len(keys))
+	}
+
+	n := 0
+	cache.ExpiringWithinFunc(time.Minute, func(key string, at time.Time) bool {
+		if at.After(clock.Now().Add(time.Minute)) {
+			t.Fatalf("Expected %s to expire within the window, got %v", key, at)
+		}
+		n++
+		return n < 5
+	})
+	if n != 5 {
+		t.Fatalf("Expected iteration to stop after 5 keys, got %d", n)
+	}
+}
+
+// panickyClock is a fakeClock that panics while armed.
+type panickyClock struct {
+	*fakeClock
+	armed atomic.Bool
+}
+
+func (c *panickyClock) Now() time.Time {
+	if c.armed.Load() {
+		panic("clock broke")
+	}
+	return c.fakeClock.Now()
+}
+
+// testing that a panic during cleanup is reported and later ticks still run
+func TestCleanupPanicRecovers(t *testing.T) {
+	clock := &panickyClock{fakeClock: newFakeClock()}
+	errs := make(chan error, 100)
+	cache := NewCache(2, 100, 5*time.Millisecond, WithClock(clock), WithErrorHandler(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	defer cache.Close()
+	cache.Store("short", "value", time.Second)
+
+	clock.armed.Store(true)
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "cleanup panicked: clock broke") {
+			t.Fatalf("Expected the panic to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the panic to be reported")
+	}
+	clock.armed.Store(false)
+
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool { return cache.Stats().CleanupRemovals == 1 })
+	cache.Store("key", "value", NoExpiration)
+	if v := mustFetch(t, cache, "key"); v != "value" {
+		t.Fatalf("Expected the cache to keep working, got %v", v)
+	}
+}
+
+// testing that absolute deadlines are stored as given and reported the same
+// way as relative ones
+func TestStoreUntil(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(2, 100, 0, WithClock(clock), WithTTLJitter(0.5), WithDefaultTTL(time.Minute))
+	defer cache.Close()
+
+	deadline := clock.Now().Add(time.Hour)
+	if err := cache.StoreUntil("token", "abc", deadline); err != nil {
+		t.Fatalf("StoreUntil failed: %v", err)
+	}
+	if at, ok := cache.ExpiresAt("token"); !ok || !at.Equal(deadline) {
+		t.Fatalf("Expected the deadline as given, got %v", at)
+	}
+	if ttl, ok := cache.TTL("token"); !ok || ttl != time.Hour {
+		t.Fatalf("Expected an hour left, got %v", ttl)
+	}
+
+	// Past deadlines are rejected and leave the entry alone
+	for _, at := range []time.Time{clock.Now(), clock.Now().Add(-time.Second), {}} {
+		if err := cache.StoreUntil("token", "old", at); !errors.Is(err, ErrPastExpiration) {
+			t.Fatalf("Expected ErrPastExpiration for %v, got %v", at, err)
+		}
+		if err := cache.UpdateUntil("token", "old", at); !errors.Is(err, ErrPastExpiration) {
+			t.Fatalf("Expected ErrPastExpiration for %v, got %v", at, err)
+		}
+	}
+	if v := mustFetch(t, cache, "token"); v != "abc" {
+		t.Fatalf("Expected the entry to be left alone, got %v", v)
+	}
+
+	later := deadline.Add(time.Hour)
+	if err := cache.UpdateUntil("token", "def", later); err != nil {
+		t.Fatalf("UpdateUntil failed: %v", err)
+	}
+	if at, _ := cache.ExpiresAt("token"); !at.Equal(later) || mustFetch(t, cache, "token") != "def" {
+		t.Fatalf("Expected the new value and deadline, got %v", at)
+	}
+	if err := cache.UpdateUntil("missing", 1, later); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if !cache.Exists("token") {
+		t.Fatal("Expected the entry to live until exactly its deadline")
+	}
+	clock.Advance(time.Nanosecond)
+	if cache.Exists("token") {
+		t.Fatal("Expected the entry to expire after its deadline")
+	}
+}
+
+// testing that absolute deadlines survive a snapshot
+func TestStoreUntilSnapshot(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(2, 100, 0, WithClock(clock))
+	defer cache.Close()
+	deadline := clock.Now().Add(90 * time.Minute)
+	cache.StoreUntil("token", "abc", deadline)
+	cache.StoreUntil("short", "xyz", clock.Now().Add(time.Minute))
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	clock.Advance(30 * time.Minute)
+	restored := NewCache(2, 100, 0, WithClock(clock))
+	defer restored.Close()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if at, ok := restored.ExpiresAt("token"); !ok || !at.Equal(deadline) {
+		t.Fatalf("Expected the deadline to survive, got %v", at)
+	}
+	if ttl, _ := restored.TTL("token"); ttl != time.Hour {
+		t.Fatalf("Expected an hour left after the restore, got %v", ttl)
+	}
+	if restored.Exists("short") {
+		t.Fatal("Expected the entry whose deadline passed to be skipped")
+	}
+}
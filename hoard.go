@@ -1,224 +1,2073 @@
 package hoard
 
 import (
-	"container/list"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
-	"hash"
-	"hash/fnv"
+	"hash/maphash"
+	"log/slog"
+	"math/rand/v2"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type CacheItem struct {
 	Value      []byte
-	Expiration int64
-	LRUElement *list.Element
+	Expiration int64 // unix nanoseconds, 0 means the item never expires
+
+	// Under WithArenaStorage Value is nil and the value is stored in the
+	// shard's arena instead, see CacheShard.value
+	arenaSlab, arenaOff, arenaLen uint32
+
+	key   string
+	flags byte
+	sum   uint32 // checksum of Value, see WithChecksums
+	cost  int64
+	ttl   time.Duration // lifetime granted by the last write, see setExpiration
+	tags  []string
+	// pinned items are unlinked from the eviction policy so they are never
+	// chosen as victims
+	pinned bool
+	// sliding items renew their deadline on each hit, see ItemOptions
+	sliding bool
+
+	// Eviction policy bookkeeping
+	prev, next *CacheItem // links in an LRU, FIFO or SLRU list, see itemList
+	freq       uint32
+	lastAccess uint64
+	heapIndex  int          // position in the LFU heap or the ApproxLRU slice
+	lastUsed   atomic.Int64 // unix nanoseconds of the last hit under ApproxLRU
+	expIndex   int          // position in the shard's expiry heap
+	protected  bool
+
+	// Access metadata, recorded with WithAccessMetadata
+	created  int64 // unix nanoseconds of the last store
+	accessed atomic.Int64
+	hits     atomic.Uint64
+}
+
+// CacheItem flags.
+const (
+	// itemRaw marks values stored with StoreBytes, which bypass the serializer.
+	itemRaw byte = 1 << iota
+	// itemInt marks counters written by Increment, stored as 8 little endian
+	// bytes.
+	itemInt
+	// itemNegative marks keys known to be missing, written by StoreNegative
+	// with an empty value.
+	itemNegative
+	// itemCompressed marks values compressed with the codec configured with
+	// WithCompression; the other flags describe the decompressed value.
+	itemCompressed
+	// itemEncrypted marks values encrypted with the key configured with
+	// WithEncryption, after any compression.
+	itemEncrypted
+	// itemCorrupted marks views of values that failed their checksum, see
+	// WithChecksums. It is never stored.
+	itemCorrupted
+)
+
+// itemView is a copy of the fields of a CacheItem taken under the shard lock.
+// It stays valid after the lock is released and the item is recycled.
+type itemView struct {
+	value      []byte
+	expiration int64
+	ttl        time.Duration
+	flags      byte
+	sum        uint32
+}
+
+func (item *CacheItem) view() itemView {
+	return itemView{value: item.Value, expiration: item.Expiration, ttl: item.ttl, flags: item.flags, sum: item.sum}
+}
+
+// expired reports whether the item's deadline passed before now.
+func (item *CacheItem) expired(now int64) bool {
+	return item.Expiration != 0 && now > item.Expiration
+}
+
+// Special ttl values.
+const (
+	// NoExpiration keeps an entry until it is deleted or evicted.
+	NoExpiration time.Duration = -1
+	// DefaultExpiration uses the ttl configured with WithDefaultTTL.
+	DefaultExpiration time.Duration = 0
+)
+
+// expiration converts a ttl into an absolute deadline for CacheItem.Expiration.
+// It resolves ttl against the configured default TTL, which applies to every
+// ttl <= 0 other than NoExpiration, and applies the TTL jitter.
+func (c *Cache) expiration(ttl time.Duration) int64 {
+	if ttl <= 0 && ttl != NoExpiration && c.defaultTTL != 0 {
+		ttl = c.defaultTTL
+	}
+	if ttl == NoExpiration {
+		return 0
+	}
+	if ttl > 0 && c.ttlJitter > 0 {
+		ttl += time.Duration(float64(ttl) * c.ttlJitter * (2*rand.Float64() - 1))
+	}
+	return c.now() + int64(ttl)
+}
+
+type CacheShard struct {
+	mu     shardMutex
+	data   map[string]*CacheItem
+	policy evictionPolicy
+	bytes  int64                          // estimated memory held by the entries, see itemSize
+	cost   int64                          // total cost of the entries, see StoreWithCost
+	tags   map[string]map[string]struct{} // tag -> keys in this shard
+	expiry expiryHeap
+	stats  shardStats
+	stamp  bool // lookups record hits in lastUsed, see ApproxLRU
+	meta   bool // hits and stores record access metadata, see WithAccessMetadata
+	// checksums makes stores record the checksum of each value, see
+	// WithChecksums
+	checksums bool
+	// items counts the entries of the whole cache under WithMaxItems, and
+	// size mirrors len(data) so other shards can read it without the lock;
+	// both are only maintained when items is set
+	items *itemCounter
+	size  atomic.Int64
+	// reserved counts the entries a Txn commit reserved in items for the
+	// keys it adds, see Tx.reserve. It is only used under mu.
+	reserved int
+	// admission decides whether new keys may evict, see WithAdmissionFilter
+	admission *tinyLFU
+	// capacity is the maximum number of entries, maxItemsPerShard unless
+	// changed by Resize
+	capacity int
+	// view holds the entries for lock-free lookups, and viewMisses counts
+	// the lookups that took the lock since it was built, see
+	// WithReadOptimizedShards
+	view       atomic.Pointer[readView]
+	viewMisses atomic.Int64
+	// arena holds the values under WithArenaStorage
+	arena *arena
+	// evicted records the first entry evicted while set, see
+	// StoreGetEvicted. It is only set and read under mu.
+	evicted *evictedEntry
+	// scanIdx orders the keys by scan position once Scan has used the
+	// shard, see scanIndex
+	scanIdx *scanIndex
+}
+
+// itemOverhead estimates the memory an entry needs besides its key and
+// value: the CacheItem, its eviction policy node and its map slot.
+const itemOverhead = 96
+
+// itemSize estimates the memory held by an entry.
+func itemSize(key string, val []byte) int64 {
+	return int64(len(key) + len(val) + itemOverhead)
+}
+
+type Cache struct {
+	shards           []*CacheShard
+	numShards        int
+	maxItemsPerShard int
+	initialCapacity  int
+	maxItems         int // across all shards, see WithMaxItems
+	admission        bool
+	mem              *memWatcher
+	// maintenance serializes the cleanup and memory watcher passes
+	maintenance      sync.Mutex
+	items            itemCounter
+	maxKeyLength     int
+	maxValueSize     int
+	cleanupInterval  time.Duration
+	defaultTTL       time.Duration
+	hash             func(key string) uint32
+	shardFn          func(key string, numShards int) int
+	pow2Shards       bool
+	evictionPolicy   EvictionPolicy
+	policySet        bool // evictionPolicy was chosen with WithEvictionPolicy
+	segmentRatio     float64
+	lruSamples       int
+	sliding          bool
+	slidingItems     atomic.Bool // an entry was stored with ItemOptions.Sliding
+	clock            Clock
+	ttlJitter        float64
+	cleanupBatchSize int
+	cleanupTimeSlice time.Duration
+	iterateWorkers   int
+	maxBytes         int64
+	maxCost          int64
+	hot              *hotKeys
+	accessMeta       bool
+	latency          *latencyTracker // see WithLatencyTracking and WithSlowOpThreshold
+	readOptimized    bool            // see WithReadOptimizedShards
+	arenaStorage     bool            // see WithArenaStorage
+	logger           *slog.Logger    // see WithLogger
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	loads  group
+	loader func(ctx context.Context, key string) (interface{}, time.Duration, error)
+	watch  watchers
+	// Keys being reloaded by refresh-ahead, see WithRefreshAhead
+	refreshAhead float64
+	refreshing   sync.Map
+	backend      Backend
+	tier         TierStore
+	turns        keyTurns // orders the backend and tier I/O of each key
+
+	id            string // origin of published invalidation events
+	bus           InvalidationBus
+	unsubscribe   func() // returned by bus.Subscribe
+	invalidateOn  InvalidateOn
+	invalidations chan string
+
+	wb           *writeBehind
+	wbQueueSize  int
+	wbWorkers    int
+	onFlushError func(key string, err error)
+
+	snapshotPath     string
+	snapshotInterval time.Duration
+	onError          func(error)
+	wal              *writeLog
+	serializer       Serializer
+	codec            Codec // compresses large values, see WithCompression
+	compressMin      int
+	encryptionKey    []byte
+	checksums        bool
+	aead             cipher.AEAD // built from encryptionKey
+}
+
+var (
+	// ErrCacheClosed is returned by operations on a cache that has been closed.
+	ErrCacheClosed = errors.New("hoard: cache is closed")
+	// ErrKeyNotFound is returned when an operation requires a live entry.
+	ErrKeyNotFound = errors.New("hoard: key not found")
+	// ErrCacheFull is returned when a new entry does not fit in its shard
+	// because every entry there is pinned.
+	ErrCacheFull = errors.New("hoard: cache is full")
+	// ErrSerialization wraps errors from the Serializer and values that
+	// cannot be decoded into the requested type.
+	ErrSerialization = errors.New("hoard: serialization failed")
+	// ErrBackend wraps errors returned by the Backend set with WithBackend.
+	ErrBackend = errors.New("hoard: backend failed")
+)
+
+// cacheItemPool recycles CacheItem structs. Only the struct is reused: a
+// Value slice is never written to after it has been stored, every Store and
+// Update installs a freshly serialized slice instead. Bytes handed out by
+// FetchBytesData or Iterate therefore stay valid after the item is recycled.
+var cacheItemPool = sync.Pool{
+	New: func() interface{} { return &CacheItem{} },
+}
+
+// releaseItem clears item and returns it to cacheItemPool. The item must
+// already be unlinked from its shard's map and LRU list.
+func releaseItem(item *CacheItem) {
+	item.Value = nil
+	item.arenaSlab, item.arenaOff, item.arenaLen = 0, 0, 0
+	item.Expiration = 0
+	item.key = ""
+	item.flags = 0
+	item.cost = 0
+	item.sum = 0
+	item.ttl = 0
+	item.pinned = false
+	item.sliding = false
+	item.tags = nil
+	item.prev = nil
+	item.next = nil
+	item.freq = 0
+	item.lastAccess = 0
+	item.heapIndex = 0
+	item.lastUsed.Store(0)
+	item.expIndex = 0
+	item.protected = false
+	item.created = 0
+	item.accessed.Store(0)
+	item.hits.Store(0)
+	cacheItemPool.Put(item)
+}
+
+// Defaults used by NewCacheWithOptions for settings that are not configured.
+const (
+	defaultShards           = 16
+	defaultMaxItemsPerShard = 1024
+	defaultCleanupInterval  = time.Minute
+)
+
+// NewCache creates a cache with numShards shards holding up to
+// maxItemsPerShard entries each and removes expired entries every
+// cleanupInterval. It panics on invalid settings; NewCacheE returns an error
+// instead.
+func NewCache(numShards, maxItemsPerShard int, cleanupInterval time.Duration, opts ...Option) *Cache {
+	cache, err := NewCacheE(numShards, maxItemsPerShard, cleanupInterval, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+// NewCacheE is like NewCache but reports invalid settings as an error. A
+// cleanupInterval of zero disables background cleanup; expired entries are
+// then only removed when they are accessed.
+func NewCacheE(numShards, maxItemsPerShard int, cleanupInterval time.Duration, opts ...Option) (*Cache, error) {
+	base := []Option{
+		WithShards(numShards),
+		WithMaxItemsPerShard(maxItemsPerShard),
+		WithCleanupInterval(cleanupInterval),
+	}
+	return NewCacheWithOptions(append(base, opts...)...)
+}
+
+// NewCacheWithOptions creates a cache configured by opts. Settings that are
+// not given default to 16 shards of 1024 entries and a cleanup pass every
+// minute. Invalid settings are reported as an error.
+func NewCacheWithOptions(opts ...Option) (*Cache, error) {
+	cache := &Cache{
+		numShards:        defaultShards,
+		maxItemsPerShard: defaultMaxItemsPerShard,
+		cleanupInterval:  defaultCleanupInterval,
+		hash:             fnv32a,
+		segmentRatio:     defaultSegmentRatio,
+		lruSamples:       defaultLRUSamples,
+		iterateWorkers:   runtime.GOMAXPROCS(0),
+		clock:            systemClock{},
+		done:             make(chan struct{}),
+		serializer:       msgpackSerializer{},
+	}
+	for _, opt := range opts {
+		opt(cache)
+	}
+	if cache.readOptimized && !cache.policySet {
+		cache.evictionPolicy = ApproxLRU
+	}
+	if err := cache.validate(); err != nil {
+		return nil, err
+	}
+	if cache.wal != nil && cache.wal.w == nil {
+		cache.wal = nil
+	}
+
+	cache.pow2Shards = cache.numShards&(cache.numShards-1) == 0
+	cache.shards = make([]*CacheShard, cache.numShards)
+	seed := maphash.MakeSeed()
+	for i := range cache.shards {
+		cache.shards[i] = &CacheShard{
+			data:   make(map[string]*CacheItem, min(cache.initialCapacity, cache.maxItemsPerShard)),
+			policy: cache.newPolicy(),
+			stamp:  cache.evictionPolicy == ApproxLRU,
+			meta:   cache.accessMeta,
+
+			checksums: cache.checksums,
+			capacity:  cache.maxItemsPerShard,
+		}
+		if cache.maxItems > 0 {
+			cache.shards[i].items = &cache.items
+		}
+		if cache.readOptimized {
+			cache.shards[i].mu.view = &cache.shards[i].view
+		}
+		if cache.arenaStorage {
+			cache.shards[i].arena = &arena{}
+		}
+		if cache.admission {
+			cache.shards[i].admission = newTinyLFU(cache.maxItemsPerShard, seed)
+		}
+	}
+	cache.initEncryption()
+	if cache.hot != nil {
+		cache.hot.init(cache.now())
+	}
+	if cache.wb != nil {
+		cache.startWriteBehind(cache.wbQueueSize, cache.wbWorkers)
+	}
+	if cache.bus != nil {
+		cache.id = newInstanceID()
+		cache.invalidations = make(chan string, invalidationQueueSize)
+		cache.startInvalidation()
+	}
+	if cache.mem != nil {
+		cache.wg.Add(1)
+		go cache.startMemoryWatcher()
+	}
+	if cache.cleanupInterval > 0 {
+		cache.wg.Add(1)
+		go cache.startCleanup()
+	}
+	if cache.snapshotPath != "" && cache.snapshotInterval > 0 {
+		cache.wg.Add(1)
+		go cache.runAutoSnapshot(cache.snapshotPath, cache.snapshotInterval)
+	}
+	return cache, nil
+}
+
+// validate checks the settings applied by the constructor options.
+func (c *Cache) validate() error {
+	switch {
+	case c.numShards <= 0:
+		return fmt.Errorf("hoard: number of shards must be positive, got %d", c.numShards)
+	case c.maxItemsPerShard <= 0:
+		return fmt.Errorf("hoard: max items per shard must be positive, got %d", c.maxItemsPerShard)
+	case c.mem != nil && (c.mem.softLimit == 0 || c.mem.interval <= 0):
+		return errors.New("hoard: memory watcher needs a positive soft limit and interval")
+	case c.mem != nil && (c.mem.fraction <= 0 || c.mem.fraction > 1):
+		return fmt.Errorf("hoard: shrink fraction must be in (0, 1], got %v", c.mem.fraction)
+	case c.mem != nil && c.mem.lowWatermark > c.mem.softLimit:
+		return fmt.Errorf("hoard: low watermark %d is above the soft limit %d", c.mem.lowWatermark, c.mem.softLimit)
+	case c.maxItems < 0:
+		return fmt.Errorf("hoard: max items must not be negative, got %d", c.maxItems)
+	case c.initialCapacity < 0:
+		return fmt.Errorf("hoard: initial capacity must not be negative, got %d", c.initialCapacity)
+	case c.maxKeyLength < 0:
+		return fmt.Errorf("hoard: max key length must not be negative, got %d", c.maxKeyLength)
+	case c.maxValueSize < 0:
+		return fmt.Errorf("hoard: max value size must not be negative, got %d", c.maxValueSize)
+	case c.cleanupInterval < 0:
+		return fmt.Errorf("hoard: cleanup interval must not be negative, got %v", c.cleanupInterval)
+	case c.defaultTTL < 0 && c.defaultTTL != NoExpiration:
+		return fmt.Errorf("hoard: invalid default ttl %v", c.defaultTTL)
+	case c.evictionPolicy < LRU || c.evictionPolicy > ApproxLRU:
+		return fmt.Errorf("hoard: unknown eviction policy %v", c.evictionPolicy)
+	case c.maxBytes < 0:
+		return fmt.Errorf("hoard: max bytes must not be negative, got %d", c.maxBytes)
+	case c.maxCost < 0:
+		return fmt.Errorf("hoard: max cost must not be negative, got %d", c.maxCost)
+	case c.cleanupBatchSize < 0:
+		return fmt.Errorf("hoard: cleanup batch size must not be negative, got %d", c.cleanupBatchSize)
+	case c.cleanupTimeSlice < 0:
+		return fmt.Errorf("hoard: cleanup time slice must not be negative, got %v", c.cleanupTimeSlice)
+	case c.ttlJitter < 0 || c.ttlJitter >= 1:
+		return fmt.Errorf("hoard: ttl jitter must be in [0, 1), got %v", c.ttlJitter)
+	case c.segmentRatio <= 0 || c.segmentRatio >= 1:
+		return fmt.Errorf("hoard: segment ratio must be between 0 and 1, got %v", c.segmentRatio)
+	case c.iterateWorkers <= 0:
+		return fmt.Errorf("hoard: iterate workers must be positive, got %d", c.iterateWorkers)
+	case c.lruSamples <= 0:
+		return fmt.Errorf("hoard: lru samples must be positive, got %d", c.lruSamples)
+	case c.hash == nil:
+		return errors.New("hoard: hash function must not be nil")
+	case c.clock == nil:
+		return errors.New("hoard: clock must not be nil")
+	case c.hot != nil && (c.hot.n <= 0 || c.hot.window < 0):
+		return fmt.Errorf("hoard: hot key tracking needs a positive count and a non-negative window, got %d and %v", c.hot.n, c.hot.window)
+	case c.wb != nil && c.backend == nil:
+		return errors.New("hoard: write-behind requires a backend")
+	case c.wb != nil && (c.wbQueueSize <= 0 || c.wbWorkers <= 0):
+		return fmt.Errorf("hoard: write-behind needs a positive queue size and worker count, got %d and %d", c.wbQueueSize, c.wbWorkers)
+	case c.wb != nil && (c.wb.full < BlockWhenFull || c.wb.full > FailWhenFull):
+		return fmt.Errorf("hoard: unknown queue full policy %d", c.wb.full)
+	case c.refreshAhead < 0 || c.refreshAhead >= 1:
+		return fmt.Errorf("hoard: refresh-ahead fraction must be in [0, 1), got %v", c.refreshAhead)
+	case c.loader != nil && c.backend != nil:
+		return errors.New("hoard: a loader and a backend cannot be used together")
+	case c.serializer == nil:
+		return errors.New("hoard: serializer must not be nil")
+	case c.readOptimized && c.evictionPolicy != ApproxLRU && c.evictionPolicy != FIFO:
+		return fmt.Errorf("hoard: read-optimized shards need the ApproxLRU or FIFO policy, got %v", c.evictionPolicy)
+	case c.readOptimized && c.sliding:
+		return errors.New("hoard: read-optimized shards cannot be combined with sliding expiration")
+	case c.latency != nil && c.latency.slow < 0:
+		return fmt.Errorf("hoard: slow operation threshold must not be negative, got %v", c.latency.slow)
+	case c.compressMin < 0:
+		return fmt.Errorf("hoard: compression threshold must not be negative, got %d", c.compressMin)
+	case c.encryptionKey != nil && len(c.encryptionKey) != 16 && len(c.encryptionKey) != 24 && len(c.encryptionKey) != 32:
+		return fmt.Errorf("hoard: encryption key must be 16, 24 or 32 bytes, got %d", len(c.encryptionKey))
+	}
+	return nil
+}
+
+// reportError logs err and hands it to the handler registered with
+// WithErrorHandler.
+func (c *Cache) reportError(err error) {
+	c.log(slog.LevelError, "hoard: background error", slog.Any("err", err))
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// Close stops the background goroutines and waits for them to exit.
+// Subsequent Store, Fetch and Update calls fail with ErrCacheClosed.
+// Calling Close more than once is safe.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.wg.Wait()
+	if c.wb != nil {
+		c.wb.close()
+	}
+	c.watch.closeAll()
+	return nil
+}
+
+func (c *Cache) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Len returns the number of entries held by the cache. Expired entries that
+// have not been removed yet are counted. Shards are read-locked one at a time,
+// so the total is not an atomic snapshot under concurrent writes.
+func (c *Cache) Len() int {
+	n := 0
+	for _, l := range c.ShardLens() {
+		n += l
+	}
+	return n
+}
+
+// ShardLens returns the number of entries held by each shard, with the same
+// counting rules as Len.
+func (c *Cache) ShardLens() []int {
+	lens := make([]int, len(c.shards))
+	for i, shard := range c.shards {
+		shard.mu.RLock()
+		lens[i] = len(shard.data)
+		shard.mu.RUnlock()
+	}
+	return lens
+}
+
+// ShardDistribution reports how many entries each shard holds, indexed like
+// the shards, so an uneven hash or sharding function shows up as a skew.
+func (c *Cache) ShardDistribution() []int {
+	return c.ShardLens()
+}
+
+// reserveLocked sizes the map of an empty shard for n incoming entries, so
+// a bulk load does not rehash as it grows. The caller must hold shard.mu for
+// writing.
+func (c *Cache) reserveLocked(shard *CacheShard, n int) {
+	n = min(n, shard.capacity)
+	if len(shard.data) == 0 && n > c.initialCapacity {
+		shard.data = make(map[string]*CacheItem, n)
+	}
+}
+
+// removeItem unlinks item from the shard and recycles it. The caller must
+// hold s.mu for writing. Read-optimized shards leave the item to the garbage
+// collector instead, since a read view may still stamp hits on it.
+func (s *CacheShard) removeItem(key string, item *CacheItem) {
+	s.unlinkItem(key, item)
+	if s.mu.view == nil {
+		releaseItem(item)
+	}
+}
+
+// unlinkItem removes item from the shard without recycling it. The caller
+// must hold s.mu for writing.
+func (s *CacheShard) unlinkItem(key string, item *CacheItem) {
+	s.bytes -= itemSize(key, s.value(item))
+	s.cost -= item.cost
+	s.untag(item)
+	s.unlinkDeadline(item)
+	if !item.pinned {
+		s.policy.remove(item)
+	}
+	delete(s.data, key)
+	if s.scanIdx != nil {
+		s.scanIdx.remove(key)
+	}
+	if s.items != nil {
+		s.items.total.Add(-1)
+		s.size.Add(-1)
+	}
+	s.freeValue(item)
+	s.compactArena()
+}
+
+// access records a hit or an overwrite of item with the eviction policy.
+// The caller must hold s.mu for writing.
+func (s *CacheShard) access(item *CacheItem) {
+	if !item.pinned {
+		s.policy.access(item)
+	}
+}
+
+// setValue replaces the value of item and keeps the byte estimate in sync.
+// The caller must hold s.mu for writing.
+func (s *CacheShard) setValue(item *CacheItem, val []byte) {
+	s.bytes += int64(len(val) - len(s.value(item)))
+	s.freeValue(item)
+	s.putValue(item, val)
+	s.setSum(item)
+	s.compactArena()
+}
+
+// evictLocked evicts entries chosen by the eviction policy until the shard
+// fits the budgets set with WithMaxBytes and WithMaxCostPerShard. An entry
+// larger than a budget is evicted as well. The caller must hold shard.mu for
+// writing.
+func (c *Cache) evictLocked(shard *CacheShard) {
+	for c.overBudget(shard) {
+		victim := shard.policy.victim()
+		if victim == nil {
+			return
+		}
+		c.evictLockedItem(shard, victim)
+	}
+}
+
+// overBudget reports whether shard exceeds its byte or cost budget.
+func (c *Cache) overBudget(shard *CacheShard) bool {
+	return (c.maxBytes > 0 && shard.bytes > c.maxBytes) ||
+		(c.maxCost > 0 && shard.cost > c.maxCost)
+}
+
+// FNV-1a parameters, see hash/fnv.
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// fnv32a is 32-bit FNV-1a over the bytes of key. It matches hash/fnv's
+// New32a without allocating a hasher or converting key to a []byte.
+func fnv32a(key string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+// shardIndex maps key to a shard. With a power-of-two shard count the
+// modulo is a mask, which picks the same shard.
+func (c *Cache) shardIndex(key string) int {
+	if c.shardFn != nil {
+		idx := c.shardFn(key, c.numShards)
+		if idx < 0 || idx >= c.numShards {
+			panic(fmt.Sprintf("hoard: sharding func mapped %q to shard %d of %d", key, idx, c.numShards))
+		}
+		return idx
+	}
+	h := c.hash(key)
+	if c.pow2Shards {
+		return int(h & uint32(c.numShards-1))
+	}
+	return int(h % uint32(c.numShards))
+}
+
+func (c *Cache) getShard(key string) *CacheShard {
+	return c.shards[c.shardIndex(key)]
+}
+
+//Store / Fetch
+
+// Store serializes value and stores it under key with a cost of 1. It fails
+// with ErrCacheFull when the key's shard is full and every entry is pinned.
+func (c *Cache) Store(key string, value interface{}, ttl time.Duration) error {
+	return c.StoreCtx(context.Background(), key, value, ttl)
+}
+
+// StoreCtx is like Store but honors ctx: it fails with the context's error,
+// storing nothing, when ctx is done before the shard lock is taken, and
+// passes ctx to the backend save, to SaveCtx if the backend is a
+// ContextBackend and to the wait for room in a full write-behind queue. A
+// save cut short by ctx fails like any other, dropping key from the cache.
+func (c *Cache) StoreCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.store(ctx, key, value, 1, ItemOptions{TTL: ttl}, nil)
+}
+
+// ItemOptions configures a single entry written by StoreWithOptions. The zero
+// value stores like Store.
+type ItemOptions struct {
+	// TTL is the lifetime of the entry. Zero uses the default ttl and
+	// NoExpiration keeps the entry until it is removed.
+	TTL time.Duration
+	// ExpiresAt, when not zero, is the absolute deadline of the entry and
+	// TTL is ignored, as with StoreUntil. Unlike StoreUntil, a deadline in
+	// the past stores an entry that is already expired.
+	ExpiresAt time.Time
+	// Cost is charged against the budget set with WithMaxCostPerShard. Zero
+	// means 1; use StoreWithCost for entries that cost nothing.
+	Cost int64
+	// Tags attach the entry to tags, as StoreTagged does.
+	Tags []string
+	// Sliding renews the entry's lifetime on every hit, as WithSlidingTTL
+	// does for every entry. Once an entry is stored with Sliding, lookups
+	// under the FIFO and ApproxLRU policies take a write lock.
+	Sliding bool
+	// Pinned keeps the entry from being evicted, as Pin does.
+	Pinned bool
+	// RawBytes stores value, which must be a []byte, without serializing it,
+	// as StoreBytes does.
+	RawBytes bool
+}
+
+// StoreWithOptions stores value under key configured by opts, combining what
+// Store, StoreWithCost, StoreTagged, StoreBytes and Pin do one at a time.
+// Storing a key again replaces its tags and sliding setting but keeps a pin.
+func (c *Cache) StoreWithOptions(key string, value interface{}, opts ItemOptions) error {
+	cost := opts.Cost
+	if cost == 0 {
+		cost = 1
+	}
+	return c.store(context.Background(), key, value, cost, opts, nil)
+}
+
+// StoreWithCost is like Store but charges cost units against the shard budget
+// set with WithMaxCostPerShard. Entries restored by Load or Replay have a
+// cost of 1.
+func (c *Cache) StoreWithCost(key string, value interface{}, ttl time.Duration, cost int64) error {
+	return c.store(context.Background(), key, value, cost, ItemOptions{TTL: ttl}, nil)
+}
+
+// store implements StoreWithOptions with an explicit cost, saving to the
+// backend with ctx. If evicted is not nil, it records the first entry the
+// store evicts from the shard.
+func (c *Cache) store(ctx context.Context, key string, value interface{}, cost int64, opts ItemOptions, evicted *evictedEntry) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if c.latency != nil {
+		defer c.latency.observe(opStore, key, time.Now())
+	}
+	p, err := c.prepareStore(key, value, cost, opts)
+	if err != nil {
+		return err
+	}
+	p.ctx = ctx
+
+	shard := c.getShard(key)
+	shard.mu.Lock()
+	if evicted != nil {
+		evicted.store = key
+		shard.evicted = evicted
+	}
+	err = c.storeLocked(shard, key, p)
+	shard.evicted = nil
+	return shard.mu.unlockErr(err)
+}
+
+// pendingStore is a value prepared by prepareStore, ready for storeLocked.
+type pendingStore struct {
+	val    []byte // unpacked, for the backend; nil to unpack stored
+	stored []byte // packed, for the shard
+	flags  byte
+	exp    int64
+	cost   int64
+	opts   ItemOptions
+	ctx    context.Context // for the backend save, nil for none
+	saved  bool            // already saved to the backend, see Tx.commit
+}
+
+// view returns the entry p stores with its value unpacked, as the backend
+// gets it.
+func (p pendingStore) view() itemView {
+	if p.val != nil {
+		return itemView{value: p.val, flags: p.flags &^ (itemCompressed | itemEncrypted)}
+	}
+	return itemView{value: p.stored, flags: p.flags}
+}
+
+// prepareStore checks key and serializes and packs value as store does,
+// before any lock is taken.
+func (c *Cache) prepareStore(key string, value interface{}, cost int64, opts ItemOptions) (pendingStore, error) {
+	if err := c.checkKey(key); err != nil {
+		return pendingStore{}, err
+	}
+	if cost < 0 {
+		return pendingStore{}, fmt.Errorf("hoard: cost must not be negative, got %d", cost)
+	}
+	p := pendingStore{exp: c.expiration(opts.TTL), cost: cost, opts: opts}
+	if !opts.ExpiresAt.IsZero() {
+		p.exp = opts.ExpiresAt.UnixNano()
+	}
+
+	if opts.RawBytes {
+		b, ok := value.([]byte)
+		if !ok {
+			return pendingStore{}, fmt.Errorf("%w: raw bytes of %q must be a []byte, got %T", ErrSerialization, key, value)
+		}
+		p.val, p.flags = bytes.Clone(b), itemRaw
+		if p.val == nil {
+			p.val = []byte{}
+		}
+	} else {
+		var err error
+		if p.val, err = c.serialize(value); err != nil {
+			return pendingStore{}, err
+		}
+	}
+	if err := c.checkValue(key, p.val); err != nil {
+		return pendingStore{}, err
+	}
+	if opts.Sliding {
+		c.slidingItems.Store(true)
+	}
+	var err error
+	p.stored, p.flags, err = c.pack(p.val, p.flags)
+	return p, err
+}
+
+// storeLocked stores p under key, as every write creating or replacing an
+// entry does: it sets the value in shard with the options it was prepared
+// with, queues its save to the backend, logs it and publishes it. The caller
+// must hold shard.mu for writing, and release it with unlockErr to learn
+// whether the save failed.
+func (c *Cache) storeLocked(shard *CacheShard, key string, p pendingStore) error {
+	var save *ioOp
+	if !p.saved {
+		var err error
+		if save, err = c.saveOp(p.ctx, key, p.view()); err != nil {
+			return err
+		}
+	}
+	if err := c.setLocked(shard, key, p.stored, p.exp, p.flags, p.cost); err != nil {
+		return err
+	}
+	c.queueIO(shard, save)
+	// The entry is gone already if it did not fit the shard budgets
+	if item, ok := shard.data[key]; ok {
+		shard.tag(item, p.opts.Tags)
+		if p.opts.Sliding {
+			item.sliding = true
+			item.ttl = c.lifetime(item, p.exp)
+		}
+		if p.opts.Pinned {
+			shard.pinLocked(item, true)
+		}
+	}
+	c.logWrite(walSet, key, p.stored, p.exp, p.flags)
+	c.publish(InvalidateOnStore, key)
+	return nil
+}
+
+// SetIfAbsent stores value under key only if the key is missing or expired,
+// and reports whether it stored. The check and the write happen under the
+// shard lock, so exactly one of several concurrent callers wins.
+func (c *Cache) SetIfAbsent(key string, value interface{}, ttl time.Duration) (bool, error) {
+	if c.isClosed() {
+		return false, ErrCacheClosed
+	}
+	if c.latency != nil {
+		defer c.latency.observe(opStore, key, time.Now())
+	}
+	p, err := c.prepareStore(key, value, 1, ItemOptions{TTL: ttl})
+	if err != nil {
+		return false, err
+	}
+
+	shard := c.getShard(key)
+	shard.mu.Lock()
+	if item, ok := shard.data[key]; ok {
+		if !item.expired(c.now()) {
+			shard.mu.Unlock()
+			return false, nil
+		}
+		c.expireLocked(shard, key, item)
+	}
+	if err := shard.mu.unlockErr(c.storeLocked(shard, key, p)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetSet stores value under key like Store and returns the value it replaced,
+// deserialized, and whether a live one existed. The old value is decoded from
+// its own slice after the shard lock is released; stored slices are never
+// reused, so a later Store cannot change it.
+func (c *Cache) GetSet(key string, value interface{}, ttl time.Duration) (interface{}, bool, error) {
+	if c.isClosed() {
+		return nil, false, ErrCacheClosed
+	}
+	if c.latency != nil {
+		defer c.latency.observe(opStore, key, time.Now())
+	}
+	p, err := c.prepareStore(key, value, 1, ItemOptions{TTL: ttl})
+	if err != nil {
+		return nil, false, err
+	}
+
+	shard := c.getShard(key)
+	shard.mu.Lock()
+	var old itemView
+	existed := false
+	if item, ok := shard.data[key]; ok {
+		if item.expired(c.now()) {
+			c.expireLocked(shard, key, item)
+		} else {
+			old, existed = shard.viewOf(item), true
+		}
+	}
+	err = shard.mu.unlockErr(c.storeLocked(shard, key, p))
+
+	if err != nil || !existed {
+		return nil, false, err
+	}
+	prev, err := c.decode(old)
+	return prev, true, err
+}
+
+// StoreDefault stores value under key with the ttl configured with
+// WithDefaultTTL.
+func (c *Cache) StoreDefault(key string, value interface{}) error {
+	return c.Store(key, value, DefaultExpiration)
+}
+
+// StoreBytes stores value as is, bypassing the serializer. The slice is
+// copied, so the caller may reuse it. FetchBytes returns exactly these bytes
+// and FetchData returns them as a []byte.
+func (c *Cache) StoreBytes(key string, value []byte, ttl time.Duration) error {
+	return c.store(context.Background(), key, value, 1, ItemOptions{TTL: ttl, RawBytes: true}, nil)
+}
+
+// setLocked inserts or overwrites key in shard. Inserting into a full shard
+// first evicts the entry chosen by the eviction policy, or fails with
+// ErrCacheFull if every entry is pinned. Entries are evicted afterwards until
+// the shard fits its byte and cost budgets. The caller must hold shard.mu for
+// writing.
+func (c *Cache) setLocked(shard *CacheShard, key string, val []byte, exp int64, flags byte, cost int64) error {
+	if shard.admission != nil {
+		shard.admission.record(key)
+	}
+	// Reuse the existing item when overwriting a key
+	if existing, ok := shard.data[key]; ok {
+		shard.setValue(existing, val)
+		shard.cost += cost - existing.cost
+		existing.cost = cost
+		shard.untag(existing)
+		existing.sliding = false
+		c.setExpiration(shard, existing, exp)
+		existing.flags = flags
+		shard.access(existing)
+		shard.resetMeta(existing, c.now())
+		shard.stats.stores.Add(1)
+		c.notifyValue(OpStore, key, val, flags)
+		c.evictLocked(shard)
+		return nil
+	}
+
+	// Make room first so the policy never picks the new entry as its victim
+	if len(shard.data) >= shard.capacity {
+		victim := shard.policy.victim()
+		if victim == nil {
+			return ErrCacheFull
+		}
+		if shard.admission != nil && !shard.admission.admit(key, victim) {
+			shard.stats.rejected.Add(1)
+			return nil
+		}
+		c.evictLockedItem(shard, victim)
+	}
+	if c.maxItems > 0 {
+		if shard.reserved > 0 {
+			shard.reserved--
+		} else if !c.reserveItem(shard) {
+			return ErrCacheFull
+		}
+	}
+	c.dropFromTierLocked(shard, key)
+
+	item := cacheItemPool.Get().(*CacheItem)
+	shard.putValue(item, val)
+	shard.setSum(item)
+	c.setExpiration(shard, item, exp)
+	item.key = key
+	item.flags = flags
+	item.cost = cost
+	shard.resetMeta(item, c.now())
+	shard.policy.insert(item)
+	shard.data[key] = item
+	if shard.scanIdx != nil {
+		shard.scanIdx.add(key)
+	}
+	if shard.items != nil {
+		shard.size.Add(1)
+	}
+	shard.bytes += itemSize(key, val)
+	shard.cost += cost
+	shard.stats.stores.Add(1)
+	c.notifyValue(OpStore, key, val, flags)
+	c.evictLocked(shard)
+	return nil
+}
+
+// StoreMany stores every entry of items with the same ttl. Values are
+// serialized before any lock is taken and each shard is locked once for the
+// whole batch. Entries that fail to serialize or to store, as Store would,
+// are skipped and reported in the returned map, keyed by their cache key;
+// the map is nil when all succeed.
+func (c *Cache) StoreMany(items map[string]interface{}, ttl time.Duration) map[string]error {
+	var errs map[string]error
+	fail := func(key string, err error) {
+		if errs == nil {
+			errs = make(map[string]error)
+		}
+		errs[key] = err
+	}
+	if c.isClosed() {
+		for key := range items {
+			fail(key, ErrCacheClosed)
+		}
+		return errs
+	}
+
+	type pending struct {
+		key string
+		p   pendingStore
+	}
+	groups := make([][]pending, c.numShards)
+	for key, value := range items {
+		p, err := c.prepareStore(key, value, 1, ItemOptions{TTL: ttl})
+		if err != nil {
+			fail(key, err)
+			continue
+		}
+		idx := c.shardIndex(key)
+		groups[idx] = append(groups[idx], pending{key: key, p: p})
+	}
+
+	for idx, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		shard := c.shards[idx]
+		shard.mu.Lock()
+		c.reserveLocked(shard, len(group))
+		for _, e := range group {
+			if err := c.storeLocked(shard, e.key, e.p); err != nil {
+				fail(e.key, err)
+			}
+		}
+		shard.mu.unlockSaves(fail)
+	}
+	return errs
+}
+
+// fetching data
+func (c *Cache) FetchBytesData(key string) ([]byte, bool) {
+	v, ok := c.fetch(key)
+	if !ok {
+		return nil, false
+	}
+	if v, ok = c.unpackFound(v); !ok || v.flags&itemNegative != 0 {
+		return nil, false
+	}
+	return v.value, true
+}
+
+// fetch looks key up and records the access with the eviction policy.
+func (c *Cache) fetch(key string) (itemView, bool) {
+	if c.isClosed() {
+		return itemView{}, false
+	}
+	if c.latency != nil {
+		defer c.latency.observe(opFetch, key, time.Now())
+	}
+	shard := c.getShard(key)
+	now := c.now()
+	if c.hot != nil {
+		c.hot.record(key, now)
+	}
+	if shard.admission != nil {
+		shard.admission.record(key)
+	}
+	v, ok := c.fetchShard(shard, key, now)
+	if ok && c.checksums {
+		v = c.verify(shard, key, v)
+	}
+	if ok && c.refreshAhead > 0 && c.loader != nil {
+		c.maybeRefresh(key, v, now)
+	}
+	if ok || c.tier == nil {
+		return v, ok
+	}
+	return c.promote(shard, key, now)
+}
+
+// fetchShard looks key up in the memory of shard.
+func (c *Cache) fetchShard(shard *CacheShard, key string, now int64) (itemView, bool) {
+	if c.readOptimized && c.readOnlyLookups() {
+		return c.fetchView(shard, key, now)
+	}
+	if c.readOnlyLookups() {
+		shard.mu.RLock()
+		defer shard.mu.RUnlock()
+		return shard.lookup(key, now)
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return c.getLocked(shard, key, now)
+}
+
+// readOnlyLookups reports whether a hit leaves the entry untouched, or only
+// stamps it atomically, so lookups only need a shard read lock.
+func (c *Cache) readOnlyLookups() bool {
+	return (c.evictionPolicy == FIFO || c.evictionPolicy == ApproxLRU) && !c.sliding && !c.slidingItems.Load()
+}
+
+// setExpiration sets the deadline of item and, for sliding entries or with
+// WithRefreshAhead, remembers the lifetime it grants so hits can renew or
+// refresh it. The caller must hold shard.mu for writing.
+func (c *Cache) setExpiration(shard *CacheShard, item *CacheItem, exp int64) {
+	shard.setDeadline(item, exp)
+	item.ttl = c.lifetime(item, exp)
+}
+
+// lifetime is the ttl setExpiration remembers for item expiring at exp, or
+// zero if hits never need it.
+func (c *Cache) lifetime(item *CacheItem, exp int64) time.Duration {
+	if exp == 0 || !c.sliding && !item.sliding && c.refreshAhead == 0 {
+		return 0
+	}
+	return time.Duration(exp - c.now())
+}
+
+// getLocked returns the entry stored under key and records the access with
+// the eviction policy, removing the entry instead if it expired before now.
+// The caller must hold shard.mu for writing.
+func (c *Cache) getLocked(shard *CacheShard, key string, now int64) (itemView, bool) {
+	item, ok := shard.data[key]
+	if !ok {
+		shard.stats.misses.Add(1)
+		return itemView{}, false
+	}
+
+	if item.expired(now) {
+		c.expireLocked(shard, key, item)
+		shard.stats.misses.Add(1)
+		return itemView{}, false
+	}
+
+	shard.access(item)
+	shard.recordHit(item, now)
+	if (c.sliding || item.sliding) && item.ttl > 0 {
+		shard.setDeadline(item, now+int64(item.ttl))
+	}
+	return shard.viewOf(item), true
+}
+
+// lookup is the read-only variant of getLocked for policies that ignore
+// accesses or only stamp them. Expired entries are left for the cleanup
+// goroutine. The caller must hold s.mu for reading.
+func (s *CacheShard) lookup(key string, now int64) (itemView, bool) {
+	item, ok := s.data[key]
+	if !ok || item.expired(now) {
+		s.stats.misses.Add(1)
+		return itemView{}, false
+	}
+	s.recordHit(item, now)
+	return s.viewOf(item), true
+}
+
+// decode turns a stored entry back into a value. Raw entries are returned as
+// a copy of their bytes and counters as an int64.
+func (c *Cache) decode(v itemView) (interface{}, error) {
+	v, err := c.unpack(v)
+	if err != nil {
+		return nil, err
+	}
+	if v.flags&itemNegative != 0 {
+		return nil, ErrNegativeEntry
+	}
+	if v.flags&itemRaw != 0 {
+		return bytes.Clone(v.value), nil
+	}
+	if v.flags&itemInt != 0 {
+		return decodeInt(v.value), nil
+	}
+	return c.deserialize(v.value)
+}
+
+// Exists reports whether key holds a live entry with a value, so false for a
+// negative entry written by StoreNegative. It takes at most a read lock and
+// neither deserializes the value nor touches the LRU order.
+func (c *Cache) Exists(key string) bool {
+	v, ok := c.peek(key)
+	return ok && v.flags&itemNegative == 0
+}
+
+// Peek returns the deserialized value stored under key without promoting it
+// in the LRU. Expired entries report false but are left for the cleanup
+// goroutine to remove.
+func (c *Cache) Peek(key string) (interface{}, bool, error) {
+	if c.isClosed() {
+		return nil, false, ErrCacheClosed
+	}
+	v, ok := c.peek(key)
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := c.decode(v)
+	return val, true, err
+}
+
+// TTL returns the time left before key expires without touching the LRU.
+// Entries stored with NoExpiration report NoExpiration. The boolean is false
+// when the key is missing or already expired.
+func (c *Cache) TTL(key string) (time.Duration, bool) {
+	exp, ok := c.expiresAt(key)
+	if !ok {
+		return 0, false
+	}
+	if exp == 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(exp - c.now()), true
+}
+
+// ExpiresAt returns the absolute deadline of key without touching the LRU.
+// Entries stored with NoExpiration report the zero time.Time.
+func (c *Cache) ExpiresAt(key string) (time.Time, bool) {
+	exp, ok := c.expiresAt(key)
+	if !ok || exp == 0 {
+		return time.Time{}, ok
+	}
+	return time.Unix(0, exp), true
+}
+
+func (c *Cache) expiresAt(key string) (int64, bool) {
+	if c.isClosed() {
+		return 0, false
+	}
+	shard := c.getShard(key)
+	now := c.now()
+	if view := shard.view.Load(); view != nil {
+		v, ok := view.peek(key, now)
+		return v.expiration, ok
+	}
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	item, ok := shard.data[key]
+	if !ok || item.expired(now) {
+		return 0, false
+	}
+	return item.Expiration, true
 }
 
-type CacheShard struct {
-	mu      sync.RWMutex
-	data    map[string]*CacheItem
-	lruList *list.List
-}
+// peek looks key up without changing the LRU order, in the read view of the
+// shard if it has one or else under a read lock.
+func (c *Cache) peek(key string) (itemView, bool) {
+	if c.isClosed() {
+		return itemView{}, false
+	}
+	shard := c.getShard(key)
+	now := c.now()
+	if view := shard.view.Load(); view != nil {
+		v, ok := view.peek(key, now)
+		if ok && shard.arena != nil {
+			v.value = bytes.Clone(v.value)
+		}
+		return v, ok
+	}
 
-type Cache struct {
-	shards           []*CacheShard
-	numShards        int
-	maxItemsPerShard int
-	cleanupInterval  time.Duration
-	hashFn           func() hash.Hash32
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	item, ok := shard.data[key]
+	if !ok || item.expired(now) {
+		return itemView{}, false
+	}
+	return shard.viewOf(item), true
 }
 
-var cacheItemPool = sync.Pool{
-	New: func() interface{} { return &CacheItem{} },
+// FetchBytesMany returns copies of the serialized values stored under keys,
+// locking each shard once for all of its keys. Keys that are missing or
+// expired are returned in missing, in the order they were requested, along
+// with keys holding a negative entry.
+func (c *Cache) FetchBytesMany(keys []string) (found map[string][]byte, missing []string) {
+	views, missing := c.fetchMany(keys)
+	found = make(map[string][]byte, len(views))
+	for key, v := range views {
+		if v, ok := c.unpackFound(v); ok && v.flags&itemNegative == 0 {
+			found[key] = bytes.Clone(v.value)
+		}
+	}
+	if len(found) < len(views) {
+		missing = missing[:0]
+		for _, key := range keys {
+			if _, ok := found[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+	}
+	return found, missing
 }
 
-func NewCache(numShards, maxItemsPerShard int, cleanupInterval time.Duration) *Cache {
-	if numShards <= 0 || maxItemsPerShard <= 0 {
-		panic("invalid shard or maxItemsPerShard")
+// fetchMany looks up keys shard by shard and records hits with the eviction
+// policy.
+func (c *Cache) fetchMany(keys []string) (found map[string]itemView, missing []string) {
+	found = make(map[string]itemView, len(keys))
+	if c.isClosed() {
+		return found, append(missing, keys...)
+	}
+
+	groups := make([][]string, c.numShards)
+	for _, key := range keys {
+		idx := c.shardIndex(key)
+		groups[idx] = append(groups[idx], key)
 	}
-	shards := make([]*CacheShard, numShards)
-	for i := range shards {
-		shards[i] = &CacheShard{
-			data:    make(map[string]*CacheItem),
-			lruList: list.New(),
+
+	now := c.now()
+	if c.hot != nil {
+		for _, key := range keys {
+			c.hot.record(key, now)
 		}
 	}
-	cache := &Cache{
-		shards:           shards,
-		numShards:        numShards,
-		maxItemsPerShard: maxItemsPerShard,
-		cleanupInterval:  cleanupInterval,
-		hashFn:           fnv.New32a,
+	for idx, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		shard := c.shards[idx]
+		if shard.admission != nil {
+			for _, key := range group {
+				shard.admission.record(key)
+			}
+		}
+		if c.readOnlyLookups() {
+			shard.mu.RLock()
+			for _, key := range group {
+				if v, ok := shard.lookup(key, now); ok {
+					found[key] = v
+				}
+			}
+			shard.mu.RUnlock()
+			continue
+		}
+		shard.mu.Lock()
+		for _, key := range group {
+			if v, ok := c.getLocked(shard, key, now); ok {
+				found[key] = v
+			}
+		}
+		shard.mu.Unlock()
 	}
-	go cache.startCleanup()
-	return cache
+
+	for _, key := range keys {
+		if v, ok := found[key]; ok {
+			if c.checksums {
+				found[key] = c.verify(c.getShard(key), key, v)
+			}
+			continue
+		}
+		if c.tier != nil {
+			if v, ok := c.promote(c.getShard(key), key, now); ok {
+				found[key] = v
+				continue
+			}
+		}
+		missing = append(missing, key)
+	}
+	return found, missing
 }
 
-func (c *Cache) getShard(key string) *CacheShard {
-	h := c.hashFn()
-	h.Write([]byte(key))
-	return c.shards[h.Sum32()%uint32(c.numShards)]
+// FetchMany is the deserializing counterpart of FetchBytesMany. Values are
+// decoded after all shard locks have been released; entries that fail to
+// decode are left out of found and their errors are joined into err, as are
+// negative entries with ErrNegativeEntry. The loader configured with
+// WithLoader is not consulted.
+func (c *Cache) FetchMany(keys []string) (found map[string]interface{}, missing []string, err error) {
+	if c.isClosed() {
+		return nil, nil, ErrCacheClosed
+	}
+	views, missing := c.fetchMany(keys)
+	found = make(map[string]interface{}, len(views))
+	var errs []error
+	for key, v := range views {
+		val, err := c.decode(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		found[key] = val
+	}
+	return found, missing, errors.Join(errs...)
 }
 
-//Store / Fetch
+// FetchBytes returns a copy of the serialized value stored under key without
+// deserializing it. Like FetchData it moves the entry to the front of the LRU
+// and treats expired entries as missing.
+func (c *Cache) FetchBytes(key string) ([]byte, bool) {
+	data, ok := c.FetchBytesData(key)
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(data), true
+}
 
-func (c *Cache) Store(key string, value interface{}, ttl time.Duration) error {
-	shard := c.getShard(key)
-	exp := time.Now().Add(ttl).UnixNano()
+func (c *Cache) FetchData(key string) (interface{}, bool, error) {
+	return c.FetchCtx(context.Background(), key)
+}
+
+// FetchCtx is like FetchData but fails with the context's error when ctx is
+// done before the lookup, and hands ctx to the loader set with
+// WithLoaderCtx or to a ContextBackend resolving a miss. A caller waiting
+// for a load started by another caller stops waiting when its own ctx is
+// done; the load itself runs with the context of the caller that started it.
+func (c *Cache) FetchCtx(ctx context.Context, key string) (interface{}, bool, error) {
+	var zero interface{}
+	if c.isClosed() {
+		return zero, false, ErrCacheClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, false, err
+	}
+	val, ok, err := c.fetchValue(key)
+	if !ok && c.loader != nil {
+		return c.load(ctx, key)
+	}
+	if !ok && c.backend != nil {
+		return c.readThrough(ctx, key)
+	}
+	return val, ok, err
+}
 
-	val, err := Serialize(value)
+// FetchInto decodes the value stored under key directly into dest, which
+// must be a pointer, preserving concrete types such as structs that FetchData
+// would return as maps. It reports false with a nil error on a miss and
+// returns a descriptive error when the stored bytes do not fit dest. Entries
+// stored with StoreBytes can only be fetched into a *[]byte. The loader
+// configured with WithLoader is not consulted.
+func (c *Cache) FetchInto(key string, dest interface{}) (bool, error) {
+	if c.isClosed() {
+		return false, ErrCacheClosed
+	}
+	v, ok := c.fetch(key)
+	if !ok {
+		return false, nil
+	}
+	v, err := c.unpack(v)
 	if err != nil {
-		return err
+		return true, err
+	}
+	if v.flags&itemNegative != 0 {
+		return true, ErrNegativeEntry
+	}
+	if v.flags&itemRaw != 0 {
+		p, isBytes := dest.(*[]byte)
+		if !isBytes {
+			return true, fmt.Errorf("%w: cannot decode raw bytes of %q into %T", ErrSerialization, key, dest)
+		}
+		*p = bytes.Clone(v.value)
+		return true, nil
+	}
+	if v.flags&itemInt != 0 {
+		// Counters are not serialized; encode them so dest may be any numeric type
+		val, err := c.serialize(decodeInt(v.value))
+		if err != nil {
+			return true, err
+		}
+		v.value = val
+	}
+	if err := c.serializer.Unmarshal(v.value, dest); err != nil {
+		return true, fmt.Errorf("%w: cannot decode %q into %T: %w", ErrSerialization, key, dest, err)
 	}
+	return true, nil
+}
 
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
+// fetchValue deserializes the entry stored under key without consulting the
+// loader. Decoding runs after the shard lock is released, on a view whose
+// bytes later writes never modify.
+func (c *Cache) fetchValue(key string) (interface{}, bool, error) {
+	v, ok := c.fetch(key)
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := c.decode(v)
+	return val, true, err
+}
 
-	// Remove existing
-	if existing, ok := shard.data[key]; ok {
-		shard.lruList.Remove(existing.LRUElement)
+// load resolves a miss through the configured loader, coalescing concurrent
+// callers for the same key.
+func (c *Cache) load(ctx context.Context, key string) (interface{}, bool, error) {
+	res, err, _ := c.loads.do(ctx, key, func() (interface{}, error) {
+		if value, exists, err := c.fetchValue(key); exists || err != nil {
+			return loadResult{value: value}, err
+		}
+		value, ttl, err := c.loader(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Store(key, value, ttl); err != nil {
+			return nil, err
+		}
+		return loadResult{value: value, loaded: true}, nil
+	})
+	if err != nil {
+		return nil, false, err
 	}
+	return res.(loadResult).value, true, nil
+}
 
-	item := cacheItemPool.Get().(*CacheItem)
-	item.Value = val
-	item.Expiration = exp
-	item.LRUElement = shard.lruList.PushFront(key)
-	shard.data[key] = item
+// loadResult carries the outcome of a GetOrStore load through c.loads.
+type loadResult struct {
+	value  interface{}
+	loaded bool
+}
+
+// GetOrStore returns the value stored under key. If the key is missing or
+// expired, loader is called and its result is stored with the given ttl.
+// Concurrent callers for the same missing key share a single loader call and
+// all receive its result. loaded reports whether the value came from loader.
+// Loader errors are returned to every waiter and nothing is cached.
+func (c *Cache) GetOrStore(key string, ttl time.Duration, loader func() (interface{}, error)) (interface{}, bool, error) {
+	return c.GetOrStoreCtx(context.Background(), key, ttl, func(context.Context) (interface{}, error) {
+		return loader()
+	})
+}
+
+// GetOrStoreCtx is like GetOrStore but fails with the context's error when
+// ctx is done before the lookup, and calls loader with ctx. Like FetchCtx, a
+// caller waiting for another caller's load stops waiting when its own ctx is
+// done.
+func (c *Cache) GetOrStoreCtx(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+	if c.isClosed() {
+		return nil, false, ErrCacheClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	if value, exists, err := c.fetchValue(key); exists || err != nil {
+		return value, false, err
+	}
 
-	// Evict LRU if over capacity
-	if len(shard.data) > c.maxItemsPerShard {
-		oldest := shard.lruList.Back()
-		if oldest != nil {
-			oldKey := oldest.Value.(string)
-			delete(shard.data, oldKey)
-			shard.lruList.Remove(oldest)
+	res, err, _ := c.loads.do(ctx, key, func() (interface{}, error) {
+		// Another caller may have stored the key since our miss
+		if value, exists, err := c.fetchValue(key); exists || err != nil {
+			return loadResult{value: value}, err
 		}
+		value, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Store(key, value, ttl); err != nil {
+			return nil, err
+		}
+		return loadResult{value: value, loaded: true}, nil
+	})
+	if err != nil {
+		return nil, false, err
 	}
-	return nil
+	r := res.(loadResult)
+	return r.value, r.loaded, nil
 }
 
-// fetching data
-func (c *Cache) FetchBytesData(key string) ([]byte, bool) {
+// Touch resets the expiration of key to ttl from now and moves it to the LRU
+// front without rewriting its value. Missing or expired keys are not revived
+// and return ErrKeyNotFound.
+func (c *Cache) Touch(key string, ttl time.Duration) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
 	shard := c.getShard(key)
 
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	item, ok := shard.data[key]
-	if !ok {
-		return nil, false
+	exp := c.expiration(ttl)
+	if !c.touchLocked(shard, key, exp, c.now()) {
+		return ErrKeyNotFound
 	}
+	c.logWrite(walExpire, key, nil, exp, 0)
+	return nil
+}
 
-	if time.Now().UnixNano() > item.Expiration {
-		shard.lruList.Remove(item.LRUElement)
-		delete(shard.data, key)
-		return nil, false
+// TouchMany applies Touch to every key, locking each shard once, and returns
+// how many live entries were refreshed.
+func (c *Cache) TouchMany(keys []string, ttl time.Duration) int {
+	if c.isClosed() {
+		return 0
+	}
+	groups := make([][]string, c.numShards)
+	for _, key := range keys {
+		idx := c.shardIndex(key)
+		groups[idx] = append(groups[idx], key)
 	}
 
-	shard.lruList.MoveToFront(item.LRUElement)
-	return item.Value, true
+	exp := c.expiration(ttl)
+	now := c.now()
+	touched := 0
+	for idx, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		shard := c.shards[idx]
+		shard.mu.Lock()
+		for _, key := range group {
+			if c.touchLocked(shard, key, exp, now) {
+				c.logWrite(walExpire, key, nil, exp, 0)
+				touched++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return touched
 }
-func (c *Cache) FetchData(key string) (interface{}, bool, error) {
-	var zero interface{}
-	data, ok := c.FetchBytesData(key)
+
+// touchLocked sets a new deadline on a live entry. The caller must hold
+// shard.mu for writing.
+func (c *Cache) touchLocked(shard *CacheShard, key string, exp, now int64) bool {
+	item, ok := shard.data[key]
 	if !ok {
-		return zero, false, nil
+		return false
 	}
-	val, err := Deserialize(data)
-	return val, true, err
+	if item.expired(now) {
+		c.expireLocked(shard, key, item)
+		return false
+	}
+	c.setExpiration(shard, item, exp)
+	shard.access(item)
+	return true
 }
 
+// Update replaces the value and expiration of a live key. Missing or expired
+// keys return ErrKeyNotFound; use Store to create them.
 func (c *Cache) Update(key string, value interface{}, ttl time.Duration) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	return c.update(key, value, c.expiration(ttl))
+}
+
+// update implements Update with an absolute deadline, 0 for none.
+func (c *Cache) update(key string, value interface{}, exp int64) error {
+	if c.latency != nil {
+		defer c.latency.observe(opUpdate, key, time.Now())
+	}
+	if err := c.checkKey(key); err != nil {
+		return err
+	}
+	shard := c.getShard(key)
+
+	val, err := c.serialize(value)
+	if err != nil {
+		return err
+	}
+	if err := c.checkValue(key, val); err != nil {
+		return err
+	}
+	stored, flags, err := c.pack(val, 0)
+	if err != nil {
+		return err
+	}
+
+	shard.mu.Lock()
+	item, ok := shard.data[key]
+	if !ok || item.expired(c.now()) {
+		shard.mu.Unlock()
+		return ErrKeyNotFound
+	}
+	return shard.mu.unlockErr(c.replaceLocked(shard, key, item, val, stored, flags, exp))
+}
+
+// replaceLocked replaces the value of the live item stored under key with
+// stored, packed from val with flags, and its expiration with exp, as every
+// write changing an existing entry in place does: it counts an update,
+// notifies watchers, queues the save of val to the backend, logs the write
+// and publishes it. The caller must hold shard.mu for writing, and release
+// it with unlockErr to learn whether the save failed.
+func (c *Cache) replaceLocked(shard *CacheShard, key string, item *CacheItem, val, stored []byte, flags byte, exp int64) error {
+	save, err := c.saveOp(nil, key, itemView{value: val, flags: flags &^ (itemCompressed | itemEncrypted)})
+	if err != nil {
+		return err
+	}
+	shard.setValue(item, stored)
+	if exp != item.Expiration {
+		c.setExpiration(shard, item, exp)
+	}
+	item.flags = flags
+	shard.access(item)
+	shard.stats.updates.Add(1)
+	c.notify(OpUpdate, key, val)
+	c.queueIO(shard, save)
+	c.logWrite(walSet, key, stored, exp, flags)
+	c.evictLocked(shard)
+	c.publish(InvalidateOnUpdate, key)
+	return nil
+}
+
+// UpdateValue replaces the value of a live key like Update but keeps its
+// current expiration. Missing or expired keys return ErrKeyNotFound.
+func (c *Cache) UpdateValue(key string, value interface{}) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if err := c.checkKey(key); err != nil {
+		return err
+	}
 	shard := c.getShard(key)
-	exp := time.Now().Add(ttl).UnixNano()
 
-	val, err := Serialize(value)
+	val, err := c.serialize(value)
+	if err != nil {
+		return err
+	}
+	if err := c.checkValue(key, val); err != nil {
+		return err
+	}
+	stored, flags, err := c.pack(val, 0)
 	if err != nil {
 		return err
 	}
 
+	shard.mu.Lock()
+	item, ok := shard.data[key]
+	if !ok || item.expired(c.now()) {
+		shard.mu.Unlock()
+		return ErrKeyNotFound
+	}
+	return shard.mu.unlockErr(c.replaceLocked(shard, key, item, val, stored, flags, item.Expiration))
+}
+
+// UpdateTTL sets the expiration of a live key to ttl from now without
+// touching its value. Unlike Touch it leaves the entry's position in the
+// eviction order alone, so it does not count as a use. Missing or expired
+// keys return ErrKeyNotFound.
+func (c *Cache) UpdateTTL(key string, ttl time.Duration) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	shard := c.getShard(key)
+	exp := c.expiration(ttl)
+
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
 	item, ok := shard.data[key]
-	if !ok {
-		return fmt.Errorf("key not found: %s", key)
+	if !ok || item.expired(c.now()) {
+		return ErrKeyNotFound
+	}
+	c.setExpiration(shard, item, exp)
+	c.logWrite(walExpire, key, nil, exp, 0)
+	return nil
+}
+
+// Pin exempts key from eviction. A pinned entry still expires and can be
+// deleted, and CleanupAll removes it. Pins are not saved in snapshots or the
+// write log. Missing or expired keys return ErrKeyNotFound.
+func (c *Cache) Pin(key string) error {
+	return c.setPinned(key, true)
+}
+
+// Unpin makes a pinned key evictable again. It rejoins the eviction order as
+// if it had just been stored.
+func (c *Cache) Unpin(key string) error {
+	return c.setPinned(key, false)
+}
+
+func (c *Cache) setPinned(key string, pinned bool) error {
+	if c.isClosed() {
+		return ErrCacheClosed
 	}
+	shard := c.getShard(key)
 
-	item.Value = val
-	item.Expiration = exp
-	shard.lruList.MoveToFront(item.LRUElement)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	item, ok := shard.data[key]
+	if !ok || item.expired(c.now()) {
+		return ErrKeyNotFound
+	}
+	shard.pinLocked(item, pinned)
 	return nil
 }
 
-func (c *Cache) Delete(key string) {
+// pinLocked pins or unpins item. The caller must hold s.mu for writing.
+func (s *CacheShard) pinLocked(item *CacheItem, pinned bool) {
+	if item.pinned == pinned {
+		return
+	}
+	item.pinned = pinned
+	if pinned {
+		s.policy.remove(item)
+	} else {
+		s.policy.insert(item)
+	}
+}
+
+// Delete removes key from the cache and reports whether a live entry was
+// removed. Expired entries are dropped as well but report false.
+func (c *Cache) Delete(key string) bool {
+	if c.latency != nil {
+		defer c.latency.observe(opDelete, key, time.Now())
+	}
 	shard := c.getShard(key)
 
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	if item, ok := shard.data[key]; ok {
-		shard.lruList.Remove(item.LRUElement)
-		delete(shard.data, key)
-		cacheItemPool.Put(item)
+	return c.removeLocked(shard, key, c.now())
+}
+
+// Pop removes key and returns its deserialized value in one step, so among
+// concurrent callers exactly one receives it. Expired entries are a miss.
+// The value is decoded after the shard lock is released.
+func (c *Cache) Pop(key string) (interface{}, bool, error) {
+	if c.isClosed() {
+		return nil, false, ErrCacheClosed
+	}
+	shard := c.getShard(key)
+
+	now := c.now()
+	shard.mu.Lock()
+	v, ok := c.getLocked(shard, key, now)
+	if ok {
+		c.removeLocked(shard, key, now)
+	}
+	shard.mu.Unlock()
+
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := c.decode(v)
+	return val, true, err
+}
+
+// removeLocked deletes key as every write removing an entry does: it
+// removes it from shard, logs the delete, deletes the key from the backend
+// and publishes it. It reports whether key held a live entry. The caller
+// must hold shard.mu for writing.
+func (c *Cache) removeLocked(shard *CacheShard, key string, now int64) bool {
+	existed := c.deleteLocked(shard, key, now)
+	c.logWrite(walDelete, key, nil, 0, 0)
+	c.deleteThroughLocked(shard, key)
+	c.publish(InvalidateOnDelete, key)
+	return existed
+}
+
+// deleteLocked removes key from shard and reports whether it held a live
+// entry, without the side effects of removeLocked. The caller must hold
+// shard.mu for writing.
+func (c *Cache) deleteLocked(shard *CacheShard, key string, now int64) bool {
+	c.dropFromTierLocked(shard, key)
+	item, ok := shard.data[key]
+	if !ok {
+		return false
 	}
+	if item.expired(now) {
+		c.expireLocked(shard, key, item)
+		return false
+	}
+	shard.removeItem(key, item)
+	shard.stats.deletes.Add(1)
+	c.notify(OpDelete, key, nil)
+	return true
 }
 
-// Iterate
-func (c *Cache) Iterate(fn func(key string, value []byte)) {
-	now := time.Now().UnixNano()
-	var wg sync.WaitGroup
-	wg.Add(len(c.shards))
+// DeleteMany removes keys from the cache, locking each shard once for all of
+// its keys, and returns how many live entries were removed. Missing keys are
+// skipped.
+func (c *Cache) DeleteMany(keys []string) int {
+	groups := make([][]string, c.numShards)
+	for _, key := range keys {
+		idx := c.shardIndex(key)
+		groups[idx] = append(groups[idx], key)
+	}
+
+	now := c.now()
+	deleted := 0
+	for idx, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		shard := c.shards[idx]
+		shard.mu.Lock()
+		for _, key := range group {
+			if c.removeLocked(shard, key, now) {
+				deleted++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return deleted
+}
 
+// DeleteByPrefix removes every entry whose key starts with prefix, walking
+// each shard once under its write lock, and returns how many live entries
+// were removed. Each key is deleted as Delete does, from the backend too,
+// but only keys held in memory are seen. The empty prefix removes every
+// entry.
+func (c *Cache) DeleteByPrefix(prefix string) int {
+	now := c.now()
+	deleted := 0
 	for _, shard := range c.shards {
-		go func(s *CacheShard) {
-			defer wg.Done()
-			s.mu.RLock()
-			for k, item := range s.data {
-				if now <= item.Expiration {
-					fn(k, item.Value)
-				}
+		shard.mu.Lock()
+		for key := range shard.data {
+			if strings.HasPrefix(key, prefix) && c.removeLocked(shard, key, now) {
+				deleted++
 			}
-			s.mu.RUnlock()
-		}(shard)
+		}
+		shard.mu.Unlock()
 	}
-	wg.Wait()
+	return deleted
 }
 
 // Cleanup
 func (c *Cache) startCleanup() {
+	defer c.wg.Done()
 	ticker := time.NewTicker(c.cleanupInterval)
 	defer ticker.Stop()
-	for range ticker.C {
-		for _, shard := range c.shards {
-			c.cleanupShard(shard)
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.cleanupTick()
+		}
+	}
+}
+
+// cleanupTick runs one pass of the background cleanup. A panic during the
+// pass is recovered and reported to the handler set with WithErrorHandler,
+// so the next tick runs as usual instead of cleanup stopping for good.
+func (c *Cache) cleanupTick() {
+	defer func() {
+		if r := recover(); r != nil {
+			c.reportError(fmt.Errorf("hoard: cleanup panicked: %v\n%s", r, debug.Stack()))
+		}
+	}()
+	start := time.Now()
+	if c.latency != nil {
+		defer c.latency.observe(opCleanup, "", start)
+	}
+	c.maintenance.Lock()
+	defer c.maintenance.Unlock()
+	// Visit shards in a fresh order so a huge shard does not always delay
+	// the ones after it
+	removed := 0
+	for _, i := range rand.Perm(len(c.shards)) {
+		removed += c.cleanupShard(c.shards[i])
+	}
+	c.logCleanup(removed, start)
+}
+
+// cleanupShard removes the expired entries of shard. It pops them off the
+// expiry heap, so entries that are not due are never visited. With
+// WithCleanupBatchSize or WithCleanupTimeSlice the work is split into
+// batches and the lock is released between them. It returns the number of
+// entries removed.
+func (c *Cache) cleanupShard(shard *CacheShard) int {
+	total := 0
+	for {
+		removed, more := c.cleanupBatch(shard)
+		total += removed
+		if !more {
+			return total
 		}
+		// Let callers waiting for the lock in before the next batch
+		runtime.Gosched()
 	}
 }
 
-func (c *Cache) cleanupShard(shard *CacheShard) {
+// cleanupBatch removes expired entries from shard under a single lock
+// acquisition until the batch budget is spent. It returns the number of
+// entries removed and whether due entries remain.
+func (c *Cache) cleanupBatch(shard *CacheShard) (int, bool) {
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
-	for key, item := range shard.data {
-		if time.Now().UnixNano() > item.Expiration {
-			shard.lruList.Remove(item.LRUElement)
-			delete(shard.data, key)
-			cacheItemPool.Put(item)
+	start := time.Now() // the budget is measured in real time
+	now := c.now()
+	removed := 0
+	for ; len(shard.expiry) > 0 && shard.expiry[0].expired(now); removed++ {
+		// Always make progress, however small the budget
+		if removed > 0 && c.cleanupBudgetSpent(removed, start) {
+			return removed, true
 		}
+		item := shard.expiry[0]
+		key, val, flags := item.key, shard.value(item), item.flags
+		shard.removeItem(key, item)
+		shard.stats.cleanupRemovals.Add(1)
+		c.notifyRemoved(OpExpire, key, val, flags)
 	}
+	return removed, false
+}
+
+// cleanupBudgetSpent reports whether a batch that removed entries since
+// start used up its budget.
+func (c *Cache) cleanupBudgetSpent(removed int, start time.Time) bool {
+	return (c.cleanupBatchSize > 0 && removed >= c.cleanupBatchSize) ||
+		(c.cleanupTimeSlice > 0 && time.Since(start) >= c.cleanupTimeSlice)
 }
 
 //  CleanupAll
 
-func (c *Cache) CleanupAll() {
-	for _, shard := range c.shards {
-		shard.mu.Lock()
-		for key, item := range shard.data {
-			shard.lruList.Remove(item.LRUElement)
-			cacheItemPool.Put(item)
-			delete(shard.data, key)
+// CleanupAll removes every entry. Shards are cleared one at a time, yielding
+// the processor in between, so lookups on the other shards keep going and
+// the pause is spread out rather than taken all at once. Stores made while
+// it runs may survive on shards it has already cleared. Only the cache is
+// cleared: a backend keeps its keys. It fails with ErrCacheClosed once the
+// cache is closed.
+func (c *Cache) CleanupAll() error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	c.logWrite(walClear, "", nil, 0, 0)
+	c.clearShards()
+	c.publish(InvalidateOnDelete, "")
+	return nil
+}
+
+// CleanupShard removes every entry of shard i, leaving the others alone.
+// Use ShardForKey to find the shard of a problematic key. Each live entry
+// removed is logged and published as a delete, but like CleanupAll it only
+// clears the cache, not a backend. It fails for an index outside [0, number
+// of shards), or with ErrCacheClosed once the cache is closed.
+func (c *Cache) CleanupShard(i int) error {
+	if i < 0 || i >= c.numShards {
+		return fmt.Errorf("hoard: shard index %d out of range [0, %d)", i, c.numShards)
+	}
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	shard := c.shards[i]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	c.clearShardLocked(shard, func(key string) {
+		c.logWrite(walDelete, key, nil, 0, 0)
+		c.publish(InvalidateOnDelete, key)
+	})
+	return nil
+}
+
+// ShardForKey returns the index of the shard holding key, as used by
+// CleanupShard and ShardLens.
+func (c *Cache) ShardForKey(key string) int {
+	return c.shardIndex(key)
+}
+
+// clearShards removes every entry from every shard.
+func (c *Cache) clearShards() {
+	for i, shard := range c.shards {
+		if i > 0 {
+			runtime.Gosched()
 		}
+		shard.mu.Lock()
+		c.clearShardLocked(shard, nil)
 		shard.mu.Unlock()
 	}
 }
+
+// clearShardLocked removes every entry from shard, expiring the expired ones
+// and passing the key of each live one to removed, if not nil. The caller
+// must hold shard.mu for writing.
+func (c *Cache) clearShardLocked(shard *CacheShard, removed func(key string)) {
+	now := c.now()
+	for key, item := range shard.data {
+		if item.expired(now) {
+			c.expireLocked(shard, key, item)
+			continue
+		}
+		shard.removeItem(key, item)
+		c.notify(OpDelete, key, nil)
+		if removed != nil {
+			removed(key)
+		}
+	}
+}
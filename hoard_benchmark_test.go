@@ -3,7 +3,10 @@ package hoard
 import (
 	"fmt"
 	"math/rand"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -165,3 +168,576 @@ func BenchmarkCleanupAllHeavy(b *testing.B) {
 		cache.CleanupAll()
 	}
 }
+
+// Benchmark Store when every insert evicts the least recently used entry
+func BenchmarkStoreEviction(b *testing.B) {
+	cache := NewCache(1, 1024, time.Minute)
+	defer cache.Close()
+
+	keys := make([]string, 64*1024)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+	value := randomValue(ValueSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Store(keys[i%len(keys)], value, time.Minute)
+	}
+}
+
+// Benchmark FetchBytes against FetchData for 1KB values
+func BenchmarkFetchBytesVsFetchData(b *testing.B) {
+	cache := NewCache(16, 10000, time.Minute)
+	defer cache.Close()
+
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+		cache.Store(keys[i], randomValue(1024), time.Minute)
+	}
+
+	b.Run("FetchBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cache.FetchBytes(keys[i%len(keys)])
+		}
+	})
+	b.Run("FetchData", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cache.FetchData(keys[i%len(keys)])
+		}
+	})
+}
+
+// Benchmark StoreMany against storing the same batch in a loop
+func BenchmarkStoreMany(b *testing.B) {
+	const batchSize = 10000
+	items := make(map[string]interface{}, batchSize)
+	for i := 0; i < batchSize; i++ {
+		items["key_"+strconv.Itoa(i)] = randomValue(ValueSize)
+	}
+
+	b.Run("Loop", func(b *testing.B) {
+		cache := NewCache(16, batchSize, time.Minute)
+		defer cache.Close()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for key, value := range items {
+				cache.Store(key, value, time.Minute)
+			}
+		}
+	})
+	b.Run("StoreMany", func(b *testing.B) {
+		cache := NewCache(16, batchSize, time.Minute)
+		defer cache.Close()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cache.StoreMany(items, time.Minute)
+		}
+	})
+}
+
+// Benchmark the raw bytes fast path against serialized values for 4KB payloads
+func BenchmarkStoreBytes4KB(b *testing.B) {
+	cache := NewCache(16, 10000, time.Minute)
+	defer cache.Close()
+
+	payload := []byte(randomValue(4096))
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+
+	b.Run("StoreBytes+FetchBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			key := keys[i%len(keys)]
+			cache.StoreBytes(key, payload, time.Minute)
+			cache.FetchBytes(key)
+		}
+	})
+	b.Run("Store+FetchData", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			key := keys[i%len(keys)]
+			cache.Store(key, payload, time.Minute)
+			cache.FetchData(key)
+		}
+	})
+}
+
+// benchStruct serializes to roughly 256 bytes
+type benchStruct struct {
+	ID      int64
+	Name    string
+	Email   string
+	Tags    []string
+	Payload string
+}
+
+// Benchmark allocations per Store for a 256-byte struct
+func BenchmarkStoreStructAllocs(b *testing.B) {
+	cache := NewCache(16, 10000, time.Minute)
+	defer cache.Close()
+
+	value := benchStruct{
+		ID:      42,
+		Name:    "Aboubakr Kouhadi",
+		Email:   "bryan@bryan.com",
+		Tags:    []string{"guitar", "soccer", "swimming", "coding"},
+		Payload: randomValue(150),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Store("key", value, time.Minute)
+	}
+}
+
+// Benchmark a 95% read workload under LRU and FIFO eviction. FIFO lookups
+// only take a read lock.
+func BenchmarkReadHeavyPolicies(b *testing.B) {
+	const numKeys = 100_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+
+	for _, policy := range []EvictionPolicy{LRU, FIFO} {
+		b.Run(policy.String(), func(b *testing.B) {
+			cache := NewCache(16, numKeys, time.Minute, WithEvictionPolicy(policy))
+			defer cache.Close()
+			for _, key := range keys {
+				cache.StoreBytes(key, []byte(key), time.Minute)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for pb.Next() {
+					key := keys[rnd.Intn(numKeys)]
+					if rnd.Intn(100) < 95 {
+						cache.FetchBytesData(key)
+					} else {
+						cache.StoreBytes(key, []byte(key), time.Minute)
+					}
+				}
+			})
+		})
+	}
+}
+
+// Benchmark dropping a 10% namespace of a 1M-key cache with DeleteByPrefix
+// versus collecting the keys with Iterate and deleting them one by one.
+func BenchmarkDeleteByPrefix(b *testing.B) {
+	const numKeys = 1_000_000
+	cache := NewCache(16, numKeys, time.Minute)
+	defer cache.Close()
+
+	keys := make([]string, numKeys)
+	for i := range keys {
+		if i%10 == 0 {
+			keys[i] = "session:" + strconv.Itoa(i)
+		} else {
+			keys[i] = "key_" + strconv.Itoa(i)
+		}
+		cache.StoreBytes(keys[i], nil, time.Minute)
+	}
+	refill := func() {
+		for i := 0; i < numKeys; i += 10 {
+			cache.StoreBytes(keys[i], nil, time.Minute)
+		}
+	}
+
+	b.Run("DeleteByPrefix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			refill()
+			b.StartTimer()
+			cache.DeleteByPrefix("session:")
+		}
+	})
+	b.Run("IterateAndDelete", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			refill()
+			b.StartTimer()
+			var mu sync.Mutex
+			var matched []string
+			cache.Iterate(func(key string, _ []byte) {
+				if strings.HasPrefix(key, "session:") {
+					mu.Lock()
+					matched = append(matched, key)
+					mu.Unlock()
+				}
+			})
+			for _, key := range matched {
+				cache.Delete(key)
+			}
+		}
+	})
+}
+
+// Benchmark a full Scan of 100k keys at several page sizes. Pages only walk
+// the scan index buckets they return keys from, so the cost stays close to
+// linear in the number of keys whatever the page size.
+func BenchmarkScan(b *testing.B) {
+	const numKeys = 100_000
+	cache := NewCache(16, numKeys, time.Minute)
+	defer cache.Close()
+	for i := 0; i < numKeys; i++ {
+		cache.StoreBytes("key_"+strconv.Itoa(i), nil, time.Minute)
+	}
+
+	for _, count := range []int{10, 100, 1000} {
+		b.Run("count="+strconv.Itoa(count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var keys []string
+				cursor, n := uint64(0), 0
+				for {
+					keys, cursor = cache.Scan(cursor, count)
+					n += len(keys)
+					if cursor == 0 {
+						break
+					}
+				}
+				if n != numKeys {
+					b.Fatalf("Expected %d keys, got %d", numKeys, n)
+				}
+			}
+		})
+	}
+}
+
+// Benchmark one cleanup tick over 1M long-lived entries. The expiry heap
+// means nothing that is not due is visited.
+func BenchmarkCleanupTickLongTTL(b *testing.B) {
+	cache := NewCache(16, NumKeys, time.Hour)
+	defer cache.Close()
+	for i := 0; i < NumKeys; i++ {
+		cache.StoreBytes("key_"+strconv.Itoa(i), nil, time.Hour)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, shard := range cache.shards {
+			cache.cleanupShard(shard)
+		}
+	}
+}
+
+// Benchmark picking a shard, which every operation does
+func BenchmarkGetShard(b *testing.B) {
+	cache := NewCache(16, 1000, time.Minute)
+	defer cache.Close()
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "user:session:" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.getShard(keys[i%len(keys)])
+	}
+}
+
+// Benchmark parallel reads of 16 shards from 64 goroutines. Exact LRU
+// serializes the readers of a shard; ApproxLRU only takes a read lock.
+func BenchmarkParallelReads(b *testing.B) {
+	const numKeys = 100_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+
+	for _, policy := range []EvictionPolicy{LRU, ApproxLRU} {
+		b.Run(policy.String(), func(b *testing.B) {
+			cache := NewCache(16, numKeys, time.Minute, WithEvictionPolicy(policy))
+			defer cache.Close()
+			for _, key := range keys {
+				cache.StoreBytes(key, []byte(key), time.Minute)
+			}
+
+			b.SetParallelism(max(1, Concurrency/runtime.GOMAXPROCS(0)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for pb.Next() {
+					cache.FetchBytesData(keys[rnd.Intn(numKeys)])
+				}
+			})
+		})
+	}
+}
+
+// Benchmark Store latency on shards whose 64KB values are being fetched
+// concurrently. Decoding happens outside the shard lock, so writers only
+// wait for the lookup itself.
+func BenchmarkStoreLatencyLargeValues(b *testing.B) {
+	const numKeys = 64
+	value := randomValue(64 << 10)
+	cache := NewCache(4, numKeys, time.Minute)
+	defer cache.Close()
+	for i := 0; i < numKeys; i++ {
+		cache.Store("key_"+strconv.Itoa(i), value, time.Minute)
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < runtime.GOMAXPROCS(0); r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for i := r; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cache.FetchData("key_" + strconv.Itoa(i%numKeys))
+			}
+		}(r)
+	}
+
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		cache.Store("key_"+strconv.Itoa(i%numKeys), value, time.Minute)
+		latencies[i] = time.Since(start)
+	}
+	b.StopTimer()
+	close(stop)
+	readers.Wait()
+
+	slices.Sort(latencies)
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+}
+
+// Benchmark fetches that hit the file tier. Every fetch promotes an entry
+// from disk and spills another one.
+func BenchmarkFileTierHit(b *testing.B) {
+	tier, err := OpenFileTier(b.TempDir() + "/tier")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tier.Close()
+	cache := NewCache(1, 100, time.Minute, WithTier(tier))
+	defer cache.Close()
+	value := randomValue(ValueSize)
+	for i := 0; i < 1000; i++ {
+		cache.Store("key_"+strconv.Itoa(i), value, time.Hour)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Walking the keys in order always finds the next one on disk
+		cache.FetchBytesData("key_" + strconv.Itoa(i%1000))
+	}
+}
+
+// Benchmark the cost of hot key tracking on parallel fetches. Only sampled
+// fetches touch the counters, so "on" should stay within a few percent of
+// "off".
+func BenchmarkHotKeyTracking(b *testing.B) {
+	const numKeys = 100_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+
+	for _, tracking := range []bool{false, true} {
+		name := "off"
+		var opts []Option
+		if tracking {
+			name = "on"
+			opts = append(opts, WithHotKeyTracking(10, time.Minute))
+		}
+		b.Run(name, func(b *testing.B) {
+			cache := NewCache(16, numKeys, time.Minute, opts...)
+			defer cache.Close()
+			for _, key := range keys {
+				cache.StoreBytes(key, []byte(key), time.Minute)
+			}
+
+			b.SetParallelism(max(1, Concurrency/runtime.GOMAXPROCS(0)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for pb.Next() {
+					// Skew towards a few keys, as hot keys do
+					cache.FetchBytesData(keys[rnd.Intn(numKeys)>>rnd.Intn(16)])
+				}
+			})
+		})
+	}
+}
+
+// Benchmark warming up shards to their item limit, with and without the maps
+// sized up front.
+func BenchmarkWarmup(b *testing.B) {
+	const shards, perShard = 16, 1 << 14
+	keys := make([]string, shards*perShard)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+	val := []byte("value")
+
+	for _, capacity := range []int{0, perShard} {
+		b.Run(fmt.Sprintf("capacity=%d", capacity), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cache := NewCache(shards, perShard, 0, WithInitialCapacity(capacity))
+				for _, key := range keys {
+					cache.StoreBytes(key, val, NoExpiration)
+				}
+				cache.Close()
+			}
+		})
+	}
+}
+
+// Benchmark FetchData of 256 byte values with and without checksums
+func BenchmarkFetchChecksums(b *testing.B) {
+	for _, checked := range []bool{false, true} {
+		b.Run(fmt.Sprintf("checksums=%t", checked), func(b *testing.B) {
+			var opts []Option
+			if checked {
+				opts = append(opts, WithChecksums())
+			}
+			cache := NewCache(16, 10000, time.Minute, opts...)
+			defer cache.Close()
+
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = "key_" + strconv.Itoa(i)
+				cache.Store(keys[i], randomValue(256), time.Minute)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.FetchData(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+// Benchmark Store and FetchBytesData with and without latency tracking
+func BenchmarkLatencyTracking(b *testing.B) {
+	for _, tracked := range []bool{false, true} {
+		b.Run(fmt.Sprintf("tracking=%t", tracked), func(b *testing.B) {
+			var opts []Option
+			if tracked {
+				opts = append(opts, WithLatencyTracking(), WithSlowOpThreshold(time.Second, func(string, string, time.Duration) {}))
+			}
+			cache := NewCache(16, 10000, time.Minute, opts...)
+			defer cache.Close()
+
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = "key_" + strconv.Itoa(i)
+			}
+			value := randomValue(64)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						cache.Store(key, value, time.Minute)
+					} else {
+						cache.FetchBytesData(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+// Benchmark parallel lookups of a cache that is no longer written to, with
+// the default shards and with WithReadOptimizedShards. The difference shows
+// with many cores, e.g. -cpu 1,8,32.
+func BenchmarkReadOptimizedShards(b *testing.B) {
+	const numKeys = 100_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "key_" + strconv.Itoa(i)
+	}
+
+	for _, optimized := range []bool{false, true} {
+		b.Run(fmt.Sprintf("optimized=%t", optimized), func(b *testing.B) {
+			opts := []Option{WithEvictionPolicy(ApproxLRU)}
+			if optimized {
+				opts = append(opts, WithReadOptimizedShards())
+			}
+			cache := NewCache(16, numKeys, time.Minute, opts...)
+			defer cache.Close()
+			for _, key := range keys {
+				cache.StoreBytes(key, []byte(key), time.Minute)
+			}
+			// Warm up the read views
+			for _, key := range keys {
+				cache.FetchBytesData(key)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Intn(numKeys)
+				for pb.Next() {
+					cache.FetchBytesData(keys[i%numKeys])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// gcEntries is the size of the caches BenchmarkArenaGC collects.
+const gcEntries = 10_000_000
+
+// measureGC forces b.N full collections, so ns/op is the time of a cycle,
+// and reports what else gctrace would show: the time the world was stopped
+// per cycle and the heap objects that had to be marked.
+func measureGC(b *testing.B) {
+	b.Helper()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "stw-ns/op")
+	b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+}
+
+// Benchmark the cost of garbage collection with a cache of 10M small
+// entries, with values in slices of their own and in arenas. Needs several
+// GB of memory.
+func BenchmarkArenaGC(b *testing.B) {
+	for _, arena := range []bool{false, true} {
+		b.Run(fmt.Sprintf("arena=%t", arena), func(b *testing.B) {
+			var opts []Option
+			if arena {
+				opts = append(opts, WithArenaStorage())
+			}
+			cache := NewCache(256, gcEntries/256+1, time.Hour, opts...)
+			defer cache.Close()
+			value := make([]byte, 32)
+			for i := 0; i < gcEntries; i++ {
+				cache.StoreBytes("key_"+strconv.Itoa(i), value, NoExpiration)
+			}
+			measureGC(b)
+			runtime.KeepAlive(cache)
+		})
+	}
+}
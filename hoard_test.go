@@ -1,9 +1,14 @@
 package hoard
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -33,7 +38,8 @@ func TestStoreAndFetch(t *testing.T) {
 
 // testing that items expire after their TTL.
 func TestExpiration(t *testing.T) {
-	cache := NewCache(4, 1000, time.Second)
+	clock := newFakeClock()
+	cache := NewCache(4, 1000, time.Second, WithClock(clock))
 
 	// Store an item with a short TTL
 	err := cache.Store("aboubakr", "kouhadi", time.Second*2)
@@ -53,8 +59,8 @@ func TestExpiration(t *testing.T) {
 		t.Fatalf("Expected value 'bar', got '%v'", value)
 	}
 
-	// Wait for the item to expire
-	time.Sleep(3 * time.Second)
+	// Let the item expire
+	clock.Advance(3 * time.Second)
 
 	// Fetch the item again (should not exist)
 	_, exists = cache.FetchBytesData("aboubakr")
@@ -119,7 +125,8 @@ func TestLRUEviction(t *testing.T) {
 
 // testing  that expired items are removed by the cleanup goroutine.
 func TestCleanup(t *testing.T) {
-	cache := NewCache(4, 1000, time.Second)
+	clock := newFakeClock()
+	cache := NewCache(4, 1000, 0, WithClock(clock))
 
 	// Store an item with a short TTL
 	err := cache.Store("aboubakr", "kouhadi", time.Second*2)
@@ -127,10 +134,13 @@ func TestCleanup(t *testing.T) {
 		t.Fatalf("Store failed: %v", err)
 	}
 
-	// Wait for the item to expire and the cleanup goroutine to run
-	time.Sleep(3 * time.Second)
+	// Let the item expire and run a cleanup pass
+	clock.Advance(3 * time.Second)
+	cache.RunCleanup()
 
-	// Fetch the item (should not exist)
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected item to be removed by cleanup, got %d items", n)
+	}
 	_, exists := cache.FetchBytesData("aboubakr")
 	if exists {
 		t.Fatal("Expected item to be expired and removed by cleanup")
@@ -172,6 +182,7 @@ func TestConcurrentAccess(t *testing.T) {
 		}(i)
 	}
 	wg.Wait()
+	checkIntegrity(t, cache)
 }
 
 // testing the update of a piece of data
@@ -220,7 +231,9 @@ func TestDelete(t *testing.T) {
 	}
 
 	// Delete the value
-	cache.Delete("haroun")
+	if !cache.Delete("haroun") {
+		t.Error("Expected Delete to report that 'haroun' existed")
+	}
 
 	// Fetch the deleted value
 	value, exists := cache.FetchBytesData("haroun")
@@ -235,7 +248,35 @@ func TestDelete(t *testing.T) {
 	}
 
 	// Test deleting a non-existent key (should not panic or error)
-	cache.Delete("nonexistent")
+	if cache.Delete("nonexistent") {
+		t.Error("Expected Delete to report false for a non-existent key")
+	}
+}
+
+// testing batched deletes with DeleteMany
+func TestDeleteMany(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := cache.Store("key"+strconv.Itoa(i), i, time.Minute); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	keys := []string{"nonexistent"}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, "key"+strconv.Itoa(i))
+	}
+	if deleted := cache.DeleteMany(keys); deleted != 50 {
+		t.Fatalf("Expected 50 deletions, got %d", deleted)
+	}
+	for i := 0; i < 100; i++ {
+		_, exists := cache.FetchBytesData("key" + strconv.Itoa(i))
+		if exists != (i >= 50) {
+			t.Fatalf("Unexpected presence of key%d: %v", i, exists)
+		}
+	}
 }
 
 // testing the concurrent update and delete
@@ -273,6 +314,7 @@ func TestConcurrentUpdateDelete(t *testing.T) {
 		t.Fatalf("Fetch failed: %v", err)
 	}
 	t.Logf("Final state: value=%v, exists=%v", value, exists)
+	checkIntegrity(t, cache)
 }
 
 // testing the cleaning up of cache
@@ -304,6 +346,111 @@ func TestCleanupAllParallel(t *testing.T) {
 	}
 }
 
+// testing that CleanupShard empties only the shard ShardForKey points at
+func TestCleanupShard(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 400; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	idx := cache.ShardForKey("key7")
+	before := cache.ShardLens()
+	if err := cache.CleanupShard(idx); err != nil {
+		t.Fatalf("CleanupShard failed: %v", err)
+	}
+	for i, n := range cache.ShardLens() {
+		if i == idx && n != 0 {
+			t.Fatalf("Expected shard %d to be empty, got %d entries", i, n)
+		}
+		if i != idx && n != before[i] {
+			t.Fatalf("Expected shard %d to keep %d entries, got %d", i, before[i], n)
+		}
+	}
+	if cache.Exists("key7") {
+		t.Fatal("Expected key7 to be removed")
+	}
+
+	for _, i := range []int{-1, 4} {
+		if err := cache.CleanupShard(i); err == nil {
+			t.Fatalf("Expected an error for shard %d", i)
+		}
+	}
+}
+
+// testing that CleanupShard logs and publishes only the live keys it
+// removes, and that both cleanups fail once the cache is closed
+func TestCleanupShardLiveKeys(t *testing.T) {
+	clock := newFakeClock()
+	bus := &recordingBus{}
+	var log bytes.Buffer
+	cache := NewCache(1, 10, 0, WithClock(clock), WithWriteLog(&log),
+		WithInvalidationBus(bus, InvalidateOnDelete))
+	cache.Store("short", 1, time.Second)
+	cache.Store("long", 2, time.Minute)
+	clock.Advance(2 * time.Second)
+	logged := log.Len()
+
+	if err := cache.CleanupShard(0); err != nil {
+		t.Fatalf("CleanupShard failed: %v", err)
+	}
+	if n := cache.ShardLens()[0]; n != 0 {
+		t.Fatalf("Expected the shard to be empty, got %d entries", n)
+	}
+	cache.Close() // publishes what is queued
+	if !bus.published("long") || bus.published("short") {
+		t.Fatalf("Expected only the live key to be published, got %v", bus.keys)
+	}
+	if written := log.Bytes()[logged:]; !bytes.Contains(written, []byte("long")) || bytes.Contains(written, []byte("short")) {
+		t.Fatal("Expected only the live key to be logged")
+	}
+
+	if err := cache.CleanupShard(0); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("Expected CleanupShard to fail with ErrCacheClosed, got %v", err)
+	}
+	if err := cache.CleanupAll(); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("Expected CleanupAll to fail with ErrCacheClosed, got %v", err)
+	}
+}
+
+// testing that lookups on other shards proceed while a large shard is being
+// flushed
+func TestCleanupShardConcurrentFetch(t *testing.T) {
+	cache := NewCache(4, 300_000, time.Minute, WithShardingFunc(skewed))
+	defer cache.Close()
+
+	for i := 0; i < 200_000; i++ {
+		cache.StoreBytes("hot"+strconv.Itoa(i), []byte("v"), NoExpiration)
+	}
+	cache.StoreBytes("cold", []byte("v"), NoExpiration)
+
+	done := make(chan error)
+	go func() { done <- cache.CleanupShard(0) }()
+	fetches := 0
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("CleanupShard failed: %v", err)
+			}
+			if fetches == 0 {
+				t.Fatal("Expected fetches on another shard to complete during the flush")
+			}
+			t.Logf("%d fetches completed during the flush", fetches)
+			if cache.Len() != 1 {
+				t.Fatalf("Expected only the cold key to remain, got %d entries", cache.Len())
+			}
+			checkIntegrity(t, cache)
+			return
+		default:
+		}
+		if _, ok := cache.FetchBytesData("cold"); !ok {
+			t.Fatal("Expected the cold key to stay")
+		}
+		fetches++
+	}
+}
+
 // TestIterate ensures that Iterate visits all items in the cache.
 func TestIterate(t *testing.T) {
 	cache := NewCache(10, 10000, time.Minute)
@@ -329,3 +476,1023 @@ func TestIterate(t *testing.T) {
 		t.Fatalf("Expected %d items, got %d", numItems, len(visited))
 	}
 }
+
+// testing that Close stops the cleanup goroutine and rejects further use
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cache := NewCache(4, 1000, time.Millisecond)
+
+	if err := cache.Store("aboubakr", "kouhadi", time.Minute); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	// Closing twice must be safe
+	if err := cache.Close(); err != nil {
+		t.Fatalf("second Close failed: %v", err)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("Expected cleanup goroutine to exit, goroutines before=%d after=%d", before, after)
+	}
+
+	if err := cache.Store("haroun", 30, time.Minute); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("Expected ErrCacheClosed from Store, got %v", err)
+	}
+	if _, _, err := cache.FetchData("aboubakr"); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("Expected ErrCacheClosed from FetchData, got %v", err)
+	}
+	if err := cache.Update("aboubakr", "bryan", time.Minute); !errors.Is(err, ErrCacheClosed) {
+		t.Fatalf("Expected ErrCacheClosed from Update, got %v", err)
+	}
+}
+
+// testing that recycled items never leak another key's value to a reader.
+// Run with -race to also catch unsynchronized access to pooled items.
+func TestPoolReuseNoCorruption(t *testing.T) {
+	cache := NewCache(2, 16, time.Millisecond)
+	defer cache.Close()
+
+	keys := make([]string, 8)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 2000; n++ {
+				key := keys[(g+n)%len(keys)]
+				switch n % 3 {
+				case 0:
+					if err := cache.Store(key, key+":"+strconv.Itoa(n), time.Minute); err != nil {
+						t.Errorf("Store failed: %v", err)
+						return
+					}
+				case 1:
+					value, exists, err := cache.FetchData(key)
+					if err != nil {
+						t.Errorf("Fetch failed: %v", err)
+						return
+					}
+					if exists && !strings.HasPrefix(value.(string), key+":") {
+						t.Errorf("Fetched %q for key %s", value, key)
+						return
+					}
+				case 2:
+					cache.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// testing that FetchBytes returns a private copy of the serialized value
+func TestFetchBytes(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	if err := cache.Store("aboubakr", "kouhadi", time.Minute); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	data, exists := cache.FetchBytes("aboubakr")
+	if !exists {
+		t.Fatal("Expected item to exist in the cache")
+	}
+	value, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if value != "kouhadi" {
+		t.Fatalf("Expected value 'kouhadi', got '%v'", value)
+	}
+
+	// Mutating the returned slice must not affect the cache
+	for i := range data {
+		data[i] = 0
+	}
+	value, _, err = cache.FetchData("aboubakr")
+	if err != nil || value != "kouhadi" {
+		t.Fatalf("Expected cached value to be untouched, got '%v' (err %v)", value, err)
+	}
+
+	if _, exists := cache.FetchBytes("nonexistent"); exists {
+		t.Fatal("Expected missing key to report false")
+	}
+}
+
+// testing that GetOrStore runs the loader once per missing key
+func TestGetOrStore(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	var calls int32
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "kouhadi", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, _, err := cache.GetOrStore("aboubakr", time.Minute, loader)
+			if err != nil {
+				t.Errorf("GetOrStore failed: %v", err)
+				return
+			}
+			if value != "kouhadi" {
+				t.Errorf("Expected value 'kouhadi', got '%v'", value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected loader to run once, ran %d times", n)
+	}
+
+	// The value is now cached, so the loader must not run again
+	value, loaded, err := cache.GetOrStore("aboubakr", time.Minute, loader)
+	if err != nil {
+		t.Fatalf("GetOrStore failed: %v", err)
+	}
+	if loaded {
+		t.Fatal("Expected loaded to be false for an existing key")
+	}
+	if value != "kouhadi" {
+		t.Fatalf("Expected value 'kouhadi', got '%v'", value)
+	}
+}
+
+// testing that GetOrStore does not cache loader errors
+func TestGetOrStoreError(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	errBoom := errors.New("boom")
+	_, _, err := cache.GetOrStore("haroun", time.Minute, func() (interface{}, error) {
+		return nil, errBoom
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("Expected loader error, got %v", err)
+	}
+	if _, exists := cache.FetchBytesData("haroun"); exists {
+		t.Fatal("Expected failed load not to be cached")
+	}
+
+	value, loaded, err := cache.GetOrStore("haroun", time.Minute, func() (interface{}, error) {
+		return 30, nil
+	})
+	if err != nil || !loaded || value != 30 {
+		t.Fatalf("Expected (30, true, nil), got (%v, %v, %v)", value, loaded, err)
+	}
+}
+
+// testing that a registered loader coalesces concurrent misses
+func TestLoaderCoalescesMisses(t *testing.T) {
+	var calls int32
+	cache := NewCache(4, 1000, time.Minute, WithLoader(func(key string) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "loaded:" + key, time.Minute, nil
+	}))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, exists, err := cache.FetchData("aboubakr")
+			if err != nil {
+				t.Errorf("Fetch failed: %v", err)
+				return
+			}
+			if !exists || value != "loaded:aboubakr" {
+				t.Errorf("Expected loaded value, got (%v, %v)", value, exists)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected loader to run once, ran %d times", n)
+	}
+	if _, exists := cache.FetchBytesData("aboubakr"); !exists {
+		t.Fatal("Expected loaded value to be stored")
+	}
+}
+
+// testing that loader errors reach the caller and are not cached
+func TestLoaderError(t *testing.T) {
+	errBoom := errors.New("boom")
+	var calls int32
+	cache := NewCache(4, 1000, time.Minute, WithLoader(func(key string) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, 0, errBoom
+	}))
+	defer cache.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, exists, err := cache.FetchData("haroun"); !errors.Is(err, errBoom) || exists {
+			t.Fatalf("Expected loader error, got (%v, %v)", exists, err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("Expected errors not to be cached, loader ran %d times", n)
+	}
+}
+
+// testing that a panicking loader panics in every caller waiting on it,
+// not just the one running it, and leaves the key loadable
+func TestLoaderPanic(t *testing.T) {
+	errBoom := errors.New("boom")
+	var calls int32
+	cache := NewCache(4, 1000, time.Minute, WithLoader(func(key string) (interface{}, time.Duration, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			panic(errBoom)
+		}
+		return "loaded", time.Minute, nil
+	}))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if err, ok := recover().(error); !ok || !errors.Is(err, errBoom) {
+					t.Errorf("Expected the loader's panic, got %v", err)
+				}
+			}()
+			cache.FetchData("key")
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected loader to run once, ran %d times", n)
+	}
+	if value, exists, err := cache.FetchData("key"); err != nil || !exists || value != "loaded" {
+		t.Fatalf("Expected the next load to succeed, got (%v, %v, %v)", value, exists, err)
+	}
+}
+
+// testing bulk inserts with StoreMany
+func TestStoreMany(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	items := make(map[string]interface{})
+	for i := 0; i < 500; i++ {
+		items["key"+strconv.Itoa(i)] = "value" + strconv.Itoa(i)
+	}
+	// A channel cannot be serialized and must be reported, not abort the batch
+	items["bad"] = make(chan int)
+
+	errs := cache.StoreMany(items, time.Minute)
+	if len(errs) != 1 || errs["bad"] == nil {
+		t.Fatalf("Expected a single error for key 'bad', got %v", errs)
+	}
+
+	for i := 0; i < 500; i++ {
+		key := "key" + strconv.Itoa(i)
+		value, exists, err := cache.FetchData(key)
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if !exists || value != "value"+strconv.Itoa(i) {
+			t.Fatalf("Expected %s to hold 'value%d', got (%v, %v)", key, i, value, exists)
+		}
+	}
+	if _, exists := cache.FetchBytesData("bad"); exists {
+		t.Fatal("Expected 'bad' not to be stored")
+	}
+}
+
+// testing batched fetches with FetchMany and FetchBytesMany
+func TestFetchMany(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := cache.Store("key"+strconv.Itoa(i), i, time.Minute); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	if err := cache.Store("expired", "gone", -time.Second); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	keys := []string{"key1", "nonexistent", "key20", "expired", "key49"}
+	found, missing, err := cache.FetchMany(keys)
+	if err != nil {
+		t.Fatalf("FetchMany failed: %v", err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("Expected 3 values, got %v", found)
+	}
+	for _, i := range []int{1, 20, 49} {
+		value := found["key"+strconv.Itoa(i)]
+		if fmt.Sprint(value) != strconv.Itoa(i) {
+			t.Fatalf("Expected key%d to hold %d, got %v", i, i, value)
+		}
+	}
+	if len(missing) != 2 || missing[0] != "nonexistent" || missing[1] != "expired" {
+		t.Fatalf("Expected missing [nonexistent expired], got %v", missing)
+	}
+
+	raw, missing := cache.FetchBytesMany([]string{"key1", "nonexistent"})
+	if len(raw) != 1 || len(missing) != 1 {
+		t.Fatalf("Expected one hit and one miss, got %v and %v", raw, missing)
+	}
+	value, err := Deserialize(raw["key1"])
+	if err != nil || fmt.Sprint(value) != "1" {
+		t.Fatalf("Expected key1 to decode to 1, got %v (err %v)", value, err)
+	}
+}
+
+// testing that Exists reports presence without promoting the key
+func TestExists(t *testing.T) {
+	cache := NewCache(1, 2, time.Minute) // 1 shard, max 2 items per shard
+	defer cache.Close()
+
+	cache.Store("aboubakr", "kouhadi", time.Minute)
+	cache.Store("haroun", 30, time.Minute)
+	cache.Store("expired", "gone", -time.Second)
+
+	if !cache.Exists("haroun") {
+		t.Fatal("Expected 'haroun' to exist")
+	}
+	if cache.Exists("expired") || cache.Exists("nonexistent") {
+		t.Fatal("Expected expired and missing keys not to exist")
+	}
+
+	// "aboubakr" is the least recently used; Exists must not rescue it
+	cache = NewCache(1, 2, time.Minute)
+	defer cache.Close()
+	cache.Store("aboubakr", "kouhadi", time.Minute)
+	cache.Store("haroun", 30, time.Minute)
+	cache.Exists("aboubakr")
+	cache.Store("qux", 3.14, time.Minute)
+	if cache.Exists("aboubakr") {
+		t.Fatal("Expected Exists not to affect LRU order")
+	}
+}
+
+// testing that Peek does not rescue a key from LRU eviction the way Fetch does
+func TestPeekDoesNotPromote(t *testing.T) {
+	cache := NewCache(1, 2, time.Minute) // 1 shard, max 2 items per shard
+	defer cache.Close()
+
+	cache.Store("aboubakr", "kouhadi", time.Minute)
+	cache.Store("haroun", 30, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		value, exists, err := cache.Peek("aboubakr")
+		if err != nil {
+			t.Fatalf("Peek failed: %v", err)
+		}
+		if !exists || value != "kouhadi" {
+			t.Fatalf("Expected 'kouhadi', got (%v, %v)", value, exists)
+		}
+	}
+
+	// "aboubakr" is still the least recently used and must be evicted
+	cache.Store("qux", 3.14, time.Minute)
+	if _, exists, _ := cache.Peek("aboubakr"); exists {
+		t.Fatal("Expected Peek not to protect 'aboubakr' from eviction")
+	}
+	if _, exists, _ := cache.Peek("haroun"); !exists {
+		t.Fatal("Expected 'haroun' to survive")
+	}
+
+	cache.Store("expired", "gone", -time.Second)
+	if _, exists, _ := cache.Peek("expired"); exists {
+		t.Fatal("Expected Peek to honor expiration")
+	}
+}
+
+// testing TTL and ExpiresAt queries
+func TestTTL(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	before := time.Now()
+	cache.Store("aboubakr", "kouhadi", time.Minute)
+	cache.Store("forever", "kouhadi", NoExpiration)
+
+	ttl, exists := cache.TTL("aboubakr")
+	if !exists || ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("Expected a TTL close to one minute, got (%v, %v)", ttl, exists)
+	}
+	deadline, exists := cache.ExpiresAt("aboubakr")
+	if !exists || deadline.Before(before.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("Unexpected deadline (%v, %v)", deadline, exists)
+	}
+
+	if ttl, exists := cache.TTL("forever"); !exists || ttl != NoExpiration {
+		t.Fatalf("Expected NoExpiration, got (%v, %v)", ttl, exists)
+	}
+	if deadline, exists := cache.ExpiresAt("forever"); !exists || !deadline.IsZero() {
+		t.Fatalf("Expected zero deadline, got (%v, %v)", deadline, exists)
+	}
+	if _, exists := cache.FetchBytesData("forever"); !exists {
+		t.Fatal("Expected never-expiring item to exist")
+	}
+
+	if _, exists := cache.TTL("nonexistent"); exists {
+		t.Fatal("Expected missing key to report false")
+	}
+}
+
+// testing that Touch extends a live entry without rewriting it
+func TestTouch(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	cache.Store("aboubakr", "kouhadi", time.Second)
+	if err := cache.Touch("aboubakr", time.Hour); err != nil {
+		t.Fatalf("Touch failed: %v", err)
+	}
+	if ttl, _ := cache.TTL("aboubakr"); ttl <= time.Minute {
+		t.Fatalf("Expected TTL to be extended, got %v", ttl)
+	}
+	if value, _, _ := cache.FetchData("aboubakr"); value != "kouhadi" {
+		t.Fatalf("Expected value to be untouched, got '%v'", value)
+	}
+
+	if err := cache.Touch("nonexistent", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// An already expired entry must not be resurrected
+	cache.Store("expired", "gone", -time.Second)
+	if err := cache.Touch("expired", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound for expired entry, got %v", err)
+	}
+	if cache.Exists("expired") {
+		t.Fatal("Expected expired entry to stay gone")
+	}
+}
+
+// testing batched TTL refreshes with TouchMany
+func TestTouchMany(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("session"+strconv.Itoa(i), i, time.Second)
+	}
+	cache.Store("expired", "gone", -time.Second)
+
+	keys := []string{"expired", "nonexistent"}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "session"+strconv.Itoa(i))
+	}
+	if touched := cache.TouchMany(keys, time.Hour); touched != 10 {
+		t.Fatalf("Expected 10 touched keys, got %d", touched)
+	}
+	for i := 0; i < 10; i++ {
+		if ttl, _ := cache.TTL("session" + strconv.Itoa(i)); ttl <= time.Minute {
+			t.Fatalf("Expected session%d to be extended, got %v", i, ttl)
+		}
+	}
+}
+
+// testing that StoreBytes skips serialization and stores a private copy
+func TestStoreBytes(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	payload := []byte("raw payload")
+	if err := cache.StoreBytes("raw", payload, time.Minute); err != nil {
+		t.Fatalf("StoreBytes failed: %v", err)
+	}
+	payload[0] = 'X'
+
+	data, exists := cache.FetchBytes("raw")
+	if !exists || string(data) != "raw payload" {
+		t.Fatalf("Expected the original bytes, got (%q, %v)", data, exists)
+	}
+
+	value, exists, err := cache.FetchData("raw")
+	if err != nil || !exists {
+		t.Fatalf("Fetch failed: (%v, %v)", exists, err)
+	}
+	if b, ok := value.([]byte); !ok || string(b) != "raw payload" {
+		t.Fatalf("Expected FetchData to return the raw bytes, got %#v", value)
+	}
+
+	// Overwriting with a regular value switches back to the serializer
+	cache.Store("raw", "kouhadi", time.Minute)
+	if value, _, _ := cache.FetchData("raw"); value != "kouhadi" {
+		t.Fatalf("Expected 'kouhadi', got %#v", value)
+	}
+}
+
+type person struct {
+	Name    string
+	Age     int
+	Hobbies []string
+}
+
+// testing decoding into caller-provided destinations with FetchInto
+func TestFetchInto(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	bakr := person{Name: "Aboubakr", Age: 33, Hobbies: []string{"guitar", "soccer"}}
+	cache.Store("person", bakr, time.Minute)
+	cache.Store("people", []person{bakr, {Name: "Haroun", Age: 3}}, time.Minute)
+	cache.Store("pointer", &bakr, time.Minute)
+	cache.Store("name", "kouhadi", time.Minute)
+
+	var p person
+	exists, err := cache.FetchInto("person", &p)
+	if err != nil || !exists {
+		t.Fatalf("FetchInto failed: (%v, %v)", exists, err)
+	}
+	if p.Name != "Aboubakr" || p.Age != 33 || len(p.Hobbies) != 2 {
+		t.Fatalf("Unexpected struct %+v", p)
+	}
+
+	var people []person
+	if _, err := cache.FetchInto("people", &people); err != nil {
+		t.Fatalf("FetchInto failed: %v", err)
+	}
+	if len(people) != 2 || people[1].Name != "Haroun" {
+		t.Fatalf("Unexpected slice %+v", people)
+	}
+
+	var pp *person
+	if _, err := cache.FetchInto("pointer", &pp); err != nil {
+		t.Fatalf("FetchInto failed: %v", err)
+	}
+	if pp == nil || pp.Age != 33 {
+		t.Fatalf("Unexpected pointer %+v", pp)
+	}
+
+	exists, err = cache.FetchInto("nonexistent", &p)
+	if exists || err != nil {
+		t.Fatalf("Expected (false, nil) on a miss, got (%v, %v)", exists, err)
+	}
+
+	var n int
+	if _, err := cache.FetchInto("name", &n); err == nil || !strings.Contains(err.Error(), "*int") {
+		t.Fatalf("Expected a descriptive decode error, got %v", err)
+	}
+}
+
+// testing that Len follows stores, deletes, evictions and CleanupAll
+func TestLen(t *testing.T) {
+	cache := NewCache(2, 5, time.Hour)
+	defer cache.Close()
+
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected empty cache, got %d", n)
+	}
+	for i := 0; i < 4; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	if n := cache.Len(); n != 4 {
+		t.Fatalf("Expected 4 items, got %d", n)
+	}
+
+	cache.Store("key0", "overwritten", time.Minute)
+	cache.Delete("key1")
+	if n := cache.Len(); n != 3 {
+		t.Fatalf("Expected 3 items, got %d", n)
+	}
+
+	// Overflow both shards so LRU eviction caps each at 5
+	for i := 0; i < 100; i++ {
+		cache.Store("more"+strconv.Itoa(i), i, time.Minute)
+	}
+	lens := cache.ShardLens()
+	if len(lens) != 2 || lens[0] != 5 || lens[1] != 5 || cache.Len() != 10 {
+		t.Fatalf("Expected 5 items per shard, got %v", lens)
+	}
+
+	cache.CleanupAll()
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected empty cache after CleanupAll, got %d", n)
+	}
+}
+
+// testing that NewCacheE reports invalid arguments instead of panicking
+func TestNewCacheE(t *testing.T) {
+	tests := []struct {
+		shards, items int
+		interval      time.Duration
+	}{
+		{0, 10, time.Second},
+		{-1, 10, time.Second},
+		{2, 0, time.Second},
+		{2, 10, -time.Second},
+	}
+	for _, tt := range tests {
+		if cache, err := NewCacheE(tt.shards, tt.items, tt.interval); err == nil {
+			cache.Close()
+			t.Errorf("Expected an error for %+v", tt)
+		}
+	}
+
+	// A zero interval disables background cleanup
+	clock := newFakeClock()
+	cache, err := NewCacheE(2, 10, 0, WithClock(clock))
+	if err != nil {
+		t.Fatalf("Expected zero cleanup interval to be valid, got %v", err)
+	}
+	defer cache.Close()
+	cache.Store("key", "value", time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Expected expired entry to stay without cleanup, got %d items", n)
+	}
+	if _, ok, _ := cache.FetchData("key"); ok {
+		t.Error("Expected expired entry to be a miss")
+	}
+}
+
+// testing DeleteByPrefix with a namespace and with the empty prefix
+func TestDeleteByPrefix(t *testing.T) {
+	cache := NewCache(4, 100, time.Hour)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("session:42:"+strconv.Itoa(i), i, time.Minute)
+		cache.Store("session:7:"+strconv.Itoa(i), i, time.Minute)
+	}
+	cache.Store("session:42:expired", "gone", -time.Second)
+
+	if n := cache.DeleteByPrefix("session:42:"); n != 10 {
+		t.Fatalf("Expected 10 live entries deleted, got %d", n)
+	}
+	if keys := cache.KeysWithPrefix("session:42:"); len(keys) != 0 {
+		t.Fatalf("Expected no session:42 keys left, got %v", keys)
+	}
+	if n := cache.Len(); n != 10 {
+		t.Fatalf("Expected session:7 keys to remain, got %d items", n)
+	}
+
+	if n := cache.DeleteByPrefix(""); n != 10 {
+		t.Fatalf("Expected the empty prefix to delete everything, got %d", n)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected an empty cache, got %d items", n)
+	}
+}
+
+// testing that SetIfAbsent only stores when the key is missing
+func TestSetIfAbsent(t *testing.T) {
+	cache := NewCache(2, 10, time.Hour)
+	defer cache.Close()
+
+	if stored, err := cache.SetIfAbsent("lock", "owner-1", time.Minute); err != nil || !stored {
+		t.Fatalf("Expected first SetIfAbsent to store, got %v (%v)", stored, err)
+	}
+	if stored, err := cache.SetIfAbsent("lock", "owner-2", time.Minute); err != nil || stored {
+		t.Fatalf("Expected second SetIfAbsent to be rejected, got %v (%v)", stored, err)
+	}
+	if value, _, _ := cache.FetchData("lock"); value != "owner-1" {
+		t.Fatalf("Expected owner-1 to hold the lock, got %v", value)
+	}
+}
+
+// testing that an expired but not yet cleaned entry can be taken over
+func TestSetIfAbsentExpiredTakeover(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock))
+	defer cache.Close()
+
+	cache.SetIfAbsent("lock", "owner-1", 5*time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("Expected the expired entry to still be held, got %d items", n)
+	}
+
+	if stored, err := cache.SetIfAbsent("lock", "owner-2", time.Minute); err != nil || !stored {
+		t.Fatalf("Expected the expired lock to be taken over, got %v (%v)", stored, err)
+	}
+	if value, _, _ := cache.FetchData("lock"); value != "owner-2" {
+		t.Fatalf("Expected owner-2 to hold the lock, got %v", value)
+	}
+}
+
+// testing that exactly one of many concurrent SetIfAbsent calls wins
+func TestSetIfAbsentConcurrent(t *testing.T) {
+	cache := NewCache(4, 10, time.Hour)
+	defer cache.Close()
+
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if stored, _ := cache.SetIfAbsent("marker", i, time.Minute); stored {
+				wins.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := wins.Load(); n != 1 {
+		t.Fatalf("Expected exactly one winner, got %d", n)
+	}
+	checkIntegrity(t, cache)
+}
+
+// testing that Pop returns and removes the value, and treats expired entries
+// as a miss
+func TestPop(t *testing.T) {
+	cache := NewCache(2, 10, time.Hour)
+	defer cache.Close()
+
+	cache.Store("job", "payload", time.Minute)
+	value, ok, err := cache.Pop("job")
+	if err != nil || !ok || value != "payload" {
+		t.Fatalf("Expected to pop payload, got %v %v %v", value, ok, err)
+	}
+	if cache.Exists("job") {
+		t.Fatal("Expected the popped key to be gone")
+	}
+	if _, ok, _ := cache.Pop("job"); ok {
+		t.Fatal("Expected a second Pop to miss")
+	}
+
+	cache.Store("expired", "gone", -time.Second)
+	if _, ok, _ := cache.Pop("expired"); ok {
+		t.Fatal("Expected an expired entry to be a miss")
+	}
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected an empty cache, got %d items", n)
+	}
+}
+
+// testing that exactly one of many concurrent Pop calls receives the value
+func TestPopConcurrent(t *testing.T) {
+	cache := NewCache(4, 10, time.Hour)
+	defer cache.Close()
+
+	for round := 0; round < 100; round++ {
+		cache.Store("job", round, time.Minute)
+		var got atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, ok, _ := cache.Pop("job"); ok {
+					got.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := got.Load(); n != 1 {
+			t.Fatalf("Round %d: expected exactly one consumer, got %d", round, n)
+		}
+	}
+	checkIntegrity(t, cache)
+}
+
+// testing that GetSet returns the replaced value
+func TestGetSet(t *testing.T) {
+	cache := NewCache(1, 2, time.Hour)
+	defer cache.Close()
+
+	if old, existed, err := cache.GetSet("key", "first", time.Minute); err != nil || existed || old != nil {
+		t.Fatalf("Expected no previous value, got %v %v %v", old, existed, err)
+	}
+	old, existed, err := cache.GetSet("key", "second", time.Minute)
+	if err != nil || !existed || old != "first" {
+		t.Fatalf("Expected first, got %v %v %v", old, existed, err)
+	}
+
+	// The returned value is unaffected by later writes and pool reuse
+	for i := 0; i < 100; i++ {
+		cache.Store("other"+strconv.Itoa(i), strings.Repeat("x", 5), time.Minute)
+	}
+	if old != "first" {
+		t.Fatalf("Expected the old value to stay intact, got %v", old)
+	}
+
+	// Like Store, a new key evicts the least recently used entry
+	cache.CleanupAll()
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+	cache.GetSet("c", 3, time.Minute)
+	if cache.Exists("a") || !cache.Exists("c") {
+		t.Fatal("Expected GetSet to evict like Store")
+	}
+
+	cache.Store("expired", "gone", -time.Second)
+	if _, existed, _ := cache.GetSet("expired", "new", time.Minute); existed {
+		t.Fatal("Expected an expired entry not to count as existing")
+	}
+}
+
+// testing that UpdateValue keeps the expiration
+func TestUpdateValue(t *testing.T) {
+	cache := NewCache(1, 10, time.Hour)
+	defer cache.Close()
+
+	cache.Store("key", "old", time.Minute)
+	before, _ := cache.ExpiresAt("key")
+	if err := cache.UpdateValue("key", "new"); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := cache.ExpiresAt("key")
+	if !after.Equal(before) {
+		t.Fatalf("Expected the expiration to be kept, got %v instead of %v", after, before)
+	}
+	if value, _, _ := cache.FetchData("key"); value != "new" {
+		t.Fatalf("Expected new, got %v", value)
+	}
+
+	cache.Store("expired", "gone", -time.Second)
+	for _, key := range []string{"missing", "expired"} {
+		if err := cache.UpdateValue(key, "value"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound for %s, got %v", key, err)
+		}
+	}
+}
+
+// testing that UpdateTTL changes only the expiration while Touch also counts
+// as a use
+func TestUpdateTTLVersusTouch(t *testing.T) {
+	cache := NewCache(1, 2, time.Hour)
+	defer cache.Close()
+
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+
+	// UpdateTTL does not save "a" from eviction
+	if err := cache.UpdateTTL("a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := cache.TTL("a"); ttl <= time.Minute {
+		t.Fatalf("Expected the new TTL, got %v", ttl)
+	}
+	cache.Store("c", 3, time.Minute)
+	if cache.Exists("a") {
+		t.Fatal("Expected UpdateTTL to leave the LRU order alone")
+	}
+
+	// Touch does
+	cache.Touch("b", time.Hour)
+	cache.Store("d", 4, time.Minute)
+	if !cache.Exists("b") || cache.Exists("c") {
+		t.Fatal("Expected Touch to move the entry to the LRU front")
+	}
+
+	if err := cache.UpdateTTL("missing", time.Minute); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+// testing that with WithSlidingTTL an entry lives as long as it is read and
+// expires one TTL after the last read
+func TestSlidingTTL(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithSlidingTTL(), WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("session", "data", ttl)
+	// Read every 25ms for five TTLs
+	for i := 0; i < 20; i++ {
+		clock.Advance(ttl / 4)
+		if _, ok := cache.FetchBytesData("session"); !ok {
+			t.Fatalf("Expected the session to survive while in use, lost after %d reads", i)
+		}
+	}
+
+	// Exists does not slide, so the entry dies one TTL after the last read
+	clock.Advance(ttl)
+	if !cache.Exists("session") {
+		t.Fatal("Expected the session to live one TTL after the last read")
+	}
+	clock.Advance(time.Nanosecond)
+	if cache.Exists("session") {
+		t.Fatal("Expected the session to expire one TTL after the last read")
+	}
+}
+
+// testing that entries do not slide without the option
+func TestNoSlidingTTLByDefault(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("key", "value", time.Minute)
+	before, _ := cache.ExpiresAt("key")
+	clock.Advance(2 * time.Millisecond)
+	cache.FetchBytesData("key")
+	if after, _ := cache.ExpiresAt("key"); !after.Equal(before) {
+		t.Fatalf("Expected the expiration to stay %v, got %v", before, after)
+	}
+}
+
+// testing that every ItemOptions field takes effect through StoreWithOptions
+func TestStoreWithOptions(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 3, 0, WithClock(clock), WithMaxCostPerShard(10))
+	defer cache.Close()
+
+	// Zero options store like Store
+	if err := cache.StoreWithOptions("plain", "value", ItemOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.ExpiresAt("plain"); !ok {
+		t.Fatal("Expected the plain entry to be stored")
+	}
+	if stats := cache.Stats(); stats.Cost != 1 {
+		t.Fatalf("Expected a default cost of 1, got %d", stats.Cost)
+	}
+
+	// Pinned, tagged and sliding compose
+	err := cache.StoreWithOptions("config", "on", ItemOptions{
+		TTL:     time.Minute,
+		Cost:    4,
+		Tags:    []string{"settings"},
+		Sliding: true,
+		Pinned:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Cost != 5 {
+		t.Fatalf("Expected the cost to be charged, got %d", stats.Cost)
+	}
+	clock.Advance(50 * time.Second)
+	cache.FetchData("config")
+	clock.Advance(50 * time.Second)
+	if _, ok, _ := cache.FetchData("config"); !ok {
+		t.Fatal("Expected the hit to renew the sliding entry")
+	}
+	if ttl, _ := cache.TTL("config"); ttl != time.Minute {
+		t.Fatalf("Expected a renewed ttl of a minute, got %v", ttl)
+	}
+	cache.Store("a", 1, NoExpiration)
+	cache.Store("b", 2, NoExpiration) // evicts plain, not the pinned entry
+	if !cache.Exists("config") || cache.Exists("plain") {
+		t.Fatal("Expected the pinned entry to survive eviction")
+	}
+	if n := cache.InvalidateTag("settings"); n != 1 || cache.Exists("config") {
+		t.Fatalf("Expected the tag to remove the entry, removed %d", n)
+	}
+
+	// Raw bytes bypass the serializer
+	if err := cache.StoreWithOptions("raw", []byte("bytes"), ItemOptions{RawBytes: true}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := cache.FetchBytes("raw"); !ok || string(v) != "bytes" {
+		t.Fatalf("Expected the raw bytes, got %q", v)
+	}
+	if err := cache.StoreWithOptions("raw", "string", ItemOptions{RawBytes: true}); !errors.Is(err, ErrSerialization) {
+		t.Fatalf("Expected ErrSerialization for a non-byte value, got %v", err)
+	}
+	if err := cache.StoreWithOptions("neg", 1, ItemOptions{Cost: -1}); err == nil {
+		t.Fatal("Expected an error for a negative cost")
+	}
+}
+
+// testing that sliding entries renew under a policy with read-only lookups,
+// while storing again without Sliding stops the renewal
+func TestStoreWithOptionsSlidingFIFO(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock), WithEvictionPolicy(FIFO))
+	defer cache.Close()
+
+	cache.StoreWithOptions("key", 1, ItemOptions{TTL: time.Minute, Sliding: true})
+	cache.Store("fixed", 1, time.Minute)
+	for i := 0; i < 5; i++ {
+		clock.Advance(30 * time.Second)
+		if _, ok := cache.FetchBytes("key"); !ok {
+			t.Fatalf("Expected the sliding entry to stay alive, round %d", i)
+		}
+	}
+	if cache.Exists("fixed") {
+		t.Fatal("Expected entries stored without Sliding to expire")
+	}
+
+	cache.Store("key", 2, time.Minute)
+	clock.Advance(30 * time.Second)
+	cache.FetchBytes("key")
+	clock.Advance(31 * time.Second)
+	if cache.Exists("key") {
+		t.Fatal("Expected Store to drop the sliding setting")
+	}
+}
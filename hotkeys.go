@@ -0,0 +1,152 @@
+package hoard
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyCount is an entry of the HotKeys report.
+type KeyCount struct {
+	Key   string
+	Count uint64 // estimated fetches over the last window
+	Shard int
+}
+
+const (
+	// hotKeySampleRate is how many fetches one sampled fetch stands for; it
+	// must be a power of two.
+	hotKeySampleRate = 16
+	// hotKeyStripes splits the counters so sampled fetches of different keys
+	// rarely share a lock; it must be a power of two.
+	hotKeyStripes = 16
+)
+
+// hotKeys estimates the most fetched keys. It samples fetches and counts the
+// sampled keys per window in striped maps of bounded size, replacing the
+// least counted key when a stripe is full, as in the Space-Saving algorithm.
+// The counts of the previous window are kept to weigh in while the current
+// one fills, approximating a sliding window.
+type hotKeys struct {
+	n       int
+	window  time.Duration
+	rate    uint32 // 1 in rate fetches is counted
+	stripes [hotKeyStripes]hotKeyStripe
+}
+
+type hotKeyStripe struct {
+	mu    sync.Mutex
+	start int64 // when the current window began
+	cur   map[string]uint64
+	prev  map[string]uint64
+}
+
+func (h *hotKeys) init(now int64) {
+	h.rate = hotKeySampleRate
+	for i := range h.stripes {
+		h.stripes[i].start = now
+		h.stripes[i].cur = make(map[string]uint64)
+	}
+}
+
+// capacity is how many keys a stripe counts at a time.
+func (h *hotKeys) capacity() int {
+	return max(4*h.n, 64)
+}
+
+// record counts a fetch of key, unless it is not sampled.
+func (h *hotKeys) record(key string, now int64) {
+	if h.rate > 1 && rand.Uint32()&(h.rate-1) != 0 {
+		return
+	}
+	s := &h.stripes[fnv32a(key)&(hotKeyStripes-1)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate(h.window, now)
+	if n, ok := s.cur[key]; ok || len(s.cur) < h.capacity() {
+		s.cur[key] = n + 1
+		return
+	}
+	// Take over the least counted key, inheriting its count as the
+	// error bound of Space-Saving
+	var victim string
+	least := ^uint64(0)
+	for k, n := range s.cur {
+		if n < least {
+			victim, least = k, n
+		}
+	}
+	delete(s.cur, victim)
+	s.cur[key] = least + 1
+}
+
+// rotate starts a new window once the current one is over. The caller must
+// hold s.mu.
+func (s *hotKeyStripe) rotate(window time.Duration, now int64) {
+	if window <= 0 || now-s.start < int64(window) {
+		return
+	}
+	if now-s.start < 2*int64(window) {
+		s.prev = s.cur
+	} else {
+		s.prev = nil
+	}
+	s.cur = make(map[string]uint64, len(s.cur))
+	s.start = now - (now-s.start)%int64(window)
+}
+
+// top returns the n keys with the highest estimated counts at now.
+func (h *hotKeys) top(now int64) []KeyCount {
+	var all []KeyCount
+	for i := range h.stripes {
+		s := &h.stripes[i]
+		s.mu.Lock()
+		s.rotate(h.window, now)
+		// Weigh the previous window by the share of it still inside a
+		// window ending now
+		weight := 0.0
+		if h.window > 0 && s.prev != nil {
+			weight = 1 - float64(now-s.start)/float64(h.window)
+		}
+		counts := make(map[string]float64, len(s.cur)+len(s.prev))
+		for k, n := range s.cur {
+			counts[k] += float64(n)
+		}
+		for k, n := range s.prev {
+			counts[k] += weight * float64(n)
+		}
+		s.mu.Unlock()
+		for k, n := range counts {
+			if c := uint64(n*float64(h.rate) + 0.5); c > 0 {
+				all = append(all, KeyCount{Key: k, Count: c})
+			}
+		}
+	}
+	slices.SortFunc(all, func(a, b KeyCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if len(all) > h.n {
+		all = all[:h.n]
+	}
+	return all
+}
+
+// HotKeys returns the keys fetched most often over the last window set with
+// WithHotKeyTracking, most fetched first, with their estimated fetch counts
+// and shards. It returns nil if tracking is disabled.
+func (c *Cache) HotKeys() []KeyCount {
+	if c.hot == nil {
+		return nil
+	}
+	keys := c.hot.top(c.now())
+	for i := range keys {
+		keys[i].Shard = c.shardIndex(keys[i].Key)
+	}
+	return keys
+}
@@ -0,0 +1,106 @@
+package hoard
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newHotKeyCache returns a cache counting every fetch, so counts are exact.
+func newHotKeyCache(t *testing.T, n int, window time.Duration, clock Clock) *Cache {
+	t.Helper()
+	cache := NewCache(4, 100, 0, WithHotKeyTracking(n, window), WithClock(clock))
+	cache.hot.rate = 1
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+// testing that HotKeys reports the most fetched keys in order
+func TestHotKeys(t *testing.T) {
+	cache := newHotKeyCache(t, 2, time.Minute, newFakeClock())
+	for i := 1; i <= 3; i++ {
+		key := "key" + strconv.Itoa(i)
+		cache.Store(key, i, time.Hour)
+		for j := 0; j < i*10; j++ {
+			cache.FetchBytes(key)
+		}
+	}
+	cache.FetchMany([]string{"key1", "missing"})
+
+	hot := cache.HotKeys()
+	if len(hot) != 2 {
+		t.Fatalf("Expected the top 2 keys, got %v", hot)
+	}
+	if hot[0].Key != "key3" || hot[0].Count != 30 || hot[1].Key != "key2" || hot[1].Count != 20 {
+		t.Fatalf("Expected key3 then key2, got %v", hot)
+	}
+	if hot[0].Shard != cache.shardIndex("key3") {
+		t.Fatalf("Expected the shard of key3, got %d", hot[0].Shard)
+	}
+
+	if keys := NewCache(1, 10, 0).HotKeys(); keys != nil {
+		t.Fatalf("Expected no report without tracking, got %v", keys)
+	}
+}
+
+// testing that counts fade out of the sliding window
+func TestHotKeysWindow(t *testing.T) {
+	clock := newFakeClock()
+	cache := newHotKeyCache(t, 5, time.Minute, clock)
+	for i := 0; i < 100; i++ {
+		cache.FetchBytes("old")
+	}
+
+	// Half way into the next window, half of the old counts remain
+	clock.Advance(90 * time.Second)
+	cache.FetchBytes("new")
+	hot := cache.HotKeys()
+	if len(hot) != 2 || hot[0].Key != "old" || hot[0].Count != 50 || hot[1].Count != 1 {
+		t.Fatalf("Expected old to be weighed by half, got %v", hot)
+	}
+
+	// Once that window is over the old fetches are out of range, while the
+	// new ones still count in full at the start of the next one
+	clock.Advance(30 * time.Second)
+	for i := 0; i < 3; i++ {
+		cache.FetchBytes("new")
+	}
+	if hot := cache.HotKeys(); len(hot) != 1 || hot[0].Key != "new" || hot[0].Count != 4 {
+		t.Fatalf("Expected the old key to be gone, got %v", hot)
+	}
+
+	// Two idle windows clear everything
+	clock.Advance(3 * time.Minute)
+	if hot := cache.HotKeys(); len(hot) != 0 {
+		t.Fatalf("Expected nothing after idle windows, got %v", hot)
+	}
+}
+
+// testing that a full stripe keeps counting the keys that matter
+func TestHotKeysBounded(t *testing.T) {
+	cache := newHotKeyCache(t, 1, 0, newFakeClock())
+	for i := 0; i < 10000; i++ {
+		cache.FetchBytes("cold" + strconv.Itoa(i))
+		if i%10 == 0 {
+			cache.FetchBytes("hot")
+		}
+	}
+	for i := range cache.hot.stripes {
+		if n := len(cache.hot.stripes[i].cur); n > cache.hot.capacity() {
+			t.Fatalf("Expected at most %d keys per stripe, got %d", cache.hot.capacity(), n)
+		}
+	}
+	if hot := cache.HotKeys(); len(hot) != 1 || hot[0].Key != "hot" || hot[0].Count < 1000 {
+		t.Fatalf("Expected hot to stay on top, got %v", hot)
+	}
+}
+
+// testing that invalid settings are rejected
+func TestHotKeysInvalid(t *testing.T) {
+	if _, err := NewCacheWithOptions(WithHotKeyTracking(0, time.Minute)); err == nil {
+		t.Fatal("Expected an error for a non-positive count")
+	}
+	if _, err := NewCacheWithOptions(WithHotKeyTracking(5, -time.Second)); err == nil {
+		t.Fatal("Expected an error for a negative window")
+	}
+}
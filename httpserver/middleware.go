@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+// CacheStatusHeader is the response header CacheMiddleware sets to HIT or
+// MISS.
+const CacheStatusHeader = "X-Hoard-Cache"
+
+// MaxCachedResponseSize is the largest response body CacheMiddleware stores.
+// Larger responses are served but not cached.
+const MaxCachedResponseSize = DefaultMaxBodySize
+
+// cachedResponse is a response stored by CacheMiddleware.
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// DefaultKey is the cache key CacheMiddleware uses when keyFn is nil: the
+// host and request URI. Pass it a request to Delete the cached response.
+func DefaultKey(r *http.Request) string {
+	return "http:" + r.Host + r.URL.RequestURI()
+}
+
+// CacheMiddleware caches the responses of the wrapped handler in c. A GET or
+// HEAD request whose key, computed by keyFn or DefaultKey, holds a response
+// is answered from the cache; otherwise the handler runs and a 200 response
+// to a GET is stored for ttl, unless it sets Cache-Control: no-store or its
+// body exceeds MaxCachedResponseSize. Other methods pass through. Every
+// cacheable request gets a CacheStatusHeader of HIT or MISS.
+func CacheMiddleware(c *hoard.Cache, ttl time.Duration, keyFn func(*http.Request) string) func(http.Handler) http.Handler {
+	if keyFn == nil {
+		keyFn = DefaultKey
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := keyFn(r)
+
+			var cached cachedResponse
+			if ok, err := c.FetchInto(key, &cached); ok && err == nil {
+				for k, v := range cached.Header {
+					w.Header()[k] = v
+				}
+				w.Header().Set(CacheStatusHeader, "HIT")
+				w.WriteHeader(cached.Status)
+				if r.Method == http.MethodGet {
+					w.Write(cached.Body)
+				}
+				return
+			}
+
+			w.Header().Set(CacheStatusHeader, "MISS")
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if r.Method != http.MethodGet || rec.status != http.StatusOK || rec.tooLarge || noStore(w.Header()) {
+				return
+			}
+			header := w.Header().Clone()
+			header.Del(CacheStatusHeader)
+			c.Store(key, cachedResponse{Status: rec.status, Header: header, Body: rec.body}, ttl)
+		})
+	}
+}
+
+// noStore reports whether a response forbids caching.
+func noStore(h http.Header) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recorder passes a response through while keeping a copy of its status
+// and, up to MaxCachedResponseSize, its body.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        []byte
+	tooLarge    bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	if !r.tooLarge {
+		if len(r.body)+len(p) > MaxCachedResponseSize {
+			r.tooLarge = true
+			r.body = nil
+		} else {
+			r.body = append(r.body, p...)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
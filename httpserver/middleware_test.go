@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+// slowHandler counts its calls and answers after a delay.
+type slowHandler struct {
+	calls  atomic.Int32
+	header http.Header
+	body   string
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls.Add(1)
+	time.Sleep(50 * time.Millisecond)
+	for k, v := range h.header {
+		w.Header()[k] = v
+	}
+	w.Write([]byte(h.body))
+}
+
+// serve runs one request through handler.
+func serve(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+// testing that a second request is served from the cache until the key is
+// deleted
+func TestCacheMiddleware(t *testing.T) {
+	cache := hoard.NewCache(4, 100, 0)
+	defer cache.Close()
+	slow := &slowHandler{header: http.Header{"Content-Type": {"text/plain"}}, body: "hello"}
+	handler := CacheMiddleware(cache, time.Minute, nil)(slow)
+
+	first := serve(handler, "GET", "/greeting?lang=en")
+	if first.Header().Get(CacheStatusHeader) != "MISS" || first.Body.String() != "hello" {
+		t.Fatalf("Expected a MISS with the body, got %q %q", first.Header().Get(CacheStatusHeader), first.Body)
+	}
+
+	start := time.Now()
+	second := serve(handler, "GET", "/greeting?lang=en")
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("Expected a cached response to skip the slow handler, took %v", elapsed)
+	}
+	if second.Header().Get(CacheStatusHeader) != "HIT" || second.Body.String() != "hello" ||
+		second.Header().Get("Content-Type") != "text/plain" || second.Code != http.StatusOK {
+		t.Fatalf("Expected a HIT with the cached response, got %d %v %q", second.Code, second.Header(), second.Body)
+	}
+	if head := serve(handler, "HEAD", "/greeting?lang=en"); head.Header().Get(CacheStatusHeader) != "HIT" || head.Body.Len() != 0 {
+		t.Fatal("Expected HEAD to be a HIT without a body")
+	}
+	if serve(handler, "GET", "/greeting?lang=fr").Header().Get(CacheStatusHeader) != "MISS" {
+		t.Fatal("Expected another query to be a MISS")
+	}
+
+	cache.Delete(DefaultKey(httptest.NewRequest("GET", "/greeting?lang=en", nil)))
+	if serve(handler, "GET", "/greeting?lang=en").Header().Get(CacheStatusHeader) != "MISS" {
+		t.Fatal("Expected a deleted key to be a MISS")
+	}
+	if n := slow.calls.Load(); n != 3 {
+		t.Fatalf("Expected 3 handler calls, got %d", n)
+	}
+}
+
+// testing the responses that are not cached
+func TestCacheMiddlewareSkips(t *testing.T) {
+	cache := hoard.NewCache(4, 100, 0)
+	defer cache.Close()
+	key := func(r *http.Request) string { return r.URL.Path }
+
+	tests := map[string]struct {
+		handler http.Handler
+		method  string
+	}{
+		"no-store":  {&slowHandler{header: http.Header{"Cache-Control": {"private, no-store"}}}, "GET"},
+		"too-large": {&slowHandler{body: strings.Repeat("x", MaxCachedResponseSize+1)}, "GET"},
+		"error": {http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}), "GET"},
+		"post": {&slowHandler{body: "created"}, "POST"},
+	}
+	for name, tt := range tests {
+		handler := CacheMiddleware(cache, time.Minute, key)(tt.handler)
+		serve(handler, tt.method, "/"+name)
+		if rec := serve(handler, tt.method, "/"+name); rec.Header().Get(CacheStatusHeader) == "HIT" {
+			t.Errorf("%s: expected the response not to be cached", name)
+		}
+		if cache.Exists("/" + name) {
+			t.Errorf("%s: expected nothing stored", name)
+		}
+	}
+}
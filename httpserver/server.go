@@ -0,0 +1,178 @@
+// Package httpserver exposes a hoard cache over HTTP, so processes that do
+// not link the cache can share it:
+//
+//	PUT    /cache/{key}  stores the request body, with an optional ttl
+//	GET    /cache/{key}  returns the stored bytes, 404 on a miss
+//	DELETE /cache/{key}  removes the key, 404 if it was not there
+//	GET    /stats        returns the cache's Stats as JSON
+//	POST   /flush        removes every entry, or those of one shard with ?shard=N
+//
+// Values are stored with StoreBytes and returned exactly as stored. The ttl
+// of a PUT is read from the X-Hoard-TTL header or the ttl query parameter in
+// time.ParseDuration syntax; without one the cache's default applies.
+//
+// CacheMiddleware goes the other way and caches the responses of an
+// http.Handler in a cache.
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+// TTLHeader is the request header carrying the ttl of a PUT.
+const TTLHeader = "X-Hoard-TTL"
+
+// DefaultMaxBodySize is the largest value a PUT accepts unless changed with
+// WithMaxBodySize.
+const DefaultMaxBodySize = 1 << 20
+
+// Handler serves a cache over HTTP.
+type Handler struct {
+	cache       *hoard.Cache
+	mux         *http.ServeMux
+	maxBodySize int64
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithMaxBodySize sets the largest value a PUT accepts, in bytes. Larger
+// bodies are rejected with 413 Request Entity Too Large.
+func WithMaxBodySize(n int64) Option {
+	return func(h *Handler) {
+		h.maxBodySize = n
+	}
+}
+
+// New returns a Handler serving cache.
+func New(cache *hoard.Cache, opts ...Option) *Handler {
+	h := &Handler{cache: cache, mux: http.NewServeMux(), maxBodySize: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("GET /cache/{key...}", h.get)
+	h.mux.HandleFunc("PUT /cache/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /cache/{key...}", h.delete)
+	h.mux.HandleFunc("GET /stats", h.stats)
+	h.mux.HandleFunc("POST /flush", h.flush)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	value, ok := h.cache.FetchBytes(r.PathValue("key"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	ttl, err := requestTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The client may have gone away while the body was read
+	if err := r.Context().Err(); err != nil {
+		return
+	}
+	if err := h.cache.StoreBytes(r.PathValue("key"), body, ttl); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	if !h.cache.Delete(r.PathValue("key")) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.cache.Stats())
+}
+
+func (h *Handler) flush(w http.ResponseWriter, r *http.Request) {
+	s := r.URL.Query().Get("shard")
+	if s == "" {
+		if err := h.cache.CleanupAll(); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	shard, err := strconv.Atoi(s)
+	if err == nil {
+		err = h.cache.CleanupShard(shard)
+	}
+	if errors.Is(err, hoard.ErrCacheClosed) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, "httpserver: invalid shard "+s, http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestTTL reads the ttl of a PUT, or hoard.DefaultExpiration if none is
+// given.
+func requestTTL(r *http.Request) (time.Duration, error) {
+	s := r.Header.Get(TTLHeader)
+	if s == "" {
+		s = r.URL.Query().Get("ttl")
+	}
+	if s == "" {
+		return hoard.DefaultExpiration, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("httpserver: invalid ttl " + s)
+	}
+	return ttl, nil
+}
+
+// writeError maps a cache error to a response.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, hoard.ErrCacheClosed):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, hoard.ErrCacheFull):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, hoard.ErrValueTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, hoard.ErrInvalidKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
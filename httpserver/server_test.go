@@ -0,0 +1,148 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+// fakeClock is a hoard.Clock that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// do sends a request to srv and returns the status and body.
+func do(t *testing.T, srv *httptest.Server, method, path, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// testing store, fetch, expire and delete over HTTP
+func TestServer(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	cache := hoard.NewCache(4, 100, 0, hoard.WithClock(clock))
+	defer cache.Close()
+	srv := httptest.NewServer(New(cache))
+	defer srv.Close()
+
+	if code, _ := do(t, srv, "PUT", "/cache/user/42", "kouhadi", http.Header{TTLHeader: {"1m"}}); code != http.StatusNoContent {
+		t.Fatalf("Expected PUT to succeed, got %d", code)
+	}
+	if code, body := do(t, srv, "GET", "/cache/user/42", "", nil); code != http.StatusOK || body != "kouhadi" {
+		t.Fatalf("Expected the stored body, got %d %q", code, body)
+	}
+	if data, _ := cache.FetchBytes("user/42"); string(data) != "kouhadi" {
+		t.Fatalf("Expected the raw bytes in the cache, got %q", data)
+	}
+
+	do(t, srv, "PUT", "/cache/short?ttl=1s", "value", nil)
+	clock.Advance(2 * time.Second)
+	if code, _ := do(t, srv, "GET", "/cache/short", "", nil); code != http.StatusNotFound {
+		t.Fatalf("Expected an expired key to be a 404, got %d", code)
+	}
+
+	if code, _ := do(t, srv, "DELETE", "/cache/user/42", "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected DELETE to succeed, got %d", code)
+	}
+	if code, _ := do(t, srv, "GET", "/cache/user/42", "", nil); code != http.StatusNotFound {
+		t.Fatalf("Expected a deleted key to be a 404, got %d", code)
+	}
+	if code, _ := do(t, srv, "DELETE", "/cache/user/42", "", nil); code != http.StatusNotFound {
+		t.Fatalf("Expected deleting a missing key to be a 404, got %d", code)
+	}
+
+	code, body := do(t, srv, "GET", "/stats", "", nil)
+	var stats hoard.Stats
+	if err := json.Unmarshal([]byte(body), &stats); code != http.StatusOK || err != nil {
+		t.Fatalf("Expected stats as JSON, got %d %q", code, body)
+	}
+	if stats.Stores != 2 || stats.Deletes != 1 {
+		t.Fatalf("Expected 2 stores and 1 delete, got %+v", stats)
+	}
+}
+
+// testing that oversized bodies and invalid ttls are rejected
+func TestServerRejects(t *testing.T) {
+	cache := hoard.NewCache(4, 100, 0)
+	defer cache.Close()
+	srv := httptest.NewServer(New(cache, WithMaxBodySize(8)))
+	defer srv.Close()
+
+	if code, _ := do(t, srv, "PUT", "/cache/big", strings.Repeat("x", 9), nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected an oversized body to be a 413, got %d", code)
+	}
+	if code, _ := do(t, srv, "PUT", "/cache/fits", strings.Repeat("x", 8), nil); code != http.StatusNoContent {
+		t.Fatalf("Expected a body at the limit to be stored, got %d", code)
+	}
+	if code, _ := do(t, srv, "PUT", "/cache/key?ttl=soon", "value", nil); code != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid ttl to be a 400, got %d", code)
+	}
+	if cache.Exists("big") || cache.Exists("key") {
+		t.Fatal("Expected rejected values not to be stored")
+	}
+
+	cache.Close()
+	if code, _ := do(t, srv, "PUT", "/cache/key", "value", nil); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a closed cache to be a 503, got %d", code)
+	}
+}
+
+// testing that the flush endpoint clears the cache or a single shard
+func TestServerFlush(t *testing.T) {
+	cache := hoard.NewCache(4, 100, 0)
+	defer cache.Close()
+	srv := httptest.NewServer(New(cache))
+	defer srv.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Store(key, key, hoard.NoExpiration)
+	}
+	shard := cache.ShardForKey("a")
+	before := cache.ShardLens()[shard]
+	if code, _ := do(t, srv, "POST", "/flush?shard="+strconv.Itoa(shard), "", nil); code != http.StatusNoContent {
+		t.Fatalf("Expected a 204, got %d", code)
+	}
+	if cache.Exists("a") || cache.Len() != 5-before {
+		t.Fatal("Expected only the shard of a to be flushed")
+	}
+	if code, _ := do(t, srv, "POST", "/flush?shard=9", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid shard to be a 400, got %d", code)
+	}
+	if code, _ := do(t, srv, "POST", "/flush", "", nil); code != http.StatusNoContent || cache.Len() != 0 {
+		t.Fatalf("Expected the cache to be flushed, got %d with %d entries", code, cache.Len())
+	}
+}
@@ -0,0 +1,217 @@
+package hoard
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CheckIntegrity verifies the internal bookkeeping of every shard and
+// returns the first inconsistency found, naming the shard and the key
+// involved, or nil. It checks that the map of entries, the eviction policy's
+// list or heap, the expiry heap, the tag index and the scan index hold the
+// same entries and are linked correctly, and that the byte and cost totals
+// add up.
+//
+// It is meant for tests and debugging. Each shard is walked under its read
+// lock in turn, so it is safe to call on a live cache, but it visits every
+// entry and blocks writers to the shard being checked meanwhile.
+func (c *Cache) CheckIntegrity() error {
+	for i, shard := range c.shards {
+		if err := shard.checkIntegrity(); err != nil {
+			return fmt.Errorf("hoard: shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkIntegrity verifies the bookkeeping of the shard.
+func (s *CacheShard) checkIntegrity() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var bytes, cost int64
+	var pinned, deadlines int
+	for key, item := range s.data {
+		if item.key != key {
+			return fmt.Errorf("key %q: item is stored under key %q", key, item.key)
+		}
+		bytes += itemSize(key, s.value(item))
+		cost += item.cost
+		if item.pinned {
+			pinned++
+		}
+		if item.Expiration != 0 {
+			deadlines++
+			if item.expIndex < 0 || item.expIndex >= len(s.expiry) || s.expiry[item.expIndex] != item {
+				return fmt.Errorf("key %q: not at its index %d in the expiry heap", key, item.expIndex)
+			}
+		}
+		for _, tag := range item.tags {
+			if _, ok := s.tags[tag][key]; !ok {
+				return fmt.Errorf("key %q: missing from the index of tag %q", key, tag)
+			}
+		}
+	}
+	if bytes != s.bytes {
+		return fmt.Errorf("entries hold %d bytes, shard counts %d", bytes, s.bytes)
+	}
+	if cost != s.cost {
+		return fmt.Errorf("entries cost %d, shard counts %d", cost, s.cost)
+	}
+	if s.items != nil && s.size.Load() != int64(len(s.data)) {
+		return fmt.Errorf("%d entries, shard size says %d", len(s.data), s.size.Load())
+	}
+
+	// Check the links first, walking a broken list may never end
+	if err := checkPolicy(s.policy); err != nil {
+		return err
+	}
+	// Every unpinned entry is in the policy exactly once
+	var linked int
+	var err error
+	seen := make(map[*CacheItem]struct{}, len(s.data))
+	s.policy.walk(func(item *CacheItem) {
+		linked++
+		if err != nil {
+			return
+		}
+		if _, ok := seen[item]; ok {
+			err = fmt.Errorf("key %q: linked twice in the eviction policy", item.key)
+		} else if s.data[item.key] != item {
+			err = fmt.Errorf("key %q: in the eviction policy but not stored", item.key)
+		} else if item.pinned {
+			err = fmt.Errorf("key %q: pinned but in the eviction policy", item.key)
+		}
+		seen[item] = struct{}{}
+	})
+	if err != nil {
+		return err
+	}
+	if want := len(s.data) - pinned; linked != want {
+		return fmt.Errorf("%d unpinned entries, eviction policy holds %d", want, linked)
+	}
+
+	if len(s.expiry) != deadlines {
+		return fmt.Errorf("%d entries with a deadline, expiry heap holds %d", deadlines, len(s.expiry))
+	}
+	for i, item := range s.expiry {
+		if s.data[item.key] != item {
+			return fmt.Errorf("key %q: in the expiry heap but not stored", item.key)
+		}
+		if parent := (i - 1) / 2; i > 0 && s.expiry[parent].Expiration > item.Expiration {
+			return fmt.Errorf("key %q: expiry heap out of order", item.key)
+		}
+	}
+
+	for tag, keys := range s.tags {
+		if len(keys) == 0 {
+			return fmt.Errorf("tag %q: empty index left behind", tag)
+		}
+		for key := range keys {
+			item, ok := s.data[key]
+			if !ok {
+				return fmt.Errorf("key %q: in the index of tag %q but not stored", key, tag)
+			}
+			if !hasTag(item.tags, tag) {
+				return fmt.Errorf("key %q: in the index of tag %q it does not carry", key, tag)
+			}
+		}
+	}
+
+	if x := s.scanIdx; x != nil {
+		if x.n != len(s.data) {
+			return fmt.Errorf("%d entries, scan index holds %d", len(s.data), x.n)
+		}
+		for b, bucket := range x.buckets {
+			for _, e := range bucket {
+				if _, ok := s.data[e.key]; !ok {
+					return fmt.Errorf("key %q: in the scan index but not stored", e.key)
+				}
+				if e.pos != fnv32a(e.key) || x.bucket(e.pos) != b {
+					return fmt.Errorf("key %q: in the wrong scan bucket %d", e.key, b)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasTag reports whether tags contains tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicy verifies the links of the lists or the order of the heap
+// behind p.
+func checkPolicy(p evictionPolicy) error {
+	switch p := p.(type) {
+	case *lruPolicy:
+		return p.list.check()
+	case *fifoPolicy:
+		return p.list.check()
+	case *slruPolicy:
+		if err := p.probation.check(); err != nil {
+			return fmt.Errorf("probationary list: %w", err)
+		}
+		if err := p.protected.check(); err != nil {
+			return fmt.Errorf("protected list: %w", err)
+		}
+		for item := p.probation.front; item != nil; item = item.next {
+			if item.protected {
+				return fmt.Errorf("key %q: marked protected in the probationary list", item.key)
+			}
+		}
+		for item := p.protected.front; item != nil; item = item.next {
+			if !item.protected {
+				return fmt.Errorf("key %q: not marked protected in the protected list", item.key)
+			}
+		}
+		if p.protected.len > p.protectedCap {
+			return fmt.Errorf("protected list holds %d entries, more than its %d", p.protected.len, p.protectedCap)
+		}
+	case *lfuPolicy:
+		for i, item := range p.items {
+			if item.heapIndex != i {
+				return fmt.Errorf("key %q: at %d in the LFU heap, records %d", item.key, i, item.heapIndex)
+			}
+			if parent := (i - 1) / 2; i > 0 && p.items.Less(i, parent) {
+				return fmt.Errorf("key %q: LFU heap out of order", item.key)
+			}
+		}
+	case *sampledLRUPolicy:
+		for i, item := range p.items {
+			if item.heapIndex != i {
+				return fmt.Errorf("key %q: at %d in the sample set, records %d", item.key, i, item.heapIndex)
+			}
+		}
+	}
+	return nil
+}
+
+// check verifies that the links of l agree in both directions and that its
+// length is right.
+func (l *itemList) check() error {
+	n := 0
+	var prev *CacheItem
+	for item := l.front; item != nil; item = item.next {
+		if item.prev != prev {
+			return fmt.Errorf("key %q: broken back link", item.key)
+		}
+		if n++; n > l.len {
+			return errors.New("list is longer than its length, or has a cycle")
+		}
+		prev = item
+	}
+	if prev != l.back {
+		return errors.New("last item is not the back of the list")
+	}
+	if n != l.len {
+		return fmt.Errorf("list holds %d items, length says %d", n, l.len)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package hoard
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checkIntegrity fails the test if the bookkeeping of c is inconsistent.
+func checkIntegrity(t *testing.T, c *Cache) {
+	t.Helper()
+	if err := c.CheckIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testing that a concurrent mix of writes, evictions and cleanups leaves the
+// bookkeeping consistent under every policy
+func TestCheckIntegrityWorkload(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, FIFO, SLRU, ApproxLRU} {
+		t.Run(policy.String(), func(t *testing.T) {
+			clock := newFakeClock()
+			cache := NewCache(4, 50, time.Millisecond, WithEvictionPolicy(policy), WithClock(clock), WithMaxBytes(4000))
+			defer cache.Close()
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					r := rand.New(rand.NewPCG(uint64(w), 0))
+					for i := 0; i < 2000; i++ {
+						key := "key" + strconv.Itoa(r.IntN(300))
+						switch r.IntN(8) {
+						case 0:
+							cache.StoreWithOptions(key, strings.Repeat("x", r.IntN(100)), ItemOptions{
+								TTL:    time.Duration(r.IntN(3)) * time.Second,
+								Tags:   []string{"tag" + strconv.Itoa(r.IntN(3))},
+								Pinned: r.IntN(10) == 0,
+							})
+						case 1:
+							cache.Delete(key)
+						case 2:
+							cache.Touch(key, time.Duration(r.IntN(3)-1)*time.Second)
+						case 3:
+							cache.InvalidateTag("tag" + strconv.Itoa(r.IntN(3)))
+						case 4:
+							cache.Rename(key, "key"+strconv.Itoa(r.IntN(300)))
+						case 5:
+							clock.Advance(100 * time.Millisecond)
+						default:
+							cache.FetchData(key)
+							cache.Store(key, i, NoExpiration)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			cache.RunCleanup()
+			checkIntegrity(t, cache)
+		})
+	}
+}
+
+// testing that broken bookkeeping is reported with its shard and key
+func TestCheckIntegrityDetects(t *testing.T) {
+	newCache := func() *Cache {
+		cache := NewCache(2, 10, time.Minute, WithShardingFunc(skewed))
+		cache.StoreTagged("hot1", "a", time.Minute, "t")
+		cache.Store("hot2", "b", NoExpiration)
+		cache.Store("hot3", "c", NoExpiration)
+		return cache
+	}
+	cases := map[string]struct {
+		corrupt func(s *CacheShard)
+		want    string
+	}{
+		"bytes": {
+			func(s *CacheShard) { s.bytes++ },
+			"shard 0: entries hold",
+		},
+		"list": {
+			func(s *CacheShard) { s.data["hot2"].prev = nil },
+			`shard 0: key "hot2": broken back link`,
+		},
+		"unlinked": {
+			func(s *CacheShard) { s.policy.remove(s.data["hot3"]) },
+			"shard 0: 3 unpinned entries, eviction policy holds 2",
+		},
+		"expiry": {
+			func(s *CacheShard) { s.data["hot1"].expIndex = 5 },
+			`shard 0: key "hot1": not at its index 5 in the expiry heap`,
+		},
+		"tags": {
+			func(s *CacheShard) { s.tags["t"]["hot2"] = struct{}{} },
+			`shard 0: key "hot2": in the index of tag "t" it does not carry`,
+		},
+		"map": {
+			func(s *CacheShard) { delete(s.data, "hot3") },
+			"shard 0:",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache()
+			defer cache.Close()
+			checkIntegrity(t, cache)
+			tc.corrupt(cache.shards[0])
+			err := cache.CheckIntegrity()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
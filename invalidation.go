@@ -0,0 +1,169 @@
+package hoard
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// InvalidationEvent announces that Key changed on the cache instance Origin,
+// so other instances should drop their copy. An empty Key, which no entry
+// can have, announces that every key was removed by CleanupAll.
+type InvalidationEvent struct {
+	Origin string
+	Key    string
+}
+
+// InvalidationBus carries invalidation events between cache instances,
+// typically one per process. Publish sends an event to every subscriber,
+// including the publisher's own, which ignore events of their own origin.
+// Subscribe registers fn for every event published from now on, until the
+// returned func is called; fn must be safe for concurrent use. A cache
+// subscribes once and unsubscribes when it is closed.
+type InvalidationBus interface {
+	Publish(event InvalidationEvent) error
+	Subscribe(fn func(event InvalidationEvent)) (unsubscribe func())
+}
+
+// InvalidateOn selects which local writes publish an invalidation event.
+// Expirations and evictions are never published, since every instance
+// applies them on its own.
+type InvalidateOn int
+
+const (
+	// InvalidateOnDelete publishes keys removed by any delete, from Delete
+	// and Pop to InvalidateTag, CompareAndDelete and CleanupShard. CleanupAll
+	// publishes a single event for every key.
+	InvalidateOnDelete InvalidateOn = 1 << iota
+	// InvalidateOnUpdate publishes keys changed in place by any update, from
+	// Update and UpdateValue to CompareAndSwap and Increment of an existing
+	// counter.
+	InvalidateOnUpdate
+	// InvalidateOnStore publishes keys written by any store, from Store and
+	// StoreMany to SetIfAbsent, Copy, Rename, Merge and Txn.
+	InvalidateOnStore
+)
+
+// invalidationQueueSize bounds the events waiting to be published. Further
+// events are dropped and reported to the error handler.
+const invalidationQueueSize = 1024
+
+// newInstanceID returns a random id for the origin of published events.
+func newInstanceID() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// startInvalidation subscribes c to its bus and starts the goroutine that
+// publishes queued events and unsubscribes once c is closed.
+func (c *Cache) startInvalidation() {
+	c.unsubscribe = c.bus.Subscribe(func(ev InvalidationEvent) {
+		if ev.Origin != c.id && !c.isClosed() {
+			c.invalidateLocal(ev.Key)
+		}
+	})
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case key := <-c.invalidations:
+				c.publishNow(key)
+			case <-c.done:
+				// Publish what is already queued
+				for {
+					select {
+					case key := <-c.invalidations:
+						c.publishNow(key)
+					default:
+						if c.unsubscribe != nil {
+							c.unsubscribe()
+						}
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// publish queues an invalidation of key if op is one of the configured
+// writes. It never blocks.
+func (c *Cache) publish(op InvalidateOn, key string) {
+	if c.bus == nil || c.invalidateOn&op == 0 {
+		return
+	}
+	select {
+	case c.invalidations <- key:
+	default:
+		c.reportError(fmt.Errorf("hoard: invalidation queue full, dropped %q", key))
+	}
+}
+
+func (c *Cache) publishNow(key string) {
+	if err := c.bus.Publish(InvalidationEvent{Origin: c.id, Key: key}); err != nil {
+		c.reportError(fmt.Errorf("hoard: publish invalidation of %q: %w", key, err))
+	}
+}
+
+// invalidateLocal deletes key, or every key if it is empty, on behalf of
+// another instance. It does not publish, nor delete from the backend, which
+// the origin already did.
+func (c *Cache) invalidateLocal(key string) {
+	if key == "" {
+		c.logWrite(walClear, "", nil, 0, 0)
+		for _, shard := range c.shards {
+			shard.mu.Lock()
+			c.clearShardLocked(shard, func(string) { shard.stats.invalidations.Add(1) })
+			shard.mu.Unlock()
+		}
+		return
+	}
+	shard := c.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if c.deleteLocked(shard, key, c.now()) {
+		shard.stats.invalidations.Add(1)
+	}
+	c.logWrite(walDelete, key, nil, 0, 0)
+}
+
+// LocalBus is an in-process InvalidationBus, for caches sharing a process
+// and for tests. Publish delivers events synchronously to every subscriber.
+type LocalBus struct {
+	mu   sync.RWMutex
+	subs []*localSub
+}
+
+// localSub is a subscription to a LocalBus.
+type localSub struct {
+	fn func(InvalidationEvent)
+}
+
+// NewLocalBus returns an empty LocalBus.
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+func (b *LocalBus) Publish(ev InvalidationEvent) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		s.fn(ev)
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(fn func(InvalidationEvent)) func() {
+	s := &localSub{fn: fn}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		b.subs = slices.DeleteFunc(b.subs, func(sub *localSub) bool { return sub == s })
+		b.mu.Unlock()
+	}
+}
@@ -0,0 +1,156 @@
+package hoard
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// testing that a delete on one cache removes the key from its peer
+func TestInvalidationDelete(t *testing.T) {
+	bus := NewLocalBus()
+	a := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnDelete))
+	defer a.Close()
+	b := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnDelete))
+	defer b.Close()
+
+	a.Store("key", "a", time.Minute)
+	b.Store("key", "b", time.Minute)
+
+	a.Delete("key")
+	waitFor(t, func() bool { return !b.Exists("key") })
+	if n := b.Stats().Invalidations; n != 1 {
+		t.Fatalf("Expected 1 invalidation, got %d", n)
+	}
+
+	// Stores are not published, so the peers diverge again
+	a.Store("key", "a", time.Minute)
+	b.Store("key", "b", time.Minute)
+	b.DeleteMany([]string{"key"})
+	waitFor(t, func() bool { return !a.Exists("key") })
+}
+
+// testing that stores and updates are published when configured
+func TestInvalidationStoreAndUpdate(t *testing.T) {
+	bus := NewLocalBus()
+	a := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnUpdate))
+	defer a.Close()
+	b := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnStore))
+	defer b.Close()
+
+	a.Store("key", "a", time.Minute)
+	b.Store("key", "b", time.Minute)
+	waitFor(t, func() bool { return !a.Exists("key") })
+
+	// a does not publish stores, so b keeps its copy until the update
+	a.Store("key", "a", time.Minute)
+	if err := a.Update("key", "new", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !b.Exists("key") })
+	if n := b.Stats().Invalidations; n != 1 {
+		t.Fatalf("Expected 1 invalidation, got %d", n)
+	}
+}
+
+// testing that a cache ignores its own events
+func TestInvalidationNoEcho(t *testing.T) {
+	bus := NewLocalBus()
+	a := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnStore))
+	a.Store("key", "value", time.Minute)
+	a.Close() // publishes what is queued before returning
+
+	if n := a.Stats().Invalidations; n != 0 {
+		t.Fatalf("Expected no invalidation of the publisher, got %d", n)
+	}
+}
+
+// testing that clearing a shard or the whole cache clears the peers too
+func TestInvalidationCleanup(t *testing.T) {
+	bus := NewLocalBus()
+	a := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnDelete))
+	defer a.Close()
+	b := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnDelete))
+	defer b.Close()
+
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		a.Store(key, i, time.Minute)
+		b.Store(key, i, time.Minute)
+	}
+	shard := a.ShardForKey("key0")
+	if err := a.CleanupShard(shard); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !b.Exists("key0") })
+	if n := b.ShardLens()[shard]; n != 0 {
+		t.Fatalf("Expected the peer's shard to be cleared, got %d entries", n)
+	}
+	if b.Len() == 0 {
+		t.Fatal("Expected the peer's other shards to be kept")
+	}
+
+	a.CleanupAll()
+	waitFor(t, func() bool { return b.Len() == 0 })
+	if n := b.Stats().Invalidations; n != 20 {
+		t.Fatalf("Expected 20 invalidations, got %d", n)
+	}
+}
+
+// testing that closing a cache unsubscribes it from the bus
+func TestInvalidationUnsubscribe(t *testing.T) {
+	bus := NewLocalBus()
+	a := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnDelete))
+	b := NewCache(4, 100, 0, WithInvalidationBus(bus, InvalidateOnDelete))
+	defer b.Close()
+	if n := len(bus.subs); n != 2 {
+		t.Fatalf("Expected 2 subscriptions, got %d", n)
+	}
+	a.Close()
+	a.Close()
+	if n := len(bus.subs); n != 1 {
+		t.Fatalf("Expected the closed cache to unsubscribe, got %d subscriptions", n)
+	}
+	b.Delete("key")
+	b.Close()
+	if n := len(bus.subs); n != 0 {
+		t.Fatalf("Expected no subscriptions, got %d", n)
+	}
+}
+
+type failingBus struct{ LocalBus }
+
+func (*failingBus) Publish(InvalidationEvent) error { return errors.New("unreachable") }
+
+// testing that failed publishes are reported
+func TestInvalidationPublishError(t *testing.T) {
+	errs := make(chan error, 1)
+	cache := NewCache(1, 10, 0,
+		WithInvalidationBus(&failingBus{}, InvalidateOnDelete),
+		WithErrorHandler(func(err error) { errs <- err }),
+	)
+	cache.Delete("key")
+	cache.Close()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("Expected a publish error")
+		}
+	default:
+		t.Fatal("Expected the failed publish to be reported")
+	}
+}
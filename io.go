@@ -0,0 +1,185 @@
+package hoard
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ioKind is what an ioOp does.
+type ioKind uint8
+
+const (
+	ioSave       ioKind = iota // save value to the backend
+	ioDelete                   // delete key from the backend
+	ioTierPut                  // put entry into the tier
+	ioTierDelete               // delete key from the tier
+)
+
+// ioOp is a backend or tier call queued by a write while it holds a shard
+// lock, and made by the writer once it has released the lock, so slow I/O
+// never blocks other keys of the shard. The op's turn keeps the calls for a
+// key in the order the cache applied the writes.
+type ioOp struct {
+	c     *Cache
+	shard *CacheShard
+	kind  ioKind
+	key   string
+	value []byte          // for ioSave
+	entry TierEntry       // for ioTierPut
+	ctx   context.Context // for ioSave, nil for none
+	turn  *keyTurn
+}
+
+// queueIO queues op, if not nil, to run in turn once shard.mu is released.
+// The caller must hold shard.mu for writing.
+func (c *Cache) queueIO(shard *CacheShard, op *ioOp) {
+	if op == nil {
+		return
+	}
+	op.c, op.shard = c, shard
+	op.turn = c.turns.take(op.key)
+	shard.mu.ops = append(shard.mu.ops, *op)
+}
+
+// runIO makes the calls of ops in order. A save that fails leaves the cache
+// holding a value the backend never got, so its key is dropped from the
+// cache, once all the ops are done, and the error handed to failed, or to the
+// error handler if failed is nil. Other failures go to the error handler.
+func runIO(ops []ioOp, failed func(key string, err error)) {
+	type unsaved struct {
+		op  ioOp
+		err error
+	}
+	var lost []unsaved
+	for _, op := range ops {
+		if err := op.c.doIO(op); err != nil {
+			lost = append(lost, unsaved{op, err})
+		}
+	}
+	// Drop only once every turn is over, turn holders take no locks
+	for _, u := range lost {
+		u.op.c.dropUnsaved(u.op.shard, u.op.key)
+		if failed != nil {
+			failed(u.op.key, u.err)
+		} else {
+			u.op.c.reportError(u.err)
+		}
+	}
+}
+
+// doIO waits for the turn of op and makes its call. It only returns the
+// errors of saves.
+func (c *Cache) doIO(op ioOp) error {
+	ctx := op.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := op.turn.wait(ctx); err != nil {
+		// Pass the turn on only once the previous one is over
+		go func() {
+			op.turn.wait(context.Background())
+			c.turns.done(op.turn)
+		}()
+		return fmt.Errorf("%w: save %q: %w", ErrBackend, op.key, err)
+	}
+	defer c.turns.done(op.turn)
+
+	switch op.kind {
+	case ioSave:
+		return c.save(ctx, op.key, op.value)
+	case ioDelete:
+		c.deleteThrough(op.key)
+	case ioTierPut:
+		if err := c.tier.Put(op.key, op.entry); err != nil {
+			c.reportError(fmt.Errorf("hoard: tier put %q: %w", op.key, err))
+		}
+	case ioTierDelete:
+		if err := c.tier.Delete(op.key); err != nil {
+			c.reportError(fmt.Errorf("hoard: tier delete %q: %w", op.key, err))
+		}
+	}
+	return nil
+}
+
+// dropUnsaved removes key, whose backend save failed, from the cache like an
+// expiry: the backend is not told, since it never got the value.
+func (c *Cache) dropUnsaved(shard *CacheShard, key string) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if c.deleteLocked(shard, key, c.now()) {
+		c.logWrite(walDelete, key, nil, 0, 0)
+		c.publish(InvalidateOnDelete, key)
+	}
+}
+
+// keyTurns orders the I/O queued for each key. A turn is taken under the
+// shard lock of its key and waits for the turns taken before it, so the
+// backend and the tier see the writes to a key in the order the cache
+// applied them even though the calls are made after the lock is released.
+//
+// Turns never deadlock: a holder waits for nothing but earlier turns, and
+// takes no lock until its turn is over.
+type keyTurns struct {
+	mu   sync.Mutex
+	last map[string]*keyTurn
+}
+
+// keyTurn is a place in the I/O order of a key.
+type keyTurn struct {
+	key  string
+	prev chan struct{} // closed when the previous turn is over, nil if none
+	over chan struct{}
+}
+
+// take returns the next turn for key.
+func (k *keyTurns) take(key string) *keyTurn {
+	t := &keyTurn{key: key, over: make(chan struct{})}
+	k.mu.Lock()
+	if k.last == nil {
+		k.last = make(map[string]*keyTurn)
+	}
+	if prev, ok := k.last[key]; ok {
+		t.prev = prev.over
+	}
+	k.last[key] = t
+	k.mu.Unlock()
+	return t
+}
+
+// wait blocks until the previous turn is over or ctx is done.
+func (t *keyTurn) wait(ctx context.Context) error {
+	if t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pass ends t, letting the next turn for its key go ahead.
+func (k *keyTurns) pass(t *keyTurn) {
+	close(t.over)
+}
+
+// forget stops tracking the ended turn t and reports whether it was the last
+// turn taken for its key, that is whether no I/O was queued for the key
+// since t was taken.
+func (k *keyTurns) forget(t *keyTurn) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.last[t.key] != t {
+		return false
+	}
+	delete(k.last, t.key)
+	return true
+}
+
+// done ends t and stops tracking it.
+func (k *keyTurns) done(t *keyTurn) {
+	k.pass(t)
+	k.forget(t)
+}
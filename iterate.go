@@ -0,0 +1,197 @@
+package hoard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// Iterate calls fn with every live entry and a copy of its stored bytes,
+// which fn may keep and modify. Shards are visited concurrently by up to the
+// number of workers set with WithIterateWorkers, so fn must be safe for
+// concurrent use; IterateSeq visits them on the caller's goroutine instead.
+// No lock is held while fn runs, so it may read and write the cache; entries
+// written during the scan may or may not be visited.
+//
+// If fn panics, the scan stops, the other workers finish the calls they
+// are making and the panic is raised again on the caller's goroutine, where
+// it can be recovered; the same holds for every Iterate variant.
+func (c *Cache) Iterate(fn func(key string, value []byte)) {
+	c.iterate(context.Background(), func(key string, v itemView) error {
+		fn(key, bytes.Clone(v.value))
+		return nil
+	})
+}
+
+// IterateUnsafe is like Iterate but hands fn the cache's own bytes instead
+// of a copy, saving an allocation per entry. The cache never modifies stored
+// bytes in place, so fn may keep the slice, but it must not modify it.
+func (c *Cache) IterateUnsafe(fn func(key string, value []byte)) {
+	c.iterate(context.Background(), func(key string, v itemView) error {
+		fn(key, v.value)
+		return nil
+	})
+}
+
+// errStopIteration is returned by IterateUntil's callback wrapper to stop.
+var errStopIteration = errors.New("hoard: iteration stopped")
+
+// IterateUntil is like Iterate but stops as soon as fn returns false. Shards
+// are still visited concurrently: calls already running on other shards
+// finish, but no new ones start.
+func (c *Cache) IterateUntil(fn func(key string, value []byte) bool) {
+	c.iterate(context.Background(), func(key string, v itemView) error {
+		if !fn(key, bytes.Clone(v.value)) {
+			return errStopIteration
+		}
+		return nil
+	})
+}
+
+// IterateCtx is like Iterate but stops when fn returns an error or ctx is
+// done, and returns that error or the context's. ctx is checked before each
+// shard is locked and before each call, so a canceled scan stops within one
+// entry; like IterateUntil, calls already running on other shards finish
+// first.
+func (c *Cache) IterateCtx(ctx context.Context, fn func(key string, value []byte) error) error {
+	return c.iterate(ctx, func(key string, v itemView) error {
+		return fn(key, bytes.Clone(v.value))
+	})
+}
+
+// IterateValues is like IterateUntil but hands fn each value decoded as
+// FetchData would, outside the shard lock. Entries that fail to decode are
+// skipped and reported to the handler set with WithErrorHandler; negative
+// entries are skipped silently.
+func (c *Cache) IterateValues(fn func(key string, value interface{}) bool) {
+	c.IterateWhere(nil, fn)
+}
+
+// IterateWhere is like IterateValues but only decodes and visits the entries
+// whose key passes filter, so skipped entries cost no decoding. A nil filter
+// visits every entry.
+func (c *Cache) IterateWhere(filter func(key string) bool, fn func(key string, value interface{}) bool) {
+	c.iterate(context.Background(), func(key string, v itemView) error {
+		if filter != nil && !filter(key) {
+			return nil
+		}
+		if v.flags&itemNegative != 0 {
+			return nil
+		}
+		value, err := c.decode(v)
+		if err != nil {
+			c.reportError(fmt.Errorf("hoard: iterate %q: %w", key, err))
+			return nil
+		}
+		if !fn(key, value) {
+			return errStopIteration
+		}
+		return nil
+	})
+}
+
+// IterateSeq returns an iterator over every live entry and a copy of its
+// stored bytes. Shards are visited one at a time on the caller's goroutine,
+// so the loop body needs no synchronization, and only one shard's entries
+// are buffered at a time. No lock is held while the loop body runs, so it
+// may modify the cache.
+func (c *Cache) IterateSeq() iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		var buf []shardEntry
+		for _, shard := range c.shards {
+			buf = shard.appendEntries(buf[:0], c.now())
+			for _, e := range buf {
+				v, ok := c.unpackFound(e.view)
+				if !ok {
+					continue
+				}
+				if !yield(e.key, bytes.Clone(v.value)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// iteratePanic carries a panic out of an iterate worker.
+type iteratePanic struct {
+	value interface{}
+}
+
+// iterate calls fn with every live entry until fn fails or ctx is done.
+// Workers take shards one at a time until all are visited. A panic in fn
+// stops the workers and is raised again once they have all returned.
+func (c *Cache) iterate(ctx context.Context, fn func(key string, v itemView) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	now := c.now()
+	workers := c.iterateWorkers
+	if workers > len(c.shards) {
+		workers = len(c.shards)
+	}
+
+	var next atomic.Int64
+	var panicked atomic.Pointer[iteratePanic]
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicked.CompareAndSwap(nil, &iteratePanic{r})
+					cancel(errStopIteration)
+				}
+			}()
+			var buf []shardEntry
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= len(c.shards) {
+					return
+				}
+				buf = c.shards[i].appendEntries(buf[:0], now)
+				for _, e := range buf {
+					if ctx.Err() != nil {
+						return
+					}
+					v, ok := c.unpackFound(e.view)
+					if !ok {
+						continue
+					}
+					if err := fn(e.key, v); err != nil {
+						cancel(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if p := panicked.Load(); p != nil {
+		panic(p.value)
+	}
+	return context.Cause(ctx)
+}
+
+// shardEntry is a live entry copied out of a shard.
+type shardEntry struct {
+	key  string
+	view itemView
+}
+
+// appendEntries appends the live entries of the shard to dst. The shard is
+// only read-locked while they are copied.
+func (s *CacheShard) appendEntries(dst []shardEntry, now int64) []shardEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, item := range s.data {
+		if !item.expired(now) {
+			dst = append(dst, shardEntry{key: key, view: s.sharedView(item)})
+		}
+	}
+	return dst
+}
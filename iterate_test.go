@@ -0,0 +1,334 @@
+package hoard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing that the callback may write to the cache without deadlocking
+func TestIterateMutatesCache(t *testing.T) {
+	cache := NewCache(1, 1000, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), []byte("value"), time.Minute)
+	}
+
+	done := make(chan int)
+	go func() {
+		visited := 0
+		cache.Iterate(func(key string, value []byte) {
+			visited++
+			cache.Delete(key)
+			cache.StoreBytes("new:"+key, value, time.Minute)
+		})
+		done <- visited
+	}()
+
+	select {
+	case visited := <-done:
+		if visited != 100 {
+			t.Fatalf("Expected 100 entries to be visited, got %d", visited)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Iterate not to deadlock when the callback writes")
+	}
+	if keys := cache.KeysWithPrefix("new:"); len(keys) != 100 || cache.Len() != 100 {
+		t.Fatalf("Expected every entry to be moved, got %d of %d", len(keys), cache.Len())
+	}
+}
+
+// testing that Iterate hands out copies and IterateUnsafe the stored bytes
+func TestIterateCopies(t *testing.T) {
+	cache := NewCache(2, 100, time.Minute)
+	defer cache.Close()
+	cache.StoreBytes("key", []byte("value"), time.Minute)
+
+	var kept []byte
+	cache.Iterate(func(_ string, value []byte) {
+		kept = value
+		copy(value, "XXXXX")
+	})
+	if data, _ := cache.FetchBytesData("key"); string(data) != "value" {
+		t.Fatalf("Expected the stored bytes to be untouched, got %s", data)
+	}
+
+	// An update installs new bytes, so a kept slice keeps its contents
+	cache.StoreBytes("key", []byte("other"), time.Minute)
+	if string(kept) != "XXXXX" {
+		t.Fatalf("Expected the kept copy to stay as written, got %s", kept)
+	}
+
+	var mu sync.Mutex
+	var unsafe []byte
+	cache.IterateUnsafe(func(_ string, value []byte) {
+		mu.Lock()
+		unsafe = value
+		mu.Unlock()
+	})
+	if data, _ := cache.FetchBytesData("key"); !bytes.Equal(unsafe, data) || &unsafe[0] != &data[0] {
+		t.Fatal("Expected IterateUnsafe to hand out the stored bytes")
+	}
+}
+
+// testing that IterateUntil stops every shard once the callback returns false
+func TestIterateUntil(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+
+	var mu sync.Mutex
+	visited := 0
+	cache.IterateUntil(func(key string, _ []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		visited++
+		return false
+	})
+	// Each shard may have started one call before the stop was seen
+	if visited < 1 || visited > 4 {
+		t.Fatalf("Expected iteration to stop promptly, visited %d entries", visited)
+	}
+}
+
+// testing that IterateCtx surfaces the callback's error and cancellation
+func TestIterateCtx(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+
+	errFound := errors.New("found")
+	err := cache.IterateCtx(context.Background(), func(key string, _ []byte) error {
+		if key == "key42" {
+			return errFound
+		}
+		return nil
+	})
+	if !errors.Is(err, errFound) {
+		t.Fatalf("Expected the callback's error, got %v", err)
+	}
+
+	if err := cache.IterateCtx(context.Background(), func(string, []byte) error { return nil }); err != nil {
+		t.Fatalf("Expected a full scan to succeed, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err = cache.IterateCtx(ctx, func(string, []byte) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("Expected a canceled scan to visit nothing, got %v (called %v)", err, called)
+	}
+}
+
+// testing that IterateSeq visits every entry on the caller's goroutine and
+// stops when the loop breaks
+func TestIterateSeq(t *testing.T) {
+	cache := NewCache(8, 1000, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 500; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), []byte(strconv.Itoa(i)), time.Minute)
+	}
+
+	// No mutex: the loop body runs on this goroutine
+	visited := make(map[string]bool)
+	for key, value := range cache.IterateSeq() {
+		if "key"+string(value) != key {
+			t.Fatalf("Expected %s to hold its number, got %s", key, value)
+		}
+		visited[key] = true
+	}
+	if len(visited) != 500 {
+		t.Fatalf("Expected 500 entries, got %d", len(visited))
+	}
+
+	n := 0
+	for range cache.IterateSeq() {
+		if n++; n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("Expected the loop to stop after 10 entries, got %d", n)
+	}
+}
+
+// testing that WithIterateWorkers bounds how many callbacks run at once
+func TestIterateWorkers(t *testing.T) {
+	cache := NewCache(16, 1000, time.Minute, WithIterateWorkers(2))
+	defer cache.Close()
+	for i := 0; i < 500; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+
+	var running, peak, visited atomic.Int32
+	cache.Iterate(func(string, []byte) {
+		n := running.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Microsecond)
+		visited.Add(1)
+		running.Add(-1)
+	})
+	if visited.Load() != 500 {
+		t.Fatalf("Expected 500 entries, got %d", visited.Load())
+	}
+	if peak.Load() > 2 {
+		t.Fatalf("Expected at most 2 concurrent callbacks, got %d", peak.Load())
+	}
+
+	if _, err := NewCacheWithOptions(WithIterateWorkers(0)); err == nil {
+		t.Fatal("Expected an error for zero workers")
+	}
+}
+
+// testing that IterateValues decodes every kind of entry
+func TestIterateValues(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	cache.Store("string", "kouhadi", time.Minute)
+	cache.Store("int", 42, time.Minute)
+	cache.Store("map", map[string]interface{}{"name": "aboubakr"}, time.Minute)
+	cache.StoreBytes("raw", []byte("bytes"), time.Minute)
+	cache.Increment("counter", 7, time.Minute)
+
+	var mu sync.Mutex
+	got := make(map[string]string)
+	cache.IterateValues(func(key string, value interface{}) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		got[key] = fmt.Sprint(value)
+		return true
+	})
+	want := map[string]string{
+		"string":  "kouhadi",
+		"int":     "42",
+		"map":     "map[name:aboubakr]",
+		"raw":     "[98 121 116 101 115]",
+		"counter": "7",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+}
+
+// countingSerializer is a JSON serializer that counts decodes and fails to
+// decode the JSON string "bad".
+type countingSerializer struct {
+	jsonSerializer
+	decodes *atomic.Int32
+}
+
+func (s countingSerializer) Unmarshal(data []byte, v interface{}) error {
+	s.decodes.Add(1)
+	if string(data) == `"bad"` {
+		return errors.New("bad value")
+	}
+	return s.jsonSerializer.Unmarshal(data, v)
+}
+
+// testing that IterateWhere only decodes matching keys and that decode
+// failures are skipped and reported
+func TestIterateWhere(t *testing.T) {
+	var decodes atomic.Int32
+	var mu sync.Mutex
+	var reported []error
+	cache := NewCache(4, 1000, time.Minute,
+		WithSerializer(countingSerializer{decodes: &decodes}),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		}))
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.Store("user:"+strconv.Itoa(i), i, time.Minute)
+		cache.Store("order:"+strconv.Itoa(i), i, time.Minute)
+	}
+	cache.Store("user:bad", "bad", time.Minute)
+
+	var visited atomic.Int32
+	cache.IterateWhere(func(key string) bool {
+		return strings.HasPrefix(key, "user:")
+	}, func(key string, value interface{}) bool {
+		visited.Add(1)
+		return true
+	})
+	if visited.Load() != 100 || decodes.Load() != 101 {
+		t.Fatalf("Expected 100 visits and 101 decodes, got %d and %d", visited.Load(), decodes.Load())
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], ErrSerialization) {
+		t.Fatalf("Expected the bad entry to be reported, got %v", reported)
+	}
+}
+
+// testing that a panicking callback reaches the caller and leaves the
+// shards usable
+func TestIteratePanic(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute, WithIterateWorkers(4))
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+
+	recovered := func(fn func()) (r interface{}) {
+		defer func() { r = recover() }()
+		fn()
+		return nil
+	}
+	var calls atomic.Int64
+	r := recovered(func() {
+		cache.Iterate(func(key string, value []byte) {
+			if calls.Add(1) == 10 {
+				panic("boom")
+			}
+		})
+	})
+	if r != "boom" {
+		t.Fatalf("Expected the panic to reach the caller, got %v", r)
+	}
+	if n := calls.Load(); n >= 100 {
+		t.Fatalf("Expected the scan to stop early, got %d calls", n)
+	}
+
+	r = recovered(func() {
+		cache.IterateCtx(context.Background(), func(key string, value []byte) error {
+			panic(errors.New("ctx boom"))
+		})
+	})
+	if err, ok := r.(error); !ok || err.Error() != "ctx boom" {
+		t.Fatalf("Expected the panic to reach the caller, got %v", r)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			cache.Store(key, "new", NoExpiration)
+			if v, _, _ := cache.FetchData(key); v != "new" {
+				t.Errorf("Expected %s to be updated, got %v", key, v)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Store and Fetch not to block after the panic")
+	}
+}
@@ -0,0 +1,212 @@
+package hoard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// jsonEntry is an entry of the dump written by ExportJSON. A value is
+// written as JSON in value when reading it back stores the same bytes, and
+// base64 encoded in valueBase64 otherwise: the serialized value, the raw
+// bytes of StoreBytes entries, or whatever could be read of a value that
+// failed to decode.
+type jsonEntry struct {
+	Key         string          `json:"key"`
+	ExpiresAt   *time.Time      `json:"expiresAt,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	ValueBase64 []byte          `json:"valueBase64,omitempty"`
+	Raw         bool            `json:"raw,omitempty"`         // stored with StoreBytes
+	Counter     bool            `json:"counter,omitempty"`     // written by Increment
+	Undecodable bool            `json:"undecodable,omitempty"` // failed with DecodeError
+	DecodeError string          `json:"decodeError,omitempty"`
+}
+
+// ExportJSON writes the live entries to w as a JSON array meant for people:
+// one object per line with the key, the expiration if any and, with
+// includeValues, the value. Values that survive a round trip through JSON
+// are written as JSON, others base64 encoded; values that cannot be decoded
+// are written as stored with "undecodable" set rather than failing the
+// export. Negative entries are left out.
+//
+// Shards are read like Save reads them and entries are encoded one at a
+// time, so the dump is never held in memory as a whole.
+func (c *Cache) ExportJSON(w io.Writer, includeValues bool) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("["); err != nil {
+		return err
+	}
+	first := true
+	var records []snapshotRecord
+	for _, shard := range c.shards {
+		records = c.snapshotShard(shard, records[:0])
+		for _, rec := range records {
+			if rec.flags&itemNegative != 0 {
+				continue
+			}
+			e := jsonEntry{Key: rec.key}
+			if rec.expiration != 0 {
+				at := time.Unix(0, rec.expiration).UTC()
+				e.ExpiresAt = &at
+			}
+			if includeValues {
+				c.exportValue(&e, rec)
+			}
+			line, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			sep := ",\n"
+			if first {
+				sep, first = "\n", false
+			}
+			if _, err := bw.WriteString(sep); err != nil {
+				return err
+			}
+			if _, err := bw.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := bw.WriteString("\n]\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// exportValue fills in the value of e from rec.
+func (c *Cache) exportValue(e *jsonEntry, rec snapshotRecord) {
+	v, err := c.unpack(itemView{value: rec.value, flags: rec.flags})
+	if err != nil {
+		e.ValueBase64, e.Undecodable, e.DecodeError = rec.value, true, err.Error()
+		return
+	}
+	switch {
+	case v.flags&itemRaw != 0:
+		e.ValueBase64, e.Raw = v.value, true
+		return
+	case v.flags&itemInt != 0:
+		e.Value, e.Counter = strconv.AppendInt(nil, decodeInt(v.value), 10), true
+		return
+	}
+	val, err := c.deserialize(v.value)
+	if err != nil {
+		e.ValueBase64, e.Undecodable, e.DecodeError = v.value, true, err.Error()
+		return
+	}
+	if text, err := json.Marshal(val); err == nil && c.roundTrips(text, v.value) {
+		e.Value = text
+		return
+	}
+	e.ValueBase64 = v.value
+}
+
+// roundTrips reports whether storing the JSON text stores serialized.
+func (c *Cache) roundTrips(text, serialized []byte) bool {
+	var val interface{}
+	if err := json.Unmarshal(text, &val); err != nil {
+		return false
+	}
+	again, err := c.serialize(val)
+	return err == nil && bytes.Equal(again, serialized)
+}
+
+// ImportJSON stores the entries of a dump written by ExportJSON with
+// values, resolving keys already present with policy like Merge. Entries
+// whose expiration passed are skipped and the others keep their absolute
+// expiration. Entries without a value, exported as undecodable or that
+// cannot be stored are skipped and reported in the returned error; a
+// malformed dump stops the import with the entries read so far stored.
+func (c *Cache) ImportJSON(r io.Reader, policy MergePolicy) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("hoard: import: %w", err)
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("hoard: import: expected a JSON array, got %v", tok)
+	}
+	var failed int
+	var first error
+	for dec.More() {
+		var e jsonEntry
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("hoard: import: %w", err)
+		}
+		if err := c.importEntry(e, policy); err != nil {
+			if failed == 0 {
+				first = fmt.Errorf("%q: %w", e.Key, err)
+			}
+			failed++
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("hoard: import: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("hoard: import skipped %d entries, first %w", failed, first)
+	}
+	return nil
+}
+
+// importEntry stores one entry of a JSON dump.
+func (c *Cache) importEntry(e jsonEntry, policy MergePolicy) error {
+	var exp int64
+	if e.ExpiresAt != nil {
+		if exp = e.ExpiresAt.UnixNano(); c.now() > exp {
+			return nil
+		}
+	}
+	if err := c.checkKey(e.Key); err != nil {
+		return err
+	}
+	var val []byte
+	var flags byte
+	switch {
+	case e.Undecodable:
+		return fmt.Errorf("%w: undecodable value in dump: %s", ErrSerialization, e.DecodeError)
+	case e.Counter:
+		n, err := strconv.ParseInt(string(e.Value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: counter: %w", ErrSerialization, err)
+		}
+		val, flags = encodeInt(n), itemInt
+	case e.Value != nil:
+		var v interface{}
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return fmt.Errorf("%w: %w", ErrSerialization, err)
+		}
+		var err error
+		if val, err = c.serialize(v); err != nil {
+			return err
+		}
+	case e.Raw:
+		val, flags = e.ValueBase64, itemRaw
+		if val == nil {
+			val = []byte{}
+		}
+	case e.ValueBase64 != nil:
+		val = e.ValueBase64
+	default:
+		return errors.New("hoard: no value in dump")
+	}
+	if err := c.checkValue(e.Key, val); err != nil {
+		return err
+	}
+	stored, flags, err := c.pack(val, flags)
+	if err != nil {
+		return err
+	}
+	shard := c.getShard(e.Key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return c.mergeLocked(shard, e.Key, stored, exp, flags, 1, policy, c.now())
+}
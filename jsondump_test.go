@@ -0,0 +1,171 @@
+package hoard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testing that a dump is readable and loads back into an equal cache
+func TestExportImportJSON(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(4, 100, time.Minute, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("name", "hoard", time.Hour)
+	cache.Store("tags", []string{"a", "b"}, NoExpiration)
+	cache.Store("number", 42, NoExpiration)
+	cache.StoreBytes("raw", []byte{0, 1, 2}, NoExpiration)
+	cache.StoreBytes("empty", []byte{}, NoExpiration)
+	cache.Increment("hits", 7, NoExpiration)
+	cache.StoreNegative("missing", NoExpiration)
+
+	var buf bytes.Buffer
+	if err := cache.ExportJSON(&buf, true); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	var dump []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, buf.String())
+	}
+	if len(dump) != 6 || strings.Count(buf.String(), "\n") != 8 {
+		t.Fatalf("Expected 6 entries, one per line, got:\n%s", buf.String())
+	}
+	byKey := make(map[string]map[string]interface{})
+	for _, e := range dump {
+		byKey[e["key"].(string)] = e
+	}
+	if e := byKey["name"]; e["value"] != "hoard" || e["expiresAt"] != "2024-01-01T01:00:00Z" {
+		t.Fatalf("Expected the string as JSON with its expiration, got %v", e)
+	}
+	if e := byKey["tags"]; !reflect.DeepEqual(e["value"], []interface{}{"a", "b"}) || e["expiresAt"] != nil {
+		t.Fatalf("Expected the list as JSON, got %v", e)
+	}
+	if e := byKey["raw"]; e["valueBase64"] != "AAEC" || e["raw"] != true {
+		t.Fatalf("Expected the raw bytes in base64, got %v", e)
+	}
+	if e := byKey["hits"]; e["value"] != 7.0 || e["counter"] != true {
+		t.Fatalf("Expected the counter, got %v", e)
+	}
+
+	other := NewCache(2, 100, time.Minute, WithClock(clock))
+	defer other.Close()
+	if err := other.ImportJSON(&buf, Overwrite); err != nil {
+		t.Fatalf("ImportJSON failed: %v", err)
+	}
+	if other.Len() != 6 || other.Exists("missing") {
+		t.Fatalf("Expected the 6 entries back, got %v", other.Keys())
+	}
+	for _, key := range []string{"name", "tags", "number"} {
+		if got, want := mustFetch(t, other, key), mustFetch(t, cache, key); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s to be %v, got %v", key, want, got)
+		}
+	}
+	if b, _ := other.FetchBytes("raw"); !bytes.Equal(b, []byte{0, 1, 2}) {
+		t.Fatalf("Expected the raw bytes back, got %v", b)
+	}
+	if b, ok := other.FetchBytes("empty"); !ok || len(b) != 0 {
+		t.Fatal("Expected the empty raw value back")
+	}
+	if n, err := other.Increment("hits", 1, NoExpiration); err != nil || n != 8 {
+		t.Fatalf("Expected the counter back, got %d, %v", n, err)
+	}
+	info, _ := other.Metadata("name")
+	if !info.ExpiresAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Expected the expiration back, got %v", info.ExpiresAt)
+	}
+}
+
+// testing keys-only dumps, expired entries, undecodable values and the
+// conflict policy
+func TestImportJSONSkips(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 100, time.Minute, WithClock(clock))
+	defer cache.Close()
+	cache.Store("short", 1, time.Second)
+	cache.Store("bad", "x", NoExpiration)
+	cache.shards[0].data["bad"].Value = []byte{0xc1}
+
+	var keys bytes.Buffer
+	if err := cache.ExportJSON(&keys, false); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	if strings.Contains(keys.String(), "value") {
+		t.Fatalf("Expected only keys, got %s", keys.String())
+	}
+
+	var buf bytes.Buffer
+	if err := cache.ExportJSON(&buf, true); err != nil {
+		t.Fatalf("Expected an undecodable value not to fail the export, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `"valueBase64":"wQ==","undecodable":true`) {
+		t.Fatalf("Expected the bad value flagged, got %s", buf.String())
+	}
+
+	clock.Advance(2 * time.Second)
+	other := NewCache(1, 100, time.Minute, WithClock(clock))
+	defer other.Close()
+	other.Store("bad", "kept", NoExpiration)
+	if err := other.ImportJSON(&buf, Overwrite); !errors.Is(err, ErrSerialization) {
+		t.Fatalf("Expected the undecodable entry to be reported, got %v", err)
+	}
+	if other.Exists("short") || mustFetch(t, other, "bad") != "kept" {
+		t.Fatal("Expected the expired and undecodable entries to be skipped")
+	}
+
+	if err := other.ImportJSON(&keys, Overwrite); err == nil {
+		t.Fatal("Expected entries without values to be reported")
+	}
+	if err := other.ImportJSON(strings.NewReader(`{"key": "a"}`), Overwrite); err == nil {
+		t.Fatal("Expected an error for a dump that is not an array")
+	}
+
+	other.Store("name", "old", NoExpiration)
+	dump := `[{"key":"name","value":"new"},{"key":"fresh","value":true}]`
+	if err := other.ImportJSON(strings.NewReader(dump), KeepExisting); err != nil {
+		t.Fatalf("ImportJSON failed: %v", err)
+	}
+	if mustFetch(t, other, "name") != "old" || mustFetch(t, other, "fresh") != true {
+		t.Fatal("Expected KeepExisting to keep the existing entry")
+	}
+}
+
+// testing a round trip through a dump of a large cache
+func TestExportImportJSONLarge(t *testing.T) {
+	const n = 100_000
+	cache := NewCache(16, n, time.Minute)
+	defer cache.Close()
+	for i := 0; i < n; i++ {
+		cache.Store("key"+strconv.Itoa(i), "value"+strconv.Itoa(i), NoExpiration)
+	}
+
+	var buf bytes.Buffer
+	if err := cache.ExportJSON(&buf, true); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	other := NewCache(16, n, time.Minute)
+	defer other.Close()
+	if err := other.ImportJSON(&buf, Overwrite); err != nil {
+		t.Fatalf("ImportJSON failed: %v", err)
+	}
+	if other.Len() != n {
+		t.Fatalf("Expected %d entries, got %d", n, other.Len())
+	}
+	if v := mustFetch(t, other, "key99999"); v != "value99999" {
+		t.Fatalf("Expected the last value, got %v", v)
+	}
+
+	// An empty cache dumps an empty array
+	empty := NewCache(1, 10, time.Minute)
+	defer empty.Close()
+	buf.Reset()
+	empty.ExportJSON(&buf, true)
+	if buf.String() != "[\n]\n" {
+		t.Fatalf("Expected an empty array, got %q", buf.String())
+	}
+}
@@ -0,0 +1,261 @@
+package hoard
+
+import (
+	"cmp"
+	"iter"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// Keys returns the keys of every live entry. Shards are read-locked and
+// copied one at a time, so the result is not an atomic snapshot under
+// concurrent writes. Keys are grouped by shard and in no particular order
+// within a shard.
+func (c *Cache) Keys() []string {
+	return c.KeysWithPrefix("")
+}
+
+// KeysWithPrefix is like Keys but only returns keys starting with prefix,
+// such as "user:42:".
+func (c *Cache) KeysWithPrefix(prefix string) []string {
+	var keys []string
+	now := c.now()
+	for _, shard := range c.shards {
+		keys = shard.appendKeys(keys, prefix, now)
+	}
+	return keys
+}
+
+// KeysIter streams the keys starting with prefix, in the same order as
+// KeysWithPrefix, without materializing them all: only one shard's keys are
+// buffered at a time, and no lock is held while the caller's loop body runs,
+// so it may modify the cache.
+func (c *Cache) KeysIter(prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		var buf []string
+		for _, shard := range c.shards {
+			buf = shard.appendKeys(buf[:0], prefix, c.now())
+			for _, key := range buf {
+				if !yield(key) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// appendKeys appends the keys of live entries starting with prefix to dst.
+func (s *CacheShard) appendKeys(dst []string, prefix string, now int64) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, item := range s.data {
+		if strings.HasPrefix(key, prefix) && !item.expired(now) {
+			dst = append(dst, key)
+		}
+	}
+	return dst
+}
+
+// defaultScanCount is the page size Scan uses for a count <= 0.
+const defaultScanCount = 10
+
+// Scan pages through the keys of live entries like Redis SCAN. Start with
+// cursor 0 and pass the returned cursor to the next call until it is 0
+// again. Each call returns about count keys; it may return more when keys
+// share a position, or fewer, even none, before the scan is complete. No
+// lock is held between calls. Keys present for the whole scan are returned
+// at least once; keys added or removed meanwhile may or may not be.
+//
+// The cursor encodes a shard and a position within it, so a scan only makes
+// sense on the cache that returned the cursor. The first Scan of a shard
+// indexes its keys by position; after that each call costs O(count) and
+// the index is kept up to date by every store and delete.
+func (c *Cache) Scan(cursor uint64, count int) ([]string, uint64) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	var keys []string
+	now := c.now()
+	idx, from := int(cursor>>32), uint32(cursor)
+	for ; idx < len(c.shards); idx, from = idx+1, 0 {
+		var next uint32
+		var more bool
+		keys, next, more = c.shards[idx].scan(keys, from, count-len(keys), now)
+		if more {
+			return keys, uint64(idx)<<32 | uint64(next)
+		}
+		if len(keys) >= count && idx+1 < len(c.shards) {
+			return keys, uint64(idx+1) << 32
+		}
+	}
+	return keys, 0
+}
+
+// scanEntry is a key and its scan position.
+type scanEntry struct {
+	pos uint32
+	key string
+}
+
+// minScanBits is the fewest position bits a scanIndex buckets by.
+const minScanBits = 4
+
+// scanIndex groups the keys of a shard into buckets by the top bits of
+// their scan position, so walking the buckets in order walks the keys in
+// position order and a page only visits the buckets it returns keys from.
+// Buckets double when they hold two keys each on average and halve when
+// they are an eighth full, so they stay small.
+type scanIndex struct {
+	bits    uint
+	buckets [][]scanEntry
+	n       int
+}
+
+// newScanIndex indexes the keys of data.
+func newScanIndex(data map[string]*CacheItem) *scanIndex {
+	x := &scanIndex{}
+	x.resize(max(minScanBits, uint(bits.Len(uint(len(data))))), func(yield func(scanEntry) bool) {
+		for key := range data {
+			if !yield(scanEntry{pos: fnv32a(key), key: key}) {
+				return
+			}
+		}
+	})
+	return x
+}
+
+// bucket returns the bucket of position pos.
+func (x *scanIndex) bucket(pos uint32) int {
+	return int(pos >> (32 - x.bits))
+}
+
+// add indexes key.
+func (x *scanIndex) add(key string) {
+	e := scanEntry{pos: fnv32a(key), key: key}
+	b := x.bucket(e.pos)
+	x.buckets[b] = append(x.buckets[b], e)
+	x.n++
+	if x.n > 2*len(x.buckets) && x.bits < 32 {
+		x.resize(x.bits+1, x.all)
+	}
+}
+
+// remove drops key from the index.
+func (x *scanIndex) remove(key string) {
+	b := x.bucket(fnv32a(key))
+	bucket := x.buckets[b]
+	for i, e := range bucket {
+		if e.key == key {
+			last := len(bucket) - 1
+			bucket[i] = bucket[last]
+			bucket[last] = scanEntry{}
+			x.buckets[b] = bucket[:last]
+			x.n--
+			break
+		}
+	}
+	if x.bits > minScanBits && x.n < len(x.buckets)/8 {
+		x.resize(x.bits-1, x.all)
+	}
+}
+
+// all yields every indexed entry.
+func (x *scanIndex) all(yield func(scanEntry) bool) {
+	for _, bucket := range x.buckets {
+		for _, e := range bucket {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// resize rebuckets the entries of seq by their top n bits.
+func (x *scanIndex) resize(n uint, seq iter.Seq[scanEntry]) {
+	buckets := make([][]scanEntry, 1<<n)
+	count := 0
+	for e := range seq {
+		b := int(e.pos >> (32 - n))
+		buckets[b] = append(buckets[b], e)
+		count++
+	}
+	x.bits, x.buckets, x.n = n, buckets, count
+}
+
+// indexLocked returns the scan index of the shard, building it on first
+// use. The caller must hold s.mu for writing.
+func (s *CacheShard) indexLocked() *scanIndex {
+	if s.scanIdx == nil {
+		s.scanIdx = newScanIndex(s.data)
+	}
+	return s.scanIdx
+}
+
+// scan appends to dst the keys of live entries at positions from onwards, in
+// position order, stopping after n keys but never between keys at the same
+// position. Positions are the FNV-1a hash of the key, so they stay fixed
+// while the key exists. It returns the position to resume from and whether
+// any keys are left.
+//
+// The keys are found through the scan index, which the first call builds
+// under the write lock; later calls hold the read lock while they walk the
+// buckets they return keys from.
+func (s *CacheShard) scan(dst []string, from uint32, n int, now int64) ([]string, uint32, bool) {
+	s.mu.RLock()
+	if s.scanIdx == nil {
+		s.mu.RUnlock()
+		s.mu.Lock()
+		s.indexLocked()
+		s.mu.Unlock()
+		s.mu.RLock()
+	}
+	x := s.scanIdx
+	var found []scanEntry
+	for b := x.bucket(from); b < len(x.buckets); b++ {
+		start := len(found)
+		for _, e := range x.buckets[b] {
+			if e.pos >= from && !s.data[e.key].expired(now) {
+				found = append(found, e)
+			}
+		}
+		if len(found) >= n {
+			slices.SortFunc(found[start:], func(a, b scanEntry) int {
+				return cmp.Compare(a.pos, b.pos)
+			})
+			// Cut after n keys, keeping the keys that share the last
+			// position, and report whether any key is left beyond it.
+			cut := n
+			for cut < len(found) && found[cut].pos == found[n-1].pos {
+				cut++
+			}
+			more := cut < len(found)
+			if !more {
+				for _, bucket := range x.buckets[b+1:] {
+					if len(bucket) > 0 {
+						more = true
+						break
+					}
+				}
+			}
+			last := found[cut-1].pos
+			s.mu.RUnlock()
+			for _, e := range found[:cut] {
+				dst = append(dst, e.key)
+			}
+			if !more || last == math.MaxUint32 {
+				return dst, 0, false
+			}
+			return dst, last + 1, true
+		}
+		slices.SortFunc(found[start:], func(a, b scanEntry) int {
+			return cmp.Compare(a.pos, b.pos)
+		})
+	}
+	s.mu.RUnlock()
+	for _, e := range found {
+		dst = append(dst, e.key)
+	}
+	return dst, 0, false
+}
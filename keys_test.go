@@ -0,0 +1,242 @@
+package hoard
+
+import (
+	"runtime"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testing Keys and KeysWithPrefix
+func TestKeys(t *testing.T) {
+	cache := NewCache(4, 100, time.Hour)
+	defer cache.Close()
+
+	cache.Store("user:42:name", "aboubakr", time.Minute)
+	cache.Store("user:42:age", 33, time.Minute)
+	cache.Store("user:7:name", "haroun", time.Minute)
+	cache.Store("expired", "gone", -time.Second)
+
+	keys := cache.Keys()
+	slices.Sort(keys)
+	if want := []string{"user:42:age", "user:42:name", "user:7:name"}; !slices.Equal(keys, want) {
+		t.Errorf("Expected %v, got %v", want, keys)
+	}
+
+	keys = cache.KeysWithPrefix("user:42:")
+	slices.Sort(keys)
+	if want := []string{"user:42:age", "user:42:name"}; !slices.Equal(keys, want) {
+		t.Errorf("Expected %v, got %v", want, keys)
+	}
+	if keys := cache.KeysWithPrefix("nope"); len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+}
+
+// testing that KeysIter streams every key, stops early and allows writes
+func TestKeysIter(t *testing.T) {
+	cache := NewCache(4, 1000, time.Hour)
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+
+	n := 0
+	for key := range cache.KeysIter("") {
+		cache.Delete(key) // no lock is held while yielding
+		n++
+	}
+	if n != 100 || cache.Len() != 0 {
+		t.Fatalf("Expected to visit and delete 100 keys, got %d with %d left", n, cache.Len())
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	n = 0
+	for range cache.KeysIter("") {
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("Expected to stop after 10 keys, got %d", n)
+	}
+}
+
+// testing that KeysIter buffers one shard at a time on a large cache
+func TestKeysIterMemory(t *testing.T) {
+	const numKeys = 200_000
+	const numShards = 64
+	cache := NewCache(numShards, numKeys, time.Hour)
+	defer cache.Close()
+	for i := 0; i < numKeys; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+
+	measure := func(fn func()) uint64 {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		fn()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	var all []string
+	full := measure(func() { all = cache.Keys() })
+	n := 0
+	streamed := measure(func() {
+		for range cache.KeysIter("") {
+			n++
+		}
+	})
+	if len(all) != numKeys || n != numKeys {
+		t.Fatalf("Expected %d keys, got %d and %d", numKeys, len(all), n)
+	}
+	if streamed*8 > full {
+		t.Errorf("Expected KeysIter to allocate far less than Keys, got %d vs %d bytes", streamed, full)
+	}
+}
+
+// testing that a Scan of a static cache returns every key exactly once
+func TestScan(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(8, 1000, 0, WithClock(clock))
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+	cache.StoreBytes("expired", nil, time.Millisecond)
+	clock.Advance(time.Second)
+
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		var keys []string
+		keys, cursor = cache.Scan(cursor, 64)
+		calls++
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 1000 {
+		t.Fatalf("Expected 1000 keys, got %d", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Expected %s once, got it %d times", key, n)
+		}
+	}
+	if calls < 1000/64 || calls > 1000/64+len(cache.shards)+1 {
+		t.Errorf("Expected pages of about 64 keys, took %d calls", calls)
+	}
+}
+
+// testing that keys present for a whole Scan are returned while others are
+// added and removed
+func TestScanConcurrentChanges(t *testing.T) {
+	cache := NewCache(4, 10000, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.StoreBytes("stable"+strconv.Itoa(i), nil, time.Minute)
+		cache.StoreBytes("churn"+strconv.Itoa(i), nil, time.Minute)
+	}
+
+	seen := make(map[string]bool)
+	cursor, i := uint64(0), 0
+	for {
+		var keys []string
+		keys, cursor = cache.Scan(cursor, 50)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if cursor == 0 {
+			break
+		}
+		// Remove an old key and add a new one between pages
+		cache.Delete("churn" + strconv.Itoa(i))
+		cache.StoreBytes("added"+strconv.Itoa(i), nil, time.Minute)
+		i++
+	}
+	for i := 0; i < 1000; i++ {
+		if !seen["stable"+strconv.Itoa(i)] {
+			t.Fatalf("Expected stable%d to be returned", i)
+		}
+	}
+	checkIntegrity(t, cache)
+}
+
+// testing Scan on an empty cache and with a default page size
+func TestScanEmpty(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute)
+	defer cache.Close()
+	if keys, cursor := cache.Scan(0, 0); len(keys) != 0 || cursor != 0 {
+		t.Fatalf("Expected an empty, complete scan, got %v and %d", keys, cursor)
+	}
+
+	for i := 0; i < 30; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+	if keys, cursor := cache.Scan(0, 0); len(keys) < defaultScanCount || cursor == 0 {
+		t.Fatalf("Expected a page of %d keys, got %d and cursor %d", defaultScanCount, len(keys), cursor)
+	}
+}
+
+// testing that the scan index follows a shard as it grows and shrinks, so
+// later scans still return every key exactly once
+func TestScanIndexResizes(t *testing.T) {
+	cache := NewCache(1, 10000, time.Minute)
+	defer cache.Close()
+	scanAll := func() map[string]int {
+		seen := make(map[string]int)
+		cursor := uint64(0)
+		for {
+			var keys []string
+			keys, cursor = cache.Scan(cursor, 100)
+			for _, key := range keys {
+				seen[key]++
+			}
+			if cursor == 0 {
+				return seen
+			}
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+	if seen := scanAll(); len(seen) != 10 {
+		t.Fatalf("Expected 10 keys, got %d", len(seen))
+	}
+	for i := 10; i < 5000; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), nil, time.Minute)
+	}
+	checkIntegrity(t, cache)
+	grown := cache.shards[0].scanIdx.bits
+	if grown <= minScanBits {
+		t.Errorf("Expected the scan index to grow, got %d bits", grown)
+	}
+	for i := 0; i < 4990; i++ {
+		cache.Delete("key" + strconv.Itoa(i))
+	}
+	checkIntegrity(t, cache)
+	if bits := cache.shards[0].scanIdx.bits; bits >= grown-2 {
+		t.Errorf("Expected the scan index to shrink from %d bits, got %d", grown, bits)
+	}
+	seen := scanAll()
+	if len(seen) != 10 {
+		t.Fatalf("Expected 10 keys, got %d", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Expected %s once, got it %d times", key, n)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package hoard
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Operations timed by WithLatencyTracking and WithSlowOpThreshold, as they
+// are passed to the slow operation callback.
+const (
+	OpNameStore   = "Store"   // Store, StoreBytes and the Store variants taking options
+	OpNameFetch   = "Fetch"   // single key lookups, without loader or backend time
+	OpNameUpdate  = "Update"  // Update and UpdateUntil
+	OpNameDelete  = "Delete"  // Delete
+	OpNameCleanup = "Cleanup" // one pass of the background cleanup, with an empty key
+)
+
+// latencyOps lists the timed operations in the order of latencyTracker.hists.
+var latencyOps = [...]string{OpNameStore, OpNameFetch, OpNameUpdate, OpNameDelete, OpNameCleanup}
+
+const (
+	opStore = iota
+	opFetch
+	opUpdate
+	opDelete
+	opCleanup
+)
+
+// latencyBounds are the upper bounds of the histogram buckets, growing four
+// fold from a microsecond to a second.
+var latencyBounds = [...]time.Duration{
+	time.Microsecond,
+	4 * time.Microsecond,
+	16 * time.Microsecond,
+	64 * time.Microsecond,
+	256 * time.Microsecond,
+	time.Millisecond,
+	4 * time.Millisecond,
+	16 * time.Millisecond,
+	64 * time.Millisecond,
+	256 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyStats holds the latency histogram of each timed operation, see
+// WithLatencyTracking.
+type LatencyStats struct {
+	Store   LatencyHistogram // Store, StoreBytes and the Store variants taking options
+	Fetch   LatencyHistogram // single key lookups, without loader or backend time
+	Update  LatencyHistogram // Update and UpdateUntil
+	Delete  LatencyHistogram // Delete
+	Cleanup LatencyHistogram // passes of the background cleanup
+}
+
+// LatencyHistogram is the distribution of the durations of one operation.
+type LatencyHistogram struct {
+	Count uint64
+	Sum   time.Duration
+	Max   time.Duration
+	// Bounds are the upper bounds of the buckets, and Counts[i] the number
+	// of operations that took more than Bounds[i-1] and at most Bounds[i].
+	// Counts has one more element than Bounds for operations slower than
+	// the last bound.
+	Bounds []time.Duration
+	Counts []uint64
+}
+
+// Mean returns the average duration, or 0 if nothing was recorded.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// latencyHist records the durations of one operation.
+type latencyHist struct {
+	sum    atomic.Int64
+	max    atomic.Int64
+	counts [len(latencyBounds) + 1]atomic.Uint64
+}
+
+func (h *latencyHist) observe(took time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && took > latencyBounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(took))
+	for {
+		max := h.max.Load()
+		if int64(took) <= max || h.max.CompareAndSwap(max, int64(took)) {
+			return
+		}
+	}
+}
+
+func (h *latencyHist) snapshot() LatencyHistogram {
+	s := LatencyHistogram{
+		Sum:    time.Duration(h.sum.Load()),
+		Max:    time.Duration(h.max.Load()),
+		Bounds: latencyBounds[:],
+		Counts: make([]uint64, len(h.counts)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+func (h *latencyHist) reset() {
+	h.sum.Store(0)
+	h.max.Store(0)
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+}
+
+// latencyTracker times operations for WithLatencyTracking and
+// WithSlowOpThreshold. A cache without either option has none, so
+// operations only pay a nil check.
+type latencyTracker struct {
+	hists  *[len(latencyOps)]latencyHist // nil without WithLatencyTracking
+	slow   time.Duration
+	onSlow func(op, key string, took time.Duration)
+}
+
+// latencyTrackerOf returns the tracker of c, creating it for an option.
+func latencyTrackerOf(c *Cache) *latencyTracker {
+	if c.latency == nil {
+		c.latency = &latencyTracker{}
+	}
+	return c.latency
+}
+
+// observe records an operation on key that started at start. It is meant to
+// be deferred before any lock is taken, so the slow operation callback runs
+// with none held.
+func (t *latencyTracker) observe(op int, key string, start time.Time) {
+	took := time.Since(start)
+	if t.hists != nil {
+		t.hists[op].observe(took)
+	}
+	if t.onSlow != nil && took >= t.slow {
+		t.onSlow(latencyOps[op], key, took)
+	}
+}
+
+// snapshot returns the histograms, or nil without WithLatencyTracking.
+func (t *latencyTracker) snapshot() *LatencyStats {
+	if t == nil || t.hists == nil {
+		return nil
+	}
+	return &LatencyStats{
+		Store:   t.hists[opStore].snapshot(),
+		Fetch:   t.hists[opFetch].snapshot(),
+		Update:  t.hists[opUpdate].snapshot(),
+		Delete:  t.hists[opDelete].snapshot(),
+		Cleanup: t.hists[opCleanup].snapshot(),
+	}
+}
+
+func (t *latencyTracker) reset() {
+	if t == nil || t.hists == nil {
+		return
+	}
+	for i := range t.hists {
+		t.hists[i].reset()
+	}
+}
+
+// clone returns a tracker with the settings of t and empty histograms.
+func (t *latencyTracker) clone() *latencyTracker {
+	if t == nil {
+		return nil
+	}
+	n := &latencyTracker{slow: t.slow, onSlow: t.onSlow}
+	if t.hists != nil {
+		n.hists = new([len(latencyOps)]latencyHist)
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package hoard
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testing that tracked operations land in their histograms
+func TestLatencyTracking(t *testing.T) {
+	cache := NewCache(2, 100, time.Millisecond, WithLatencyTracking())
+	defer cache.Close()
+
+	cache.Store("a", 1, NoExpiration)
+	cache.Store("b", 2, NoExpiration)
+	cache.StoreBytes("c", []byte("3"), NoExpiration)
+	cache.FetchData("a")
+	cache.FetchBytes("missing")
+	cache.Update("a", 3, NoExpiration)
+	cache.Delete("b")
+	waitFor(t, func() bool { return cache.Stats().Latency.Cleanup.Count > 0 })
+
+	l := cache.Stats().Latency
+	for name, tc := range map[string]struct {
+		h    LatencyHistogram
+		want uint64
+	}{
+		"Store": {l.Store, 3}, "Fetch": {l.Fetch, 2}, "Update": {l.Update, 1}, "Delete": {l.Delete, 1},
+	} {
+		if tc.h.Count != tc.want {
+			t.Errorf("Expected %d %s operations, got %d", tc.want, name, tc.h.Count)
+		}
+		var n uint64
+		for _, c := range tc.h.Counts {
+			n += c
+		}
+		if n != tc.h.Count || len(tc.h.Counts) != len(tc.h.Bounds)+1 {
+			t.Errorf("Expected the %s buckets to add up to %d, got %v", name, tc.h.Count, tc.h.Counts)
+		}
+		if tc.h.Max < tc.h.Mean() || tc.h.Sum <= 0 {
+			t.Errorf("Expected a sum and a max of at least the mean for %s, got %+v", name, tc.h)
+		}
+	}
+
+	cache.ResetStats()
+	if l := cache.Stats().Latency; l.Store.Count != 0 || l.Store.Max != 0 {
+		t.Fatalf("Expected ResetStats to clear the histograms, got %+v", l.Store)
+	}
+
+	plain := NewCache(2, 100, 0)
+	defer plain.Close()
+	plain.Store("a", 1, NoExpiration)
+	if plain.Stats().Latency != nil || plain.latency != nil {
+		t.Fatal("Expected no latency tracking without the option")
+	}
+}
+
+// slowSerializer is a JSON serializer that takes a while on "slow".
+type slowSerializer struct {
+	jsonSerializer
+}
+
+func (s slowSerializer) Marshal(v interface{}) ([]byte, error) {
+	if v == "slow" {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return s.jsonSerializer.Marshal(v)
+}
+
+// testing that only operations over the threshold reach the callback, with
+// the shard unlocked
+func TestSlowOpThreshold(t *testing.T) {
+	type slowOp struct {
+		op, key string
+		took    time.Duration
+	}
+	var mu sync.Mutex
+	var slow []slowOp
+	var cache *Cache
+	cache = NewCache(1, 100, 0, WithSerializer(slowSerializer{}), WithSlowOpThreshold(10*time.Millisecond, func(op, key string, took time.Duration) {
+		cache.Store("from-callback", 1, NoExpiration) // the lock is released
+		mu.Lock()
+		slow = append(slow, slowOp{op, key, took})
+		mu.Unlock()
+	}))
+	defer cache.Close()
+
+	cache.Store("fast", "fast", NoExpiration)
+	cache.Store("key", "slow", NoExpiration)
+	cache.FetchData("key")
+	cache.Update("fast", "slow", NoExpiration)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(slow) != 2 || slow[0].op != OpNameStore || slow[0].key != "key" || slow[1].op != OpNameUpdate || slow[1].key != "fast" {
+		t.Fatalf("Expected the slow Store and Update, got %+v", slow)
+	}
+	if slow[0].took < 10*time.Millisecond {
+		t.Fatalf("Expected the duration over the threshold, got %v", slow[0].took)
+	}
+	if cache.Stats().Latency != nil {
+		t.Fatal("Expected no histograms without WithLatencyTracking")
+	}
+
+	if _, err := NewCacheWithOptions(WithSlowOpThreshold(-time.Second, func(string, string, time.Duration) {})); err == nil {
+		t.Fatal("Expected a negative threshold to be rejected")
+	}
+}
@@ -0,0 +1,35 @@
+package hoard
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidKey is returned by writes with an empty key or a key longer
+	// than the limit set with WithMaxKeyLength.
+	ErrInvalidKey = errors.New("hoard: invalid key")
+	// ErrValueTooLarge is returned by writes whose serialized value exceeds
+	// the limit set with WithMaxValueSize. The existing entry, if any, is
+	// left untouched.
+	ErrValueTooLarge = errors.New("hoard: value too large")
+)
+
+// checkKey rejects keys that writes must not create.
+func (c *Cache) checkKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", ErrInvalidKey)
+	}
+	if c.maxKeyLength > 0 && len(key) > c.maxKeyLength {
+		return fmt.Errorf("%w: key of %d bytes exceeds the limit of %d", ErrInvalidKey, len(key), c.maxKeyLength)
+	}
+	return nil
+}
+
+// checkValue rejects serialized or raw values larger than the limit.
+func (c *Cache) checkValue(key string, val []byte) error {
+	if c.maxValueSize > 0 && len(val) > c.maxValueSize {
+		return fmt.Errorf("%w: value of %q is %d bytes, the limit is %d", ErrValueTooLarge, key, len(val), c.maxValueSize)
+	}
+	return nil
+}
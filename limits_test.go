@@ -0,0 +1,87 @@
+package hoard
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writes calls every mutation method with key and value and returns their
+// errors by name.
+func writes(c *Cache, key string, value string) map[string]error {
+	errs := map[string]error{
+		"Store":      c.Store(key, value, time.Minute),
+		"StoreBytes": c.StoreBytes(key, []byte(value), time.Minute),
+		"StoreWithOptions": c.StoreWithOptions(key, value, ItemOptions{
+			TTL: time.Minute,
+		}),
+		"StoreTagged": c.StoreTagged(key, value, time.Minute, "tag"),
+		"Update":      c.Update(key, value, time.Minute),
+		"UpdateValue": c.UpdateValue(key, value),
+		"Txn": c.Txn([]string{key}, func(tx *Tx) error {
+			return tx.Set(key, value, time.Minute)
+		}),
+	}
+	_, errs["SetIfAbsent"] = c.SetIfAbsent(key, value, time.Minute)
+	_, _, errs["GetSet"] = c.GetSet(key, value, time.Minute)
+	_, errs["CompareAndSwap"] = c.CompareAndSwap(key, "old", value, time.Minute)
+	errs["StoreMany"] = c.StoreMany(map[string]interface{}{key: value}, time.Minute)[key]
+	return errs
+}
+
+// testing that every mutation method rejects empty and overlong keys
+func TestInvalidKeys(t *testing.T) {
+	cache := NewCache(2, 100, time.Minute, WithMaxKeyLength(8))
+	defer cache.Close()
+
+	for _, key := range []string{"", "ninechars"} {
+		errs := writes(cache, key, "value")
+		_, errs["Increment"] = cache.Increment(key, 1, time.Minute)
+		errs["StoreNegative"] = cache.StoreNegative(key, time.Minute)
+		for name, err := range errs {
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Expected %s to reject key %q with ErrInvalidKey, got %v", name, key, err)
+			}
+		}
+	}
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected nothing stored, got %d items", n)
+	}
+
+	if err := cache.Store("eightchr", 1, time.Minute); err != nil {
+		t.Fatalf("Expected a key at the limit to be accepted, got %v", err)
+	}
+}
+
+// testing that oversized values are rejected and leave existing entries as
+// they are
+func TestMaxValueSize(t *testing.T) {
+	cache := NewCache(2, 100, time.Minute, WithMaxValueSize(64))
+	defer cache.Close()
+
+	cache.Store("key", "small", time.Minute)
+	large := strings.Repeat("x", 100)
+	for name, err := range writes(cache, "key", large) {
+		if !errors.Is(err, ErrValueTooLarge) {
+			t.Errorf("Expected %s to fail with ErrValueTooLarge, got %v", name, err)
+		}
+	}
+	if v, _, _ := cache.FetchData("key"); v != "small" {
+		t.Fatalf("Expected the existing entry to be untouched, got %v", v)
+	}
+
+	err := cache.StoreBytes("raw", make([]byte, 65), time.Minute)
+	if !errors.Is(err, ErrValueTooLarge) || !strings.Contains(err.Error(), "65 bytes") {
+		t.Fatalf("Expected the error to carry the size, got %v", err)
+	}
+	if err := cache.StoreBytes("raw", make([]byte, 64), time.Minute); err != nil {
+		t.Fatalf("Expected a value at the limit to be accepted, got %v", err)
+	}
+
+	for _, opt := range []Option{WithMaxValueSize(-1), WithMaxKeyLength(-1)} {
+		if _, err := NewCacheWithOptions(opt); err == nil {
+			t.Fatal("Expected an error for a negative limit")
+		}
+	}
+}
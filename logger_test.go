@@ -0,0 +1,120 @@
+package hoard
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler is a slog.Handler that keeps every record.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+// find returns the attributes of the records with msg.
+func (h *recordingHandler) find(msg string) []map[string]slog.Value {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var found []map[string]slog.Value
+	for _, r := range h.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := map[string]slog.Value{"level": slog.StringValue(r.Level.String())}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		found = append(found, attrs)
+	}
+	return found
+}
+
+// testing that a cleanup pass logs how many expired entries it removed
+func TestLoggerCleanup(t *testing.T) {
+	h := &recordingHandler{}
+	clock := newFakeClock()
+	cache := NewCache(4, 100, time.Minute, WithClock(clock), WithLogger(slog.New(h)))
+	defer cache.Close()
+	for i := 0; i < 5; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Second)
+	}
+	cache.Store("kept", "v", NoExpiration)
+	cache.FetchData("kept")
+	if got := h.find("hoard: cleanup pass"); len(got) != 0 {
+		t.Fatalf("Expected no cleanup record yet, got %v", got)
+	}
+
+	clock.Advance(2 * time.Second)
+	cache.RunCleanup()
+	got := h.find("hoard: cleanup pass")
+	if len(got) != 1 {
+		t.Fatalf("Expected one cleanup record, got %v", got)
+	}
+	if got[0]["level"].String() != "DEBUG" || got[0]["removed"].Int64() != 5 {
+		t.Fatalf("Expected a debug record of 5 removals, got %v", got[0])
+	}
+	if len(h.records) != 1 {
+		t.Fatalf("Expected Store and Fetch not to log, got %d records", len(h.records))
+	}
+}
+
+// testing the eviction, snapshot and backend records
+func TestLoggerEvents(t *testing.T) {
+	h := &recordingHandler{}
+	backend := newMemBackend()
+	cache := NewCache(1, 2, time.Minute, WithLogger(slog.New(h)), WithBackend(backend))
+	defer cache.Close()
+
+	cache.Store("a", 1, NoExpiration)
+	cache.Store("b", 2, NoExpiration)
+	cache.Store("c", 3, NoExpiration)
+	if got := h.find("hoard: evicted"); len(got) != 1 || got[0]["key"].String() != "a" {
+		t.Fatalf("Expected the eviction of a, got %v", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	if err := cache.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if got := h.find("hoard: snapshot saved"); len(got) != 1 || got[0]["level"].String() != "INFO" ||
+		got[0]["path"].String() != path || got[0]["entries"].Int64() != 2 {
+		t.Fatalf("Expected a snapshot record, got %v", got)
+	}
+	if err := cache.LoadFile(path + ".missing"); err == nil {
+		t.Fatal("Expected loading a missing file to fail")
+	}
+	if got := h.find("hoard: snapshot load failed"); len(got) != 1 || got[0]["level"].String() != "ERROR" {
+		t.Fatalf("Expected a failed load record, got %v", got)
+	}
+
+	backend.err = errors.New("backend down")
+	if _, _, err := cache.FetchCtx(context.Background(), "missing"); !errors.Is(err, ErrBackend) {
+		t.Fatalf("Expected a backend error, got %v", err)
+	}
+	if got := h.find("hoard: backend load failed"); len(got) != 1 || got[0]["level"].String() != "WARN" ||
+		got[0]["key"].String() != "missing" {
+		t.Fatalf("Expected a failed backend load record, got %v", got)
+	}
+	cache.Delete("b")
+	if got := h.find("hoard: background error"); len(got) != 1 || got[0]["level"].String() != "ERROR" {
+		t.Fatalf("Expected the failed delete to be logged, got %v", got)
+	}
+}
@@ -0,0 +1,38 @@
+package hoard
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// log writes a record to the logger set with WithLogger, if any. The attrs
+// may be allocated before the check, so hot paths test c.logger themselves.
+func (c *Cache) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if c.logger == nil {
+		return
+	}
+	c.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// logCleanup logs a cleanup pass that started at start and removed
+// removed expired entries.
+func (c *Cache) logCleanup(removed int, start time.Time) {
+	if c.logger == nil {
+		return
+	}
+	c.log(slog.LevelDebug, "hoard: cleanup pass", slog.Int("removed", removed), slog.Duration("took", time.Since(start)))
+}
+
+// logSnapshot logs the result of a snapshot written to or read from path,
+// with msg on success and failed otherwise.
+func (c *Cache) logSnapshot(msg, failed, path string, start time.Time, err error) {
+	if c.logger == nil {
+		return
+	}
+	if err != nil {
+		c.log(slog.LevelError, failed, slog.String("path", path), slog.Any("err", err))
+		return
+	}
+	c.log(slog.LevelInfo, msg, slog.String("path", path), slog.Int("entries", c.Len()), slog.Duration("took", time.Since(start)))
+}
@@ -0,0 +1,58 @@
+package hoard
+
+import "sync/atomic"
+
+// itemCounter tracks the entries of the whole cache for WithMaxItems.
+type itemCounter struct {
+	total atomic.Int64
+}
+
+// reserveItem accounts for a new entry about to be inserted into shard,
+// evicting one entry elsewhere first if the cache is at its global limit. It
+// reports false, with nothing reserved, if no entry could be evicted. The
+// caller must hold shard.mu for writing.
+func (c *Cache) reserveItem(shard *CacheShard) bool {
+	if c.items.total.Add(1) <= int64(c.maxItems) {
+		return true
+	}
+	if c.evictGlobal(shard) {
+		return true
+	}
+	c.items.total.Add(-1)
+	return false
+}
+
+// evictGlobal evicts one entry to make room under the global limit, from the
+// most loaded shard if its lock can be taken without waiting, or else from
+// shard, whose lock the caller holds. Taking another shard's lock only with
+// TryLock keeps concurrent writers from deadlocking on each other.
+func (c *Cache) evictGlobal(shard *CacheShard) bool {
+	target := c.mostLoadedShard()
+	if target != shard && target.mu.TryLock() {
+		victim := target.policy.victim()
+		if victim != nil {
+			c.evictLockedItem(target, victim)
+		}
+		target.mu.Unlock()
+		if victim != nil {
+			return true
+		}
+	}
+	if victim := shard.policy.victim(); victim != nil {
+		c.evictLockedItem(shard, victim)
+		return true
+	}
+	return false
+}
+
+// mostLoadedShard returns the shard holding the most entries, judged by
+// counts that may lag concurrent writes.
+func (c *Cache) mostLoadedShard() *CacheShard {
+	best := c.shards[0]
+	for _, shard := range c.shards[1:] {
+		if shard.size.Load() > best.size.Load() {
+			best = shard
+		}
+	}
+	return best
+}
@@ -0,0 +1,91 @@
+package hoard
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// skewed sends keys starting with "hot" to shard 0 and spreads the rest
+// over the other shards.
+func skewed(key string, numShards int) int {
+	if strings.HasPrefix(key, "hot") {
+		return 0
+	}
+	return 1 + int(fnv32a(key)%uint32(numShards-1))
+}
+
+// testing that the global cap holds when shards are unbalanced
+func TestMaxItemsSkewed(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute, WithShardingFunc(skewed), WithMaxItems(100))
+	defer cache.Close()
+
+	for i := 0; i < 30; i++ {
+		cache.Store("cold"+strconv.Itoa(i), i, NoExpiration)
+	}
+	for i := 0; i < 500; i++ {
+		cache.Store("hot"+strconv.Itoa(i), i, NoExpiration)
+		if n := cache.Len(); n > 100 {
+			t.Fatalf("Expected at most 100 items, got %d after %d stores", n, i+31)
+		}
+	}
+	if n := cache.Stats().ItemCount; n != 100 {
+		t.Fatalf("Expected the cache to stay full at 100, got %d", n)
+	}
+	// The hot shard was the most loaded, so it paid for its own growth
+	lens := cache.ShardLens()
+	if lens[0] != 70 {
+		t.Fatalf("Expected the hot shard to hold 70 items, got %v", lens)
+	}
+
+	// Writing to a cold shard now evicts from the hot one
+	for i := 30; i < 50; i++ {
+		cache.Store("cold"+strconv.Itoa(i), i, NoExpiration)
+	}
+	lens = cache.ShardLens()
+	if lens[0] != 50 || cache.Len() != 100 {
+		t.Fatalf("Expected cold stores to evict from the hot shard, got %v", lens)
+	}
+	if n := cache.Stats().Evictions; n != 450 {
+		t.Fatalf("Expected 450 evictions, got %d", n)
+	}
+
+	// Deletes free room
+	cache.Delete("cold0")
+	cache.Store("cold0", 0, NoExpiration)
+	if n := cache.Stats().Evictions; n != 450 {
+		t.Fatalf("Expected no eviction after a delete, got %d", n-450)
+	}
+
+	if _, err := NewCacheWithOptions(WithMaxItems(-1)); err == nil {
+		t.Fatal("Expected an error for a negative cap")
+	}
+}
+
+// testing that concurrent writers never push the total past the cap
+func TestMaxItemsConcurrent(t *testing.T) {
+	cache := NewCache(8, 1000, time.Minute, WithShardingFunc(skewed), WithMaxItems(200))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prefix := "cold" + strconv.Itoa(w) + "_"
+			if w%2 == 0 {
+				prefix = "hot" + strconv.Itoa(w) + "_"
+			}
+			for i := 0; i < 1000; i++ {
+				cache.Store(prefix+strconv.Itoa(i), i, NoExpiration)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := cache.Len(); n != 200 {
+		t.Fatalf("Expected 200 items, got %d", n)
+	}
+	checkIntegrity(t, cache)
+}
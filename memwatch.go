@@ -0,0 +1,137 @@
+package hoard
+
+import (
+	"math"
+	"runtime"
+	"time"
+)
+
+// ShrinkEvent describes one pass of the memory watcher, see
+// WithMemoryWatcher.
+type ShrinkEvent struct {
+	HeapBefore uint64 // heap size that triggered the pass
+	HeapAfter  uint64 // heap size when the pass stopped
+	Evicted    int    // entries evicted
+	Rounds     int    // eviction rounds run
+}
+
+// Memory watcher defaults.
+const (
+	defaultShrinkFraction = 0.1
+	// maxShrinkRounds bounds a single pass so memory held outside the cache
+	// cannot make it evict everything in one go; the next tick continues.
+	maxShrinkRounds = 10
+)
+
+// memWatcher holds the settings of WithMemoryWatcher and its companions.
+type memWatcher struct {
+	softLimit    uint64
+	lowWatermark uint64 // 0 means 90% of softLimit
+	interval     time.Duration
+	fraction     float64
+	read         func() uint64 // nil means runtime.MemStats.HeapAlloc
+	onShrink     func(ShrinkEvent)
+}
+
+// readHeapAlloc reads HeapAlloc from the runtime.
+func readHeapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// startMemoryWatcher samples the heap every interval and shrinks the cache
+// while it is above the soft limit.
+func (c *Cache) startMemoryWatcher() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.mem.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.checkMemory()
+		}
+	}
+}
+
+// checkMemory runs a shrink pass if the heap is above the soft limit.
+func (c *Cache) checkMemory() {
+	m := c.mem
+	read := m.read
+	if read == nil {
+		read = readHeapAlloc
+	}
+	heap := read()
+	if heap <= m.softLimit {
+		return
+	}
+	low := m.lowWatermark
+	if low == 0 {
+		low = m.softLimit / 10 * 9
+	}
+
+	// Take turns with the cleanup goroutine rather than racing it for the
+	// same shards
+	c.maintenance.Lock()
+	defer c.maintenance.Unlock()
+
+	ev := ShrinkEvent{HeapBefore: heap}
+	for heap > low && ev.Rounds < maxShrinkRounds && !c.isClosed() {
+		evicted := c.shrink(m.fraction)
+		ev.Rounds++
+		ev.Evicted += evicted
+		if evicted == 0 {
+			break
+		}
+		if m.read == nil {
+			// HeapAlloc only drops once the evicted entries are collected
+			runtime.GC()
+		}
+		heap = read()
+	}
+	ev.HeapAfter = heap
+	if m.onShrink != nil {
+		m.onShrink(ev)
+	}
+}
+
+// shrink removes the expired entries of every shard and evicts fraction of
+// the remaining ones, rounded up, from the tail of each shard's eviction
+// order. It returns how many entries were removed.
+func (c *Cache) shrink(fraction float64) int {
+	removed := 0
+	now := c.now()
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		before := len(shard.data)
+		for len(shard.expiry) > 0 && shard.expiry[0].expired(now) {
+			item := shard.expiry[0]
+			key, val, flags := item.key, shard.value(item), item.flags
+			shard.removeItem(key, item)
+			shard.stats.cleanupRemovals.Add(1)
+			c.notifyRemoved(OpExpire, key, val, flags)
+		}
+		n := int(math.Ceil(float64(len(shard.data)) * fraction))
+		for ; n > 0; n-- {
+			victim := shard.policy.victim()
+			if victim == nil {
+				break
+			}
+			c.evictLockedItem(shard, victim)
+		}
+		removed += before - len(shard.data)
+		shard.mu.Unlock()
+	}
+	return removed
+}
+
+// memWatcher returns the memory watcher settings, creating them with their
+// defaults for the first option that touches them.
+func (c *Cache) memWatcher() *memWatcher {
+	if c.mem == nil {
+		c.mem = &memWatcher{fraction: defaultShrinkFraction}
+	}
+	return c.mem
+}
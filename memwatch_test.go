@@ -0,0 +1,98 @@
+package hoard
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing that the watcher shrinks an over-limit cache from the LRU tail
+// down to the low watermark
+func TestMemoryWatcher(t *testing.T) {
+	// Pretend every entry takes 1000 bytes of heap
+	var target atomic.Pointer[Cache]
+	heap := func() uint64 {
+		if c := target.Load(); c != nil {
+			return uint64(c.Len()) * 1000
+		}
+		return 0
+	}
+	events := make(chan ShrinkEvent, 16)
+	cache := NewCache(1, 1000, time.Minute,
+		WithMemoryWatcher(80_000, 5*time.Millisecond),
+		WithShrinkPolicy(0.1, 40_000),
+		WithMemoryReader(heap),
+		WithShrinkHandler(func(ev ShrinkEvent) { events <- ev }))
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	// Keep the oldest key hot so it survives
+	cache.FetchData("key0")
+	target.Store(cache)
+
+	var ev ShrinkEvent
+	select {
+	case ev = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a shrink pass")
+	}
+	if ev.HeapBefore != 100_000 || ev.HeapAfter != 40_000 || ev.Evicted != 60 || ev.Rounds != 8 {
+		t.Fatalf("Unexpected shrink event %+v", ev)
+	}
+	if cache.Len() != 40 || !cache.Exists("key0") || cache.Exists("key1") || !cache.Exists("key99") {
+		t.Fatal("Expected the least recently used entries to be evicted")
+	}
+	if n := cache.Stats().Evictions; n != 60 {
+		t.Fatalf("Expected 60 evictions, got %d", n)
+	}
+}
+
+// testing that a shrink pass removes expired entries before evicting and
+// that nothing happens below the soft limit
+func TestMemoryWatcherExpiredFirst(t *testing.T) {
+	clock := newFakeClock()
+	var heap atomic.Uint64
+	cache := NewCache(1, 1000, time.Minute, WithClock(clock),
+		WithMemoryWatcher(1000, time.Hour),
+		WithShrinkPolicy(0.5, 0),
+		WithMemoryReader(heap.Load))
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("short"+strconv.Itoa(i), i, time.Second)
+		cache.Store("long"+strconv.Itoa(i), i, NoExpiration)
+	}
+	heap.Store(1000)
+	cache.checkMemory()
+	if n := cache.Len(); n != 20 {
+		t.Fatalf("Expected no shrink at the soft limit, got %d items", n)
+	}
+
+	// One round: the expired half goes first, then half of the rest
+	clock.Advance(time.Minute)
+	heap.Store(2000)
+	cache.shrink(0.5)
+	stats := cache.Stats()
+	if stats.CleanupRemovals != 10 || stats.Evictions != 5 || cache.Len() != 5 {
+		t.Fatalf("Expected 10 expired and 5 evicted entries, got %+v", stats)
+	}
+}
+
+// testing the watcher settings
+func TestMemoryWatcherOptions(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithMemoryWatcher(0, time.Second)},
+		{WithMemoryWatcher(1000, 0)},
+		{WithMemoryWatcher(1000, time.Second), WithShrinkPolicy(0, 0)},
+		{WithMemoryWatcher(1000, time.Second), WithShrinkPolicy(1.5, 0)},
+		{WithMemoryWatcher(1000, time.Second), WithShrinkPolicy(0.1, 2000)},
+		{WithShrinkPolicy(0.1, 0)},
+	} {
+		if _, err := NewCacheWithOptions(opts...); err == nil {
+			t.Fatalf("Expected an error for %d options", len(opts))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package hoard
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// MergePolicy decides which entry Merge keeps when a key exists in both
+// caches.
+type MergePolicy int
+
+const (
+	// KeepExisting keeps the destination's live entry.
+	KeepExisting MergePolicy = iota
+	// Overwrite replaces the destination's entry with the source's.
+	Overwrite
+	// KeepNewerExpiration keeps the entry that expires later, an entry that
+	// never expires being the newest. Ties keep the destination's entry.
+	KeepNewerExpiration
+)
+
+func (p MergePolicy) String() string {
+	switch p {
+	case KeepExisting:
+		return "KeepExisting"
+	case Overwrite:
+		return "Overwrite"
+	case KeepNewerExpiration:
+		return "KeepNewerExpiration"
+	}
+	return fmt.Sprintf("MergePolicy(%d)", int(p))
+}
+
+// Merge copies the live entries of src into c, resolving keys present in
+// both with policy. Values and expirations are copied as stored, without
+// deserializing them, so both caches must use the same serializer; values
+// are only recompressed or re-encrypted when the caches are configured
+// differently. Tags and pins are not copied.
+//
+// src is read one shard at a time under its read lock and stays usable:
+// writes made to it during the merge may or may not be included. Entries are
+// stored into c like StoreMany stores them, evicting as c's limits require,
+// so merging a source larger than c leaves c full with the entries merged
+// last. Entries that cannot be stored are skipped and reported in the
+// returned error. Merging a cache into itself does nothing.
+func (c *Cache) Merge(src *Cache, policy MergePolicy) error {
+	if c.isClosed() || src.isClosed() {
+		return ErrCacheClosed
+	}
+	if src == c {
+		return nil
+	}
+	type pending struct {
+		key   string
+		val   []byte
+		exp   int64
+		flags byte
+		cost  int64
+	}
+	same := c.sameEncoding(src)
+	var failed int
+	var first error
+	fail := func(key string, err error) {
+		if failed == 0 {
+			first = fmt.Errorf("%q: %w", key, err)
+		}
+		failed++
+	}
+
+	groups := make([][]pending, c.numShards)
+	for _, shard := range src.shards {
+		now := src.now()
+		var entries []pending
+		shard.mu.RLock()
+		for key, item := range shard.data {
+			if !item.expired(now) {
+				entries = append(entries, pending{key, bytes.Clone(shard.value(item)), item.Expiration, item.flags, item.cost})
+			}
+		}
+		shard.mu.RUnlock()
+
+		for i := range groups {
+			groups[i] = groups[i][:0]
+		}
+		for _, e := range entries {
+			if err := c.checkKey(e.key); err != nil {
+				fail(e.key, err)
+				continue
+			}
+			if !same || c.maxValueSize > 0 {
+				v, err := src.unpack(itemView{value: e.val, flags: e.flags})
+				if err == nil {
+					err = c.checkValue(e.key, v.value)
+				}
+				if err == nil && !same {
+					e.val, e.flags, err = c.pack(v.value, v.flags)
+				}
+				if err != nil {
+					fail(e.key, err)
+					continue
+				}
+			}
+			idx := c.shardIndex(e.key)
+			groups[idx] = append(groups[idx], e)
+		}
+
+		for idx, group := range groups {
+			if len(group) == 0 {
+				continue
+			}
+			dst := c.shards[idx]
+			dst.mu.Lock()
+			now := c.now()
+			for _, e := range group {
+				if err := c.mergeLocked(dst, e.key, e.val, e.exp, e.flags, e.cost, policy, now); err != nil {
+					fail(e.key, err)
+				}
+			}
+			dst.mu.unlockSaves(fail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("hoard: merge skipped %d entries, first %w", failed, first)
+	}
+	return nil
+}
+
+// mergeLocked stores an entry into shard unless policy keeps the live entry
+// already there. The caller must hold shard.mu for writing.
+func (c *Cache) mergeLocked(shard *CacheShard, key string, val []byte, exp int64, flags byte, cost int64, policy MergePolicy, now int64) error {
+	if existing, ok := shard.data[key]; ok && !existing.expired(now) && !policy.replaces(existing.Expiration, exp) {
+		return nil
+	}
+	return c.storeLocked(shard, key, pendingStore{stored: val, flags: flags, exp: exp, cost: cost})
+}
+
+// replaces reports whether p lets an entry expiring at incoming replace a
+// live one expiring at existing.
+func (p MergePolicy) replaces(existing, incoming int64) bool {
+	switch p {
+	case Overwrite:
+		return true
+	case KeepNewerExpiration:
+		return existing != 0 && (incoming == 0 || incoming > existing)
+	}
+	return false
+}
+
+// sameEncoding reports whether values stored by src can be stored by c as
+// they are, without unpacking and packing them again.
+func (c *Cache) sameEncoding(src *Cache) bool {
+	return sameCodec(c.codec, src.codec) && bytes.Equal(c.encryptionKey, src.encryptionKey)
+}
+
+// sameCodec reports whether a and b are known to be the same codec. Codecs
+// of types that cannot be compared are assumed to differ.
+func sameCodec(a, b Codec) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
@@ -0,0 +1,137 @@
+package hoard
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testing each policy on keys present in both caches
+func TestMerge(t *testing.T) {
+	clock := newFakeClock()
+	cases := []struct {
+		policy MergePolicy
+		want   map[string]string
+	}{
+		{KeepExisting, map[string]string{"both": "dst", "longer": "dst", "forever": "dst", "forever2": "dst"}},
+		{Overwrite, map[string]string{"both": "src", "longer": "src", "forever": "src", "forever2": "src"}},
+		{KeepNewerExpiration, map[string]string{"both": "dst", "longer": "src", "forever": "src", "forever2": "dst"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			src := NewCache(4, 100, 0, WithClock(clock))
+			defer src.Close()
+			dst := NewCache(2, 100, 0, WithClock(clock))
+			defer dst.Close()
+
+			src.Store("new", "src", NoExpiration)
+			src.Store("both", "src", time.Minute)
+			dst.Store("both", "dst", time.Minute)
+			src.Store("longer", "src", time.Hour)
+			dst.Store("longer", "dst", time.Minute)
+			src.Store("forever", "src", NoExpiration)
+			dst.Store("forever", "dst", time.Hour)
+			src.Store("forever2", "src", time.Hour)
+			dst.Store("forever2", "dst", NoExpiration)
+
+			if err := dst.Merge(src, tc.policy); err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+			if v, _, _ := dst.FetchData("new"); v != "src" {
+				t.Fatalf("Expected the new key to be merged, got %v", v)
+			}
+			for key, want := range tc.want {
+				if v, _, _ := dst.FetchData(key); v != want {
+					t.Errorf("Expected %s to hold %s, got %v", key, want, v)
+				}
+			}
+			if src.Len() != 5 {
+				t.Fatal("Expected the source to be left alone")
+			}
+		})
+	}
+}
+
+// testing that merged entries keep their expiration and expired ones are
+// left out
+func TestMergeExpiration(t *testing.T) {
+	clock := newFakeClock()
+	src := NewCache(1, 100, 0, WithClock(clock))
+	defer src.Close()
+	dst := NewCache(1, 100, 0, WithClock(clock))
+	defer dst.Close()
+
+	src.Store("short", 1, time.Minute)
+	src.Store("gone", 2, time.Second)
+	clock.Advance(2 * time.Second)
+	if err := dst.Merge(src, Overwrite); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if dst.Exists("gone") || !dst.Exists("short") {
+		t.Fatal("Expected only the live entry to be merged")
+	}
+	clock.Advance(time.Minute)
+	if dst.Exists("short") {
+		t.Fatal("Expected the merged entry to keep its expiration")
+	}
+}
+
+// testing that a source larger than the destination leaves it full, not
+// over capacity
+func TestMergeLargerSource(t *testing.T) {
+	src := NewCache(4, 1000, 0)
+	defer src.Close()
+	dst := NewCache(2, 10, 0)
+	defer dst.Close()
+
+	for i := 0; i < 500; i++ {
+		src.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	if err := dst.Merge(src, Overwrite); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if n := dst.Len(); n != 20 {
+		t.Fatalf("Expected the destination to be full with 20 entries, got %d", n)
+	}
+	if n := dst.Stats().Evictions; n != 480 {
+		t.Fatalf("Expected 480 evictions, got %d", n)
+	}
+	for _, key := range dst.Keys() {
+		if v, ok, err := dst.FetchData(key); !ok || err != nil || v != mustFetch(t, src, key) {
+			t.Fatalf("Expected %s to match the source, got %v", key, v)
+		}
+	}
+}
+
+// testing that values move between caches configured with different
+// compression and encryption
+func TestMergeReencodes(t *testing.T) {
+	src := NewCache(1, 100, 0, WithCompression(NewSnappyCodec(), 0), WithEncryption(testKey))
+	defer src.Close()
+	dst := NewCache(1, 100, 0, WithEncryption(otherKey), WithMaxValueSize(100))
+	defer dst.Close()
+
+	src.Store("small", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", NoExpiration)
+	src.StoreBytes("large", make([]byte, 200), NoExpiration)
+	err := dst.Merge(src, Overwrite)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Expected the large value to be reported, got %v", err)
+	}
+	if v, _, err := dst.FetchData("small"); err != nil || v != "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" {
+		t.Fatalf("Expected the value readable with the destination's key, got %v, %v", v, err)
+	}
+	if dst.Exists("large") {
+		t.Fatal("Expected the large value to be skipped")
+	}
+}
+
+// mustFetch returns the value of key, failing the test if it is missing.
+func mustFetch(t *testing.T, c *Cache, key string) interface{} {
+	t.Helper()
+	v, ok, err := c.FetchData(key)
+	if !ok || err != nil {
+		t.Fatalf("Expected %s to be present, got %v", key, err)
+	}
+	return v
+}
@@ -0,0 +1,187 @@
+package hoard
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// ItemInfo describes an entry without its value. CreatedAt, LastAccess and
+// Hits are only recorded with WithAccessMetadata and are zero otherwise.
+type ItemInfo struct {
+	CreatedAt  time.Time // when the value was last stored
+	LastAccess time.Time // last hit, zero if never read
+	Hits       uint64    // hits since the value was stored
+	ExpiresAt  time.Time // zero if the entry never expires
+	Size       int       // length of the stored bytes
+}
+
+// recordHit counts a hit on item and updates its access metadata. It only
+// uses atomics, so a read lock on s is enough.
+func (s *CacheShard) recordHit(item *CacheItem, now int64) {
+	if item.flags&itemNegative != 0 {
+		s.stats.negativeHits.Add(1)
+	} else {
+		s.stats.hits.Add(1)
+	}
+	if s.stamp {
+		item.lastUsed.Store(now)
+	}
+	if s.meta {
+		item.accessed.Store(now)
+		item.hits.Add(1)
+	}
+}
+
+// resetMeta restarts the access metadata of item when a value is stored. The
+// caller must hold s.mu for writing.
+func (s *CacheShard) resetMeta(item *CacheItem, now int64) {
+	if s.meta {
+		item.created = now
+		item.accessed.Store(0)
+		item.hits.Store(0)
+	}
+}
+
+func (item *CacheItem) info() ItemInfo {
+	info := ItemInfo{Hits: item.hits.Load(), Size: int(item.arenaLen) + len(item.Value)}
+	if item.created != 0 {
+		info.CreatedAt = time.Unix(0, item.created)
+	}
+	if at := item.accessed.Load(); at != 0 {
+		info.LastAccess = time.Unix(0, at)
+	}
+	if item.Expiration != 0 {
+		info.ExpiresAt = time.Unix(0, item.Expiration)
+	}
+	return info
+}
+
+// Metadata returns a description of the live entry stored under key. It does
+// not count as an access.
+func (c *Cache) Metadata(key string) (ItemInfo, bool) {
+	if c.isClosed() {
+		return ItemInfo{}, false
+	}
+	shard := c.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	item, ok := shard.data[key]
+	if !ok || item.expired(c.now()) {
+		return ItemInfo{}, false
+	}
+	return item.info(), true
+}
+
+// IterateInfo calls fn with the description of every live entry, like
+// Metadata, until fn returns false. Shards are visited one at a time on the
+// caller's goroutine and no lock is held while fn runs.
+func (c *Cache) IterateInfo(fn func(key string, info ItemInfo) bool) {
+	type entry struct {
+		key  string
+		info ItemInfo
+	}
+	var buf []entry
+	for _, shard := range c.shards {
+		now := c.now()
+		buf = buf[:0]
+		shard.mu.RLock()
+		for key, item := range shard.data {
+			if !item.expired(now) {
+				buf = append(buf, entry{key, item.info()})
+			}
+		}
+		shard.mu.RUnlock()
+		for _, e := range buf {
+			if !fn(e.key, e.info) {
+				return
+			}
+		}
+	}
+}
+
+// Item is an entry as returned by Items: its value along with the
+// description Metadata gives.
+type Item struct {
+	ItemInfo
+	// Value is the deserialized value, or a copy of the stored bytes if it
+	// could not be decoded, in which case Err tells why.
+	Value interface{}
+	Err   error
+	// Rank is the position of the entry in its shard's eviction order, 0
+	// for the next entry to be evicted. It is -1 for pinned entries and
+	// under LFU and ApproxLRU, which keep no such order.
+	Rank int
+}
+
+// Items returns every live entry with its value, for debugging. Each shard is
+// copied under its read lock and decoded after the lock is released, so the
+// result is owned by the caller but is not an atomic snapshot under
+// concurrent writes. Reading entries does not count as an access. On a large
+// cache prefer ItemsWithPrefix.
+func (c *Cache) Items() map[string]Item {
+	return c.ItemsWithPrefix("", 0)
+}
+
+// ItemsWithPrefix is like Items but only returns entries whose key starts
+// with prefix, and at most limit of them if limit is positive.
+func (c *Cache) ItemsWithPrefix(prefix string, limit int) map[string]Item {
+	type entry struct {
+		key  string
+		view itemView
+		item Item
+	}
+	items := make(map[string]Item)
+	var buf []entry
+	for _, shard := range c.shards {
+		if limit > 0 && len(items) >= limit {
+			break
+		}
+		now := c.now()
+		buf = buf[:0]
+		shard.mu.RLock()
+		ranks := shard.ranks(now)
+		for key, item := range shard.data {
+			if !strings.HasPrefix(key, prefix) || item.expired(now) {
+				continue
+			}
+			rank, ok := ranks[item]
+			if !ok {
+				rank = -1
+			}
+			buf = append(buf, entry{key, shard.viewOf(item), Item{ItemInfo: item.info(), Rank: rank}})
+		}
+		shard.mu.RUnlock()
+
+		for _, e := range buf {
+			if limit > 0 && len(items) >= limit {
+				break
+			}
+			e.item.Value, e.item.Err = c.decode(e.view)
+			if e.item.Err != nil {
+				e.item.Value = bytes.Clone(e.view.value)
+			}
+			items[e.key] = e.item
+		}
+	}
+	return items
+}
+
+// ranks maps the live items of a list based eviction policy to their
+// position, starting with the next victim. It returns nil for other
+// policies. The caller must hold s.mu.
+func (s *CacheShard) ranks(now int64) map[*CacheItem]int {
+	switch s.policy.(type) {
+	case *lruPolicy, *fifoPolicy, *slruPolicy:
+	default:
+		return nil
+	}
+	ranks := make(map[*CacheItem]int, len(s.data))
+	s.policy.walk(func(item *CacheItem) {
+		if !item.expired(now) {
+			ranks[item] = len(ranks)
+		}
+	})
+	return ranks
+}
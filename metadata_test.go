@@ -0,0 +1,202 @@
+package hoard
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testing that stores, hits and updates maintain the access metadata
+func TestMetadata(t *testing.T) {
+	clock := newFakeClock()
+	for _, policy := range []EvictionPolicy{LRU, FIFO, ApproxLRU} {
+		t.Run(policy.String(), func(t *testing.T) {
+			cache := NewCache(2, 10, 0, WithAccessMetadata(), WithClock(clock), WithEvictionPolicy(policy))
+			defer cache.Close()
+
+			created := clock.Now()
+			cache.Store("key", "value", time.Minute)
+			info, ok := cache.Metadata("key")
+			if !ok || !info.CreatedAt.Equal(created) || !info.LastAccess.IsZero() || info.Hits != 0 {
+				t.Fatalf("Expected a fresh entry, got %+v", info)
+			}
+			if !info.ExpiresAt.Equal(created.Add(time.Minute)) || info.Size == 0 {
+				t.Fatalf("Expected the deadline and size, got %+v", info)
+			}
+
+			clock.Advance(time.Second)
+			cache.FetchData("key")
+			clock.Advance(time.Second)
+			cache.FetchBytes("key")
+			cache.Peek("key") // not an access
+			info, _ = cache.Metadata("key")
+			if info.Hits != 2 || !info.LastAccess.Equal(clock.Now()) || !info.CreatedAt.Equal(created) {
+				t.Fatalf("Expected 2 hits, the last one now, got %+v", info)
+			}
+
+			// Update keeps the metadata, Store restarts it
+			cache.Update("key", "other", time.Minute)
+			if info, _ = cache.Metadata("key"); info.Hits != 2 {
+				t.Fatalf("Expected Update to keep the hits, got %+v", info)
+			}
+			cache.Store("key", "again", time.Minute)
+			if info, _ = cache.Metadata("key"); info.Hits != 0 || !info.CreatedAt.Equal(clock.Now()) || !info.LastAccess.IsZero() {
+				t.Fatalf("Expected Store to restart the metadata, got %+v", info)
+			}
+
+			if _, ok := cache.Metadata("missing"); ok {
+				t.Fatal("Expected no metadata for a missing key")
+			}
+		})
+	}
+}
+
+// testing that metadata is not recorded without the option
+func TestMetadataDisabled(t *testing.T) {
+	cache := NewCache(1, 10, 0)
+	defer cache.Close()
+	cache.Store("key", "value", NoExpiration)
+	cache.FetchData("key")
+	info, ok := cache.Metadata("key")
+	if !ok || !info.CreatedAt.IsZero() || info.Hits != 0 || !info.ExpiresAt.IsZero() {
+		t.Fatalf("Expected only the size, got %+v", info)
+	}
+}
+
+// testing that IterateInfo visits every live entry
+func TestIterateInfo(t *testing.T) {
+	cache := NewCache(4, 10, 0, WithAccessMetadata())
+	defer cache.Close()
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+	cache.FetchData("a")
+
+	seen := make(map[string]ItemInfo)
+	cache.IterateInfo(func(key string, info ItemInfo) bool {
+		seen[key] = info
+		return true
+	})
+	if len(seen) != 2 || seen["a"].Hits != 1 || seen["b"].Hits != 0 {
+		t.Fatalf("Expected both entries with their hits, got %+v", seen)
+	}
+
+	n := 0
+	cache.IterateInfo(func(string, ItemInfo) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("Expected iteration to stop, got %d calls", n)
+	}
+}
+
+// testing that Items returns decoded live entries with their rank
+func TestItems(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock), WithAccessMetadata())
+	defer cache.Close()
+	cache.Store("a", "one", time.Minute)
+	cache.Store("b", []int{1, 2}, NoExpiration)
+	cache.StoreBytes("raw", []byte("bytes"), NoExpiration)
+	cache.Store("gone", 1, time.Second)
+	cache.FetchData("a")
+	clock.Advance(2 * time.Second)
+
+	items := cache.Items()
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 live entries, got %v", items)
+	}
+	a := items["a"]
+	if a.Value != "one" || a.Err != nil || a.Hits != 1 || !a.ExpiresAt.Equal(clock.Now().Add(58*time.Second)) {
+		t.Fatalf("Unexpected item %+v", a)
+	}
+	// Least recently used first
+	if items["b"].Rank != 0 || items["raw"].Rank != 1 || a.Rank != 2 {
+		t.Fatalf("Expected ranks in LRU order, got %d %d %d", items["b"].Rank, items["raw"].Rank, a.Rank)
+	}
+	if a.Hits != 1 || cache.Stats().Hits != 1 {
+		t.Fatal("Expected Items not to count as an access")
+	}
+
+	// The result is a copy
+	items["b"].Value.([]interface{})[0] = 42
+	items["raw"].Value.([]byte)[0] = 'X'
+	delete(items, "a")
+	if v, _, _ := cache.FetchData("b"); !reflect.DeepEqual(v, []interface{}{int8(1), int8(2)}) {
+		t.Fatalf("Expected the cached value untouched, got %v", v)
+	}
+	if b, _ := cache.FetchBytes("raw"); string(b) != "bytes" || !cache.Exists("a") {
+		t.Fatal("Expected the cache untouched")
+	}
+}
+
+// testing that entries that fail to decode come back as stored bytes and
+// that the prefix and limit bound the result
+func TestItemsWithPrefix(t *testing.T) {
+	cache := NewCache(4, 100, 0)
+	defer cache.Close()
+	for i := 0; i < 20; i++ {
+		cache.Store(fmt.Sprintf("user:%d", i), i, NoExpiration)
+	}
+	cache.Store("other", 1, NoExpiration)
+	cache.Store("user:bad", "x", NoExpiration)
+	shard := cache.getShard("user:bad")
+	shard.data["user:bad"].Value = []byte{0xc1} // never used by msgpack
+
+	if items := cache.ItemsWithPrefix("user:", 0); len(items) != 21 {
+		t.Fatalf("Expected 21 entries, got %d", len(items))
+	} else if bad := items["user:bad"]; bad.Err == nil || !bytes.Equal(bad.Value.([]byte), []byte{0xc1}) {
+		t.Fatalf("Expected the stored bytes and the error, got %+v", bad)
+	}
+	if items := cache.ItemsWithPrefix("user:", 5); len(items) != 5 {
+		t.Fatalf("Expected 5 entries, got %d", len(items))
+	}
+}
+
+// testing that releaseItem clears every field, so recycled items never leak
+// metadata or bookkeeping into the entry that reuses them
+func TestReleaseItemClearsFields(t *testing.T) {
+	item := &CacheItem{
+		Value:      []byte("value"),
+		Expiration: 1,
+		arenaSlab:  1,
+		arenaOff:   1,
+		arenaLen:   1,
+		key:        "key",
+		flags:      itemRaw,
+		sum:        1,
+		cost:       1,
+		ttl:        time.Second,
+		tags:       []string{"tag"},
+		pinned:     true,
+		sliding:    true,
+		freq:       1,
+		prev:       &CacheItem{},
+		next:       &CacheItem{},
+		lastAccess: 1,
+		heapIndex:  1,
+		expIndex:   1,
+		protected:  true,
+		created:    1,
+	}
+	item.lastUsed.Store(1)
+	item.accessed.Store(1)
+	item.hits.Store(1)
+
+	// Fail when a field is added without being set above
+	v := reflect.ValueOf(item).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Fatalf("Expected the test to set %s", v.Type().Field(i).Name)
+		}
+	}
+
+	releaseItem(item)
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsZero() {
+			t.Errorf("Expected releaseItem to clear %s", v.Type().Field(i).Name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package metrics exports the Stats of a hoard cache to Prometheus.
+//
+//	prometheus.MustRegister(metrics.NewCollector(cache))
+//
+// The collector reads Stats and ShardStats on every scrape and keeps no state
+// of its own, so ResetStats makes its counters start over, which Prometheus
+// treats as a counter reset. Caches created with WithLatencyTracking also
+// export the duration of each operation as a histogram.
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/mrkouhadi/hoard"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector publishing the Stats of a cache.
+type Collector struct {
+	cache *hoard.Cache
+
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	expirations *prometheus.Desc
+	stores      *prometheus.Desc
+	updates     *prometheus.Desc
+	deletes     *prometheus.Desc
+	items       *prometheus.Desc
+	bytes       *prometheus.Desc
+	shardItems  *prometheus.Desc
+	latency     *prometheus.Desc
+}
+
+// NewCollector returns a Collector for cache. Every series carries
+// constLabels, which tell several caches registered in one process apart.
+func NewCollector(cache *hoard.Cache, constLabels prometheus.Labels) *Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("hoard", "cache", name), help, labels, constLabels)
+	}
+	return &Collector{
+		cache:       cache,
+		hits:        desc("hits_total", "Fetches that found a live entry."),
+		misses:      desc("misses_total", "Fetches that found nothing or an expired entry."),
+		evictions:   desc("evictions_total", "Entries evicted to make room."),
+		expirations: desc("expirations_total", "Expired entries removed on access or by cleanup."),
+		stores:      desc("stores_total", "Entries written by Store and its variants."),
+		updates:     desc("updates_total", "Successful Update calls."),
+		deletes:     desc("deletes_total", "Live entries removed by Delete and DeleteMany."),
+		items:       desc("items", "Entries in the cache, including expired ones not yet removed."),
+		bytes:       desc("bytes", "Estimated memory held by the entries."),
+		shardItems:  desc("shard_items", "Entries in each shard.", "shard"),
+		latency:     desc("operation_duration_seconds", "Duration of cache operations, with WithLatencyTracking.", "op"),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.stores
+	ch <- c.updates
+	ch <- c.deletes
+	ch <- c.items
+	ch <- c.bytes
+	ch <- c.shardItems
+	ch <- c.latency
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	shards := c.cache.ShardStats()
+	var total hoard.Stats
+	for i, s := range shards {
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+		total.Evictions += s.Evictions
+		total.Expired += s.Expired
+		total.CleanupRemovals += s.CleanupRemovals
+		total.Stores += s.Stores
+		total.Updates += s.Updates
+		total.Deletes += s.Deletes
+		total.ItemCount += s.ItemCount
+		total.Bytes += s.Bytes
+		ch <- prometheus.MustNewConstMetric(c.shardItems, prometheus.GaugeValue, float64(s.ItemCount), strconv.Itoa(i))
+	}
+
+	counter := func(d *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v))
+	}
+	counter(c.hits, total.Hits)
+	counter(c.misses, total.Misses)
+	counter(c.evictions, total.Evictions)
+	counter(c.expirations, total.Expired+total.CleanupRemovals)
+	counter(c.stores, total.Stores)
+	counter(c.updates, total.Updates)
+	counter(c.deletes, total.Deletes)
+	ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(total.ItemCount))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(total.Bytes))
+
+	if l := c.cache.Stats().Latency; l != nil {
+		histogram := func(op string, h hoard.LatencyHistogram) {
+			buckets := make(map[float64]uint64, len(h.Bounds))
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				buckets[bound.Seconds()] = cumulative
+			}
+			ch <- prometheus.MustNewConstHistogram(c.latency, h.Count, h.Sum.Seconds(), buckets, op)
+		}
+		histogram(hoard.OpNameStore, l.Store)
+		histogram(hoard.OpNameFetch, l.Fetch)
+		histogram(hoard.OpNameUpdate, l.Update)
+		histogram(hoard.OpNameDelete, l.Delete)
+		histogram(hoard.OpNameCleanup, l.Cleanup)
+	}
+}
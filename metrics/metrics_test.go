@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testing that a registered collector reports the cache's counters
+func TestCollector(t *testing.T) {
+	cache := hoard.NewCache(2, 2, 0, hoard.WithShardingFunc(func(key string, n int) int {
+		if strings.HasPrefix(key, "a") {
+			return 0
+		}
+		return 1
+	}))
+	defer cache.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(cache, prometheus.Labels{"cache": "test"}))
+
+	cache.Store("a1", 1, time.Minute)
+	cache.Store("a2", 2, time.Minute)
+	cache.Store("a3", 3, time.Minute) // evicts a1
+	cache.Store("b1", 4, time.Minute)
+	cache.FetchBytes("a3")
+	cache.FetchBytes("a1")
+	cache.Delete("b1")
+
+	expected := `
+# HELP hoard_cache_deletes_total Live entries removed by Delete and DeleteMany.
+# TYPE hoard_cache_deletes_total counter
+hoard_cache_deletes_total{cache="test"} 1
+# HELP hoard_cache_evictions_total Entries evicted to make room.
+# TYPE hoard_cache_evictions_total counter
+hoard_cache_evictions_total{cache="test"} 1
+# HELP hoard_cache_hits_total Fetches that found a live entry.
+# TYPE hoard_cache_hits_total counter
+hoard_cache_hits_total{cache="test"} 1
+# HELP hoard_cache_items Entries in the cache, including expired ones not yet removed.
+# TYPE hoard_cache_items gauge
+hoard_cache_items{cache="test"} 2
+# HELP hoard_cache_misses_total Fetches that found nothing or an expired entry.
+# TYPE hoard_cache_misses_total counter
+hoard_cache_misses_total{cache="test"} 1
+# HELP hoard_cache_shard_items Entries in each shard.
+# TYPE hoard_cache_shard_items gauge
+hoard_cache_shard_items{cache="test",shard="0"} 2
+hoard_cache_shard_items{cache="test",shard="1"} 0
+# HELP hoard_cache_stores_total Entries written by Store and its variants.
+# TYPE hoard_cache_stores_total counter
+hoard_cache_stores_total{cache="test"} 4
+`
+	names := []string{
+		"hoard_cache_deletes_total", "hoard_cache_evictions_total", "hoard_cache_hits_total",
+		"hoard_cache_items", "hoard_cache_misses_total", "hoard_cache_shard_items", "hoard_cache_stores_total",
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), names...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values are read again on every scrape
+	cache.FetchBytes("a2")
+	if n := testutil.CollectAndCount(NewCollector(cache, nil)); n != 11 {
+		t.Fatalf("Expected 11 series, got %d", n)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP hoard_cache_hits_total Fetches that found a live entry.
+# TYPE hoard_cache_hits_total counter
+hoard_cache_hits_total{cache="test"} 2
+`), "hoard_cache_hits_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testing that expirations count both lazy and cleanup removals
+func TestCollectorExpirations(t *testing.T) {
+	cache := hoard.NewCache(1, 10, 0)
+	defer cache.Close()
+	cache.Store("lazy", 1, time.Millisecond)
+	cache.Store("cleanup", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.FetchBytes("lazy")
+	cache.RunCleanup()
+
+	if err := testutil.CollectAndCompare(NewCollector(cache, nil), strings.NewReader(`
+# HELP hoard_cache_expirations_total Expired entries removed on access or by cleanup.
+# TYPE hoard_cache_expirations_total counter
+hoard_cache_expirations_total 2
+`), "hoard_cache_expirations_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testing that caches tracking latency export a histogram per operation
+func TestCollectorLatency(t *testing.T) {
+	cache := hoard.NewCache(2, 10, 0, hoard.WithLatencyTracking())
+	defer cache.Close()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(cache, nil))
+
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+	cache.FetchBytes("a")
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for _, f := range families {
+		if f.GetName() != "hoard_cache_operation_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			h := m.GetHistogram()
+			if n := len(h.GetBucket()); n != 11 {
+				t.Fatalf("Expected 11 buckets, got %d", n)
+			}
+			counts[m.GetLabel()[0].GetValue()] = h.GetSampleCount()
+		}
+	}
+	want := map[string]uint64{"Store": 2, "Fetch": 1, "Update": 0, "Delete": 0, "Cleanup": 0}
+	if len(counts) != len(want) {
+		t.Fatalf("Expected a histogram per operation, got %v", counts)
+	}
+	for op, n := range want {
+		if counts[op] != n {
+			t.Fatalf("Expected %d %s operations, got %v", n, op, counts)
+		}
+	}
+
+	// Caches without tracking export no histograms
+	plain := hoard.NewCache(2, 10, 0)
+	defer plain.Close()
+	if n := testutil.CollectAndCount(NewCollector(plain, nil), "hoard_cache_operation_duration_seconds"); n != 0 {
+		t.Fatalf("Expected no histograms, got %d", n)
+	}
+}
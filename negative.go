@@ -0,0 +1,32 @@
+package hoard
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNegativeEntry is returned by FetchData, FetchInto, Peek, GetOrStore and
+// the other decoding reads when key holds a negative entry written by
+// StoreNegative. They report the key as found, so loaders are not called.
+var ErrNegativeEntry = errors.New("hoard: key is known to be missing")
+
+// StoreNegative records that key is known to be missing for ttl, so lookups
+// of keys the source of truth does not have stop reaching it. Decoding reads
+// report such an entry with ErrNegativeEntry, while Exists and byte reads
+// such as FetchBytes report a miss, and hits on it are counted in
+// Stats.NegativeHits rather than Stats.Hits. Storing a value replaces the
+// marker; Increment starts a new counter over it. The marker is not written
+// to the backend.
+func (c *Cache) StoreNegative(key string, ttl time.Duration) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if err := c.checkKey(key); err != nil {
+		return err
+	}
+	shard := c.getShard(key)
+	p := pendingStore{stored: []byte{}, flags: itemNegative, exp: c.expiration(ttl), cost: 1}
+
+	shard.mu.Lock()
+	return shard.mu.unlockErr(c.storeLocked(shard, key, p))
+}
@@ -0,0 +1,109 @@
+package hoard
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testing that a negative entry is reported distinctly and replaced by a value
+func TestStoreNegative(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock))
+	defer cache.Close()
+
+	if err := cache.StoreNegative("user:42", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := cache.FetchData("user:42"); !ok || !errors.Is(err, ErrNegativeEntry) {
+		t.Fatalf("Expected a negative entry, got %v, %v", ok, err)
+	}
+	var dest string
+	if ok, err := cache.FetchInto("user:42", &dest); !ok || !errors.Is(err, ErrNegativeEntry) {
+		t.Fatalf("Expected FetchInto to report the negative entry, got %v, %v", ok, err)
+	}
+	if _, ok := cache.FetchBytes("user:42"); ok {
+		t.Fatal("Expected FetchBytes to report a miss")
+	}
+	if cache.Exists("user:42") {
+		t.Fatal("Expected Exists to report a miss")
+	}
+	found, missing := cache.FetchBytesMany([]string{"user:42"})
+	if len(found) != 0 || len(missing) != 1 {
+		t.Fatalf("Expected the negative entry among the missing, got %v, %v", found, missing)
+	}
+	if _, _, err := cache.FetchMany([]string{"user:42"}); !errors.Is(err, ErrNegativeEntry) {
+		t.Fatalf("Expected FetchMany to report ErrNegativeEntry, got %v", err)
+	}
+
+	stats := cache.Stats()
+	if stats.NegativeHits != 5 || stats.Hits != 0 {
+		t.Fatalf("Expected 5 negative hits and no hits, got %+v", stats)
+	}
+
+	// A real value replaces the marker
+	cache.Store("user:42", "Aboubakr", time.Minute)
+	if v, ok, err := cache.FetchData("user:42"); !ok || err != nil || v != "Aboubakr" {
+		t.Fatalf("Expected the stored value, got %v, %v, %v", v, ok, err)
+	}
+	if !cache.Exists("user:42") {
+		t.Fatal("Expected Exists to report the stored value")
+	}
+
+	// And the marker expires like any entry
+	cache.StoreNegative("user:43", time.Second)
+	clock.Advance(2 * time.Second)
+	if _, ok, err := cache.FetchData("user:43"); ok || err != nil {
+		t.Fatalf("Expected the marker to expire, got %v, %v", ok, err)
+	}
+}
+
+// testing that fresh negative entries keep loaders from running
+func TestNegativeSkipsLoaders(t *testing.T) {
+	loads := 0
+	cache := NewCache(1, 10, 0, WithLoader(func(key string) (interface{}, time.Duration, error) {
+		loads++
+		return "loaded", time.Minute, nil
+	}))
+	defer cache.Close()
+
+	cache.StoreNegative("missing", time.Minute)
+	if _, _, err := cache.FetchData("missing"); !errors.Is(err, ErrNegativeEntry) {
+		t.Fatalf("Expected ErrNegativeEntry, got %v", err)
+	}
+	_, loaded, err := cache.GetOrStore("missing", time.Minute, func() (interface{}, error) {
+		loads++
+		return "stored", nil
+	})
+	if loaded || !errors.Is(err, ErrNegativeEntry) {
+		t.Fatalf("Expected GetOrStore to report the negative entry, got %v, %v", loaded, err)
+	}
+	if loads != 0 {
+		t.Fatalf("Expected no loader call, got %d", loads)
+	}
+}
+
+// testing that negative entries survive a snapshot and take counters
+func TestNegativeRoundTrip(t *testing.T) {
+	cache := NewCache(1, 10, 0)
+	defer cache.Close()
+	cache.StoreNegative("key", time.Minute)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewCache(1, 10, 0)
+	defer restored.Close()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := restored.FetchData("key"); !errors.Is(err, ErrNegativeEntry) {
+		t.Fatalf("Expected the marker to be restored, got %v", err)
+	}
+
+	if n, err := restored.Increment("key", 2, time.Minute); err != nil || n != 2 {
+		t.Fatalf("Expected a new counter over the marker, got %d, %v", n, err)
+	}
+}
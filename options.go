@@ -0,0 +1,574 @@
+package hoard
+
+import (
+	"context"
+	"hash"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Option configures optional behavior of a Cache at construction time.
+type Option func(*Cache)
+
+// WithShards sets the number of shards. Keys are spread over the shards by
+// hash, and each shard has its own lock and capacity. A power of two picks
+// shards slightly faster.
+func WithShards(n int) Option {
+	return func(c *Cache) {
+		c.numShards = n
+	}
+}
+
+// WithMaxItemsPerShard sets how many entries a shard holds before it evicts
+// the least recently used one.
+func WithMaxItemsPerShard(n int) Option {
+	return func(c *Cache) {
+		c.maxItemsPerShard = n
+	}
+}
+
+// WithAdmissionFilter guards full shards against keys that are written once
+// and never read. Each shard keeps a TinyLFU sketch of how often keys were
+// recently fetched or stored; when a new key would evict an entry, it is
+// only admitted if it was accessed more often than the eviction victim.
+// Otherwise the store leaves the cache unchanged, still returns nil, and is
+// counted in Stats.Rejected. Overwrites of existing keys are always
+// applied. The sketch takes about 16 bytes per entry of shard capacity,
+// rounded up to a power of two.
+func WithAdmissionFilter() Option {
+	return func(c *Cache) {
+		c.admission = true
+	}
+}
+
+// WithMaxItems caps the number of entries across all shards at n, on top of
+// the per-shard limit, so a skewed key distribution cannot push the total
+// past it. A store that would exceed it first evicts the next victim of the
+// most loaded shard, or of the shard being written when that one is busy.
+// Stats.ItemCount reports the live total. Zero, the default, means no
+// global cap.
+func WithMaxItems(n int) Option {
+	return func(c *Cache) {
+		c.maxItems = n
+	}
+}
+
+// WithMemoryWatcher samples the heap every interval and, when HeapAlloc
+// exceeds softLimit, shrinks the cache until the heap falls below a low
+// watermark, 90% of softLimit unless set with WithShrinkPolicy. Each round
+// removes the expired entries of every shard and evicts a fraction of the
+// rest from the tail of its eviction order, then forces a garbage
+// collection so the next sample sees the freed memory. A pass runs at most
+// 10 rounds; the next tick continues if the heap is still high. Passes never
+// overlap the cleanup goroutine's. The watcher stops on Close.
+func WithMemoryWatcher(softLimit uint64, interval time.Duration) Option {
+	return func(c *Cache) {
+		c.memWatcher().softLimit = softLimit
+		c.memWatcher().interval = interval
+	}
+}
+
+// WithShrinkPolicy sets the fraction of each shard the memory watcher evicts
+// per round, 0.1 by default, and the heap size below which it stops. A zero
+// lowWatermark keeps the default of 90% of the soft limit.
+func WithShrinkPolicy(fraction float64, lowWatermark uint64) Option {
+	return func(c *Cache) {
+		c.memWatcher().fraction = fraction
+		c.memWatcher().lowWatermark = lowWatermark
+	}
+}
+
+// WithMemoryReader replaces the heap sample taken by the memory watcher,
+// runtime.MemStats.HeapAlloc, with fn, for instance to watch a cgroup limit.
+// No garbage collection is forced between rounds then.
+func WithMemoryReader(fn func() uint64) Option {
+	return func(c *Cache) {
+		c.memWatcher().read = fn
+	}
+}
+
+// WithShrinkHandler calls fn after every pass of the memory watcher with what
+// it evicted. fn runs on the watcher goroutine.
+func WithShrinkHandler(fn func(ShrinkEvent)) Option {
+	return func(c *Cache) {
+		c.memWatcher().onShrink = fn
+	}
+}
+
+// WithInitialCapacity sizes each shard's map for n entries up front, at most
+// the shard's item limit, so warming up a cache does not rehash its maps as
+// they grow. The memory is taken whether or not the entries come, so the
+// default of zero suits sparse caches. StoreMany sizes an empty shard for its
+// batch either way.
+func WithInitialCapacity(n int) Option {
+	return func(c *Cache) {
+		c.initialCapacity = n
+	}
+}
+
+// WithMaxValueSize rejects writes whose serialized value, or raw value for
+// StoreBytes, is longer than n bytes with ErrValueTooLarge, leaving any
+// existing entry untouched. Values read through a Backend that exceed it are
+// returned but not cached. Zero, the default, means unlimited.
+func WithMaxValueSize(n int) Option {
+	return func(c *Cache) {
+		c.maxValueSize = n
+	}
+}
+
+// WithMaxKeyLength rejects writes of keys longer than n bytes with
+// ErrInvalidKey. Empty keys are always rejected. Zero, the default, means
+// unlimited.
+func WithMaxKeyLength(n int) Option {
+	return func(c *Cache) {
+		c.maxKeyLength = n
+	}
+}
+
+// WithMaxBytes bounds the estimated memory held by each shard. The estimate
+// of an entry is the length of its key and serialized value plus a fixed
+// per-entry overhead. After every write the shard evicts entries chosen by
+// the eviction policy until it fits, so one large value can evict several
+// small ones; a value that does not fit on its own is not kept. The item
+// limit set by WithMaxItemsPerShard still applies. Zero means no limit.
+func WithMaxBytes(perShard int64) Option {
+	return func(c *Cache) {
+		c.maxBytes = perShard
+	}
+}
+
+// WithMaxCostPerShard bounds the total cost of the entries in each shard.
+// Store charges a cost of 1 and StoreWithCost charges the given cost; Update
+// keeps the entry's cost. After every write the shard evicts entries chosen by
+// the eviction policy until the budget fits. Zero means no limit.
+func WithMaxCostPerShard(cost int64) Option {
+	return func(c *Cache) {
+		c.maxCost = cost
+	}
+}
+
+// WithCleanupInterval sets how often the background goroutine removes
+// expired entries. Zero disables background cleanup.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(c *Cache) {
+		c.cleanupInterval = d
+	}
+}
+
+// WithCleanupBatchSize caps how many expired entries a cleanup pass removes
+// from a shard per lock acquisition. The pass releases the lock, yields and
+// continues with the next batch, so readers and writers never wait for more
+// than one batch. Zero means no limit.
+func WithCleanupBatchSize(n int) Option {
+	return func(c *Cache) {
+		c.cleanupBatchSize = n
+	}
+}
+
+// WithCleanupTimeSlice caps how long a cleanup pass holds a shard's lock at
+// a time, like WithCleanupBatchSize but measured in time. Zero means no
+// limit.
+func WithCleanupTimeSlice(d time.Duration) Option {
+	return func(c *Cache) {
+		c.cleanupTimeSlice = d
+	}
+}
+
+// WithIterateWorkers sets how many goroutines Iterate and its variants use
+// to visit shards concurrently. The default is GOMAXPROCS; more workers than
+// shards are never started.
+func WithIterateWorkers(n int) Option {
+	return func(c *Cache) {
+		c.iterateWorkers = n
+	}
+}
+
+// WithHotKeyTracking makes the cache estimate which keys are fetched most
+// often, reported by HotKeys as the top n over the last window. Only a
+// sample of fetches is counted and at most a few times n keys per stripe are
+// tracked, so counts are estimates. A window of zero counts since the cache
+// was created.
+func WithHotKeyTracking(n int, window time.Duration) Option {
+	return func(c *Cache) {
+		c.hot = &hotKeys{n: n, window: window}
+	}
+}
+
+// WithAccessMetadata makes every entry record when it was stored, when it
+// was last hit and how many hits it had, as reported by Metadata and
+// IterateInfo. Storing a value restarts its metadata; Update and Touch keep
+// it. Hits are recorded with atomics and cost no extra locking.
+func WithAccessMetadata() Option {
+	return func(c *Cache) {
+		c.accessMeta = true
+	}
+}
+
+// WithDefaultTTL sets the ttl used by StoreDefault and by Store, StoreBytes,
+// StoreMany, Update and Touch when they are given a ttl <= 0 other than
+// NoExpiration. Pass NoExpiration to keep such entries forever.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// WithTTLJitter spreads expirations by perturbing every positive ttl given to
+// the cache by a random amount of up to ±fraction, e.g. 0.1 for ±10%, so keys
+// stored together do not all expire in the same cleanup pass. TTL and
+// ExpiresAt report the jittered deadline.
+func WithTTLJitter(fraction float64) Option {
+	return func(c *Cache) {
+		c.ttlJitter = fraction
+	}
+}
+
+// WithClock sets the Clock used for expiration, typically a fake one in
+// tests. The background cleanup still ticks in real time; call RunCleanup to
+// clean up after advancing a fake clock.
+func WithClock(clock Clock) Option {
+	return func(c *Cache) {
+		c.clock = clock
+	}
+}
+
+// WithHashFunc sets the hash used to pick a key's shard. The default is an
+// inlined 32-bit FNV-1a that places keys exactly like hash/fnv's New32a; a
+// custom hash costs a hasher allocation per operation.
+func WithHashFunc(fn func() hash.Hash32) Option {
+	return func(c *Cache) {
+		if fn == nil {
+			c.hash = nil
+			return
+		}
+		c.hash = func(key string) uint32 {
+			h := fn()
+			h.Write([]byte(key))
+			return h.Sum32()
+		}
+	}
+}
+
+// WithShardingFunc replaces hashing as the way keys are mapped to shards: fn
+// receives the key and the number of shards and returns an index in
+// [0, numShards). It suits keys the default hash spreads poorly, a 64-bit
+// hash or a scheme that keeps a tenant's keys together. fn must be
+// deterministic; an index out of range panics. It takes precedence over
+// WithHashFunc.
+func WithShardingFunc(fn func(key string, numShards int) int) Option {
+	return func(c *Cache) {
+		c.shardFn = fn
+	}
+}
+
+// WithEvictionPolicy selects how a full shard picks the entry to evict. The
+// default is LRU.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(c *Cache) {
+		c.evictionPolicy = p
+		c.policySet = true
+	}
+}
+
+// WithSegmentRatio sets the share of each shard reserved for probationary
+// entries under the SLRU policy; the rest is protected. The default is 0.2.
+func WithSegmentRatio(r float64) Option {
+	return func(c *Cache) {
+		c.segmentRatio = r
+	}
+}
+
+// WithLRUSamples sets how many randomly chosen entries the ApproxLRU policy
+// compares to pick a victim. More samples approximate LRU more closely at a
+// higher cost per eviction. The default is 5.
+func WithLRUSamples(n int) Option {
+	return func(c *Cache) {
+		c.lruSamples = n
+	}
+}
+
+// WithReadOptimizedShards makes lookups lock-free for read-mostly workloads.
+// Each shard keeps an immutable copy of its entries that FetchData,
+// FetchBytesData and the other Fetch lookups of a single key, as well as
+// Peek, Exists, TTL and ExpiresAt, read without taking the shard lock;
+// batch lookups such as FetchMany always take it. Any write to a shard drops
+// its copy; lookups then take the read lock as usual until as many of them
+// as a quarter of the shard's entries have, and the next one rebuilds the
+// copy. Writes stay as fast, but the copy holds a second index
+// of the entries and costs a rebuild after each burst of writes, so it only
+// pays off when writes are rare, say a periodic refresh of a cache read
+// constantly in between.
+//
+// Lookups only stamp entries in this mode, so it uses the ApproxLRU policy
+// unless FIFO is chosen with WithEvictionPolicy, in either order. Choosing
+// another policy or WithSlidingTTL is an error, and entries stored with
+// ItemOptions.Sliding make lookups take the lock again.
+func WithReadOptimizedShards() Option {
+	return func(c *Cache) {
+		c.readOptimized = true
+	}
+}
+
+// WithArenaStorage packs the serialized values of each shard into large
+// byte slabs instead of giving every entry a slice of its own, which cuts
+// the number of objects the garbage collector has to track with millions of
+// small entries. Replaced and removed values leave dead space behind; once
+// it takes up more than half a shard's slabs, the next write to the shard
+// copies the live values into fresh slabs. Lookups copy values out of the
+// slabs, so each hit allocates even where it would otherwise share the
+// stored bytes.
+func WithArenaStorage() Option {
+	return func(c *Cache) {
+		c.arenaStorage = true
+	}
+}
+
+// WithSlidingTTL makes every hit renew an entry's lifetime: reading it
+// resets its expiration to the ttl it was last stored, updated or touched
+// with, so entries only expire after that long without use. Exists, Peek,
+// TTL and iteration do not count as use, and renewals are not written to the
+// write log. With the FIFO and ApproxLRU policies, lookups then take a write
+// lock.
+func WithSlidingTTL() Option {
+	return func(c *Cache) {
+		c.sliding = true
+	}
+}
+
+// WithLoader registers a loader that FetchData calls on a miss. Concurrent
+// misses for the same key are coalesced into a single loader call whose
+// result is stored with the returned ttl and handed to every waiter. Loader
+// errors are returned to all waiters and are not cached.
+func WithLoader(loader func(key string) (interface{}, time.Duration, error)) Option {
+	return func(c *Cache) {
+		c.loader = func(_ context.Context, key string) (interface{}, time.Duration, error) {
+			return loader(key)
+		}
+	}
+}
+
+// WithLoaderCtx is like WithLoader but the loader also receives the context
+// passed to FetchCtx, or context.Background for FetchData and background
+// refreshes, so it can honor deadlines and carry tracing data.
+func WithLoaderCtx(loader func(ctx context.Context, key string) (interface{}, time.Duration, error)) Option {
+	return func(c *Cache) {
+		c.loader = loader
+	}
+}
+
+// WithRefreshAhead makes fetches that hit an entry past fraction of its
+// lifetime, e.g. 0.8, reload it in the background with the loader set by
+// WithLoader, so frequently read keys are replaced before they expire and
+// never miss. The old value is served until the new one is stored. Only one
+// refresh per key runs at a time; a failed refresh is reported to the error
+// handler and leaves the old value until it expires, and a key deleted in the
+// meantime stays deleted. Without a loader it has no effect.
+func WithRefreshAhead(fraction float64) Option {
+	return func(c *Cache) {
+		c.refreshAhead = fraction
+	}
+}
+
+// WithBackend puts the cache in front of a persistent store. FetchData
+// resolves a miss by loading the key from b and caching it, with concurrent
+// misses for the same key sharing one Load. Every write setting a value,
+// from Store to Increment, Rename and Txn, saves it to b, and if the save
+// fails it returns the error and drops the key from the cache; raw bytes and
+// counters are saved serialized. Every delete, from Delete to Pop and
+// InvalidateTag, deletes the key from b too and reports failures to the
+// error handler. Expirations, evictions, CleanupAll and CleanupShard only
+// change the cache. Backend failures are returned wrapped in ErrBackend, while a key b
+// does not have is a plain miss. b cannot be combined with WithLoader.
+//
+// Writes call b after releasing the shard lock, so a slow backend only
+// delays writers of the same key, never readers or the other keys of the
+// shard. The calls for a key are still made in the order the cache applied
+// the writes. Readers may see a value before b has it.
+func WithBackend(b Backend) Option {
+	return func(c *Cache) {
+		c.backend = b
+	}
+}
+
+// WithTier adds a second tier below memory: live entries evicted from a
+// shard are put into store instead of being dropped, and fetches that miss
+// in memory look in store and move hits back into memory. Entries keep their
+// expiration in store but not their cost, tags or pin. A key is held by one
+// tier at a time: storing it or removing it with Delete or DeleteMany also
+// removes it from store, while Pop, DeleteByPrefix and InvalidateTag only see
+// keys held in memory. store is called after the shard lock is released, in
+// the order the cache evicted, stored and deleted each key, like a backend
+// set with WithBackend.
+func WithTier(store TierStore) Option {
+	return func(c *Cache) {
+		c.tier = store
+	}
+}
+
+// WithWriteBehind makes the writes WithBackend passes to the backend
+// asynchronous: writes and deletes queue them and return, and workers
+// goroutines apply them. The queue holds up to queueSize writes, and full
+// decides what a write does when it is full. Writes to the same key reach
+// the backend in order. Close waits until every queued write is applied.
+// Failures go to the handler set with WithFlushErrorHandler.
+func WithWriteBehind(queueSize, workers int, full QueueFullPolicy) Option {
+	return func(c *Cache) {
+		c.wb = &writeBehind{full: full, closing: make(chan struct{})}
+		c.wbQueueSize = queueSize
+		c.wbWorkers = workers
+	}
+}
+
+// WithFlushErrorHandler registers fn to receive the key and error of every
+// write-behind write the backend fails or the full policy drops. Without it
+// these errors go to the handler set with WithErrorHandler. fn must be safe
+// for concurrent use.
+func WithFlushErrorHandler(fn func(key string, err error)) Option {
+	return func(c *Cache) {
+		c.onFlushError = fn
+	}
+}
+
+// WithInvalidationBus keeps several cache instances coherent: the local
+// writes selected by on publish their key on bus, and keys published by
+// other instances are deleted locally. Publishing is fire-and-forget from a
+// bounded queue; events that do not fit are dropped and reported to the
+// error handler, like failed publishes. Remote deletes are counted in
+// Stats.Invalidations and do not reach the backend.
+func WithInvalidationBus(bus InvalidationBus, on InvalidateOn) Option {
+	return func(c *Cache) {
+		c.bus = bus
+		c.invalidateOn = on
+	}
+}
+
+// WithAutoSnapshot makes the cache save itself to path every interval using
+// SaveFile, so the previous snapshot is only replaced by a complete one. The
+// snapshot goroutine stops when the cache is closed. Use WithErrorHandler to
+// observe failed snapshots.
+func WithAutoSnapshot(path string, interval time.Duration) Option {
+	return func(c *Cache) {
+		c.snapshotPath = path
+		c.snapshotInterval = interval
+	}
+}
+
+// WithLatencyTracking records how long Store, Fetch, Update and Delete calls
+// and background cleanup passes take in histograms exposed as
+// Stats.Latency. Durations are measured with the wall clock, whatever Clock
+// the cache uses. Without it operations are not timed at all.
+func WithLatencyTracking() Option {
+	return func(c *Cache) {
+		latencyTrackerOf(c).hists = new([len(latencyOps)]latencyHist)
+	}
+}
+
+// WithSlowOpThreshold calls fn with the operation, key and duration of every
+// operation timed as with WithLatencyTracking that takes d or longer. fn
+// runs on the goroutine of the operation once it has released its locks, so
+// it may use the cache, but it delays the caller; it must be safe for
+// concurrent use.
+func WithSlowOpThreshold(d time.Duration, fn func(op string, key string, took time.Duration)) Option {
+	return func(c *Cache) {
+		t := latencyTrackerOf(c)
+		t.slow, t.onSlow = d, fn
+	}
+}
+
+// WithLogger logs what the cache does in the background to l: evictions and
+// the number of entries each cleanup pass removed at debug level, snapshots
+// saved and loaded with SaveFile and LoadFile at info level, failed backend
+// loads and saves at warn level, and errors reported to the handler of
+// WithErrorHandler at error level. Fetch and Store are never logged on
+// their own. Without it nothing is logged and no log records are built.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Cache) {
+		c.logger = l
+	}
+}
+
+// WithErrorHandler registers fn to receive errors from background work such
+// as automatic snapshots and panics recovered in the cleanup goroutine, and
+// entries IterateValues fails to decode. fn must be safe for concurrent use.
+func WithErrorHandler(fn func(error)) Option {
+	return func(c *Cache) {
+		c.onError = fn
+	}
+}
+
+// WithWriteLog appends a record for every Store, Update, Touch, Delete and
+// CleanupAll to w, so writes made since the last snapshot can be recovered
+// with Replay. Records for the same key are written in the order the
+// operations were applied. Write errors are reported to the error handler.
+func WithWriteLog(w io.Writer) Option {
+	return func(c *Cache) {
+		if c.wal == nil {
+			c.wal = &writeLog{}
+		}
+		c.wal.w = w
+	}
+}
+
+// WithWriteLogRotation calls fn from a new goroutine once the write log
+// configured with WithWriteLog grows past threshold bytes. fn typically saves
+// a snapshot, truncates the log and calls ResetWriteLog, which re-arms the
+// hook.
+func WithWriteLogRotation(threshold int64, fn func(size int64)) Option {
+	return func(c *Cache) {
+		if c.wal == nil {
+			c.wal = &writeLog{}
+		}
+		c.wal.threshold = threshold
+		c.wal.onRotate = fn
+	}
+}
+
+// WithCompression compresses values whose stored form is longer than minSize
+// bytes with codec, trading CPU on every write and read of them for memory.
+// Values that do not shrink are stored as they are, and entries are
+// decompressed transparently on Fetch, FetchBytes, Iterate and friends, so
+// a cache may mix compressed and plain entries. Compressed entries keep
+// their form in snapshots, the write-ahead log and tiers, so a cache loading
+// them must use the same codec. Byte budgets count the compressed size.
+func WithCompression(codec Codec, minSize int) Option {
+	return func(c *Cache) {
+		c.codec = codec
+		c.compressMin = minSize
+	}
+}
+
+// WithEncryption encrypts stored values with AES-GCM under key, which must be
+// 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256. Every write
+// draws a random nonce, stored in front of the ciphertext. Values stay
+// encrypted in memory, snapshots, the write-ahead log and tiers and are
+// decrypted on Fetch, FetchBytes and Iterate; values that fail to decrypt,
+// because the key differs or the bytes were altered, surface as
+// ErrDecryption. Keys and values handed to a Backend are not encrypted.
+func WithEncryption(key []byte) Option {
+	return func(c *Cache) {
+		// Copy the key, non-nil even when empty so validate rejects it
+		c.encryptionKey = append([]byte{}, key...)
+	}
+}
+
+// WithChecksums records a CRC-32C checksum of every stored value and verifies
+// it on Fetch, FetchBytes and their batch variants, as a defense against
+// memory corruption elsewhere in the process. An entry that fails the check
+// is removed and counted in Stats.Corrupted; FetchData and friends return
+// ErrCorrupted for it, while lookups without an error result treat it as
+// missing and report ErrCorrupted to the error handler. Load skips snapshot
+// records that fail their checksum instead of aborting.
+func WithChecksums() Option {
+	return func(c *Cache) {
+		c.checksums = true
+	}
+}
+
+// WithSerializer sets the Serializer used to encode values on Store and
+// Update and decode them on Fetch. The default is msgpack.
+func WithSerializer(s Serializer) Option {
+	return func(c *Cache) {
+		c.serializer = s
+	}
+}
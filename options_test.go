@@ -0,0 +1,242 @@
+package hoard
+
+import (
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testing NewCacheWithOptions defaults and explicit settings
+func TestNewCacheWithOptions(t *testing.T) {
+	cache, err := NewCacheWithOptions()
+	if err != nil {
+		t.Fatalf("Expected default options to be valid, got %v", err)
+	}
+	if len(cache.shards) != defaultShards || cache.maxItemsPerShard != defaultMaxItemsPerShard {
+		t.Errorf("Expected default sizing, got %d shards of %d", len(cache.shards), cache.maxItemsPerShard)
+	}
+	cache.Close()
+
+	cache, err = NewCacheWithOptions(WithShards(3), WithMaxItemsPerShard(2), WithCleanupInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for i := 0; i < 50; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	if lens := cache.ShardLens(); len(lens) != 3 || cache.Len() != 6 {
+		t.Errorf("Expected 3 full shards of 2, got %v", lens)
+	}
+}
+
+// testing that invalid settings are reported instead of panicking
+func TestNewCacheWithOptionsInvalid(t *testing.T) {
+	tests := map[string][]Option{
+		"shards":   {WithShards(0)},
+		"items":    {WithMaxItemsPerShard(-1)},
+		"interval": {WithCleanupInterval(-time.Second)},
+		"ttl":      {WithDefaultTTL(-time.Second)},
+		"hash":     {WithHashFunc(nil)},
+	}
+	for name, opts := range tests {
+		if cache, err := NewCacheWithOptions(opts...); err == nil {
+			cache.Close()
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// testing that the default TTL applies to ttl <= 0 but not to NoExpiration
+func TestDefaultTTL(t *testing.T) {
+	cache, err := NewCacheWithOptions(WithShards(2), WithDefaultTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.StoreDefault("default", "value")
+	cache.Store("zero", "value", 0)
+	cache.Store("forever", "value", NoExpiration)
+
+	for _, key := range []string{"default", "zero"} {
+		ttl, ok := cache.TTL(key)
+		if !ok || ttl <= 50*time.Second || ttl > time.Minute {
+			t.Errorf("Expected %s to use the default TTL, got %v (%v)", key, ttl, ok)
+		}
+	}
+	if ttl, ok := cache.TTL("forever"); !ok || ttl != NoExpiration {
+		t.Errorf("Expected forever to never expire, got %v (%v)", ttl, ok)
+	}
+}
+
+// testing that WithHashFunc decides shard placement
+func TestWithHashFunc(t *testing.T) {
+	cache, err := NewCacheWithOptions(WithShards(4), WithHashFunc(func() hash.Hash32 {
+		return constantHash{crc32.NewIEEE()}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	if lens := cache.ShardLens(); lens[0] != 10 {
+		t.Errorf("Expected every key in shard 0, got %v", lens)
+	}
+}
+
+// testing that the default hash places keys like hash/fnv's New32a, with and
+// without a power-of-two shard count
+func TestDefaultShardPlacement(t *testing.T) {
+	for _, shards := range []int{1, 7, 16} {
+		cache, err := NewCacheWithOptions(WithShards(shards))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"", "a", "key42", "user:session:1234567890", "日本語"} {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			if got, want := cache.shardIndex(key), int(h.Sum32()%uint32(shards)); got != want {
+				t.Errorf("%d shards: expected %q in shard %d, got %d", shards, key, want, got)
+			}
+		}
+		cache.Close()
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { fnv32a("user:session:42") }); allocs != 0 {
+		t.Errorf("Expected hashing a key not to allocate, got %v allocations", allocs)
+	}
+}
+
+// testing that WithShardingFunc decides shard placement and that
+// ShardDistribution reports it
+func TestWithShardingFunc(t *testing.T) {
+	// Every key of a tenant goes to the shard numbered after the tenant
+	byTenant := func(key string, numShards int) int {
+		tenant, _, _ := strings.Cut(key, ":")
+		n, _ := strconv.Atoi(tenant)
+		return n % numShards
+	}
+	cache, err := NewCacheWithOptions(WithShards(4), WithShardingFunc(byTenant))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	for i := 0; i < 6; i++ {
+		cache.Store("1:object:"+strconv.Itoa(i), i, time.Minute)
+	}
+	cache.Store("3:object:0", 0, time.Minute)
+	if dist := cache.ShardDistribution(); !slices.Equal(dist, []int{0, 6, 0, 1}) {
+		t.Fatalf("Expected distribution [0 6 0 1], got %v", dist)
+	}
+	if !cache.Exists("3:object:0") {
+		t.Fatal("Expected to find a sharded key")
+	}
+}
+
+// testing that a sharding func returning an index out of range panics
+func TestShardingFuncOutOfRange(t *testing.T) {
+	cache, err := NewCacheWithOptions(WithShards(4), WithShardingFunc(func(_ string, numShards int) int {
+		return numShards
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected an out of range shard index to panic")
+		}
+	}()
+	cache.Store("key", "value", time.Minute)
+}
+
+// constantHash sends every key to shard 0.
+type constantHash struct{ hash.Hash32 }
+
+func (constantHash) Sum32() uint32 { return 0 }
+
+// testing that WithTTLJitter spreads expirations over ±fraction of the TTL
+func TestTTLJitter(t *testing.T) {
+	const ttl = time.Hour
+	cache, err := NewCacheWithOptions(WithShards(4), WithMaxItemsPerShard(10_000), WithTTLJitter(0.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	start := time.Now()
+	for i := 0; i < 10_000; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, ttl)
+	}
+	end := time.Now()
+
+	var earliest, latest time.Time
+	for i := 0; i < 10_000; i++ {
+		exp, _ := cache.ExpiresAt("key" + strconv.Itoa(i))
+		if exp.Before(start.Add(ttl*9/10)) || exp.After(end.Add(ttl*11/10)) {
+			t.Fatalf("Expected the expiration within ±10%% of %v, got %v", ttl, exp.Sub(start))
+		}
+		if earliest.IsZero() || exp.Before(earliest) {
+			earliest = exp
+		}
+		if exp.After(latest) {
+			latest = exp
+		}
+	}
+	// 10k uniform samples cover nearly the whole 12 minute window
+	if spread := latest.Sub(earliest); spread < 11*time.Minute {
+		t.Fatalf("Expected expirations spread over the jitter window, got %v", spread)
+	}
+
+	// NoExpiration is not jittered
+	cache.Store("forever", 1, NoExpiration)
+	if ttl, _ := cache.TTL("forever"); ttl != NoExpiration {
+		t.Fatalf("Expected NoExpiration, got %v", ttl)
+	}
+
+	if _, err := NewCacheWithOptions(WithTTLJitter(1.5)); err == nil {
+		t.Fatal("Expected an error for a jitter fraction of 1.5")
+	}
+}
+
+// testing that a preallocated cache behaves like a default one
+func TestWithInitialCapacity(t *testing.T) {
+	cache := NewCache(4, 1000, 0, WithInitialCapacity(5000))
+	defer cache.Close()
+
+	for i := 0; i < 200; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	if n := cache.Len(); n != 200 {
+		t.Fatalf("Expected 200 items, got %d", n)
+	}
+
+	// StoreMany sizes the still-empty shards of a fresh cache for the batch
+	bulk := NewCache(4, 1000, 0)
+	defer bulk.Close()
+	items := make(map[string]interface{}, 300)
+	for i := 0; i < 300; i++ {
+		items["key"+strconv.Itoa(i)] = i
+	}
+	if errs := bulk.StoreMany(items, NoExpiration); errs != nil {
+		t.Fatalf("StoreMany failed: %v", errs)
+	}
+	if n := bulk.Len(); n != 300 {
+		t.Fatalf("Expected 300 items, got %d", n)
+	}
+
+	if _, err := NewCacheWithOptions(WithInitialCapacity(-1)); err == nil {
+		t.Fatal("Expected an error for a negative initial capacity")
+	}
+}
@@ -0,0 +1,135 @@
+package hoard
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// readView is an immutable copy of the entries of a shard, read without
+// locks under WithReadOptimizedShards.
+type readView map[string]readEntry
+
+// readEntry is the state of an entry when its view was built. item is only
+// used to stamp hits, which it does atomically. It may have been removed
+// since, but read-optimized shards never recycle items, so a stale view
+// stamps a dead item rather than one reused for another key.
+type readEntry struct {
+	view itemView
+	item *CacheItem
+}
+
+// shardMutex is the lock of a shard. With WithReadOptimizedShards, releasing
+// it after a write drops the read view of the shard, so a view is never older
+// than the last write to complete. Releasing it also runs the backend and
+// tier I/O the write queued, see ioOp.
+type shardMutex struct {
+	sync.RWMutex
+	view *atomic.Pointer[readView] // nil unless read optimized
+	ops  []ioOp                    // queued under the write lock
+}
+
+// Unlock releases the write lock and then runs the queued I/O, reporting
+// failed saves to the error handler.
+func (m *shardMutex) Unlock() {
+	if ops := m.release(); ops != nil {
+		runIO(ops, nil)
+	}
+}
+
+// unlockErr is like Unlock but returns err, or else the error of the first
+// queued save that failed, for writes that return their save errors.
+func (m *shardMutex) unlockErr(err error) error {
+	m.unlockSaves(func(_ string, saveErr error) {
+		if err == nil {
+			err = saveErr
+		}
+	})
+	return err
+}
+
+// unlockSaves is like Unlock but hands the key and error of every queued
+// save that failed to failed, for writes of several keys.
+func (m *shardMutex) unlockSaves(failed func(key string, err error)) {
+	if ops := m.release(); ops != nil {
+		runIO(ops, failed)
+	}
+}
+
+// release releases the write lock and returns the I/O queued under it.
+func (m *shardMutex) release() []ioOp {
+	ops := m.ops
+	m.ops = nil
+	if m.view != nil {
+		m.view.Store(nil)
+	}
+	m.RWMutex.Unlock()
+	return ops
+}
+
+// fetchView looks key up in the read view of shard, or with the read lock
+// when a write dropped the view. The view is rebuilt once a quarter as many
+// lookups as the shard has entries went through the lock since it was last
+// built, so rebuilding costs a lookup a few map inserts on average however
+// writes and reads interleave.
+func (c *Cache) fetchView(shard *CacheShard, key string, now int64) (itemView, bool) {
+	if view := shard.view.Load(); view != nil {
+		e, ok := (*view)[key]
+		if !ok || e.view.expiration != 0 && now > e.view.expiration {
+			shard.stats.misses.Add(1)
+			return itemView{}, false
+		}
+		shard.recordViewHit(e, now)
+		if shard.arena != nil {
+			// Values alias the arena, which is never written to again, but
+			// lookups hand out copies to avoid pinning whole slabs
+			e.view.value = bytes.Clone(e.view.value)
+		}
+		return e.view, true
+	}
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	if shard.viewMisses.Add(1) >= int64(len(shard.data)/4) {
+		shard.buildView()
+	}
+	return shard.lookup(key, now)
+}
+
+// peek returns the entry of key in the view if it is live at now, without
+// recording a hit. Under WithArenaStorage its value aliases the arena.
+func (v *readView) peek(key string, now int64) (itemView, bool) {
+	e, ok := (*v)[key]
+	if !ok || e.view.expiration != 0 && now > e.view.expiration {
+		return itemView{}, false
+	}
+	return e.view, true
+}
+
+// buildView publishes a fresh read view of the shard. The caller must hold
+// s.mu for reading, so no write can complete, and drop the view, meanwhile.
+func (s *CacheShard) buildView() {
+	view := make(readView, len(s.data))
+	for key, item := range s.data {
+		view[key] = readEntry{view: s.sharedView(item), item: item}
+	}
+	s.view.Store(&view)
+	s.viewMisses.Store(0)
+}
+
+// recordViewHit is recordHit for an entry found in the read view, reading
+// its flags from the view rather than the item.
+func (s *CacheShard) recordViewHit(e readEntry, now int64) {
+	if e.view.flags&itemNegative != 0 {
+		s.stats.negativeHits.Add(1)
+	} else {
+		s.stats.hits.Add(1)
+	}
+	if s.stamp {
+		e.item.lastUsed.Store(now)
+	}
+	if s.meta {
+		e.item.accessed.Store(now)
+		e.item.hits.Add(1)
+	}
+}
@@ -0,0 +1,217 @@
+package hoard
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testing that lookups through the read views see every kind of write
+func TestReadOptimizedShards(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(2, 100, time.Minute, WithReadOptimizedShards(), WithClock(clock))
+	defer cache.Close()
+	if cache.evictionPolicy != ApproxLRU {
+		t.Fatalf("Expected the ApproxLRU policy, got %v", cache.evictionPolicy)
+	}
+
+	// Read enough to build the views, then check every write shows
+	readAll := func() {
+		for i := 0; i < 100; i++ {
+			cache.FetchData("key" + strconv.Itoa(i))
+		}
+	}
+	for i := 0; i < 20; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Second)
+	}
+	readAll()
+	for _, shard := range cache.shards {
+		if shard.view.Load() == nil {
+			t.Fatal("Expected the lookups to build the read views")
+		}
+	}
+	if v := mustFetch(t, cache, "key3"); v != int8(3) {
+		t.Fatalf("Expected 3, got %v", v)
+	}
+
+	cache.Store("key3", "new", NoExpiration)
+	if cache.getShard("key3").view.Load() != nil {
+		t.Fatal("Expected a store to drop the read view")
+	}
+	readAll()
+	if v := mustFetch(t, cache, "key3"); v != "new" {
+		t.Fatalf("Expected the new value, got %v", v)
+	}
+	cache.Delete("key4")
+	readAll()
+	if _, ok, _ := cache.FetchData("key4"); ok {
+		t.Fatal("Expected the deleted key to be gone")
+	}
+	if _, err := cache.Increment("count", 2, NoExpiration); err != nil {
+		t.Fatalf("Increment failed: %v", err)
+	}
+	readAll()
+	if v := mustFetch(t, cache, "count"); v != int64(2) {
+		t.Fatalf("Expected the counter, got %v", v)
+	}
+
+	// Entries expire in the view even before cleanup removes them
+	clock.Advance(2 * time.Second)
+	if _, ok, _ := cache.FetchData("key5"); ok {
+		t.Fatal("Expected the expired key to miss")
+	}
+	if v := mustFetch(t, cache, "key3"); v != "new" {
+		t.Fatalf("Expected the key without expiration, got %v", v)
+	}
+	if s := cache.Stats(); s.Hits == 0 || s.Misses == 0 {
+		t.Fatalf("Expected hits and misses to be counted, got %+v", s)
+	}
+}
+
+// testing that Peek, Exists, TTL and ExpiresAt read the view without
+// waiting for the shard lock
+func TestReadOptimizedShardsPeek(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute, WithReadOptimizedShards())
+	defer cache.Close()
+	for i := 0; i < 20; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	for i := 0; i < 20; i++ {
+		cache.FetchData("key" + strconv.Itoa(i))
+	}
+	shard := cache.shards[0]
+	if shard.view.Load() == nil {
+		t.Fatal("Expected the lookups to build the read view")
+	}
+
+	shard.mu.RWMutex.Lock()
+	defer shard.mu.RWMutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, ok, err := cache.Peek("key3"); !ok || err != nil || v != int8(3) {
+			t.Errorf("Expected Peek to find 3, got %v, %v, %v", v, ok, err)
+		}
+		if !cache.Exists("key3") || cache.Exists("missing") {
+			t.Error("Expected Exists to see only the stored key")
+		}
+		if ttl, ok := cache.TTL("key3"); !ok || ttl <= 0 || ttl > time.Minute {
+			t.Errorf("Expected a TTL within a minute, got %v, %v", ttl, ok)
+		}
+		if at, ok := cache.ExpiresAt("key3"); !ok || at.IsZero() {
+			t.Errorf("Expected a deadline, got %v, %v", at, ok)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the lookups not to wait for the shard lock")
+	}
+}
+
+// testing that readers never see a value that was not stored while writers
+// keep dropping the views
+func TestReadOptimizedShardsConcurrent(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute, WithReadOptimizedShards())
+	defer cache.Close()
+	for i := 0; i < 500; i++ {
+		cache.StoreBytes("key"+strconv.Itoa(i), []byte("key"+strconv.Itoa(i)), NoExpiration)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				key := "key" + strconv.Itoa((i*7+w)%500)
+				if w == 0 && i%10 == 0 {
+					cache.StoreBytes(key, []byte(key), NoExpiration)
+					continue
+				}
+				if v, ok := cache.FetchBytesData(key); !ok || string(v) != key {
+					t.Errorf("Expected %q, got %q, %v", key, v, ok)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	checkIntegrity(t, cache)
+}
+
+// testing the settings read-optimized shards cannot be combined with
+func TestReadOptimizedShardsSettings(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithReadOptimizedShards(), WithEvictionPolicy(LFU)},
+		{WithEvictionPolicy(LFU), WithReadOptimizedShards()},
+	} {
+		if _, err := NewCacheWithOptions(opts...); err == nil {
+			t.Fatal("Expected the LFU policy to be rejected in either option order")
+		}
+	}
+	if _, err := NewCacheWithOptions(WithReadOptimizedShards(), WithSlidingTTL()); err == nil {
+		t.Fatal("Expected sliding expiration to be rejected")
+	}
+	for _, opts := range [][]Option{
+		{WithReadOptimizedShards(), WithEvictionPolicy(FIFO)},
+		{WithEvictionPolicy(FIFO), WithReadOptimizedShards()},
+	} {
+		c, err := NewCacheWithOptions(opts...)
+		if err != nil {
+			t.Fatalf("Expected FIFO to be accepted, got %v", err)
+		}
+		if c.evictionPolicy != FIFO {
+			t.Fatalf("Expected FIFO to be kept, got %v", c.evictionPolicy)
+		}
+		c.Close()
+	}
+	cache, err := NewCacheWithOptions(WithReadOptimizedShards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.evictionPolicy != ApproxLRU {
+		t.Fatalf("Expected ApproxLRU by default, got %v", cache.evictionPolicy)
+	}
+	clone := cache.Clone()
+	defer clone.Close()
+	if clone.shards[0].mu.view == nil {
+		t.Fatal("Expected the clone to keep read-optimized shards")
+	}
+}
+
+// testing that a stale read view never stamps hits on an item that was
+// reused for another key
+func TestReadOptimizedShardsStaleView(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 100, time.Minute, WithReadOptimizedShards(), WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("old", 1, NoExpiration)
+	shard := cache.shards[0]
+	for i := 0; i < 4 && shard.view.Load() == nil; i++ {
+		cache.FetchData("old")
+	}
+	view := shard.view.Load()
+	if view == nil {
+		t.Fatal("Expected the lookups to build the read view")
+	}
+	stale := (*view)["old"]
+
+	cache.Delete("old")
+	for i := 0; i < 100; i++ {
+		cache.Store("new"+strconv.Itoa(i), i, NoExpiration)
+	}
+	if stale.item.key != "old" {
+		t.Fatalf("Expected the removed item to be left alone, got key %q", stale.item.key)
+	}
+	clock.Advance(time.Hour)
+	shard.recordViewHit(stale, clock.Now().UnixNano())
+	for key, item := range shard.data {
+		if item.lastUsed.Load() == clock.Now().UnixNano() {
+			t.Fatalf("Expected the stale view not to stamp %q", key)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package hoard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maybeRefresh reloads key in the background when the hit v has used up more
+// than the refresh-ahead fraction of its lifetime. At most one refresh per
+// key runs at a time.
+func (c *Cache) maybeRefresh(key string, v itemView, now int64) {
+	if v.expiration == 0 || v.ttl <= 0 {
+		return
+	}
+	remaining := v.expiration - now
+	if float64(remaining) >= float64(v.ttl)*(1-c.refreshAhead) {
+		return
+	}
+	if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	go c.refresh(key)
+}
+
+// refresh loads key and replaces the cached value. A failed load leaves the
+// cached value alone until it expires, and a key deleted or expired in the
+// meantime is not brought back.
+func (c *Cache) refresh(key string) {
+	defer c.refreshing.Delete(key)
+	value, ttl, err := c.loader(context.Background(), key)
+	if err != nil {
+		c.reportError(fmt.Errorf("hoard: refresh %q: %w", key, err))
+		return
+	}
+	if err := c.Update(key, value, ttl); err != nil &&
+		!errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrCacheClosed) {
+		c.reportError(fmt.Errorf("hoard: refresh %q: %w", key, err))
+	}
+}
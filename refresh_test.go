@@ -0,0 +1,121 @@
+package hoard
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing that a steadily read key never misses across many lifetimes
+func TestRefreshAheadNoMiss(t *testing.T) {
+	var loads atomic.Int64
+	cache := NewCache(1, 10, 0, WithRefreshAhead(0.5), WithLoader(func(key string) (interface{}, time.Duration, error) {
+		return loads.Add(1), 100 * time.Millisecond, nil
+	}))
+	defer cache.Close()
+
+	if _, _, err := cache.FetchData("key"); err != nil {
+		t.Fatal(err)
+	}
+	misses := cache.Stats().Misses
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if _, ok, _ := cache.FetchData("key"); !ok {
+			t.Fatal("Expected the key to be found")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := cache.Stats().Misses - misses; n != 0 {
+		t.Fatalf("Expected only the first fetch to miss, got %d more misses", n)
+	}
+	if n := loads.Load(); n < 5 {
+		t.Fatalf("Expected the key to be refreshed every lifetime, got %d loads", n)
+	}
+}
+
+// testing that hits past the fraction start a single refresh
+func TestRefreshAheadSingleFlight(t *testing.T) {
+	clock := newFakeClock()
+	release := make(chan struct{})
+	var loads atomic.Int64
+	cache := NewCache(1, 10, 0, WithClock(clock), WithRefreshAhead(0.8), WithLoader(func(key string) (interface{}, time.Duration, error) {
+		if loads.Add(1) > 1 {
+			<-release
+		}
+		return "new", time.Minute, nil
+	}))
+	defer cache.Close()
+
+	cache.FetchData("key") // initial load
+	clock.Advance(30 * time.Second)
+	cache.FetchData("key")
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("Expected no refresh before the fraction, got %d loads", n)
+	}
+
+	clock.Advance(20 * time.Second)
+	for i := 0; i < 100; i++ {
+		if v, _, _ := cache.FetchData("key"); v != "new" {
+			t.Fatalf("Expected the old value during the refresh, got %v", v)
+		}
+	}
+	waitFor(t, func() bool { return loads.Load() == 2 })
+	close(release)
+	waitFor(t, func() bool {
+		exp, _ := cache.ExpiresAt("key")
+		return exp.Equal(clock.Now().Add(time.Minute))
+	})
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("Expected a single refresh, got %d loads", n-1)
+	}
+}
+
+// testing that a failed refresh keeps the cached value
+func TestRefreshAheadError(t *testing.T) {
+	clock := newFakeClock()
+	errs := make(chan error, 1)
+	var fail atomic.Bool
+	cache := NewCache(1, 10, 0, WithClock(clock), WithRefreshAhead(0.5),
+		WithErrorHandler(func(err error) { errs <- err }),
+		WithLoader(func(key string) (interface{}, time.Duration, error) {
+			if fail.Load() {
+				return nil, 0, errors.New("unavailable")
+			}
+			return "value", time.Minute, nil
+		}),
+	)
+	defer cache.Close()
+
+	cache.FetchData("key")
+	fail.Store(true)
+	clock.Advance(45 * time.Second)
+	cache.FetchData("key")
+	if err := <-errs; err == nil {
+		t.Fatal("Expected the failed refresh to be reported")
+	}
+	if v, ok, _ := cache.FetchData("key"); !ok || v != "value" {
+		t.Fatalf("Expected the old value to survive, got %v, %v", v, ok)
+	}
+}
+
+// testing that refresh-ahead does nothing without a loader
+func TestRefreshAheadWithoutLoader(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 10, 0, WithClock(clock), WithRefreshAhead(0.5))
+	defer cache.Close()
+
+	cache.Store("key", "value", time.Minute)
+	clock.Advance(50 * time.Second)
+	if v, ok, _ := cache.FetchData("key"); !ok || v != "value" {
+		t.Fatalf("Expected a plain hit, got %v, %v", v, ok)
+	}
+	if _, busy := cache.refreshing.Load("key"); busy {
+		t.Fatal("Expected no refresh without a loader")
+	}
+
+	for _, f := range []float64{-0.1, 1} {
+		if _, err := NewCacheWithOptions(WithRefreshAhead(f)); err == nil {
+			t.Fatalf("Expected an error for fraction %v", f)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package hoard
+
+import (
+	"bytes"
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// Rename moves the live entry stored under oldKey to newKey, replacing any
+// entry newKey held. The entry keeps its value, expiration, cost, tags and
+// pin, so an entry built under a temporary key can be exposed under its final
+// name in one step: readers see either the old entry or the new one, never
+// neither. Both shards are locked for the move, in index order.
+//
+// Rename fails with ErrKeyNotFound if oldKey has no live entry, and with
+// ErrCacheFull if newKey's shard is full of pinned entries. Renaming a key to
+// itself changes nothing. Watchers see a delete of oldKey and a store of
+// newKey, and the backend a save of newKey and a delete of oldKey, made once
+// both shards are unlocked. If the save fails, Rename returns its error and
+// newKey is dropped from the cache.
+func (c *Cache) Rename(oldKey, newKey string) (err error) {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if err := c.checkKey(newKey); err != nil {
+		return err
+	}
+	si, di := c.shardIndex(oldKey), c.shardIndex(newKey)
+	src, dst := c.shards[si], c.shards[di]
+	unlock := c.lockPair(si, di)
+	defer func() { err = unlock(err) }()
+
+	item, ok := src.data[oldKey]
+	if !ok || item.expired(c.now()) {
+		return ErrKeyNotFound
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	save, err := c.saveOp(nil, newKey, src.sharedView(item))
+	if err != nil {
+		return err
+	}
+
+	// Make room first, so a failure leaves the source in place
+	if existing, ok := dst.data[newKey]; ok {
+		dst.removeItem(newKey, existing)
+	} else if src != dst && len(dst.data) >= dst.capacity {
+		victim := dst.policy.victim()
+		if victim == nil {
+			return ErrCacheFull
+		}
+		c.evictLockedItem(dst, victim)
+	}
+	c.dropFromTierLocked(src, oldKey)
+	c.dropFromTierLocked(dst, newKey)
+
+	tags := slices.Clone(item.tags)
+	val := src.value(item)
+	src.unlinkItem(oldKey, item)
+	dst.linkItem(newKey, item, val)
+	dst.tag(item, tags)
+
+	c.notify(OpDelete, oldKey, nil)
+	c.notifyValue(OpStore, newKey, val, item.flags)
+	c.logWrite(walDelete, oldKey, nil, 0, 0)
+	c.logWrite(walSet, newKey, val, item.Expiration, item.flags)
+	c.queueIO(dst, save)
+	c.deleteThroughLocked(src, oldKey)
+	c.publish(InvalidateOnDelete, oldKey)
+	c.publish(InvalidateOnStore, newKey)
+	c.evictLocked(dst)
+	return nil
+}
+
+// Copy stores a copy of the live entry under srcKey as dstKey with ttl,
+// replacing any entry dstKey held. The stored bytes are copied as they are,
+// without a round trip through the serializer. Copy fails with
+// ErrKeyNotFound if srcKey has no live entry; copying a key to itself changes
+// nothing. Like Store, it fails with ErrCacheFull if dstKey's shard is full
+// of pinned entries.
+func (c *Cache) Copy(srcKey, dstKey string, ttl time.Duration) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	if err := c.checkKey(dstKey); err != nil {
+		return err
+	}
+	src := c.getShard(srcKey)
+	src.mu.RLock()
+	item, ok := src.data[srcKey]
+	if !ok || item.expired(c.now()) {
+		src.mu.RUnlock()
+		return ErrKeyNotFound
+	}
+	val, flags, cost := bytes.Clone(src.value(item)), item.flags, item.cost
+	src.mu.RUnlock()
+	if srcKey == dstKey {
+		return nil
+	}
+
+	dst := c.getShard(dstKey)
+	exp := c.expiration(ttl)
+	dst.mu.Lock()
+	return dst.mu.unlockErr(c.storeLocked(dst, dstKey, pendingStore{stored: val, flags: flags, exp: exp, cost: cost}))
+}
+
+// lockPair locks the shards i and j for writing, in index order so two
+// callers locking the same pair cannot deadlock, and returns the func
+// unlocking them, which returns its argument or else the error of the first
+// save queued under either lock that failed, like unlockErr. i and j may be
+// equal.
+func (c *Cache) lockPair(i, j int) func(err error) error {
+	if i == j {
+		c.shards[i].mu.Lock()
+		return c.shards[i].mu.unlockErr
+	}
+	a, b := c.shards[min(i, j)], c.shards[max(i, j)]
+	a.mu.Lock()
+	b.mu.Lock()
+	return func(err error) error {
+		return a.mu.unlockErr(b.mu.unlockErr(err))
+	}
+}
+
+// linkItem adds an item unlinked from another shard under key with the
+// value val, keeping its expiration and pin. The caller must hold s.mu for
+// writing and have made room for it.
+func (s *CacheShard) linkItem(key string, item *CacheItem, val []byte) {
+	item.key = key
+	s.putValue(item, val)
+	if !item.pinned {
+		s.policy.insert(item)
+	}
+	if item.Expiration != 0 {
+		heap.Push(&s.expiry, item)
+	}
+	s.data[key] = item
+	if s.scanIdx != nil {
+		s.scanIdx.add(key)
+	}
+	if s.items != nil {
+		s.items.total.Add(1)
+		s.size.Add(1)
+	}
+	s.bytes += itemSize(key, val)
+	s.cost += item.cost
+}
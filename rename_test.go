@@ -0,0 +1,210 @@
+package hoard
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keysInShards returns two keys mapped to the same shard and one mapped to
+// another.
+func keysInShards(c *Cache) (a, b, other string) {
+	a = "key0"
+	for i := 1; b == "" || other == ""; i++ {
+		key := "key" + strconv.Itoa(i)
+		if c.ShardForKey(key) == c.ShardForKey(a) {
+			if b == "" {
+				b = key
+			}
+		} else if other == "" {
+			other = key
+		}
+	}
+	return a, b, other
+}
+
+// testing renames within a shard and across shards
+func TestRename(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(4, 100, time.Minute, WithClock(clock))
+	defer cache.Close()
+	a, same, other := keysInShards(cache)
+
+	for _, dst := range []string{same, other} {
+		cache.StoreTagged(a, "built", time.Minute, "release")
+		if err := cache.Rename(a, dst); err != nil {
+			t.Fatalf("Rename to %s failed: %v", dst, err)
+		}
+		if cache.Exists(a) {
+			t.Fatal("Expected the old key to be gone")
+		}
+		if v, ok, _ := cache.FetchData(dst); !ok || v != "built" {
+			t.Fatalf("Expected the value under the new key, got %v", v)
+		}
+		if n := cache.InvalidateTag("release"); n != 1 || cache.Exists(dst) {
+			t.Fatal("Expected the tags to move with the entry")
+		}
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Expected an empty cache, got %d entries", cache.Len())
+	}
+
+	// The expiration moves along
+	cache.Store(a, 1, time.Minute)
+	cache.Rename(a, other)
+	clock.Advance(2 * time.Minute)
+	if cache.Exists(other) {
+		t.Fatal("Expected the renamed entry to keep its expiration")
+	}
+}
+
+// testing that Rename replaces the destination and handles missing and
+// identical keys
+func TestRenameEdgeCases(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute)
+	defer cache.Close()
+
+	cache.Store("tmp", "new", NoExpiration)
+	cache.Store("live", "old", NoExpiration)
+	if err := cache.Rename("tmp", "live"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if v, _, _ := cache.FetchData("live"); v != "new" || cache.Len() != 1 {
+		t.Fatalf("Expected the destination to be replaced, got %v", v)
+	}
+
+	if err := cache.Rename("missing", "live"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if v, _, _ := cache.FetchData("live"); v != "new" {
+		t.Fatal("Expected a failed rename to leave the destination alone")
+	}
+	if err := cache.Rename("live", "live"); err != nil || !cache.Exists("live") {
+		t.Fatalf("Expected renaming a key to itself to change nothing, got %v", err)
+	}
+	if err := cache.Rename("missing", "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if err := cache.Rename("live", ""); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+// testing that a rename into a full shard evicts there, or fails leaving the
+// source in place if everything there is pinned, and that watchers see it as
+// a delete and a store
+func TestRenameFullShard(t *testing.T) {
+	cache := NewCache(2, 2, time.Minute, WithShardingFunc(skewed))
+	defer cache.Close()
+
+	cache.Store("hot1", 1, NoExpiration)
+	cache.Store("hot2", 2, NoExpiration)
+	cache.Store("cold", 3, NoExpiration)
+	moved, stopMoved := cache.Watch("cold")
+	defer stopMoved()
+	evicted, stopEvicted := cache.Watch("hot1")
+	defer stopEvicted()
+	stored, stopStored := cache.Watch("hot3")
+	defer stopStored()
+
+	if err := cache.Rename("cold", "hot3"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if ev := next(t, evicted); ev.Op != OpEvict {
+		t.Fatalf("Expected hot1 to be evicted, got %v", ev.Op)
+	}
+	if ev := next(t, moved); ev.Op != OpDelete {
+		t.Fatalf("Expected a delete of the old key, got %v", ev.Op)
+	}
+	if ev := next(t, stored); ev.Op != OpStore {
+		t.Fatalf("Expected a store of the new key, got %v", ev.Op)
+	}
+	if lens := cache.ShardLens(); lens[0] != 2 || lens[1] != 0 {
+		t.Fatalf("Expected the entry to move shards, got %v", lens)
+	}
+
+	cache.StoreWithOptions("hot2", 2, ItemOptions{Pinned: true})
+	cache.StoreWithOptions("hot3", 3, ItemOptions{Pinned: true})
+	cache.Store("cold", 4, NoExpiration)
+	if err := cache.Rename("cold", "hot4"); !errors.Is(err, ErrCacheFull) {
+		t.Fatalf("Expected ErrCacheFull, got %v", err)
+	}
+	if !cache.Exists("cold") {
+		t.Fatal("Expected a failed rename to keep the source")
+	}
+}
+
+// testing that Copy duplicates the stored bytes with its own ttl
+func TestCopy(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(4, 100, time.Minute, WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("src", map[string]interface{}{"a": "b"}, NoExpiration)
+	cache.StoreBytes("raw", []byte("bytes"), NoExpiration)
+	if err := cache.Copy("src", "dst", time.Minute); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if err := cache.Copy("raw", "raw2", NoExpiration); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if v, ok, _ := cache.FetchData("dst"); !ok || v.(map[string]interface{})["a"] != "b" {
+		t.Fatalf("Expected the copied value, got %v", v)
+	}
+	if b, ok := cache.FetchBytes("raw2"); !ok || string(b) != "bytes" {
+		t.Fatalf("Expected the raw bytes copied, got %q", b)
+	}
+
+	// The copy is independent of the source
+	cache.Store("src", "changed", NoExpiration)
+	if v, _, _ := cache.FetchData("dst"); v == "changed" {
+		t.Fatal("Expected the copy to be independent")
+	}
+	clock.Advance(2 * time.Minute)
+	if cache.Exists("dst") || !cache.Exists("src") {
+		t.Fatal("Expected the copy to expire on its own ttl")
+	}
+
+	if err := cache.Copy("missing", "dst", NoExpiration); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if err := cache.Copy("src", "src", time.Second); err != nil {
+		t.Fatalf("Expected copying a key to itself to change nothing, got %v", err)
+	}
+	clock.Advance(time.Hour)
+	if !cache.Exists("src") {
+		t.Fatal("Expected copying a key to itself to leave its ttl alone")
+	}
+}
+
+// testing that renames in opposite directions across two shards cannot
+// deadlock
+func TestRenameConcurrent(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	a, _, b := keysInShards(cache)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			from, to := a, b
+			if w%2 == 1 {
+				from, to = b, a
+			}
+			for i := 0; i < 1000; i++ {
+				cache.Store(from, i, NoExpiration)
+				cache.Rename(from, to)
+				cache.Copy(to, from, NoExpiration)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n := cache.Len(); n > 2 {
+		t.Fatalf("Expected at most 2 entries, got %d", n)
+	}
+	checkIntegrity(t, cache)
+}
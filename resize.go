@@ -0,0 +1,68 @@
+package hoard
+
+import "fmt"
+
+// Evict removes up to n entries, taking one from the tail of each shard's
+// eviction order in turn, and returns how many were removed. It sheds load
+// evenly rather than emptying the first shards. Evicted entries are handled
+// like those evicted to make room: they move to the tier if one is set,
+// count in Stats.Evictions and are reported to watchers as OpEvict. Pinned
+// entries are never evicted.
+func (c *Cache) Evict(n int) int {
+	if n <= 0 || c.isClosed() {
+		return 0
+	}
+	evicted := 0
+	for evicted < n {
+		progress := false
+		for _, shard := range c.shards {
+			if evicted == n {
+				break
+			}
+			shard.mu.Lock()
+			if victim := shard.policy.victim(); victim != nil {
+				c.evictLockedItem(shard, victim)
+				evicted++
+				progress = true
+			}
+			shard.mu.Unlock()
+		}
+		if !progress {
+			break
+		}
+	}
+	return evicted
+}
+
+// Resize changes the maximum number of entries per shard. Shrinking evicts
+// each shard down to the new size right away, the same way Evict does;
+// growing only lets later stores fill the extra room. Stores running
+// concurrently see either the old or the new size. Under SLRU the protected
+// segment is resized with the shard.
+func (c *Cache) Resize(maxItemsPerShard int) error {
+	if maxItemsPerShard <= 0 {
+		return fmt.Errorf("hoard: max items per shard must be positive, got %d", maxItemsPerShard)
+	}
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		shard.capacity = maxItemsPerShard
+		switch p := shard.policy.(type) {
+		case *slruPolicy:
+			p.resize(int(float64(maxItemsPerShard) * (1 - c.segmentRatio)))
+		case *lfuPolicy:
+			p.decayEvery = maxItemsPerShard * lfuDecayFactor
+		}
+		for len(shard.data) > maxItemsPerShard {
+			victim := shard.policy.victim()
+			if victim == nil {
+				break
+			}
+			c.evictLockedItem(shard, victim)
+		}
+		shard.mu.Unlock()
+	}
+	return nil
+}
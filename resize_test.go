@@ -0,0 +1,164 @@
+package hoard
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testing that Evict takes entries from every shard in turn, oldest first
+func TestEvict(t *testing.T) {
+	cache := NewCache(4, 100, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	before := cache.ShardLens()
+	events, stop := cache.WatchPrefix("key")
+	defer stop()
+
+	if n := cache.Evict(8); n != 8 {
+		t.Fatalf("Expected 8 evictions, got %d", n)
+	}
+	for i, n := range cache.ShardLens() {
+		if n != before[i]-2 {
+			t.Fatalf("Expected shard %d to lose 2 entries, went from %d to %d", i, before[i], n)
+		}
+	}
+	if cache.Exists("key0") {
+		t.Fatal("Expected the least recently used entry to go first")
+	}
+	if ev := next(t, events); ev.Op != OpEvict {
+		t.Fatalf("Expected an eviction event, got %v", ev.Op)
+	}
+	if n := cache.Stats().Evictions; n != 8 {
+		t.Fatalf("Expected 8 evictions counted, got %d", n)
+	}
+
+	// Asking for more than there is empties the cache
+	if n := cache.Evict(1000); n != 92 {
+		t.Fatalf("Expected the remaining 92 entries, got %d", n)
+	}
+	if cache.Len() != 0 || cache.Evict(1) != 0 {
+		t.Fatal("Expected an empty cache")
+	}
+}
+
+// testing that shrinking evicts down to the new size and later stores keep
+// to it
+func TestResizeDown(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	cache.FetchData("key0")
+	if err := cache.Resize(10); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	if n := cache.Len(); n != 10 {
+		t.Fatalf("Expected 10 entries, got %d", n)
+	}
+	if !cache.Exists("key0") || cache.Exists("key1") || !cache.Exists("key99") {
+		t.Fatal("Expected the least recently used entries to be evicted")
+	}
+	if n := cache.Stats().Evictions; n != 90 {
+		t.Fatalf("Expected 90 evictions, got %d", n)
+	}
+
+	cache.Store("new", 1, NoExpiration)
+	if n := cache.Len(); n != 10 {
+		t.Fatalf("Expected the new size to hold, got %d entries", n)
+	}
+
+	if err := cache.Resize(0); err == nil {
+		t.Fatal("Expected an error for a size of 0")
+	}
+}
+
+// testing that growing evicts nothing and lets the shard fill up
+func TestResizeUp(t *testing.T) {
+	cache := NewCache(1, 10, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	if err := cache.Resize(50); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	if n := cache.Stats().Evictions; n != 0 {
+		t.Fatalf("Expected no evictions, got %d", n)
+	}
+	for i := 10; i < 60; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, NoExpiration)
+	}
+	if n := cache.Len(); n != 50 {
+		t.Fatalf("Expected 50 entries, got %d", n)
+	}
+	if cache.Exists("key9") || !cache.Exists("key10") {
+		t.Fatal("Expected the oldest entries to be evicted once full")
+	}
+}
+
+// testing that shrinking under SLRU keeps the protected segment within its
+// new share
+func TestResizeSLRU(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute, WithEvictionPolicy(SLRU))
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		cache.Store(key, i, NoExpiration)
+		cache.FetchData(key)
+	}
+	if err := cache.Resize(10); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	p := cache.shards[0].policy.(*slruPolicy)
+	if p.protectedCap != 8 || p.protected.len > 8 || cache.Len() != 10 {
+		t.Fatalf("Expected 10 entries with at most 8 protected, got %d with %d protected", cache.Len(), p.protected.len)
+	}
+}
+
+// testing Evict and Resize against concurrent stores and lookups
+func TestResizeConcurrent(t *testing.T) {
+	cache := NewCache(8, 200, time.Minute)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := "key" + strconv.Itoa(w*1_000_000+i)
+				cache.Store(key, i, NoExpiration)
+				cache.FetchData(key)
+			}
+		}(w)
+	}
+	for i := 0; i < 200; i++ {
+		cache.Resize(10 + i%50)
+		cache.Evict(20)
+	}
+	close(stop)
+	wg.Wait()
+
+	cache.Resize(5)
+	for i, n := range cache.ShardLens() {
+		if n > 5 {
+			t.Fatalf("Expected shard %d to hold at most 5 entries, got %d", i, n)
+		}
+	}
+	checkIntegrity(t, cache)
+}
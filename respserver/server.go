@@ -0,0 +1,288 @@
+// Package respserver serves a hoard cache over a subset of the Redis
+// protocol (RESP2), so redis-cli and Redis client libraries can talk to an
+// embedded cache during local development.
+//
+// Supported commands and the cache operations they map to:
+//
+//	PING [message]
+//	GET key                     FetchBytes
+//	SET key value [EX s|PX ms]  StoreBytes, without expiration unless EX or PX is given
+//	DEL key [key ...]           Delete
+//	EXISTS key [key ...]        Exists
+//	TTL key                     TTL, -2 for a missing key and -1 for no expiration
+//	EXPIRE key seconds          Touch, or Delete for seconds <= 0
+//	FLUSHALL                    CleanupAll
+//
+// Every other command, including SET's NX, XX, KEEPTTL and GET options,
+// transactions, pub/sub, HELLO and RESP3, is answered with an -ERR reply.
+// Values set through the protocol are stored as raw bytes; GET of an entry
+// stored through the Go API returns its serialized bytes.
+package respserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+// Server answers RESP requests from a cache.
+type Server struct {
+	cache *hoard.Cache
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("respserver: server closed")
+
+// New returns a Server for cache.
+func New(cache *hoard.Cache) *Server {
+	return &Server{cache: cache, conns: make(map[net.Conn]struct{})}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln, each handled by its own goroutine, until
+// Close is called. It always returns a non-nil error.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listener, closes open connections and waits for their
+// goroutines to exit.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				writeError(w, perr.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.exec(w, args)
+		// Answer pipelined commands in one write
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs one command and writes its reply.
+func (s *Server) exec(w *bufio.Writer, args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	cmd.run(s.cache, w, args)
+}
+
+// command describes a supported command. Argument counts include the
+// command name; maxArgs < 0 means no limit.
+type command struct {
+	minArgs, maxArgs int
+	run              func(c *hoard.Cache, w *bufio.Writer, args []string)
+}
+
+var commands = map[string]command{
+	"PING":     {1, 2, ping},
+	"GET":      {2, 2, get},
+	"SET":      {3, 5, set},
+	"DEL":      {2, -1, del},
+	"EXISTS":   {2, -1, exists},
+	"TTL":      {2, 2, ttl},
+	"EXPIRE":   {3, 3, expire},
+	"FLUSHALL": {1, 1, flushAll},
+}
+
+func ping(_ *hoard.Cache, w *bufio.Writer, args []string) {
+	if len(args) == 2 {
+		writeBulk(w, []byte(args[1]))
+		return
+	}
+	writeSimple(w, "PONG")
+}
+
+func get(c *hoard.Cache, w *bufio.Writer, args []string) {
+	value, ok := c.FetchBytes(args[1])
+	if !ok {
+		writeNull(w)
+		return
+	}
+	writeBulk(w, value)
+}
+
+func set(c *hoard.Cache, w *bufio.Writer, args []string) {
+	ttl := hoard.NoExpiration
+	if len(args) > 3 {
+		if len(args) != 5 {
+			writeError(w, "ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, "ERR invalid expire time in 'set' command")
+			return
+		}
+		switch strings.ToUpper(args[3]) {
+		case "EX":
+			ttl = time.Duration(n) * time.Second
+		case "PX":
+			ttl = time.Duration(n) * time.Millisecond
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	if err := c.StoreBytes(args[1], []byte(args[2]), ttl); err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeSimple(w, "OK")
+}
+
+func del(c *hoard.Cache, w *bufio.Writer, args []string) {
+	n := 0
+	for _, key := range args[1:] {
+		if c.Delete(key) {
+			n++
+		}
+	}
+	writeInt(w, int64(n))
+}
+
+func exists(c *hoard.Cache, w *bufio.Writer, args []string) {
+	n := 0
+	for _, key := range args[1:] {
+		if c.Exists(key) {
+			n++
+		}
+	}
+	writeInt(w, int64(n))
+}
+
+func ttl(c *hoard.Cache, w *bufio.Writer, args []string) {
+	left, ok := c.TTL(args[1])
+	switch {
+	case !ok:
+		writeInt(w, -2)
+	case left == hoard.NoExpiration:
+		writeInt(w, -1)
+	default:
+		writeInt(w, int64((left+time.Second/2)/time.Second))
+	}
+}
+
+func expire(c *hoard.Cache, w *bufio.Writer, args []string) {
+	secs, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		writeError(w, "ERR value is not an integer or out of range")
+		return
+	}
+	if secs <= 0 {
+		if c.Delete(args[1]) {
+			writeInt(w, 1)
+		} else {
+			writeInt(w, 0)
+		}
+		return
+	}
+	if c.Touch(args[1], time.Duration(secs)*time.Second) != nil {
+		writeInt(w, 0)
+		return
+	}
+	writeInt(w, 1)
+}
+
+func flushAll(c *hoard.Cache, w *bufio.Writer, _ []string) {
+	if err := c.CleanupAll(); err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeSimple(w, "OK")
+}
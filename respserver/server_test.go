@@ -0,0 +1,137 @@
+package respserver
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrkouhadi/hoard"
+)
+
+// startServer serves a fresh cache on a local port and returns a client
+// connection to it.
+func startServer(t *testing.T) (*hoard.Cache, net.Conn, *bufio.Reader) {
+	t.Helper()
+	cache := hoard.NewCache(4, 100, 0)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(cache)
+	go srv.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Close()
+		cache.Close()
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return cache, conn, bufio.NewReader(conn)
+}
+
+// send writes raw RESP and returns the next reply, including its CRLFs.
+func send(t *testing.T, conn net.Conn, r *bufio.Reader, raw string) string {
+	t.Helper()
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	return readReply(t, r)
+}
+
+// readReply reads one reply frame.
+func readReply(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line[0] == '$' && line != "$-1\r\n" {
+		rest, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line += rest
+	}
+	return line
+}
+
+// testing the supported commands with raw RESP frames
+func TestCommands(t *testing.T) {
+	cache, conn, r := startServer(t)
+
+	tests := []struct{ request, reply string }{
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$4\r\nuser\r\n$7\r\nkouhadi\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$4\r\nuser\r\n", "$7\r\nkouhadi\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n", "$-1\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$4\r\nuser\r\n", ":-1\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$7\r\nmissing\r\n", ":-2\r\n"},
+		{"*5\r\n$3\r\nSET\r\n$7\r\nsession\r\n$1\r\nx\r\n$2\r\nEX\r\n$3\r\n100\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$7\r\nsession\r\n", ":100\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$4\r\nuser\r\n$2\r\n60\r\n", ":1\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$4\r\nuser\r\n", ":60\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$7\r\nmissing\r\n$2\r\n60\r\n", ":0\r\n"},
+		{"*4\r\n$6\r\nEXISTS\r\n$4\r\nuser\r\n$7\r\nsession\r\n$7\r\nmissing\r\n", ":2\r\n"},
+		{"*3\r\n$3\r\nDEL\r\n$4\r\nuser\r\n$7\r\nmissing\r\n", ":1\r\n"},
+		{"*1\r\n$8\r\nflushall\r\n", "+OK\r\n"},
+		{"*2\r\n$6\r\nEXISTS\r\n$7\r\nsession\r\n", ":0\r\n"},
+		// Inline commands, as typed into telnet
+		{"SET greeting hello\r\n", "+OK\r\n"},
+		{"GET greeting\n", "$5\r\nhello\r\n"},
+	}
+	for _, tt := range tests {
+		if reply := send(t, conn, r, tt.request); reply != tt.reply {
+			t.Errorf("%q: expected %q, got %q", tt.request, tt.reply, reply)
+		}
+	}
+	if data, ok := cache.FetchBytes("greeting"); !ok || string(data) != "hello" {
+		t.Fatalf("Expected SET to store raw bytes, got %q", data)
+	}
+}
+
+// testing that PX sets a millisecond ttl that expires
+func TestSetPX(t *testing.T) {
+	cache, conn, r := startServer(t)
+	send(t, conn, r, "*5\r\n$3\r\nSET\r\n$3\r\nkey\r\n$1\r\nv\r\n$2\r\nPX\r\n$2\r\n20\r\n")
+	if !cache.Exists("key") {
+		t.Fatal("Expected the key to be stored")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if reply := send(t, conn, r, "GET key\r\n"); reply != "$-1\r\n" {
+		t.Fatalf("Expected the key to expire, got %q", reply)
+	}
+}
+
+// testing error replies for unknown commands and bad arguments
+func TestErrors(t *testing.T) {
+	_, conn, r := startServer(t)
+
+	tests := []struct{ request, prefix string }{
+		{"*1\r\n$5\r\nHELLO\r\n", "-ERR unknown command 'HELLO'"},
+		{"*1\r\n$3\r\nGET\r\n", "-ERR wrong number of arguments for 'get' command"},
+		{"SET key value NX\r\n", "-ERR syntax error"},
+		{"SET key value EX soon\r\n", "-ERR invalid expire time"},
+		{"EXPIRE key soon\r\n", "-ERR value is not an integer"},
+	}
+	for _, tt := range tests {
+		if reply := send(t, conn, r, tt.request); !strings.HasPrefix(reply, tt.prefix) {
+			t.Errorf("%q: expected %q, got %q", tt.request, tt.prefix, reply)
+		}
+	}
+
+	// The connection survives errors, pipelined commands included
+	conn.Write([]byte("PING\r\nPING hi\r\n"))
+	if a, b := readReply(t, r), readReply(t, r); a != "+PONG\r\n" || b != "$2\r\nhi\r\n" {
+		t.Fatalf("Expected pipelined replies, got %q and %q", a, b)
+	}
+
+	// A malformed frame is reported before the connection is closed
+	if reply := send(t, conn, r, "*1\r\n:1\r\n"); !strings.HasPrefix(reply, "-ERR Protocol error") {
+		t.Fatalf("Expected a protocol error, got %q", reply)
+	}
+}
@@ -0,0 +1,88 @@
+package hoard
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// call is an in-flight or completed group.do invocation.
+type call struct {
+	done     chan struct{}
+	val      interface{}
+	err      error
+	panicked *panicError // set if fn panicked
+}
+
+// panicError is what group.do panics with in every caller of a call whose
+// fn panicked, carrying the value and the stack of the original panic.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("hoard: load panicked: %v\n\n%s", p.value, p.stack)
+}
+
+// Unwrap returns the value of the panic if it is an error.
+func (p *panicError) Unwrap() error {
+	err, _ := p.value.(error)
+	return err
+}
+
+// group coalesces concurrent calls that share a key so that the underlying
+// function runs at most once at a time per key. The zero value is ready to use.
+type group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+// do runs fn for key unless a call for the same key is already in flight, in
+// which case it waits for that call and returns its result. A waiter whose
+// ctx is done stops waiting and returns the context's error; the call itself
+// only sees the context fn was built with. shared reports whether the result
+// was handed to more than one caller. If fn panics, the caller running it and
+// every waiter panic with a *panicError holding the original panic.
+func (g *group) do(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			if c.panicked != nil {
+				panic(c.panicked)
+			}
+			return c.val, c.err, true
+		case <-ctx.Done():
+			return nil, ctx.Err(), true
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	// The deferred cleanup also runs if fn exits the goroutine
+	defer func() {
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		close(c.done)
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+	}()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.panicked = &panicError{value: r, stack: debug.Stack()}
+			}
+		}()
+		c.val, c.err = fn()
+	}()
+	return c.val, c.err, false
+}
@@ -0,0 +1,363 @@
+package hoard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot layout: the magic header is followed by a sequence of records,
+// each introduced by a tag byte. An entry record is
+//
+//	uvarint keyLen | key | varint expiration | flags | uvarint valueLen | value | crc32
+//
+// where the CRC-32 (IEEE, little endian) covers every byte of the record
+// after the tag. The stream ends with a single end tag.
+const (
+	snapshotMagic   = "HOARD\x01"
+	snapshotEntry   = 1
+	snapshotEnd     = 0
+	maxSnapshotSize = 1 << 31 // upper bound for a single key or value
+)
+
+// ErrInvalidSnapshot is returned when Load encounters a malformed snapshot.
+var ErrInvalidSnapshot = errors.New("hoard: invalid snapshot")
+
+// snapshotRecord is a single entry as stored in a snapshot.
+type snapshotRecord struct {
+	key        string
+	value      []byte
+	expiration int64
+	flags      byte
+}
+
+// Save writes every non-expired entry to w. Shards are copied one at a time
+// under a read lock and written after the lock is released, so a slow writer
+// never blocks the cache. Within a shard entries are written in eviction
+// order, next victim first, which Load preserves for list based policies.
+// Save may be called after Close to persist the final state of the cache.
+func (c *Cache) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+
+	var records []snapshotRecord
+	var buf []byte
+	for _, shard := range c.shards {
+		records = c.snapshotShard(shard, records[:0])
+		for _, rec := range records {
+			buf = appendSnapshotRecord(buf[:0], rec)
+			if err := bw.WriteByte(snapshotEntry); err != nil {
+				return err
+			}
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bw.WriteByte(snapshotEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// snapshotShard appends the live entries of shard to dst in eviction order,
+// next victim first. Values are immutable once stored, so they are referenced
+// rather than copied.
+func (c *Cache) snapshotShard(shard *CacheShard, dst []snapshotRecord) []snapshotRecord {
+	now := c.now()
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	add := func(item *CacheItem) {
+		if item.expired(now) {
+			return
+		}
+		dst = append(dst, snapshotRecord{
+			key:        item.key,
+			value:      shard.value(item),
+			expiration: item.Expiration,
+			flags:      item.flags,
+		})
+	}
+	// Pinned items are not tracked by the policy
+	for _, item := range shard.data {
+		if item.pinned {
+			add(item)
+		}
+	}
+	shard.policy.walk(add)
+	return dst
+}
+
+func appendSnapshotRecord(buf []byte, rec snapshotRecord) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(rec.key)))
+	buf = append(buf, rec.key...)
+	buf = binary.AppendVarint(buf, rec.expiration)
+	buf = append(buf, rec.flags)
+	buf = binary.AppendUvarint(buf, uint64(len(rec.value)))
+	buf = append(buf, rec.value...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// Load reads a snapshot produced by Save and stores its entries. Entries
+// whose deadline already passed are skipped and the remaining ones keep their
+// absolute expiration. Shard capacity is enforced as usual, so loading more
+// entries than fit evicts entries chosen by the eviction policy. A record
+// failing its checksum aborts the load, or is skipped and counted in
+// Stats.Corrupted with WithChecksums.
+func (c *Cache) Load(r io.Reader) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	var corrupt func(rec snapshotRecord)
+	if c.checksums {
+		corrupt = func(rec snapshotRecord) {
+			c.getShard(rec.key).stats.corrupted.Add(1)
+		}
+	}
+	return readSnapshot(r, corrupt, func(rec snapshotRecord) error {
+		if rec.expiration != 0 && c.now() > rec.expiration {
+			return nil
+		}
+		shard := c.getShard(rec.key)
+		shard.mu.Lock()
+		err := c.setLocked(shard, rec.key, rec.value, rec.expiration, rec.flags, 1)
+		shard.mu.Unlock()
+		return err
+	})
+}
+
+// SnapshotEntry is an entry read from a snapshot by ReadSnapshot, for tools
+// inspecting snapshot files without loading them into a cache.
+type SnapshotEntry struct {
+	Key        string
+	Expiration time.Time // zero if the entry never expires
+	Value      []byte    // the stored bytes, see Decode
+	flags      byte
+}
+
+// Decode returns the value of e as FetchData would with the default
+// serializer: raw bytes for StoreBytes entries, an int64 for counters and
+// the msgpack decoded value otherwise. Values stored compressed or encrypted
+// cannot be decoded without the cache's settings and fail with
+// ErrSerialization; negative entries fail with ErrNegativeEntry.
+func (e SnapshotEntry) Decode() (interface{}, error) {
+	switch {
+	case e.flags&itemCompressed != 0:
+		return nil, fmt.Errorf("%w: value is compressed", ErrSerialization)
+	case e.flags&itemEncrypted != 0:
+		return nil, fmt.Errorf("%w: value is encrypted", ErrSerialization)
+	case e.flags&itemNegative != 0:
+		return nil, ErrNegativeEntry
+	case e.flags&itemRaw != 0:
+		return bytes.Clone(e.Value), nil
+	case e.flags&itemInt != 0:
+		return decodeInt(e.Value), nil
+	}
+	var v interface{}
+	if err := (msgpackSerializer{}).Unmarshal(e.Value, &v); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSerialization, err)
+	}
+	return v, nil
+}
+
+// ReadSnapshot reads a snapshot written by Save and calls fn for every
+// entry, expired ones included, in the order they were saved. It stops at
+// the first error fn returns and fails with ErrInvalidSnapshot on malformed
+// input.
+func ReadSnapshot(r io.Reader, fn func(e SnapshotEntry) error) error {
+	return readSnapshot(r, nil, func(rec snapshotRecord) error {
+		e := SnapshotEntry{Key: rec.key, Value: rec.value, flags: rec.flags}
+		if rec.expiration != 0 {
+			e.Expiration = time.Unix(0, rec.expiration)
+		}
+		return fn(e)
+	})
+}
+
+// readSnapshot decodes a snapshot and calls fn for every record in order.
+// Records failing their checksum are passed to corrupt instead, or fail the
+// read if corrupt is nil.
+func readSnapshot(r io.Reader, corrupt func(rec snapshotRecord), fn func(rec snapshotRecord) error) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: bad header", ErrInvalidSnapshot)
+	}
+
+	cr := &crcReader{r: br}
+	for {
+		tag, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: missing end marker", ErrInvalidSnapshot)
+		}
+		if tag == snapshotEnd {
+			return nil
+		}
+		if tag != snapshotEntry {
+			return fmt.Errorf("%w: unknown record tag %d", ErrInvalidSnapshot, tag)
+		}
+
+		cr.crc = 0
+		rec, err := readSnapshotRecord(cr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		sum := cr.crc
+		var trailer [4]byte
+		if _, err := io.ReadFull(br, trailer[:]); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		if binary.LittleEndian.Uint32(trailer[:]) != sum {
+			if corrupt == nil {
+				return fmt.Errorf("%w: checksum mismatch for key %q", ErrInvalidSnapshot, rec.key)
+			}
+			corrupt(rec)
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func readSnapshotRecord(r *crcReader) (snapshotRecord, error) {
+	var rec snapshotRecord
+	key, err := readSnapshotBytes(r)
+	if err != nil {
+		return rec, err
+	}
+	rec.key = string(key)
+	if rec.expiration, err = binary.ReadVarint(r); err != nil {
+		return rec, err
+	}
+	if rec.flags, err = r.ReadByte(); err != nil {
+		return rec, err
+	}
+	rec.value, err = readSnapshotBytes(r)
+	return rec, err
+}
+
+func readSnapshotBytes(r *crcReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotSize {
+		return nil, fmt.Errorf("length %d out of range", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// crcReader feeds every byte it reads into a running CRC-32.
+type crcReader struct {
+	r   *bufio.Reader
+	crc uint32
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc = crc32.Update(cr.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc = crc32.Update(cr.crc, crc32.IEEETable, []byte{b})
+	}
+	return b, err
+}
+
+// SaveFile writes a snapshot of the cache to the file at path. The snapshot
+// is written to a temporary file in the same directory, synced and renamed
+// over path, so a crash or error mid-write never replaces the previous
+// snapshot with a partial one.
+func (c *Cache) SaveFile(path string) error {
+	start := time.Now()
+	err := writeFileAtomic(path, c.Save)
+	c.logSnapshot("hoard: snapshot saved", "hoard: snapshot save failed", path, start, err)
+	return err
+}
+
+// writeFileAtomic calls write with a temporary file next to path and renames
+// it over path only once write succeeded and the data reached stable storage.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// runAutoSnapshot saves the cache to path every interval until the cache is
+// closed. Failures are reported to the error handler.
+func (c *Cache) runAutoSnapshot(path string, interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			// Failures are logged by reportError, successes only at debug
+			// level as they come every interval
+			start := time.Now()
+			if err := writeFileAtomic(path, c.Save); err != nil {
+				c.reportError(fmt.Errorf("hoard: auto snapshot to %s: %w", path, err))
+			} else {
+				c.log(slog.LevelDebug, "hoard: auto snapshot saved", slog.String("path", path), slog.Duration("took", time.Since(start)))
+			}
+		}
+	}
+}
+
+// LoadFile loads a snapshot written by SaveFile.
+func (c *Cache) LoadFile(path string) error {
+	start := time.Now()
+	err := c.loadFile(path)
+	c.logSnapshot("hoard: snapshot loaded", "hoard: snapshot load failed", path, start, err)
+	return err
+}
+
+func (c *Cache) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
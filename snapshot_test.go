@@ -0,0 +1,279 @@
+package hoard
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testing that a snapshot round-trips values and absolute deadlines
+func TestSaveLoadRoundTrip(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), "value"+strconv.Itoa(i), time.Hour)
+	}
+	cache.Store("forever", 42, NoExpiration)
+	cache.Store("expired", "gone", -time.Second)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	restored := NewCache(4, 1000, time.Minute)
+	defer restored.Close()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		want, _ := cache.FetchBytesData(key)
+		got, exists := restored.FetchBytesData(key)
+		if !exists || !bytes.Equal(got, want) {
+			t.Fatalf("Expected %s to round-trip, got (%v, %v)", key, got, exists)
+		}
+		wantExp, _ := cache.ExpiresAt(key)
+		gotExp, _ := restored.ExpiresAt(key)
+		if !gotExp.Equal(wantExp) {
+			t.Fatalf("Expected deadline %v for %s, got %v", wantExp, key, gotExp)
+		}
+	}
+	if ttl, exists := restored.TTL("forever"); !exists || ttl != NoExpiration {
+		t.Fatalf("Expected 'forever' to keep NoExpiration, got (%v, %v)", ttl, exists)
+	}
+	if restored.Exists("expired") {
+		t.Fatal("Expected expired entry not to be restored")
+	}
+}
+
+// testing that Load honors the destination's shard capacity
+func TestLoadRespectsCapacity(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute)
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Hour)
+	}
+
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	if err := cache.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+
+	small := NewCache(1, 10, time.Minute)
+	defer small.Close()
+	if err := small.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	count := 0
+	small.Iterate(func(key string, value []byte) { count++ })
+	if count != 10 {
+		t.Fatalf("Expected 10 entries after load, got %d", count)
+	}
+	// The most recently used entries survive
+	if !small.Exists("key99") || small.Exists("key0") {
+		t.Fatal("Expected load to keep the most recently used entries")
+	}
+}
+
+// testing that corrupted and truncated snapshots are rejected
+func TestLoadInvalidSnapshot(t *testing.T) {
+	cache := NewCache(1, 100, time.Minute)
+	defer cache.Close()
+	cache.Store("aboubakr", "kouhadi", time.Hour)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	corrupted := bytes.Clone(data)
+	corrupted[len(corrupted)-8] ^= 0xff
+	truncated := data[:len(data)-3]
+
+	for name, snapshot := range map[string][]byte{
+		"corrupted": corrupted,
+		"truncated": truncated,
+		"garbage":   []byte("not a snapshot"),
+	} {
+		restored := NewCache(1, 100, time.Minute)
+		err := restored.Load(bytes.NewReader(snapshot))
+		restored.Close()
+		if !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("%s: expected ErrInvalidSnapshot, got %v", name, err)
+		}
+	}
+}
+
+// testing that a failed snapshot never clobbers the last good one
+func TestSaveFileIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.snapshot")
+
+	cache := NewCache(1, 100, time.Minute)
+	defer cache.Close()
+	cache.Store("aboubakr", "kouhadi", time.Hour)
+	if err := cache.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash halfway through writing the next snapshot
+	errCrash := errors.New("crash")
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		w.Write([]byte(snapshotMagic + "partial"))
+		return errCrash
+	})
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("Expected write error, got %v", err)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, good) {
+		t.Fatal("Expected the last good snapshot to be untouched")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("Expected temporary file to be removed, found %d files", len(entries))
+	}
+}
+
+// testing periodic snapshots and their shutdown
+func TestAutoSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	cache := NewCache(4, 100, time.Minute, WithAutoSnapshot(path, 10*time.Millisecond))
+	cache.Store("aboubakr", "kouhadi", time.Hour)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a snapshot to be written")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cache.Close()
+
+	restored := NewCache(4, 100, time.Minute)
+	defer restored.Close()
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if value, _, _ := restored.FetchData("aboubakr"); value != "kouhadi" {
+		t.Fatalf("Expected 'kouhadi', got '%v'", value)
+	}
+}
+
+// testing that snapshot failures reach the error handler
+func TestAutoSnapshotError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "cache.snapshot")
+	errs := make(chan error, 1)
+	cache := NewCache(4, 100, time.Minute,
+		WithAutoSnapshot(path, 10*time.Millisecond),
+		WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	defer cache.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Expected a not-exist error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the error handler to be called")
+	}
+}
+
+// testing that raw entries keep their raw flag across a snapshot
+func TestSaveLoadRawBytes(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	cache.StoreBytes("raw", []byte("raw payload"), time.Hour)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	restored := NewCache(4, 1000, time.Minute)
+	defer restored.Close()
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	value, _, err := restored.FetchData("raw")
+	if b, ok := value.([]byte); err != nil || !ok || string(b) != "raw payload" {
+		t.Fatalf("Expected raw bytes after reload, got (%#v, %v)", value, err)
+	}
+}
+
+// testing that ReadSnapshot returns the saved entries and decodes them
+func TestReadSnapshot(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 100, time.Minute, WithClock(clock))
+	defer cache.Close()
+	cache.Store("map", map[string]interface{}{"a": "b"}, time.Hour)
+	cache.StoreBytes("raw", []byte("payload"), NoExpiration)
+	cache.Increment("counter", 3, NoExpiration)
+	cache.StoreNegative("missing", NoExpiration)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got := make(map[string]interface{})
+	var expiration time.Time
+	err := ReadSnapshot(&buf, func(e SnapshotEntry) error {
+		v, err := e.Decode()
+		if err != nil {
+			got[e.Key] = err
+		} else {
+			got[e.Key] = v
+		}
+		if e.Key == "map" {
+			expiration = e.Expiration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	want := map[string]interface{}{
+		"map":     map[string]interface{}{"a": "b"},
+		"raw":     []byte("payload"),
+		"counter": int64(3),
+		"missing": ErrNegativeEntry,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	if !expiration.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Expected the expiration, got %v", expiration)
+	}
+
+	if err := ReadSnapshot(strings.NewReader("nope"), nil); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package hoard
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats holds cache counters. Counters are cumulative since the cache was
+// created or since the last ResetStats; ItemCount, Bytes and Cost describe the
+// current entries, including expired ones not yet removed.
+type Stats struct {
+	Hits            uint64 // fetches that found a live entry
+	NegativeHits    uint64 // fetches that found a negative entry, see StoreNegative
+	Misses          uint64 // fetches that found nothing or an expired entry
+	Expired         uint64 // expired entries removed lazily on access
+	CleanupRemovals uint64 // expired entries removed by the cleanup goroutine
+	Evictions       uint64 // entries evicted to make room
+	Promotions      uint64 // entries moved back into memory from the tier, see WithTier
+	Stores          uint64 // entries written by Store and its variants
+	Updates         uint64 // successful Update calls
+	Deletes         uint64 // live entries removed by Delete and DeleteMany
+	Invalidations   uint64 // live entries removed by events from other instances, see WithInvalidationBus
+	Corrupted       uint64 // entries and snapshot records that failed their checksum, see WithChecksums
+	Rejected        uint64 // new entries turned away by the admission filter, see WithAdmissionFilter
+	// DroppedEvents counts events not delivered because the channel of a
+	// Watch, WatchPrefix or Events subscriber was full. It covers the whole
+	// cache and is left zero by ShardStats.
+	DroppedEvents uint64
+	ItemCount     int
+	Bytes         int64 // estimated memory held by the entries, see WithMaxBytes
+	Cost          int64 // total cost of the entries, see StoreWithCost
+	// Latency holds the durations of operations with WithLatencyTracking
+	// and is nil otherwise. It covers the whole cache and is left nil by
+	// ShardStats.
+	Latency *LatencyStats
+}
+
+// add accumulates the counters of o into s.
+func (s *Stats) add(o Stats) {
+	s.Hits += o.Hits
+	s.NegativeHits += o.NegativeHits
+	s.Misses += o.Misses
+	s.Expired += o.Expired
+	s.CleanupRemovals += o.CleanupRemovals
+	s.Evictions += o.Evictions
+	s.Promotions += o.Promotions
+	s.Stores += o.Stores
+	s.Updates += o.Updates
+	s.Deletes += o.Deletes
+	s.Invalidations += o.Invalidations
+	s.Corrupted += o.Corrupted
+	s.Rejected += o.Rejected
+	s.ItemCount += o.ItemCount
+	s.Bytes += o.Bytes
+	s.Cost += o.Cost
+}
+
+// shardStats are the per-shard counters behind Stats. They are updated with
+// atomics so read paths holding only a read lock can record them too.
+type shardStats struct {
+	hits            atomic.Uint64
+	negativeHits    atomic.Uint64
+	misses          atomic.Uint64
+	expired         atomic.Uint64
+	cleanupRemovals atomic.Uint64
+	evictions       atomic.Uint64
+	promotions      atomic.Uint64
+	stores          atomic.Uint64
+	updates         atomic.Uint64
+	deletes         atomic.Uint64
+	invalidations   atomic.Uint64
+	corrupted       atomic.Uint64
+	rejected        atomic.Uint64
+}
+
+func (s *shardStats) snapshot() Stats {
+	return Stats{
+		Hits:            s.hits.Load(),
+		NegativeHits:    s.negativeHits.Load(),
+		Misses:          s.misses.Load(),
+		Expired:         s.expired.Load(),
+		CleanupRemovals: s.cleanupRemovals.Load(),
+		Evictions:       s.evictions.Load(),
+		Promotions:      s.promotions.Load(),
+		Stores:          s.stores.Load(),
+		Updates:         s.updates.Load(),
+		Deletes:         s.deletes.Load(),
+		Invalidations:   s.invalidations.Load(),
+		Corrupted:       s.corrupted.Load(),
+		Rejected:        s.rejected.Load(),
+	}
+}
+
+func (s *shardStats) reset() {
+	s.hits.Store(0)
+	s.negativeHits.Store(0)
+	s.misses.Store(0)
+	s.expired.Store(0)
+	s.cleanupRemovals.Store(0)
+	s.evictions.Store(0)
+	s.promotions.Store(0)
+	s.stores.Store(0)
+	s.updates.Store(0)
+	s.deletes.Store(0)
+	s.invalidations.Store(0)
+	s.corrupted.Store(0)
+	s.rejected.Store(0)
+}
+
+// Stats returns the counters aggregated over all shards.
+func (c *Cache) Stats() Stats {
+	var total Stats
+	for _, s := range c.ShardStats() {
+		total.add(s)
+	}
+	total.DroppedEvents = c.watch.dropped.Load()
+	total.Latency = c.latency.snapshot()
+	return total
+}
+
+// ShardStats returns the counters of every shard, indexed by shard, which
+// makes an uneven key distribution visible.
+func (c *Cache) ShardStats() []Stats {
+	stats := make([]Stats, len(c.shards))
+	for i, shard := range c.shards {
+		stats[i] = shard.stats.snapshot()
+		shard.mu.RLock()
+		stats[i].ItemCount = len(shard.data)
+		stats[i].Bytes = shard.bytes
+		stats[i].Cost = shard.cost
+		shard.mu.RUnlock()
+	}
+	return stats
+}
+
+// ResetStats sets every counter and latency histogram back to zero.
+// ItemCount, Bytes and Cost are not counters and are unaffected.
+func (c *Cache) ResetStats() {
+	for _, shard := range c.shards {
+		shard.stats.reset()
+	}
+	c.watch.dropped.Store(0)
+	c.latency.reset()
+}
+
+// expvarStats is the value PublishExpvar publishes.
+type expvarStats struct {
+	Stats
+	ShardItems []int
+}
+
+var (
+	expvarMu     sync.Mutex
+	expvarCaches = make(map[string]*atomic.Pointer[Cache])
+)
+
+// PublishExpvar publishes the cache's Stats and the number of entries in
+// each shard as the expvar name, shown by the /debug/vars handler and read
+// again on every request. Publishing a name already published by
+// PublishExpvar, typically by an earlier cache, makes it show this cache
+// instead; a name published by other code is an error.
+func (c *Cache) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if p, ok := expvarCaches[name]; ok {
+		p.Store(c)
+		return nil
+	}
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("hoard: expvar %q is already published", name)
+	}
+
+	p := new(atomic.Pointer[Cache])
+	p.Store(c)
+	expvarCaches[name] = p
+	expvar.Publish(name, expvar.Func(func() any {
+		shards := p.Load().ShardStats()
+		v := expvarStats{ShardItems: make([]int, len(shards))}
+		for i, s := range shards {
+			v.Stats.add(s)
+			v.ShardItems[i] = s.ItemCount
+		}
+		return v
+	}))
+	return nil
+}
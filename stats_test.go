@@ -0,0 +1,152 @@
+package hoard
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testing that every counter moves on its own path
+func TestStats(t *testing.T) {
+	cache := NewCache(1, 2, time.Hour) // 1 shard, max 2 items per shard
+	defer cache.Close()
+
+	cache.Store("aboubakr", "kouhadi", time.Minute)
+	cache.FetchData("aboubakr")    // hit
+	cache.FetchData("nonexistent") // miss
+
+	cache.Store("expired", "gone", -time.Second)
+	cache.FetchData("expired") // expired on fetch, miss
+
+	cache.Store("haroun", 30, time.Minute)
+	cache.Store("qux", 3.14, time.Minute) // evicts "aboubakr"
+	cache.Update("haroun", 31, time.Minute)
+	cache.Delete("haroun")
+
+	qux, _ := cache.serialize(3.14)
+	got := cache.Stats()
+	want := Stats{
+		Hits:      1,
+		Misses:    2,
+		Expired:   1,
+		Evictions: 1,
+		Stores:    4,
+		Updates:   1,
+		Deletes:   1,
+		ItemCount: 1,
+		Bytes:     itemSize("qux", qux),
+		Cost:      1,
+	}
+	if got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+
+	cache.ResetStats()
+	if got := cache.Stats(); got != (Stats{ItemCount: 1, Bytes: want.Bytes, Cost: 1}) {
+		t.Fatalf("Expected counters to reset, got %+v", got)
+	}
+}
+
+// testing that removals by the cleanup goroutine are counted
+func TestStatsCleanupRemovals(t *testing.T) {
+	cache := NewCache(4, 1000, 10*time.Millisecond)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, -time.Second)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for cache.Stats().CleanupRemovals < 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 10 cleanup removals, got %+v", cache.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := cache.Stats().ItemCount; n != 0 {
+		t.Fatalf("Expected an empty cache, got %d items", n)
+	}
+}
+
+// testing the per-shard breakdown adds up to the aggregate
+func TestShardStats(t *testing.T) {
+	cache := NewCache(8, 1000, time.Hour)
+	defer cache.Close()
+
+	for i := 0; i < 200; i++ {
+		key := "key" + strconv.Itoa(i)
+		cache.Store(key, i, time.Minute)
+		cache.FetchData(key)
+	}
+
+	shards := cache.ShardStats()
+	if len(shards) != 8 {
+		t.Fatalf("Expected 8 shards, got %d", len(shards))
+	}
+	var sum Stats
+	for _, s := range shards {
+		sum.add(s)
+	}
+	if sum != cache.Stats() || sum.Hits != 200 || sum.ItemCount != 200 {
+		t.Fatalf("Unexpected per-shard totals %+v", sum)
+	}
+}
+
+// testing that published stats follow the cache on every scrape
+func TestPublishExpvar(t *testing.T) {
+	cache := NewCache(2, 10, 0)
+	defer cache.Close()
+	if err := cache.PublishExpvar("hoard_test"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(expvar.Handler())
+	defer srv.Close()
+
+	scrape := func() expvarStats {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var vars struct {
+			Stats expvarStats `json:"hoard_test"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+			t.Fatal(err)
+		}
+		return vars.Stats
+	}
+
+	if s := scrape(); s.ItemCount != 0 || len(s.ShardItems) != 2 {
+		t.Fatalf("Expected an empty cache with 2 shards, got %+v", s)
+	}
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 2, time.Minute)
+	cache.FetchData("a")
+	cache.FetchData("missing")
+	s := scrape()
+	if s.ItemCount != 2 || s.Hits != 1 || s.Misses != 1 || s.Bytes == 0 || s.ShardItems[0]+s.ShardItems[1] != 2 {
+		t.Fatalf("Expected the stats to move, got %+v", s)
+	}
+
+	// Publishing the name again switches it to the new cache
+	other := NewCache(1, 10, 0)
+	defer other.Close()
+	if err := other.PublishExpvar("hoard_test"); err != nil {
+		t.Fatal(err)
+	}
+	if s := scrape(); s.ItemCount != 0 || len(s.ShardItems) != 1 {
+		t.Fatalf("Expected the new cache, got %+v", s)
+	}
+
+	if expvar.Get("hoard_test_taken") == nil {
+		expvar.NewInt("hoard_test_taken")
+	}
+	if err := cache.PublishExpvar("hoard_test_taken"); err == nil {
+		t.Fatal("Expected an error for a name published elsewhere")
+	}
+}
@@ -2,26 +2,108 @@ package hoard
 
 import (
 	"bytes"
+	"fmt"
 	"sync"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// Serializer converts values to and from the bytes stored in the cache.
+// Implementations must be safe for concurrent use.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// msgpackSerializer is the default Serializer.
+type msgpackSerializer struct{}
+
+// Marshal encodes v into a pooled buffer with a pooled encoder and returns
+// an owned copy of the result, so the only allocation left is the value
+// itself.
+func (msgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	enc := msgpack.GetEncoder()
+	enc.Reset(buf)
+
+	err := enc.Encode(v)
+	var out []byte
+	if err == nil {
+		out = bytes.Clone(buf.Bytes())
+	}
+
+	msgpack.PutEncoder(enc)
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+	return out, err
+}
+
+// Unmarshal decodes data with a pooled decoder reading from a pooled reader.
+func (msgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	r := readerPool.Get().(*bytes.Reader)
+	r.Reset(data)
+	dec := msgpack.GetDecoder()
+	dec.Reset(r)
+	dec.UsePreallocateValues(true)
+
+	err := dec.Decode(v)
+
+	msgpack.PutDecoder(dec)
+	r.Reset(nil)
+	readerPool.Put(r)
+	return err
+}
+
+// serialize encodes value with the cache's serializer. Errors wrap
+// ErrSerialization.
+func (c *Cache) serialize(value interface{}) ([]byte, error) {
+	data, err := c.serializer.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSerialization, err)
+	}
+	return data, nil
+}
+
+// deserialize decodes data with the cache's serializer. Errors wrap
+// ErrSerialization.
+func (c *Cache) deserialize(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := c.serializer.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSerialization, err)
+	}
+	return v, nil
+}
+
 // Serialization helpers
 
 var bufferPool = sync.Pool{
 	New: func() interface{} { return new(bytes.Buffer) },
 }
 
+var readerPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Reader) },
+}
+
+// maxPooledBufferSize keeps buffers grown by unusually large values out of
+// bufferPool.
+const maxPooledBufferSize = 64 << 10
+
+// Serialize encodes value with msgpack.
+//
+// Deprecated: caches encode values with the Serializer configured through
+// WithSerializer; Serialize always uses msgpack.
 func Serialize(value interface{}) ([]byte, error) {
-	buf := bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer bufferPool.Put(buf)
-	return msgpack.Marshal(value)
+	return msgpackSerializer{}.Marshal(value)
 }
 
+// Deserialize decodes msgpack data.
+//
+// Deprecated: caches decode values with the Serializer configured through
+// WithSerializer; Deserialize always uses msgpack.
 func Deserialize(data []byte) (interface{}, error) {
 	var v interface{}
-	err := msgpack.Unmarshal(data, &v)
+	err := msgpackSerializer{}.Unmarshal(data, &v)
 	return v, err
 }
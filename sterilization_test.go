@@ -0,0 +1,139 @@
+package hoard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// jsonSerializer stores values as JSON.
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// bytesSerializer stores []byte values untouched.
+type bytesSerializer struct{}
+
+func (bytesSerializer) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("bytesSerializer: unsupported type %T", v)
+	}
+	return bytes.Clone(b), nil
+}
+
+func (bytesSerializer) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*interface{})
+	if !ok {
+		return fmt.Errorf("bytesSerializer: unsupported destination %T", v)
+	}
+	*p = bytes.Clone(data)
+	return nil
+}
+
+// testing that Store, Update and Fetch go through a JSON serializer
+func TestJSONSerializer(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute, WithSerializer(jsonSerializer{}))
+	defer cache.Close()
+
+	if err := cache.Store("aboubakr", map[string]interface{}{"name": "kouhadi"}, time.Minute); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	data, _ := cache.FetchBytesData("aboubakr")
+	if string(data) != `{"name":"kouhadi"}` {
+		t.Fatalf("Expected JSON bytes, got %s", data)
+	}
+
+	if err := cache.Update("aboubakr", []int{1, 2, 3}, time.Minute); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	value, exists, err := cache.FetchData("aboubakr")
+	if err != nil || !exists {
+		t.Fatalf("Fetch failed: (%v, %v)", exists, err)
+	}
+	if fmt.Sprint(value) != "[1 2 3]" {
+		t.Fatalf("Expected [1 2 3], got %v", value)
+	}
+}
+
+// testing a passthrough serializer for values that are already bytes
+func TestBytesSerializer(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute, WithSerializer(bytesSerializer{}))
+	defer cache.Close()
+
+	payload := []byte{0x0a, 0x03, 'f', 'o', 'o'}
+	if err := cache.Store("proto", payload, time.Minute); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	data, _ := cache.FetchBytesData("proto")
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("Expected bytes to be stored untouched, got %v", data)
+	}
+	value, _, err := cache.FetchData("proto")
+	if err != nil || !bytes.Equal(value.([]byte), payload) {
+		t.Fatalf("Expected %v, got (%v, %v)", payload, value, err)
+	}
+
+	if err := cache.Store("bad", "not bytes", time.Minute); err == nil {
+		t.Fatal("Expected serializer error to be returned by Store")
+	}
+	if errs := cache.StoreMany(map[string]interface{}{"bad": 1}, time.Minute); errs["bad"] == nil {
+		t.Fatal("Expected serializer error to be reported by StoreMany")
+	}
+	if err := cache.Update("proto", 1, time.Minute); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected serializer error from Update, got %v", err)
+	}
+}
+
+// blockingSerializer is a JSON serializer whose Unmarshal waits for release.
+type blockingSerializer struct {
+	jsonSerializer
+	decoding chan struct{}
+	release  chan struct{}
+}
+
+func (s blockingSerializer) Unmarshal(data []byte, v interface{}) error {
+	s.decoding <- struct{}{}
+	<-s.release
+	return s.jsonSerializer.Unmarshal(data, v)
+}
+
+// testing that FetchData decodes after releasing the shard lock, from a copy
+// that later writes do not disturb
+func TestFetchDecodesOutsideLock(t *testing.T) {
+	ser := blockingSerializer{decoding: make(chan struct{}), release: make(chan struct{})}
+	cache := NewCache(1, 10, 0, WithSerializer(ser))
+	defer cache.Close()
+	cache.Store("key", "old", time.Minute)
+
+	fetched := make(chan interface{})
+	go func() {
+		value, _, _ := cache.FetchData("key")
+		fetched <- value
+	}()
+	<-ser.decoding
+
+	// The shard must be writable while the value is being decoded
+	stored := make(chan error)
+	go func() {
+		stored <- cache.Store("key", "new", time.Minute)
+		cache.Delete("key")
+	}()
+	select {
+	case err := <-stored:
+		if err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Store not to wait for a decode in progress")
+	}
+
+	close(ser.release)
+	if value := <-fetched; value != "old" {
+		t.Fatalf("Expected the decode to see the fetched value, got %v", value)
+	}
+}
@@ -0,0 +1,65 @@
+package hoard
+
+import (
+	"context"
+	"time"
+)
+
+// StoreTagged stores value under key like Store and attaches tags to it, so
+// the entry can later be removed with InvalidateTag. Storing the key again
+// replaces its tags; Update keeps them. Tags are not saved in snapshots or
+// the write log.
+func (c *Cache) StoreTagged(key string, value interface{}, ttl time.Duration, tags ...string) error {
+	return c.store(context.Background(), key, value, 1, ItemOptions{TTL: ttl, Tags: tags}, nil)
+}
+
+// InvalidateTag removes every entry tagged with tag and returns how many live
+// entries were removed. Each shard drops its members and its index entry
+// under a single write lock.
+func (c *Cache) InvalidateTag(tag string) int {
+	now := c.now()
+	deleted := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for key := range shard.tags[tag] {
+			if c.removeLocked(shard, key, now) {
+				deleted++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return deleted
+}
+
+// tag attaches tags to item and adds it to the shard's tag index. The caller
+// must hold s.mu for writing.
+func (s *CacheShard) tag(item *CacheItem, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]map[string]struct{})
+	}
+	item.tags = append(item.tags[:0], tags...)
+	for _, tag := range tags {
+		keys := s.tags[tag]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[item.key] = struct{}{}
+	}
+}
+
+// untag removes item from the shard's tag index and clears its tags. The
+// caller must hold s.mu for writing.
+func (s *CacheShard) untag(item *CacheItem) {
+	for _, tag := range item.tags {
+		keys := s.tags[tag]
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+	item.tags = item.tags[:0]
+}
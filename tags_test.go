@@ -0,0 +1,106 @@
+package hoard
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testing an entry with several tags
+func TestStoreTaggedMultipleTags(t *testing.T) {
+	cache := NewCache(4, 100, time.Hour)
+	defer cache.Close()
+
+	cache.StoreTagged("page:home", "<html>", time.Minute, "user:5", "product:9")
+	cache.StoreTagged("page:cart", "<html>", time.Minute, "user:5")
+	cache.StoreTagged("page:product", "<html>", time.Minute, "product:9")
+	cache.Store("untagged", "value", time.Minute)
+
+	if n := cache.InvalidateTag("product:9"); n != 2 {
+		t.Fatalf("Expected 2 entries invalidated, got %d", n)
+	}
+	if cache.Exists("page:home") || cache.Exists("page:product") {
+		t.Fatal("Expected product:9 pages to be gone")
+	}
+	// page:home is gone, so only page:cart is left under user:5
+	if n := cache.InvalidateTag("user:5"); n != 1 {
+		t.Fatalf("Expected 1 entry invalidated, got %d", n)
+	}
+	if !cache.Exists("untagged") || cache.Len() != 1 {
+		t.Fatal("Expected only the untagged entry to remain")
+	}
+	assertNoTags(t, cache)
+}
+
+// testing that evicted, deleted, expired and overwritten entries leave the
+// tag index
+func TestTagIndexCleanup(t *testing.T) {
+	cache := NewCache(1, 2, time.Hour)
+	defer cache.Close()
+
+	cache.StoreTagged("a", 1, time.Minute, "group")
+	cache.StoreTagged("b", 2, time.Minute, "group")
+	cache.Store("c", 3, time.Minute) // evicts "a"
+	if n := len(cache.shards[0].tags["group"]); n != 1 {
+		t.Fatalf("Expected the evicted key to leave the index, got %d members", n)
+	}
+
+	cache.Update("b", 20, time.Minute) // keeps tags
+	if n := cache.InvalidateTag("group"); n != 1 {
+		t.Fatalf("Expected the updated entry to stay tagged, got %d", n)
+	}
+
+	cache.StoreTagged("d", 4, time.Minute, "other")
+	cache.Store("d", 5, time.Minute) // plain Store drops tags
+	cache.StoreTagged("e", 6, -time.Second, "other")
+	cache.FetchData("e") // expired, removed on access
+	cache.Delete("c")
+	assertNoTags(t, cache)
+	if n := cache.InvalidateTag("other"); n != 0 {
+		t.Fatalf("Expected no tagged entries, got %d", n)
+	}
+}
+
+// testing InvalidateTag racing with StoreTagged
+func TestInvalidateTagConcurrent(t *testing.T) {
+	cache := NewCache(8, 1000, time.Hour)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				cache.StoreTagged("key"+strconv.Itoa(w)+"-"+strconv.Itoa(i), i, time.Minute, "tag")
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				cache.InvalidateTag("tag")
+			}
+		}()
+	}
+	wg.Wait()
+
+	cache.InvalidateTag("tag")
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("Expected every tagged entry to be gone, got %d", n)
+	}
+	assertNoTags(t, cache)
+	checkIntegrity(t, cache)
+}
+
+func assertNoTags(t *testing.T, cache *Cache) {
+	t.Helper()
+	for i, shard := range cache.shards {
+		shard.mu.RLock()
+		n := len(shard.tags)
+		shard.mu.RUnlock()
+		if n != 0 {
+			t.Fatalf("Expected an empty tag index in shard %d, got %v", i, shard.tags)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package hoard
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+// TierStore is a second, typically slower tier that entries evicted from
+// memory spill into, configured with WithTier. A key is held by at most one
+// tier at a time. Implementations must be safe for concurrent use.
+type TierStore interface {
+	// Put stores entry under key, replacing any previous entry.
+	Put(key string, entry TierEntry) error
+	// Get returns the entry stored under key, reporting false if there is
+	// none.
+	Get(key string) (TierEntry, bool, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key string) error
+}
+
+// TierEntry is an entry held by a TierStore.
+type TierEntry struct {
+	Value      []byte
+	Expiration int64 // unix nanoseconds, 0 means the entry never expires
+	Flags      byte  // opaque to the store, returned as given
+}
+
+// evictLockedItem evicts victim from shard, spilling it into the tier if
+// one is configured and the entry is still live. The put is queued to run
+// once shard.mu is released, see ioOp. The caller must hold shard.mu for
+// writing.
+func (c *Cache) evictLockedItem(shard *CacheShard, victim *CacheItem) {
+	if c.tier != nil && !victim.expired(c.now()) {
+		entry := TierEntry{Value: shard.ownedValue(victim), Expiration: victim.Expiration, Flags: victim.flags}
+		c.queueIO(shard, &ioOp{kind: ioTierPut, key: victim.key, entry: entry})
+	}
+	key, val, flags := victim.key, shard.value(victim), victim.flags
+	if e := shard.evicted; e != nil && !e.ok && key != e.store {
+		*e = evictedEntry{store: e.store, key: key, value: val, flags: flags, ok: true}
+	}
+	shard.removeItem(key, victim)
+	shard.stats.evictions.Add(1)
+	if c.logger != nil {
+		c.log(slog.LevelDebug, "hoard: evicted", slog.String("key", key))
+	}
+	c.notifyRemoved(OpEvict, key, val, flags)
+}
+
+// dropFromTierLocked queues the delete of key from the tier, so a stale copy
+// cannot come back. The caller must hold shard.mu, the lock of key, for
+// writing.
+func (c *Cache) dropFromTierLocked(shard *CacheShard, key string) {
+	if c.tier != nil {
+		c.queueIO(shard, &ioOp{kind: ioTierDelete, key: key})
+	}
+}
+
+// promote looks key up in the tier after a memory miss and moves a live hit
+// back into memory. The tier is read without the shard lock, in the turn of
+// key so earlier puts and deletes are done, and the hit is only moved if no
+// I/O was queued for key since, which would mean it was written meanwhile.
+func (c *Cache) promote(shard *CacheShard, key string, now int64) (itemView, bool) {
+	shard.mu.Lock()
+	// Another caller may have stored or promoted the key since our miss
+	if item, ok := shard.data[key]; ok && !item.expired(now) {
+		v := shard.viewOf(item)
+		shard.mu.Unlock()
+		return v, true
+	}
+	turn := c.turns.take(key)
+	shard.mu.Unlock()
+
+	turn.wait(context.Background())
+	entry, ok, err := c.tier.Get(key)
+	c.turns.pass(turn)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	current := c.turns.forget(turn)
+	if item, ok := shard.data[key]; ok && !item.expired(now) {
+		return shard.viewOf(item), true
+	}
+	if err != nil {
+		c.reportError(fmt.Errorf("hoard: tier get %q: %w", key, err))
+		return itemView{}, false
+	}
+	if !ok {
+		return itemView{}, false
+	}
+	if entry.Expiration != 0 && now > entry.Expiration {
+		if current {
+			c.dropFromTierLocked(shard, key)
+		}
+		return itemView{}, false
+	}
+	// setLocked drops the tier copy. A value that does not fit is still
+	// returned, and stays in the tier.
+	v := itemView{value: entry.Value, expiration: entry.Expiration, flags: entry.Flags}
+	if current && c.setLocked(shard, key, entry.Value, entry.Expiration, entry.Flags, 1) == nil {
+		if _, ok := shard.data[key]; ok {
+			shard.stats.promotions.Add(1)
+		}
+	}
+	return v, true
+}
+
+// FileTier is a TierStore that appends values to a file and indexes them in
+// memory. Space taken by deleted and replaced values is reclaimed by
+// rewriting the live values into a new file once they take less than half of
+// a file of at least fileTierCompactMin bytes, so the file stays within
+// about twice the size of the live values. The file is scratch space: its
+// contents do not survive the process.
+type FileTier struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	size  int64 // bytes written to f
+	live  int64 // bytes of f the index refers to
+	index map[string]fileTierRecord
+}
+
+// fileTierCompactMin is the smallest file a FileTier compacts.
+const fileTierCompactMin = 1 << 20
+
+// fileTierRecord locates a value in a FileTier's file.
+type fileTierRecord struct {
+	off, n     int64
+	expiration int64
+	flags      byte
+}
+
+// OpenFileTier creates or truncates the file at path and returns a FileTier
+// backed by it. Compaction writes a temporary file next to it.
+func OpenFileTier(path string) (*FileTier, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTier{path: path, f: f, index: make(map[string]fileTierRecord)}, nil
+}
+
+// Put appends entry's value to the file.
+func (t *FileTier) Put(key string, entry TierEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.f.WriteAt(entry.Value, t.size); err != nil {
+		return err
+	}
+	t.forget(key)
+	t.index[key] = fileTierRecord{
+		off:        t.size,
+		n:          int64(len(entry.Value)),
+		expiration: entry.Expiration,
+		flags:      entry.Flags,
+	}
+	t.size += int64(len(entry.Value))
+	t.live += int64(len(entry.Value))
+	return t.maybeCompact()
+}
+
+// Get reads the value stored under key back from the file.
+func (t *FileTier) Get(key string) (TierEntry, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.index[key]
+	if !ok {
+		return TierEntry{}, false, nil
+	}
+	val := make([]byte, rec.n)
+	if _, err := t.f.ReadAt(val, rec.off); err != nil {
+		return TierEntry{}, false, err
+	}
+	return TierEntry{Value: val, Expiration: rec.expiration, Flags: rec.flags}, true, nil
+}
+
+// Delete forgets key, truncating the file once no key is left.
+func (t *FileTier) Delete(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.forget(key) {
+		return nil
+	}
+	if len(t.index) == 0 && t.size > 0 {
+		t.size = 0
+		return t.f.Truncate(0)
+	}
+	return t.maybeCompact()
+}
+
+// forget drops key from the index and reports whether it was there. The
+// caller must hold t.mu.
+func (t *FileTier) forget(key string) bool {
+	rec, ok := t.index[key]
+	if ok {
+		delete(t.index, key)
+		t.live -= rec.n
+	}
+	return ok
+}
+
+// maybeCompact compacts the file if dead values fill more than half of it.
+// The caller must hold t.mu.
+func (t *FileTier) maybeCompact() error {
+	if t.size < fileTierCompactMin || t.live*2 >= t.size {
+		return nil
+	}
+	if err := t.compact(); err != nil {
+		return fmt.Errorf("hoard: compact file tier: %w", err)
+	}
+	return nil
+}
+
+// compact copies the live values into a new file, which then replaces the
+// current one. On failure the current file is kept. The caller must hold
+// t.mu.
+func (t *FileTier) compact() error {
+	tmp := t.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]fileTierRecord, len(t.index))
+	var size int64
+	var buf []byte
+	for key, rec := range t.index {
+		buf = slices.Grow(buf[:0], int(rec.n))[:rec.n]
+		if _, err = t.f.ReadAt(buf, rec.off); err != nil {
+			break
+		}
+		if _, err = f.WriteAt(buf, size); err != nil {
+			break
+		}
+		rec.off = size
+		index[key] = rec
+		size += rec.n
+	}
+	if err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	t.f.Close()
+	t.f, t.index, t.size = f, index, size
+	return nil
+}
+
+// Len returns the number of entries in the tier.
+func (t *FileTier) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.index)
+}
+
+// Close closes the file.
+func (t *FileTier) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.f.Close()
+}
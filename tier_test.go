@@ -0,0 +1,216 @@
+package hoard
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testing that keys evicted from a full shard stay fetchable from a file tier
+func TestFileTierOverflow(t *testing.T) {
+	tier, err := OpenFileTier(filepath.Join(t.TempDir(), "tier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tier.Close()
+	cache := NewCache(1, 100, 0, WithTier(tier))
+	defer cache.Close()
+
+	for i := 0; i < 1000; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Minute)
+	}
+	cache.StoreBytes("raw", []byte("bytes"), time.Minute)
+	if n, spilled := cache.Len(), tier.Len(); n != 100 || spilled != 901 {
+		t.Fatalf("Expected 100 entries in memory and 901 in the tier, got %d and %d", n, spilled)
+	}
+
+	for i := 0; i < 1000; i++ {
+		value, ok, err := cache.FetchData("key" + strconv.Itoa(i))
+		if err != nil || !ok || fmt.Sprint(value) != strconv.Itoa(i) {
+			t.Fatalf("Expected key%d to be fetchable, got %v (%v, %v)", i, value, ok, err)
+		}
+	}
+	if data, ok := cache.FetchBytes("raw"); !ok || string(data) != "bytes" {
+		t.Fatalf("Expected raw bytes from the tier, got %q (%v)", data, ok)
+	}
+	if n := cache.Stats().Promotions; n < 900 {
+		t.Fatalf("Expected promotions to be counted, got %d", n)
+	}
+	if total := cache.Len() + tier.Len(); total != 1001 {
+		t.Fatalf("Expected every key in exactly one tier, got %d", total)
+	}
+}
+
+// testing that tier entries keep their expiration and that deletes and new
+// stores remove stale copies
+func TestTierExpirationAndDelete(t *testing.T) {
+	clock := newFakeClock()
+	tier, err := OpenFileTier(filepath.Join(t.TempDir(), "tier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tier.Close()
+	cache := NewCache(1, 2, 0, WithTier(tier), WithClock(clock))
+	defer cache.Close()
+
+	cache.Store("short", "value", time.Second)
+	cache.Store("long", "value", time.Hour)
+	cache.Store("deleted", "value", time.Hour)
+	cache.Store("restored", "old", time.Hour)
+	// short and long are spilled
+	if tier.Len() != 2 {
+		t.Fatalf("Expected 2 spilled entries, got %d", tier.Len())
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := cache.FetchBytesData("short"); ok {
+		t.Fatal("Expected an expired tier entry to be a miss")
+	}
+	if _, ok := cache.FetchBytesData("long"); !ok {
+		t.Fatal("Expected a live tier entry to be a hit")
+	}
+
+	// deleted is now in the tier; Delete must not let it come back
+	cache.Delete("deleted")
+	if _, ok := cache.FetchBytesData("deleted"); ok {
+		t.Fatal("Expected a deleted key to stay deleted")
+	}
+
+	// Storing a spilled key removes the stale copy from the tier
+	cache.Store("other", "value", time.Hour) // spills restored
+	cache.Store("restored", "new", time.Hour)
+	cache.Delete("restored")
+	if _, ok := cache.FetchBytesData("restored"); ok {
+		t.Fatal("Expected no stale copy of restored")
+	}
+}
+
+// gatedTier is an in-memory TierStore whose puts wait for gate when it is
+// set.
+type gatedTier struct {
+	mu      sync.Mutex
+	entries map[string]TierEntry
+	gate    chan struct{}
+}
+
+func (g *gatedTier) Put(key string, entry TierEntry) error {
+	if g.gate != nil {
+		<-g.gate
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.entries[key] = entry
+	return nil
+}
+
+func (g *gatedTier) Get(key string) (TierEntry, bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.entries[key]
+	return e, ok, nil
+}
+
+func (g *gatedTier) Delete(key string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, key)
+	return nil
+}
+
+// testing that a slow spill holds up neither readers nor writers of other
+// keys in the same shard, and that the spilled key is fetchable once it is
+// done
+func TestTierCalledOutsideLock(t *testing.T) {
+	tier := &gatedTier{entries: make(map[string]TierEntry)}
+	cache := NewCache(1, 2, 0, WithTier(tier))
+	defer cache.Close()
+	cache.Store("a", "a", time.Minute)
+	cache.Store("b", "b", time.Minute)
+	tier.gate = make(chan struct{})
+
+	done := make(chan error, 1)
+	go func() { done <- cache.Store("c", "c", time.Minute) }() // spills a
+	waitFor(t, func() bool { return cache.Exists("c") })
+	if value, ok, err := cache.FetchData("b"); !ok || err != nil || value != "b" {
+		t.Fatalf("Expected a read during the spill, got %v (%v, %v)", value, ok, err)
+	}
+	if err := cache.Update("c", "new", time.Minute); err != nil {
+		t.Fatalf("Expected a write during the spill, got %v", err)
+	}
+
+	// A miss on the spilled key waits for the put rather than missing it
+	fetched := make(chan interface{}, 1)
+	go func() {
+		value, _, _ := cache.FetchData("a")
+		fetched <- value
+	}()
+	close(tier.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if value := <-fetched; value != "a" {
+		t.Fatalf("Expected the spilled value, got %v", value)
+	}
+}
+
+// testing that a tier hit the admission filter keeps out of memory is
+// returned but not counted as a promotion
+func TestTierPromotionRejected(t *testing.T) {
+	tier := &gatedTier{entries: make(map[string]TierEntry)}
+	cache := NewCache(1, 2, 0, WithTier(tier), WithAdmissionFilter())
+	defer cache.Close()
+	cache.Store("a", "a", time.Minute)
+	cache.Store("b", "b", time.Minute)
+	for i := 0; i < 5; i++ {
+		cache.FetchData("a")
+		cache.FetchData("b")
+	}
+	val, _ := Serialize("cold")
+	tier.Put("cold", TierEntry{Value: val})
+
+	if value, ok, err := cache.FetchData("cold"); !ok || err != nil || value != "cold" {
+		t.Fatalf("Expected the tier value, got %v (%v, %v)", value, ok, err)
+	}
+	if cache.Exists("cold") {
+		t.Fatal("Expected the admission filter to keep the cold key out")
+	}
+	if n := cache.Stats().Promotions; n != 0 {
+		t.Fatalf("Expected no promotion, got %d", n)
+	}
+}
+
+// testing that a file tier rewriting the same keys reclaims the space of the
+// values they replaced
+func TestFileTierCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tier")
+	tier, err := OpenFileTier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tier.Close()
+
+	value := make([]byte, 64<<10)
+	for i := 0; i < 200; i++ {
+		value[0] = byte(i)
+		if err := tier.Put("key"+strconv.Itoa(i%4), TierEntry{Value: value, Flags: byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := int64(2*fileTierCompactMin + len(value)); info.Size() > max {
+		t.Fatalf("Expected the file to stay under %d bytes, got %d", max, info.Size())
+	}
+	for i := 196; i < 200; i++ {
+		e, ok, err := tier.Get("key" + strconv.Itoa(i%4))
+		if err != nil || !ok || e.Value[0] != byte(i) || e.Flags != byte(i) || len(e.Value) != len(value) {
+			t.Fatalf("Expected the last value of key%d, got %v (%v)", i%4, ok, err)
+		}
+	}
+}
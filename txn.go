@@ -0,0 +1,261 @@
+package hoard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrKeyNotInTxn is returned by the methods of a Tx for keys that were not
+// declared to Txn.
+var ErrKeyNotInTxn = errors.New("hoard: key not declared in transaction")
+
+// Tx is the view of the cache inside a Txn callback. It is only valid until
+// the callback returns and must not be used from other goroutines.
+type Tx struct {
+	c      *Cache
+	now    int64
+	keys   map[string]struct{}
+	writes map[string]txWrite
+	order  []string // keys in the order they were first written
+	shards []*CacheShard
+}
+
+// txWrite is a pending change: a value to store or, if del is set, a delete.
+type txWrite struct {
+	val    []byte // serialized, for Get
+	stored []byte // packed, for commit
+	flags  byte
+	exp    int64
+	del    bool
+}
+
+// Txn runs fn with exclusive access to keys and applies the changes fn makes
+// through tx all together if fn returns nil, or discards them if it returns
+// an error, which Txn then returns. The shards of keys are locked in index
+// order for the whole call, so transactions never deadlock each other and
+// other reads and writes of those shards wait for the commit; a reader using
+// Txn therefore sees all of another transaction's changes or none. fn must
+// not call other methods of the cache for keys in the same shards.
+//
+// Committed writes go through the backend, write log, watchers and
+// invalidation bus like Store and Delete. The commit is all or nothing: it
+// first makes room for the new keys and saves the stored values to the
+// backend, while still holding the locks, and fails with none of the changes
+// applied if a shard is full of pinned entries or a save fails. The values
+// saved before the failing one are not undone in the backend.
+func (c *Cache) Txn(keys []string, fn func(tx *Tx) error) (err error) {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	tx := &Tx{c: c, keys: make(map[string]struct{}, len(keys)), writes: make(map[string]txWrite)}
+	var idx []int
+	for _, key := range keys {
+		if err := c.checkKey(key); err != nil {
+			return err
+		}
+		tx.keys[key] = struct{}{}
+		idx = append(idx, c.shardIndex(key))
+	}
+	slices.Sort(idx)
+	idx = slices.Compact(idx)
+	for _, i := range idx {
+		c.shards[i].mu.Lock()
+		tx.shards = append(tx.shards, c.shards[i])
+	}
+	defer func() {
+		for _, i := range slices.Backward(idx) {
+			err = c.shards[i].mu.unlockErr(err)
+		}
+	}()
+
+	tx.now = c.now()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+func (tx *Tx) check(key string) error {
+	if _, ok := tx.keys[key]; !ok {
+		return fmt.Errorf("%w: %q", ErrKeyNotInTxn, key)
+	}
+	return nil
+}
+
+// Get returns the value of key as FetchData would, including changes made
+// earlier in the transaction. It does not count as an access.
+func (tx *Tx) Get(key string) (interface{}, bool, error) {
+	if err := tx.check(key); err != nil {
+		return nil, false, err
+	}
+	if w, ok := tx.writes[key]; ok {
+		if w.del {
+			return nil, false, nil
+		}
+		val, err := tx.c.deserialize(w.val)
+		return val, true, err
+	}
+	shard := tx.c.getShard(key)
+	item, ok := shard.data[key]
+	if !ok || item.expired(tx.now) {
+		return nil, false, nil
+	}
+	val, err := tx.c.decode(shard.viewOf(item))
+	return val, true, err
+}
+
+// Set stores value under key with ttl when the transaction commits.
+func (tx *Tx) Set(key string, value interface{}, ttl time.Duration) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+	val, err := tx.c.serialize(value)
+	if err != nil {
+		return err
+	}
+	if err := tx.c.checkValue(key, val); err != nil {
+		return err
+	}
+	stored, flags, err := tx.c.pack(val, 0)
+	if err != nil {
+		return err
+	}
+	tx.write(key, txWrite{val: val, stored: stored, flags: flags, exp: tx.c.expiration(ttl)})
+	return nil
+}
+
+// Delete removes key when the transaction commits.
+func (tx *Tx) Delete(key string) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+	tx.write(key, txWrite{del: true})
+	return nil
+}
+
+func (tx *Tx) write(key string, w txWrite) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// commit applies the pending changes, or none of them if one would fail:
+// it makes room for the keys it adds and saves the stored values first, and
+// then applies the deletes, which free room, before the stores. The caller
+// holds the shard locks.
+func (tx *Tx) commit() error {
+	c := tx.c
+	defer tx.release()
+	if err := tx.reserve(); err != nil {
+		return err
+	}
+	stores := make(map[string]pendingStore, len(tx.writes))
+	for _, key := range tx.order {
+		w := tx.writes[key]
+		if w.del {
+			continue
+		}
+		p := pendingStore{val: w.val, stored: w.stored, flags: w.flags, exp: w.exp, cost: 1, saved: true}
+		if err := tx.save(key, p); err != nil {
+			return err
+		}
+		stores[key] = p
+	}
+
+	for _, key := range tx.order {
+		if tx.writes[key].del {
+			c.removeLocked(c.getShard(key), key, tx.now)
+		}
+	}
+	for _, key := range tx.order {
+		if p, ok := stores[key]; ok {
+			if err := c.storeLocked(c.getShard(key), key, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reserve makes room in each shard for the keys the commit adds, evicting as
+// a store would, and reserves them under WithMaxItems, so that storing them
+// cannot fail with ErrCacheFull.
+func (tx *Tx) reserve() error {
+	c := tx.c
+	for _, shard := range tx.shards {
+		// An eviction may take a key the commit overwrites, which it then adds
+		for {
+			adds, dels := tx.count(shard)
+			if len(shard.data)-dels+adds <= shard.capacity {
+				break
+			}
+			victim := shard.policy.victim()
+			if victim == nil {
+				return ErrCacheFull
+			}
+			c.evictLockedItem(shard, victim)
+		}
+		if c.maxItems == 0 {
+			continue
+		}
+		for {
+			if adds, _ := tx.count(shard); shard.reserved >= adds {
+				break
+			}
+			if !c.reserveItem(shard) {
+				return ErrCacheFull
+			}
+			shard.reserved++
+		}
+	}
+	return nil
+}
+
+// count returns how many keys of shard the commit adds and deletes.
+func (tx *Tx) count(shard *CacheShard) (adds, dels int) {
+	for key, w := range tx.writes {
+		if tx.c.getShard(key) != shard {
+			continue
+		}
+		_, ok := shard.data[key]
+		switch {
+		case w.del && ok:
+			dels++
+		case !w.del && !ok:
+			adds++
+		}
+	}
+	return adds, dels
+}
+
+// release returns the reservations the commit did not use.
+func (tx *Tx) release() {
+	for _, shard := range tx.shards {
+		if shard.reserved > 0 {
+			tx.c.items.total.Add(-int64(shard.reserved))
+			shard.reserved = 0
+		}
+	}
+}
+
+// save writes p through to the backend, or queues it with write-behind, after
+// the I/O already queued for key. Waiting for it under the locks is safe, as
+// turn holders take no locks.
+func (tx *Tx) save(key string, p pendingStore) error {
+	c := tx.c
+	if c.backend == nil {
+		return nil
+	}
+	val, ok, err := c.backendValue(p.view())
+	if err != nil || !ok {
+		return err
+	}
+	turn := c.turns.take(key)
+	turn.wait(context.Background())
+	defer c.turns.done(turn)
+	return c.save(context.Background(), key, val)
+}
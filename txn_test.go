@@ -0,0 +1,218 @@
+package hoard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// txnShards places "user" and the "index:" keys in different shards.
+func txnShards(key string, n int) int {
+	switch key {
+	case "user":
+		return 0
+	case "index:name":
+		return 1
+	case "index:email":
+		return 3
+	}
+	return 2
+}
+
+// newTxnCache returns a cache sharded by txnShards.
+func newTxnCache(t *testing.T) *Cache {
+	t.Helper()
+	cache := NewCache(4, 100, 0, WithShardingFunc(txnShards))
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+// failKeyBackend is a memBackend failing the saves of one key.
+type failKeyBackend struct {
+	*memBackend
+	key string
+}
+
+func (b *failKeyBackend) Save(key string, value []byte) error {
+	if key == b.key {
+		return errors.New("backend down")
+	}
+	return b.memBackend.Save(key, value)
+}
+
+// testing a transaction spanning several shards
+func TestTxn(t *testing.T) {
+	cache := newTxnCache(t)
+	cache.Store("index:email", "old", time.Minute)
+
+	keys := []string{"user", "index:name", "index:email"}
+	err := cache.Txn(keys, func(tx *Tx) error {
+		if v, ok, err := tx.Get("index:email"); !ok || err != nil || v != "old" {
+			t.Fatalf("Expected the stored value, got %v, %v, %v", v, ok, err)
+		}
+		tx.Set("user", "aboubakr", time.Minute)
+		tx.Set("index:name", "user", time.Minute)
+		tx.Delete("index:email")
+		if v, ok, _ := tx.Get("user"); !ok || v != "aboubakr" {
+			t.Fatalf("Expected the transaction to see its own write, got %v", v)
+		}
+		if _, ok, _ := tx.Get("index:email"); ok {
+			t.Fatal("Expected the transaction to see its own delete")
+		}
+		// Nothing is visible before the commit
+		if _, ok := cache.getShard("user").data["user"]; ok {
+			t.Fatal("Expected the write to wait for the commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := cache.FetchData("user"); v != "aboubakr" {
+		t.Fatalf("Expected the committed user, got %v", v)
+	}
+	if v, _, _ := cache.FetchData("index:name"); v != "user" {
+		t.Fatalf("Expected the committed index, got %v", v)
+	}
+	if cache.Exists("index:email") {
+		t.Fatal("Expected the committed delete")
+	}
+}
+
+// testing that a failing transaction changes nothing
+func TestTxnRollback(t *testing.T) {
+	cache := newTxnCache(t)
+	cache.Store("user", "before", time.Minute)
+
+	fail := errors.New("validation failed")
+	err := cache.Txn([]string{"user", "index:name"}, func(tx *Tx) error {
+		tx.Set("user", "after", time.Minute)
+		tx.Set("index:name", "user", time.Minute)
+		return fail
+	})
+	if !errors.Is(err, fail) {
+		t.Fatalf("Expected the callback's error, got %v", err)
+	}
+	if v, _, _ := cache.FetchData("user"); v != "before" {
+		t.Fatalf("Expected the old value, got %v", v)
+	}
+	if cache.Exists("index:name") {
+		t.Fatal("Expected no index after the rollback")
+	}
+
+	err = cache.Txn([]string{"user"}, func(tx *Tx) error {
+		return tx.Set("other", 1, time.Minute)
+	})
+	if !errors.Is(err, ErrKeyNotInTxn) {
+		t.Fatalf("Expected ErrKeyNotInTxn, got %v", err)
+	}
+}
+
+// testing that a commit whose last write fails applies none of the writes
+func TestTxnCommitFails(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Cache)
+		opts  []Option
+		err   error
+	}{
+		{"BackendSave", func(c *Cache) {}, []Option{WithBackend(&failKeyBackend{newMemBackend(), "index:email"})}, ErrBackend},
+		{"PinnedFull", func(c *Cache) {
+			c.StoreWithOptions("pinned", 1, ItemOptions{TTL: time.Minute, Pinned: true})
+		}, []Option{WithShardingFunc(func(key string, n int) int {
+			if key == "pinned" {
+				return 3
+			}
+			return txnShards(key, n)
+		})}, ErrCacheFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(4, 1, 0, append([]Option{WithShardingFunc(txnShards)}, tt.opts...)...)
+			defer cache.Close()
+			cache.Store("user", "before", time.Minute)
+			cache.Store("index:name", "user", time.Minute)
+			tt.setup(cache)
+
+			err := cache.Txn([]string{"user", "index:name", "index:email"}, func(tx *Tx) error {
+				tx.Set("user", "after", time.Minute)
+				tx.Delete("index:name")
+				return tx.Set("index:email", "user", time.Minute)
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected %v, got %v", tt.err, err)
+			}
+			if v, _, _ := cache.FetchData("user"); v != "before" {
+				t.Fatalf("Expected the old value, got %v", v)
+			}
+			if !cache.Exists("index:name") {
+				t.Fatal("Expected the delete not to be applied")
+			}
+			if cache.Exists("index:email") {
+				t.Fatal("Expected the failed write not to be applied")
+			}
+			checkIntegrity(t, cache)
+		})
+	}
+}
+
+// testing that concurrent readers see all of a transaction or none of it
+func TestTxnAtomicity(t *testing.T) {
+	cache := newTxnCache(t)
+	keys := []string{"user", "index:name", "index:email"}
+	cache.Txn(keys, func(tx *Tx) error {
+		for _, key := range keys {
+			tx.Set(key, 0, NoExpiration)
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 1000; i++ {
+			cache.Txn(keys, func(tx *Tx) error {
+				for _, key := range keys {
+					tx.Set(key, i, NoExpiration)
+				}
+				return nil
+			})
+		}
+		close(stop)
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				var seen []interface{}
+				cache.Txn(keys, func(tx *Tx) error {
+					for _, key := range keys {
+						v, _, _ := tx.Get(key)
+						seen = append(seen, v)
+					}
+					return nil
+				})
+				if seen[0] != seen[1] || seen[1] != seen[2] {
+					t.Errorf("Expected a consistent view, got %v", seen)
+					return
+				}
+				// A plain fetch sees a whole value, never a torn one
+				if _, ok, err := cache.FetchData("user"); !ok || err != nil {
+					t.Errorf("Expected the user to be present, got %v, %v", ok, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,97 @@
+package hoard
+
+import (
+	"fmt"
+	"time"
+)
+
+// Typed is a type-safe view over a Cache whose values are all of type T.
+// It shares storage with the underlying Cache, so the untyped API keeps
+// working on the same entries.
+type Typed[T any] struct {
+	c *Cache
+}
+
+// NewTyped returns a Typed view over c.
+func NewTyped[T any](c *Cache) *Typed[T] {
+	return &Typed[T]{c: c}
+}
+
+// Cache returns the underlying untyped cache.
+func (t *Typed[T]) Cache() *Cache {
+	return t.c
+}
+
+// Store stores v under key with the given ttl.
+func (t *Typed[T]) Store(key string, v T, ttl time.Duration) error {
+	return t.c.Store(key, v, ttl)
+}
+
+// Update replaces the value of an existing key.
+func (t *Typed[T]) Update(key string, v T, ttl time.Duration) error {
+	return t.c.Update(key, v, ttl)
+}
+
+// Delete removes key and reports whether a live entry was removed.
+func (t *Typed[T]) Delete(key string) bool {
+	return t.c.Delete(key)
+}
+
+// Fetch returns the value stored under key decoded as T. A stored value that
+// cannot be decoded as T yields an error rather than a zero value. Misses are
+// resolved like FetchData does, through the loader configured with
+// WithLoader or the backend configured with WithBackend.
+func (t *Typed[T]) Fetch(key string) (T, bool, error) {
+	var v T
+	ok, err := t.c.FetchInto(key, &v)
+	if ok || err != nil || t.c.loader == nil && t.c.backend == nil {
+		return v, ok, err
+	}
+	res, ok, err := t.c.FetchData(key)
+	if !ok || err != nil {
+		return v, ok, err
+	}
+	// Decode the entry the miss stored, which keeps concrete types
+	if ok, err := t.c.FetchInto(key, &v); ok || err != nil {
+		return v, ok, err
+	}
+	// The value was not cached, for instance because it did not fit
+	if typed, isT := res.(T); isT {
+		return typed, true, nil
+	}
+	b, err := t.c.serialize(res)
+	if err != nil {
+		return v, true, err
+	}
+	if err := t.c.serializer.Unmarshal(b, &v); err != nil {
+		return v, true, fmt.Errorf("%w: cannot decode %q into %T: %w", ErrSerialization, key, &v, err)
+	}
+	return v, true, nil
+}
+
+// GetOrStore returns the value stored under key, or calls loader and stores
+// its result when the key is missing. Concurrent callers for the same key
+// share a single loader call. loaded reports whether the value came from
+// loader.
+func (t *Typed[T]) GetOrStore(key string, ttl time.Duration, loader func() (T, error)) (T, bool, error) {
+	var v T
+	if ok, err := t.c.FetchInto(key, &v); ok || err != nil {
+		return v, false, err
+	}
+	res, loaded, err := t.c.GetOrStore(key, ttl, func() (interface{}, error) {
+		return loader()
+	})
+	if err != nil {
+		return v, false, err
+	}
+	if loaded {
+		typed, ok := res.(T)
+		if !ok {
+			return v, false, fmt.Errorf("hoard: loaded value for %q is %T, not %T", key, res, v)
+		}
+		return typed, true, nil
+	}
+	// Another caller stored the key first; decode its bytes as T
+	_, err = t.c.FetchInto(key, &v)
+	return v, false, err
+}
@@ -0,0 +1,115 @@
+package hoard
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testing the typed façade round-trips structs without interface{}
+func TestTyped(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	people := NewTyped[person](cache)
+
+	bakr := person{Name: "Aboubakr", Age: 33, Hobbies: []string{"guitar"}}
+	if err := people.Store("bakr", bakr, time.Minute); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	got, exists, err := people.Fetch("bakr")
+	if err != nil || !exists {
+		t.Fatalf("Fetch failed: (%v, %v)", exists, err)
+	}
+	if got.Name != "Aboubakr" || got.Age != 33 || len(got.Hobbies) != 1 {
+		t.Fatalf("Unexpected value %+v", got)
+	}
+
+	bakr.Age = 34
+	if err := people.Update("bakr", bakr, time.Minute); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, _, _ := people.Fetch("bakr"); got.Age != 34 {
+		t.Fatalf("Expected updated age, got %+v", got)
+	}
+
+	if _, exists, err := people.Fetch("nonexistent"); exists || err != nil {
+		t.Fatalf("Expected a clean miss, got (%v, %v)", exists, err)
+	}
+
+	if !people.Delete("bakr") {
+		t.Fatal("Expected Delete to remove 'bakr'")
+	}
+}
+
+// testing that decoding into the wrong type is an error, not a zero value
+func TestTypedMismatch(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	cache.Store("name", "kouhadi", time.Minute)
+
+	numbers := NewTyped[int](cache)
+	if _, exists, err := numbers.Fetch("name"); err == nil || !exists {
+		t.Fatalf("Expected a decode error, got (%v, %v)", exists, err)
+	}
+}
+
+// testing typed GetOrStore with concurrent callers
+func TestTypedGetOrStore(t *testing.T) {
+	cache := NewCache(4, 1000, time.Minute)
+	defer cache.Close()
+	people := NewTyped[person](cache)
+
+	var calls int32
+	loader := func() (person, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return person{Name: "Haroun", Age: 3}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, _, err := people.GetOrStore("haroun", time.Minute, loader)
+			if err != nil || p.Name != "Haroun" {
+				t.Errorf("Unexpected result (%+v, %v)", p, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected loader to run once, ran %d times", n)
+	}
+
+	p, loaded, err := people.GetOrStore("haroun", time.Minute, loader)
+	if err != nil || loaded || p.Age != 3 {
+		t.Fatalf("Expected cached value, got (%+v, %v, %v)", p, loaded, err)
+	}
+}
+
+// testing that typed fetches read misses through the backend like FetchData
+// does, keeping the concrete type
+func TestTypedReadThrough(t *testing.T) {
+	backend := newMemBackend()
+	cache := NewCache(4, 1000, time.Minute, WithBackend(backend))
+	defer cache.Close()
+	people := NewTyped[person](cache)
+
+	val, err := cache.serialize(person{Name: "Aboubakr", Age: 33})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.data["bakr"] = val
+	got, exists, err := people.Fetch("bakr")
+	if err != nil || !exists || got.Name != "Aboubakr" || got.Age != 33 {
+		t.Fatalf("Expected the backend value, got %+v, %v, %v", got, exists, err)
+	}
+	if !cache.Exists("bakr") || backend.loads.Load() != 1 {
+		t.Fatalf("Expected one load cached in memory, got %d loads", backend.loads.Load())
+	}
+	if _, exists, err := people.Fetch("nobody"); exists || err != nil {
+		t.Fatalf("Expected a clean miss, got (%v, %v)", exists, err)
+	}
+}
@@ -0,0 +1,180 @@
+package hoard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Write log operations. Every record is framed as
+//
+//	uvarint payloadLen | payload | crc32(payload)
+//
+// with the payload laid out as
+//
+//	op | uvarint keyLen | key | varint expiration | flags | uvarint valueLen | value
+const (
+	walSet    = 1
+	walDelete = 2
+	walExpire = 3
+	walClear  = 4
+)
+
+// ErrInvalidWriteLog is returned by Replay when a complete record fails its
+// checksum or cannot be decoded.
+var ErrInvalidWriteLog = errors.New("hoard: invalid write log")
+
+// writeLog appends mutation records to an io.Writer.
+type writeLog struct {
+	mu        sync.Mutex
+	w         io.Writer
+	size      int64
+	threshold int64
+	onRotate  func(size int64)
+	rotating  bool
+	buf       []byte
+}
+
+// append writes a single record and reports the new log size.
+func (l *writeLog) append(op byte, key string, val []byte, exp int64, flags byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	payload := l.buf[:0]
+	payload = append(payload, op)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = binary.AppendVarint(payload, exp)
+	payload = append(payload, flags)
+	payload = binary.AppendUvarint(payload, uint64(len(val)))
+	payload = append(payload, val...)
+
+	frame := binary.AppendUvarint(make([]byte, 0, len(payload)+binary.MaxVarintLen64+4), uint64(len(payload)))
+	frame = append(frame, payload...)
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.ChecksumIEEE(payload))
+	l.buf = payload
+
+	n, err := l.w.Write(frame)
+	l.size += int64(n)
+	if err == nil && l.threshold > 0 && l.size >= l.threshold && !l.rotating && l.onRotate != nil {
+		// Run the hook outside the shard lock the caller holds so it can
+		// snapshot the cache; it is re-armed by ResetWriteLog.
+		l.rotating = true
+		go l.onRotate(l.size)
+	}
+	return l.size, err
+}
+
+// logWrite appends a record to the write log, if one is configured. Callers
+// hold the lock of the key's shard so records for a key are ordered.
+func (c *Cache) logWrite(op byte, key string, val []byte, exp int64, flags byte) {
+	if c.wal == nil {
+		return
+	}
+	if _, err := c.wal.append(op, key, val, exp, flags); err != nil {
+		c.reportError(fmt.Errorf("hoard: write log: %w", err))
+	}
+}
+
+// ResetWriteLog points the write log at w, typically a fresh file after the
+// caller snapshotted the cache and truncated the old log. It re-arms the
+// rotation hook. It is a no-op when no write log is configured.
+func (c *Cache) ResetWriteLog(w io.Writer) {
+	if c.wal == nil {
+		return
+	}
+	c.wal.mu.Lock()
+	c.wal.w = w
+	c.wal.size = 0
+	c.wal.rotating = false
+	c.wal.mu.Unlock()
+}
+
+// Replay applies a write log produced with WithWriteLog, typically right
+// after loading the base snapshot. Records are applied in order; a truncated
+// final record, as left by a crash mid-append, is ignored. Replayed
+// operations are not written back to the cache's own write log.
+func (c *Cache) Replay(r io.Reader) error {
+	if c.isClosed() {
+		return ErrCacheClosed
+	}
+	br := bufio.NewReader(r)
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Torn length prefix at the end of the log
+			return nil
+		}
+		if n > maxSnapshotSize {
+			return fmt.Errorf("%w: record length %d out of range", ErrInvalidWriteLog, n)
+		}
+		frame := make([]byte, n+4)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			// Torn final record
+			return nil
+		}
+		payload := frame[:n]
+		if binary.LittleEndian.Uint32(frame[n:]) != crc32.ChecksumIEEE(payload) {
+			return fmt.Errorf("%w: checksum mismatch", ErrInvalidWriteLog)
+		}
+		if err := c.applyLogRecord(payload); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Cache) applyLogRecord(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("%w: empty record", ErrInvalidWriteLog)
+	}
+	op, rest := payload[0], payload[1:]
+	keyLen, n := binary.Uvarint(rest)
+	if n <= 0 || uint64(len(rest)-n) < keyLen {
+		return fmt.Errorf("%w: bad key", ErrInvalidWriteLog)
+	}
+	key := string(rest[n : n+int(keyLen)])
+	rest = rest[n+int(keyLen):]
+	exp, n := binary.Varint(rest)
+	if n <= 0 || n >= len(rest) {
+		return fmt.Errorf("%w: bad expiration", ErrInvalidWriteLog)
+	}
+	flags := rest[n]
+	rest = rest[n+1:]
+	valLen, n := binary.Uvarint(rest)
+	if n <= 0 || uint64(len(rest)-n) != valLen {
+		return fmt.Errorf("%w: bad value", ErrInvalidWriteLog)
+	}
+	val := rest[n:]
+
+	if op == walClear {
+		c.clearShards()
+		return nil
+	}
+
+	shard := c.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	now := c.now()
+	switch op {
+	case walSet:
+		if exp != 0 && now > exp {
+			c.deleteLocked(shard, key, now)
+			return nil
+		}
+		return c.setLocked(shard, key, val, exp, flags, 1)
+	case walDelete:
+		c.deleteLocked(shard, key, now)
+	case walExpire:
+		c.touchLocked(shard, key, exp, now)
+	default:
+		return fmt.Errorf("%w: unknown op %d", ErrInvalidWriteLog, op)
+	}
+	return nil
+}
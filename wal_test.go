@@ -0,0 +1,117 @@
+package hoard
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testing that replaying the write log reproduces every mutation
+func TestWriteLogReplay(t *testing.T) {
+	var log bytes.Buffer
+	cache := NewCache(4, 1000, time.Minute, WithWriteLog(&log))
+	defer cache.Close()
+
+	for i := 0; i < 20; i++ {
+		cache.Store("key"+strconv.Itoa(i), i, time.Hour)
+	}
+	cache.Update("key1", "updated", time.Hour)
+	cache.Delete("key2")
+	cache.DeleteMany([]string{"key3", "key4"})
+	cache.Touch("key5", NoExpiration)
+	cache.StoreMany(map[string]interface{}{"batch": "value"}, time.Hour)
+
+	restored := NewCache(4, 1000, time.Minute)
+	defer restored.Close()
+	if err := restored.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	for _, key := range []string{"key0", "key1", "key5", "key19", "batch"} {
+		want, _ := cache.FetchBytesData(key)
+		got, exists := restored.FetchBytesData(key)
+		if !exists || !bytes.Equal(got, want) {
+			t.Fatalf("Expected %s to be replayed, got (%v, %v)", key, got, exists)
+		}
+	}
+	for _, key := range []string{"key2", "key3", "key4"} {
+		if restored.Exists(key) {
+			t.Fatalf("Expected %s to be deleted by replay", key)
+		}
+	}
+	if ttl, _ := restored.TTL("key5"); ttl != NoExpiration {
+		t.Fatalf("Expected Touch to be replayed, got TTL %v", ttl)
+	}
+
+	cache.CleanupAll()
+	if err := restored.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if restored.Exists("key0") {
+		t.Fatal("Expected CleanupAll to be replayed")
+	}
+}
+
+// testing that a torn final record is ignored without corrupting the cache
+func TestWriteLogReplayTruncated(t *testing.T) {
+	var log bytes.Buffer
+	cache := NewCache(4, 1000, time.Minute, WithWriteLog(&log))
+	defer cache.Close()
+
+	cache.Store("aboubakr", "kouhadi", time.Hour)
+	complete := log.Len()
+	cache.Store("haroun", "a value that will be cut in half", time.Hour)
+
+	for cut := complete + 1; cut < log.Len(); cut++ {
+		restored := NewCache(4, 1000, time.Minute)
+		if err := restored.Replay(bytes.NewReader(log.Bytes()[:cut])); err != nil {
+			t.Fatalf("Replay of log cut at %d failed: %v", cut, err)
+		}
+		if value, _, err := restored.FetchData("aboubakr"); err != nil || value != "kouhadi" {
+			t.Fatalf("Expected complete record to be applied, got (%v, %v)", value, err)
+		}
+		if restored.Exists("haroun") {
+			t.Fatalf("Expected torn record at %d to be ignored", cut)
+		}
+		restored.Close()
+	}
+}
+
+// testing the rotation hook and its re-arming
+func TestWriteLogRotation(t *testing.T) {
+	var log bytes.Buffer
+	rotated := make(chan int64, 4)
+	cache := NewCache(4, 1000, time.Minute,
+		WithWriteLog(&log),
+		WithWriteLogRotation(256, func(size int64) { rotated <- size }))
+	defer cache.Close()
+
+	for i := 0; i < 50; i++ {
+		cache.Store("key"+strconv.Itoa(i), "value", time.Hour)
+	}
+	select {
+	case size := <-rotated:
+		if size < 256 {
+			t.Fatalf("Expected rotation above threshold, got %d", size)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected rotation hook to fire")
+	}
+	select {
+	case <-rotated:
+		t.Fatal("Expected hook to fire once until ResetWriteLog")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	var fresh bytes.Buffer
+	cache.ResetWriteLog(&fresh)
+	for i := 0; fresh.Len() < 256; i++ {
+		cache.Store("key"+strconv.Itoa(i), "value", time.Hour)
+	}
+	select {
+	case <-rotated:
+	case <-time.After(time.Second):
+		t.Fatal("Expected hook to fire again after ResetWriteLog")
+	}
+}
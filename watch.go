@@ -0,0 +1,237 @@
+package hoard
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ChangeOp is the kind of change a ChangeEvent reports.
+type ChangeOp int
+
+const (
+	// OpStore reports a value written by Store or one of its variants.
+	OpStore ChangeOp = iota + 1
+	// OpUpdate reports a value changed in place, by Update, Increment or
+	// CompareAndSwap.
+	OpUpdate
+	// OpDelete reports an entry removed by a delete, CleanupAll or another
+	// instance's invalidation.
+	OpDelete
+	// OpExpire reports an expired entry being removed.
+	OpExpire
+	// OpEvict reports an entry evicted to make room.
+	OpEvict
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case OpStore:
+		return "store"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	case OpExpire:
+		return "expire"
+	case OpEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// ChangeEvent describes a change to a watched key. Value holds the new
+// stored bytes for OpStore and OpUpdate and is nil otherwise; it is shared
+// with the cache and must not be modified.
+type ChangeEvent struct {
+	Op    ChangeOp
+	Key   string
+	Value []byte
+}
+
+// watchBufferSize is how many undelivered events a watcher holds.
+const watchBufferSize = 16
+
+type watcher struct {
+	ch     chan ChangeEvent
+	prefix string
+	closed bool // guarded by watchers.mu
+}
+
+// watchers routes change events to the channels returned by Watch,
+// WatchPrefix and Events. Events are sent under the shard lock of their key,
+// so a watcher sees the changes of a key in order.
+type watchers struct {
+	n        atomic.Int32 // number of watchers and subscribers, checked before taking mu
+	values   atomic.Int32 // number of subscribers asking for values
+	dropped  atomic.Uint64
+	mu       sync.RWMutex
+	keys     map[string][]*watcher
+	prefixes []*watcher
+	subs     []*subscriber
+}
+
+// Watch returns a channel receiving an event for every change to key: stores,
+// updates, deletes, expirations, including those found by the cleanup
+// goroutine, and evictions. Events are delivered without blocking the cache:
+// each watcher buffers up to 16 of them and events arriving while the buffer
+// is full are dropped and counted in Stats.DroppedEvents, so slow readers see
+// gaps rather than stalling writes.
+// The returned func unsubscribes and closes the channel; Close closes every
+// channel too.
+func (c *Cache) Watch(key string) (<-chan ChangeEvent, func()) {
+	w := &watcher{ch: make(chan ChangeEvent, watchBufferSize)}
+	c.watch.mu.Lock()
+	if c.isClosed() {
+		c.watch.mu.Unlock()
+		close(w.ch)
+		return w.ch, func() {}
+	}
+	if c.watch.keys == nil {
+		c.watch.keys = make(map[string][]*watcher)
+	}
+	c.watch.keys[key] = append(c.watch.keys[key], w)
+	c.watch.n.Add(1)
+	c.watch.mu.Unlock()
+
+	return w.ch, func() {
+		c.watch.mu.Lock()
+		defer c.watch.mu.Unlock()
+		ws := c.watch.keys[key]
+		for i, other := range ws {
+			if other == w {
+				ws = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		if len(ws) == 0 {
+			delete(c.watch.keys, key)
+		} else {
+			c.watch.keys[key] = ws
+		}
+		c.watch.stopLocked(w)
+	}
+}
+
+// WatchPrefix is like Watch but reports changes to every key starting with
+// prefix.
+func (c *Cache) WatchPrefix(prefix string) (<-chan ChangeEvent, func()) {
+	w := &watcher{ch: make(chan ChangeEvent, watchBufferSize), prefix: prefix}
+	c.watch.mu.Lock()
+	if c.isClosed() {
+		c.watch.mu.Unlock()
+		close(w.ch)
+		return w.ch, func() {}
+	}
+	c.watch.prefixes = append(c.watch.prefixes, w)
+	c.watch.n.Add(1)
+	c.watch.mu.Unlock()
+
+	return w.ch, func() {
+		c.watch.mu.Lock()
+		defer c.watch.mu.Unlock()
+		for i, other := range c.watch.prefixes {
+			if other == w {
+				c.watch.prefixes = append(c.watch.prefixes[:i], c.watch.prefixes[i+1:]...)
+				break
+			}
+		}
+		c.watch.stopLocked(w)
+	}
+}
+
+// stopLocked closes the channel of w once. The caller must hold mu.
+func (ws *watchers) stopLocked(w *watcher) {
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+		ws.n.Add(-1)
+	}
+}
+
+// closeAll closes every watcher's channel.
+func (ws *watchers) closeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, list := range ws.keys {
+		for _, w := range list {
+			ws.stopLocked(w)
+		}
+	}
+	for _, w := range ws.prefixes {
+		ws.stopLocked(w)
+	}
+	for _, s := range ws.subs {
+		ws.unsubscribeLocked(s)
+	}
+	ws.keys = nil
+	ws.prefixes = nil
+	ws.subs = nil
+}
+
+// notifyValue is notify for values stored with flags, which are decrypted
+// and decompressed for the watchers if need be.
+func (c *Cache) notifyValue(op ChangeOp, key string, val []byte, flags byte) {
+	if c.watch.n.Load() == 0 {
+		return
+	}
+	if flags&(itemCompressed|itemEncrypted) != 0 {
+		v, ok := c.unpackFound(itemView{value: val, flags: flags})
+		if !ok {
+			return
+		}
+		val = v.value
+	}
+	c.notify(op, key, val)
+}
+
+// notify sends a change event for key to its watchers and subscribers,
+// dropping it for those whose buffer is full. val is the new value of a
+// store or update, or the removed value for subscribers of Events. It costs
+// an atomic load when nothing is watched.
+func (c *Cache) notify(op ChangeOp, key string, val []byte) {
+	if c.watch.n.Load() == 0 {
+		return
+	}
+	ev := ChangeEvent{Op: op, Key: key}
+	if op == OpStore || op == OpUpdate {
+		ev.Value = val
+	}
+	dropped := &c.watch.dropped
+	c.watch.mu.RLock()
+	defer c.watch.mu.RUnlock()
+	for _, w := range c.watch.keys[key] {
+		w.send(ev, dropped)
+	}
+	for _, w := range c.watch.prefixes {
+		if strings.HasPrefix(key, w.prefix) {
+			w.send(ev, dropped)
+		}
+	}
+	if len(c.watch.subs) > 0 {
+		event := Event{Reason: op, Key: key, Time: c.clock.Now()}
+		for _, s := range c.watch.subs {
+			s.send(event, val, dropped)
+		}
+	}
+}
+
+func (w *watcher) send(ev ChangeEvent, dropped *atomic.Uint64) {
+	if w.closed {
+		return
+	}
+	select {
+	case w.ch <- ev:
+	default:
+		dropped.Add(1)
+	}
+}
+
+// expireLocked removes the expired item stored under key. The caller must
+// hold shard.mu for writing.
+func (c *Cache) expireLocked(shard *CacheShard, key string, item *CacheItem) {
+	val, flags := shard.value(item), item.flags
+	shard.removeItem(key, item)
+	shard.stats.expired.Add(1)
+	c.notifyRemoved(OpExpire, key, val, flags)
+}
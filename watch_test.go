@@ -0,0 +1,150 @@
+package hoard
+
+import (
+	"testing"
+	"time"
+)
+
+// next returns the next event on ch or fails after a second.
+func next(t *testing.T, ch <-chan ChangeEvent) ChangeEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("Expected an event, the channel is closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return ChangeEvent{}
+}
+
+// testing that a watcher sees every kind of change to its key
+func TestWatch(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewCache(1, 2, 0, WithClock(clock))
+	defer cache.Close()
+	ch, cancel := cache.Watch("key")
+	defer cancel()
+
+	cache.StoreBytes("key", []byte("v1"), time.Minute)
+	cache.Store("other", 1, time.Minute) // not watched
+	cache.Update("key", "v2", time.Minute)
+	cache.Delete("key")
+	cache.Increment("key", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.FetchData("key")
+	cache.Store("key", 1, time.Minute)
+	cache.Store("a", 1, time.Minute)
+	cache.Store("b", 1, time.Minute) // evicts key
+
+	want := []ChangeOp{OpStore, OpUpdate, OpDelete, OpStore, OpExpire, OpStore, OpEvict}
+	for _, op := range want {
+		ev := next(t, ch)
+		if ev.Op != op || ev.Key != "key" {
+			t.Fatalf("Expected %v of key, got %v of %s", op, ev.Op, ev.Key)
+		}
+		if op == OpStore && ev.Value == nil {
+			t.Fatalf("Expected the stored value with %v", op)
+		}
+	}
+	select {
+	case ev := <-ch:
+		t.Fatalf("Expected no more events, got %+v", ev)
+	default:
+	}
+}
+
+// testing that prefix watchers only see matching keys
+func TestWatchPrefix(t *testing.T) {
+	cache := NewCache(4, 10, 0)
+	defer cache.Close()
+	ch, cancel := cache.WatchPrefix("config:")
+	defer cancel()
+
+	cache.Store("config:a", 1, time.Minute)
+	cache.Store("user:a", 1, time.Minute)
+	cache.Store("config:b", 2, time.Minute)
+	cache.CleanupAll()
+
+	got := map[string][]ChangeOp{}
+	for i := 0; i < 4; i++ {
+		ev := next(t, ch)
+		got[ev.Key] = append(got[ev.Key], ev.Op)
+	}
+	if len(got) != 2 || len(got["config:a"]) != 2 || got["config:b"][1] != OpDelete {
+		t.Fatalf("Expected stores and deletes of the config keys, got %v", got)
+	}
+}
+
+// testing that cancel unsubscribes and closes the channel
+func TestWatchCancel(t *testing.T) {
+	cache := NewCache(1, 10, 0)
+	ch, cancel := cache.Watch("key")
+	cancel()
+	cancel() // safe to repeat
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected the channel to be closed")
+	}
+	cache.Store("key", 1, time.Minute)
+	if n := cache.watch.n.Load(); n != 0 {
+		t.Fatalf("Expected no watchers, got %d", n)
+	}
+
+	other, _ := cache.WatchPrefix("")
+	cache.Close()
+	if _, ok := <-other; ok {
+		t.Fatal("Expected Close to close the channel")
+	}
+	if ch, _ := cache.Watch("key"); ch == nil {
+		t.Fatal("Expected a channel after Close")
+	} else if _, ok := <-ch; ok {
+		t.Fatal("Expected a closed channel after Close")
+	}
+}
+
+// testing that events overflowing the buffer are dropped without blocking
+func TestWatchOverflow(t *testing.T) {
+	cache := NewCache(1, 10, 0)
+	defer cache.Close()
+	ch, cancel := cache.Watch("key")
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10*watchBufferSize; i++ {
+			cache.Increment("key", 1, time.Minute)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected writes not to block on a full watcher")
+	}
+
+	if n := len(ch); n != watchBufferSize {
+		t.Fatalf("Expected a full buffer, got %d events", n)
+	}
+	// The oldest events are kept
+	if ev := next(t, ch); ev.Op != OpStore || decodeInt(ev.Value) != 1 {
+		t.Fatalf("Expected the first event, got %+v", ev)
+	}
+}
+
+// testing that expirations found by the cleanup goroutine are reported
+func TestWatchCleanup(t *testing.T) {
+	cache := NewCache(1, 10, 5*time.Millisecond)
+	defer cache.Close()
+	ch, cancel := cache.Watch("key")
+	defer cancel()
+
+	cache.Store("key", 1, time.Millisecond)
+	if ev := next(t, ch); ev.Op != OpStore {
+		t.Fatalf("Expected the store, got %v", ev.Op)
+	}
+	if ev := next(t, ch); ev.Op != OpExpire {
+		t.Fatalf("Expected the cleanup to expire the key, got %v", ev.Op)
+	}
+}
@@ -0,0 +1,150 @@
+package hoard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// QueueFullPolicy decides what a write does when the write-behind queue is
+// full. See WithWriteBehind.
+type QueueFullPolicy int
+
+const (
+	// BlockWhenFull makes the write wait for room in the queue, once it has
+	// released its shard lock, so only later writes to the same key wait
+	// behind it. It is the default.
+	BlockWhenFull QueueFullPolicy = iota
+	// DropOldestWhenFull discards the oldest queued write to make room. The
+	// dropped write is reported to the flush error handler.
+	DropOldestWhenFull
+	// FailWhenFull makes the write fail with ErrQueueFull and drops its key
+	// from the cache, like a failed backend save.
+	FailWhenFull
+)
+
+// ErrQueueFull is returned by writes that find the write-behind queue full
+// under FailWhenFull, and reported for writes dropped under
+// DropOldestWhenFull.
+var ErrQueueFull = errors.New("hoard: write-behind queue is full")
+
+// writeOp is a backend mutation waiting in the write-behind queue.
+type writeOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// writeBehind queues backend mutations and applies them from worker
+// goroutines. Each key is always queued to the same worker, so mutations of
+// a key reach the backend in the order they were queued.
+type writeBehind struct {
+	mu     sync.RWMutex // guards closed against sends on closed queues
+	closed bool
+	// closing is closed when close starts, waking writes waiting for room
+	// so close can take mu
+	closing chan struct{}
+	stop    sync.Once
+	queues  []chan writeOp
+	full    QueueFullPolicy
+	wg      sync.WaitGroup
+}
+
+// startWriteBehind starts the workers draining c.wb into c.backend.
+func (c *Cache) startWriteBehind(queueSize, workers int) {
+	perWorker := (queueSize + workers - 1) / workers
+	c.wb.queues = make([]chan writeOp, workers)
+	c.wb.wg.Add(workers)
+	for i := range c.wb.queues {
+		q := make(chan writeOp, perWorker)
+		c.wb.queues[i] = q
+		go func() {
+			defer c.wb.wg.Done()
+			for op := range q {
+				var err error
+				if op.delete {
+					err = c.backend.Delete(op.key)
+				} else {
+					err = c.backend.Save(op.key, op.value)
+				}
+				if err != nil {
+					c.flushError(op.key, fmt.Errorf("%w: %w", ErrBackend, err))
+				}
+			}
+		}()
+	}
+}
+
+// enqueue queues op according to the full policy. The caller holds the turn
+// of op.key, which orders the mutations of a key, but no shard lock, so
+// waiting for room under BlockWhenFull only holds up writers of op.key. The
+// wait ends with ErrCacheClosed if the cache closes, or with ctx's error.
+func (c *Cache) enqueue(ctx context.Context, op writeOp) error {
+	wb := c.wb
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	if wb.closed {
+		return ErrCacheClosed
+	}
+
+	q := wb.queues[fnv32a(op.key)%uint32(len(wb.queues))]
+	switch wb.full {
+	case FailWhenFull:
+		select {
+		case q <- op:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	case DropOldestWhenFull:
+		for {
+			select {
+			case q <- op:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-q:
+				c.flushError(dropped.key, ErrQueueFull)
+			default:
+			}
+		}
+	default:
+		select {
+		case q <- op:
+			return nil
+		case <-wb.closing:
+			return ErrCacheClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// flushError hands a failed or dropped backend write to the handler set with
+// WithFlushErrorHandler, or else to the error handler.
+func (c *Cache) flushError(key string, err error) {
+	if c.onFlushError != nil {
+		c.log(slog.LevelError, "hoard: write-behind failed", slog.String("key", key), slog.Any("err", err))
+		c.onFlushError(key, err)
+		return
+	}
+	c.reportError(fmt.Errorf("hoard: write-behind %q: %w", key, err))
+}
+
+// close stops accepting writes, failing those still waiting for room, and
+// waits until the queued ones are applied.
+func (wb *writeBehind) close() {
+	wb.stop.Do(func() { close(wb.closing) })
+	wb.mu.Lock()
+	if !wb.closed {
+		wb.closed = true
+		for _, q := range wb.queues {
+			close(q)
+		}
+	}
+	wb.mu.Unlock()
+	wb.wg.Wait()
+}